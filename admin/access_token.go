@@ -0,0 +1,396 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	. "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+个人访问令牌说明：
+
+脚本调用管理后台时使用个人访问令牌代替浏览器会话：
+
+1. 使用方式
+   - 请求头 Authorization: Bearer <令牌>
+   - 令牌代表创建它的用户，角色和权限策略与用户在浏览器中登录时相同
+   - 令牌请求跳过登录会话和双因素认证，创建令牌时用户已经通过了双因素认证
+
+2. 权限范围
+   - read: 只读，只允许GET、HEAD和OPTIONS请求
+   - write: 读写，允许所有请求
+
+3. 管理
+   - 用户在个人资料中创建和撤销自己的令牌，创建时选择名称、权限范围和有效期
+   - 令牌只在创建后显示一次，数据库只保存哈希
+   - 每次使用记录最近使用时间和IP，同一IP一分钟内的重复请求不更新
+   - 令牌请求不能访问令牌管理页面，泄露的令牌不能用于创建新令牌
+
+4. 失效
+   - 令牌过期、被撤销、用户停用或锁定后请求返回401
+   - 权限范围不足时返回403
+*/
+
+// 个人访问令牌管理页面地址
+const (
+	accessTokensPageURL  = "/auth/access-tokens"
+	accessTokenRevokeURL = "/auth/access-tokens/revoke"
+)
+
+const (
+	accessTokenPrefix        = "qpat_"           // 令牌前缀，便于在代码和日志中识别泄露的令牌
+	accessTokenHintLength    = 12                // 保存的令牌前几位，包括前缀
+	accessTokenNameMaxSize   = 100               // 令牌名称最大长度，与AccessToken.Name的字段长度一致
+	accessTokenTouchInterval = time.Minute       // 最近使用时间的更新间隔
+	accessTokenDefaultDays   = 30                // 默认有效期
+	accessTokenFormScope     = "scope"           // 创建表单中权限范围的字段名
+	accessTokenFormDays      = "expires_in_days" // 创建表单中有效期的字段名
+)
+
+// accessTokenExpiryDays 创建令牌时可以选择的有效期（天）
+var accessTokenExpiryDays = []int{7, accessTokenDefaultDays, 90, 365}
+
+var (
+	errAccessTokenInvalid = errors.New("个人访问令牌无效或已撤销")
+	errAccessTokenExpired = errors.New("个人访问令牌已过期")
+	errAccessTokenUser    = errors.New("个人访问令牌的用户已停用或锁定")
+)
+
+// accessTokenKey 令牌请求在请求上下文中保存令牌的键
+type accessTokenKey struct{}
+
+// newAccessTokenKey 刚创建的令牌明文在请求上下文中的键，页面据此显示令牌
+type newAccessTokenKey struct{}
+
+// hashAccessToken 计算令牌的哈希
+// 令牌是随机生成的，熵足够高，不需要使用慢哈希
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// accessTokenFromContext 返回当前请求使用的个人访问令牌，不是令牌请求时返回nil
+func accessTokenFromContext(r *http.Request) *models.AccessToken {
+	t, _ := r.Context().Value(accessTokenKey{}).(*models.AccessToken)
+	return t
+}
+
+// bearerToken 从Authorization请求头中取出Bearer令牌
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// createAccessToken 为用户创建个人访问令牌
+// 参数：
+// - db: 数据库连接
+// - userID: 用户ID
+// - name: 令牌名称
+// - scopes: 权限范围
+// - expiresAt: 过期时间，为空时不过期
+// 返回：
+// - string: 令牌明文，只能显示这一次
+// - *models.AccessToken: 已保存的令牌
+// - error: 生成或保存失败时的错误信息
+func createAccessToken(db *gorm.DB, userID uint, name string, scopes []string, expiresAt *time.Time) (string, *models.AccessToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	plain := accessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	token := &models.AccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashAccessToken(plain),
+		TokenHint: plain[:accessTokenHintLength],
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(token).Error; err != nil {
+		return "", nil, err
+	}
+	return plain, token, nil
+}
+
+// resolveAccessToken 查找令牌和令牌所属的用户
+// 参数：
+// - db: 数据库连接
+// - plain: 请求中的令牌明文
+// - now: 当前时间
+// 返回：
+// - *models.AccessToken: 令牌
+// - *models.User: 令牌所属的用户，角色由withRoles加载
+// - error: 令牌无效、过期或者用户停用、锁定时的错误信息
+func resolveAccessToken(db *gorm.DB, plain string, now time.Time) (*models.AccessToken, *models.User, error) {
+	if !strings.HasPrefix(plain, accessTokenPrefix) {
+		return nil, nil, errAccessTokenInvalid
+	}
+	var token models.AccessToken
+	if err := db.Where("token_hash = ?", hashAccessToken(plain)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errAccessTokenInvalid
+		}
+		return nil, nil, err
+	}
+	if token.Expired(now) {
+		return nil, nil, errAccessTokenExpired
+	}
+	var user models.User
+	if err := db.First(&user, token.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errAccessTokenInvalid
+		}
+		return nil, nil, err
+	}
+	if user.Status == models.StatusInactive || user.GetLocked() {
+		return nil, nil, errAccessTokenUser
+	}
+	return &token, &user, nil
+}
+
+// accessTokenAllows 判断令牌的权限范围是否允许请求的方法
+func accessTokenAllows(t *models.AccessToken, method string) bool {
+	if t.HasScope(models.AccessTokenScopeWrite) {
+		return true
+	}
+	return t.HasScope(models.AccessTokenScopeRead) &&
+		(method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions)
+}
+
+// touchAccessToken 更新令牌的最近使用时间和IP，同一IP间隔不到一分钟时不更新
+func touchAccessToken(db *gorm.DB, t *models.AccessToken, clientIP string, now time.Time) error {
+	if t.LastUsedAt != nil && now.Sub(*t.LastUsedAt) < accessTokenTouchInterval && t.LastUsedIP == clientIP {
+		return nil
+	}
+	t.LastUsedAt = &now
+	t.LastUsedIP = clientIP
+	return db.Model(t).UpdateColumns(map[string]interface{}{"last_used_at": now, "last_used_ip": clientIP}).Error
+}
+
+// withAccessToken 请求带有Bearer令牌时使用个人访问令牌认证，否则交给登录会话中间件
+// 令牌认证失败时直接返回401，不跳转到登录页面
+func withAccessToken(db *gorm.DB, session func(next http.Handler) http.Handler) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := session(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			plain, ok := bearerToken(r)
+			if !ok {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now()
+			token, user, err := resolveAccessToken(db, plain, now)
+			if err != nil {
+				models.AuthLog().Warn("个人访问令牌认证失败", "ip", ip(r), "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if !accessTokenAllows(token, r.Method) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if err := touchAccessToken(db, token, ip(r), now); err != nil {
+				models.AuthLog().Error("更新个人访问令牌使用时间失败", "error", err)
+			}
+
+			ctx := context.WithValue(r.Context(), login.UserKey, user)
+			ctx = context.WithValue(ctx, accessTokenKey{}, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// mountAccessTokens 挂载个人访问令牌的管理页面和撤销地址
+func mountAccessTokens(mux *http.ServeMux, pb *presets.Builder, vh *login.ViewHelper, db *gorm.DB) {
+	page := vh.I18n().EnsureLanguage(web.New().Page(accessTokensPage(pb, vh, db)))
+	mux.HandleFunc(accessTokensPageURL, accessTokensHandler(db, page))
+	mux.HandleFunc(accessTokenRevokeURL, accessTokenRevoke(db))
+}
+
+// accessTokensHandler 令牌管理页面，GET查看令牌列表，POST创建新令牌并显示一次
+// 令牌请求不能访问，必须使用浏览器会话
+func accessTokensHandler(db *gorm.DB, page http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := getCurrentUser(r)
+		if u == nil || accessTokenFromContext(r) != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			page.ServeHTTP(w, r)
+			return
+		}
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || len([]rune(name)) > accessTokenNameMaxSize {
+			setLoginNotice(w, login.NoticeLevel_Error, Messages_en_US.AccessTokenNameRequired)
+			http.Redirect(w, r, accessTokensPageURL, http.StatusFound)
+			return
+		}
+		scopes := []string{models.AccessTokenScopeRead}
+		if r.FormValue(accessTokenFormScope) == models.AccessTokenScopeWrite {
+			scopes = append(scopes, models.AccessTokenScopeWrite)
+		}
+		days, err := strconv.Atoi(r.FormValue(accessTokenFormDays))
+		if err != nil || !slices.Contains(accessTokenExpiryDays, days) {
+			days = accessTokenDefaultDays
+		}
+		expiresAt := time.Now().AddDate(0, 0, days)
+
+		plain, token, err := createAccessToken(db, u.ID, name, scopes, &expiresAt)
+		if err != nil {
+			panic(err)
+		}
+		models.AuthLog().Info("已创建个人访问令牌",
+			"account", models.MaskAccount(u.Account),
+			"name", token.Name,
+			"scopes", token.Scopes,
+			"expires_at", expiresAt,
+		)
+		page.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), newAccessTokenKey{}, plain)))
+	}
+}
+
+// accessTokenRevoke 撤销当前用户自己的个人访问令牌
+func accessTokenRevoke(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := getCurrentUser(r)
+		if u == nil || accessTokenFromContext(r) != nil || r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		var token models.AccessToken
+		err := db.Where("user_id = ?", u.ID).First(&token, "id = ?", r.FormValue("id")).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			panic(err)
+		}
+		if err == nil {
+			if err := db.Delete(&token).Error; err != nil {
+				panic(err)
+			}
+			models.AuthLog().Info("已撤销个人访问令牌", "account", models.MaskAccount(u.Account), "name", token.Name)
+		}
+		http.Redirect(w, r, accessTokensPageURL, http.StatusFound)
+	}
+}
+
+// accessTokensPage 当前用户的个人访问令牌列表和创建表单，刚创建的令牌显示在页面顶部
+func accessTokensPage(pb *presets.Builder, vh *login.ViewHelper, db *gorm.DB) web.PageFunc {
+	return pb.PlainLayout(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		loginMsgr := i18n.MustGetModuleMessages(ctx.R, login.I18nLoginKey, login.Messages_en_US).(*login.Messages)
+		u := getCurrentUser(ctx.R)
+
+		var tokens []models.AccessToken
+		if err = db.Where("user_id = ?", u.ID).Order("id desc").Find(&tokens).Error; err != nil {
+			return
+		}
+
+		var created HTMLComponent
+		if plain, ok := ctx.R.Context().Value(newAccessTokenKey{}).(string); ok {
+			created = v.VAlert(
+				Div(Text(msgr.AccessTokenCreated)),
+				Div(Text(plain)).Class("font-weight-bold mt-2 text-break").Style("font-family: monospace"),
+			).Type("success").Variant(v.VariantTonal).Class("my-4 text-left")
+		}
+
+		now := time.Now()
+		var rows []HTMLComponent
+		for _, t := range tokens {
+			rows = append(rows, Div(
+				Div(
+					Div(Text(t.Name)).Class("font-weight-bold"),
+					Div(Text(t.TokenHint+"… · "+accessTokenScopeLabel(msgr, t))).Class("text-caption").Style("font-family: monospace"),
+					Div(Text(accessTokenUsage(msgr, t, now))).Class("text-caption"),
+				).Class("text-left"),
+				Form(
+					Input("id").Type("hidden").Value(fmt.Sprint(t.ID)),
+					v.VBtn(msgr.AccessTokenRevokeBtn).Size(v.SizeSmall).Variant(v.VariantTonal).Color("warning").Attr("type", "submit"),
+				).Method(http.MethodPost).Action(accessTokenRevokeURL),
+			).Class("d-flex justify-space-between align-center my-3"))
+		}
+		if len(rows) == 0 {
+			rows = append(rows, Div(Text(msgr.AccessTokenNone)).Class("my-3 text-medium-emphasis"))
+		}
+
+		var expiryOptions []HTMLComponent
+		for _, d := range accessTokenExpiryDays {
+			expiryOptions = append(expiryOptions, v.VRadio().Label(fmt.Sprintf(msgr.AccessTokenExpiryDays, d)).Value(strconv.Itoa(d)))
+		}
+
+		r.PageTitle = msgr.AccessTokenTitle
+		r.Body = Div(
+			plogin.DefaultViewCommon.Notice(vh, loginMsgr, ctx.W, ctx.R),
+			Div(
+				Div(
+					H1(msgr.AccessTokenTitle).Class(plogin.DefaultViewCommon.TitleClass),
+					Label(msgr.AccessTokenPrompt),
+				),
+				created,
+				Div(rows...).Class("my-4"),
+				Form(
+					plogin.DefaultViewCommon.Input("name", msgr.AccessTokenNamePlaceholder, "").Class("mt-6"),
+					v.VRadioGroup(
+						v.VRadio().Label(msgr.AccessTokenScopeRead).Value(models.AccessTokenScopeRead),
+						v.VRadio().Label(msgr.AccessTokenScopeWrite).Value(models.AccessTokenScopeWrite),
+					).Name(accessTokenFormScope).ModelValue(models.AccessTokenScopeRead).Inline(true).Label(msgr.AccessTokenScope),
+					v.VRadioGroup(expiryOptions...).
+						Name(accessTokenFormDays).ModelValue(strconv.Itoa(accessTokenDefaultDays)).Inline(true).Label(msgr.AccessTokenExpiry),
+					plogin.DefaultViewCommon.FormSubmitBtn(msgr.AccessTokenCreateBtn),
+				).Method(http.MethodPost).Action(accessTokensPageURL),
+				A(Text(msgr.Back)).Href("/").Class("d-block mt-4"),
+			).Class(plogin.DefaultViewCommon.WrapperClass).Style(plogin.DefaultViewCommon.WrapperStyle).Class("text-center"),
+		)
+		return
+	})
+}
+
+// accessTokenScopeLabel 返回令牌权限范围的显示名称
+func accessTokenScopeLabel(msgr *Messages, t models.AccessToken) string {
+	if t.HasScope(models.AccessTokenScopeWrite) {
+		return msgr.AccessTokenScopeWrite
+	}
+	return msgr.AccessTokenScopeRead
+}
+
+// accessTokenUsage 返回令牌的过期时间和最近使用时间
+func accessTokenUsage(msgr *Messages, t models.AccessToken, now time.Time) string {
+	expiry := msgr.AccessTokenNeverExpires
+	if t.Expired(now) {
+		expiry = msgr.AccessTokenExpired
+	} else if t.ExpiresAt != nil {
+		expiry = fmt.Sprintf(msgr.AccessTokenExpiresAt, t.ExpiresAt.Local().Format(time.DateTime))
+	}
+	used := msgr.AccessTokenNeverUsed
+	if t.LastUsedAt != nil {
+		used = fmt.Sprintf(msgr.AccessTokenLastUsedAt, t.LastUsedAt.Local().Format(time.DateTime))
+	}
+	return expiry + "，" + used
+}
@@ -0,0 +1,134 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/x/v3/login"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+func TestAccessTokenMiddleware(t *testing.T) {
+	withTOTPRequiredRoles(t, models.RoleAdmin)
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.AccessToken{}, &models.WebAuthnCredential{}); err != nil {
+		t.Fatal(err)
+	}
+	admin := &models.User{Name: "Admin", Status: models.StatusActive, LDAPUserPass: models.LDAPUserPass{Account: "admin@example.com"}}
+	if err := db.Create(admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	adminRole := role.Role{Name: models.RoleAdmin}
+	db.Create(&adminRole)
+	db.Exec("INSERT INTO user_role_join (user_id, role_id) VALUES (?, ?)", admin.ID, adminRole.ID)
+
+	future := time.Now().Add(time.Hour)
+	readToken, _, err := createAccessToken(db, admin.ID, "只读", []string{models.AccessTokenScopeRead}, &future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeToken, saved, err := createAccessToken(db, admin.ID, "读写", []string{models.AccessTokenScopeRead, models.AccessTokenScopeWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.TokenHash == writeToken || !strings.HasPrefix(writeToken, saved.TokenHint) {
+		t.Fatalf("数据库中只能保存令牌的哈希和前几位: %+v", saved)
+	}
+
+	var seen *models.User
+	session := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/auth/login", http.StatusFound)
+		})
+	}
+	handler := withAccessToken(db, session)(withRoles(db)(requireTOTP(login.New().ViewHelper(), "/auth/logout")(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = getCurrentUser(r)
+			w.WriteHeader(http.StatusOK)
+		}))))
+	serve := func(method, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/admin/users", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("GET", ""); w.Code != http.StatusFound {
+		t.Errorf("没有令牌的请求应该交给登录会话中间件，实际 %d", w.Code)
+	}
+	if w := serve("GET", readToken); w.Code != http.StatusOK || seen == nil || seen.ID != admin.ID {
+		t.Fatalf("有效的令牌应该通过认证，实际 %d", w.Code)
+	}
+	if roles := seen.GetRoles(); len(roles) != 1 || roles[0] != models.RoleAdmin {
+		t.Errorf("令牌请求应该加载用户的角色，实际 %v", roles)
+	}
+	if w := serve("POST", readToken); w.Code != http.StatusForbidden {
+		t.Errorf("只读令牌不能发送POST请求，实际 %d", w.Code)
+	}
+	if w := serve("POST", writeToken); w.Code != http.StatusOK {
+		t.Errorf("读写令牌应该可以发送POST请求，实际 %d", w.Code)
+	}
+	if w := serve("GET", "qpat_unknown"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("无效的令牌应该返回401，实际 %d", w.Code)
+	}
+
+	var used models.AccessToken
+	db.First(&used, saved.ID)
+	if used.LastUsedAt == nil || used.LastUsedIP == "" {
+		t.Errorf("使用后应该记录最近使用时间和IP: %+v", used)
+	}
+
+	// 过期、撤销和停用
+	past := time.Now().Add(-time.Minute)
+	expiredToken, _, _ := createAccessToken(db, admin.ID, "过期", []string{models.AccessTokenScopeRead}, &past)
+	if w := serve("GET", expiredToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("过期的令牌应该返回401，实际 %d", w.Code)
+	}
+	db.Delete(saved)
+	if w := serve("POST", writeToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("撤销的令牌应该返回401，实际 %d", w.Code)
+	}
+	db.Model(admin).Update("status", models.StatusInactive)
+	if w := serve("GET", readToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("停用用户的令牌应该返回401，实际 %d", w.Code)
+	}
+}
+
+func TestAccessTokensPageRejectsTokens(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.AccessToken{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Name: "Alice", LDAPUserPass: models.LDAPUserPass{Account: "alice@example.com"}}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := createAccessToken(db, user.ID, "脚本", []string{models.AccessTokenScopeRead, models.AccessTokenScopeWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noSession := func(next http.Handler) http.Handler { return next }
+	handler := withAccessToken(db, noSession)(accessTokensHandler(db, http.NotFoundHandler()))
+	r := httptest.NewRequest("POST", accessTokensPageURL, strings.NewReader("name=new"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("令牌请求不能创建新的令牌，实际 %d", w.Code)
+	}
+	var count int64
+	db.Model(&models.AccessToken{}).Count(&count)
+	if count != 1 {
+		t.Errorf("令牌数量应该不变，实际 %d", count)
+	}
+}
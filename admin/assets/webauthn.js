@@ -0,0 +1,165 @@
+// WebAuthn页面脚本：注册安全密钥、通行密钥登录和双因素认证
+// 页面上带有data-webauthn属性的按钮点击后执行对应的流程，按钮由Vue渲染，因此使用事件委托
+(function () {
+  function toBuffer(value) {
+    var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+    while (base64.length % 4) {
+      base64 += '=';
+    }
+    var binary = atob(base64);
+    var bytes = new Uint8Array(binary.length);
+    for (var i = 0; i < binary.length; i++) {
+      bytes[i] = binary.charCodeAt(i);
+    }
+    return bytes.buffer;
+  }
+
+  function toBase64URL(buffer) {
+    if (!buffer) {
+      return undefined;
+    }
+    var bytes = new Uint8Array(buffer);
+    var binary = '';
+    for (var i = 0; i < bytes.length; i++) {
+      binary += String.fromCharCode(bytes[i]);
+    }
+    return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+  }
+
+  function post(url, body) {
+    return fetch(url, {
+      method: 'POST',
+      credentials: 'same-origin',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body || {}),
+    }).then(function (resp) {
+      return resp.json().then(function (data) {
+        if (!resp.ok) {
+          throw new Error(data.error || resp.statusText);
+        }
+        return data;
+      });
+    });
+  }
+
+  function decodeDescriptors(list) {
+    (list || []).forEach(function (c) {
+      c.id = toBuffer(c.id);
+    });
+  }
+
+  function getAssertion(options) {
+    var publicKey = options.publicKey;
+    publicKey.challenge = toBuffer(publicKey.challenge);
+    decodeDescriptors(publicKey.allowCredentials);
+    return navigator.credentials.get({ publicKey: publicKey }).then(function (cred) {
+      return {
+        id: cred.id,
+        rawId: toBase64URL(cred.rawId),
+        type: cred.type,
+        authenticatorAttachment: cred.authenticatorAttachment,
+        clientExtensionResults: cred.getClientExtensionResults(),
+        response: {
+          clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+          authenticatorData: toBase64URL(cred.response.authenticatorData),
+          signature: toBase64URL(cred.response.signature),
+          userHandle: toBase64URL(cred.response.userHandle),
+        },
+      };
+    });
+  }
+
+  function createCredential(options) {
+    var publicKey = options.publicKey;
+    publicKey.challenge = toBuffer(publicKey.challenge);
+    publicKey.user.id = toBuffer(publicKey.user.id);
+    decodeDescriptors(publicKey.excludeCredentials);
+    return navigator.credentials.create({ publicKey: publicKey }).then(function (cred) {
+      return {
+        id: cred.id,
+        rawId: toBase64URL(cred.rawId),
+        type: cred.type,
+        authenticatorAttachment: cred.authenticatorAttachment,
+        clientExtensionResults: cred.getClientExtensionResults(),
+        response: {
+          clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+          attestationObject: toBase64URL(cred.response.attestationObject),
+          transports: cred.response.getTransports ? cred.response.getTransports() : [],
+        },
+      };
+    });
+  }
+
+  // submitLogin 用一次性登录凭证代替密码提交登录表单
+  function submitLogin(url, account, ticket) {
+    var form = document.createElement('form');
+    form.method = 'POST';
+    form.action = url;
+    [['account', account], ['password', ticket]].forEach(function (field) {
+      var input = document.createElement('input');
+      input.type = 'hidden';
+      input.name = field[0];
+      input.value = field[1];
+      form.appendChild(input);
+    });
+    document.body.appendChild(form);
+    form.submit();
+  }
+
+  var flows = {
+    login: function (btn) {
+      return post(btn.dataset.begin)
+        .then(getAssertion)
+        .then(function (cred) {
+          return post(btn.dataset.finish, cred);
+        })
+        .then(function (data) {
+          submitLogin(btn.dataset.login, data.account, data.ticket);
+        });
+    },
+    verify: function (btn) {
+      return post(btn.dataset.begin)
+        .then(getAssertion)
+        .then(function (cred) {
+          return post(btn.dataset.finish, cred);
+        })
+        .then(function (data) {
+          window.location.href = data.redirect;
+        });
+    },
+    register: function (btn) {
+      var input = document.querySelector(btn.dataset.nameInput);
+      return post(btn.dataset.begin, { name: input ? input.value : '' })
+        .then(createCredential)
+        .then(function (cred) {
+          return post(btn.dataset.finish, cred);
+        })
+        .then(function (data) {
+          window.location.href = data.redirect;
+        });
+    },
+  };
+
+  document.addEventListener('click', function (e) {
+    var btn = e.target.closest('[data-webauthn]');
+    if (!btn || !flows[btn.dataset.webauthn]) {
+      return;
+    }
+    e.preventDefault();
+    if (!window.PublicKeyCredential) {
+      alert(btn.dataset.unsupported);
+      return;
+    }
+    btn.disabled = true;
+    flows[btn.dataset.webauthn](btn)
+      .catch(function (err) {
+        // 用户取消时浏览器返回NotAllowedError，不需要提示
+        if (err.name !== 'NotAllowedError') {
+          alert(err.message || btn.dataset.error);
+        }
+      })
+      .finally(function () {
+        btn.disabled = false;
+      });
+  });
+})();
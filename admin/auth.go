@@ -2,6 +2,7 @@ package admin
 
 import (
 	_ "embed"
+	"fmt"
 	"net/http"
 
 	"github.com/markbates/goth/providers/dingtalk"
@@ -42,6 +43,15 @@ var (
 	loginInitialUserPassword   = getEnvWithDefault("LOGIN_INITIAL_USER_PASSWORD", "")
 )
 
+// builtinOAuthIdentifiers 内置OAuth提供商与本地用户的OAuth标识匹配的字段
+var builtinOAuthIdentifiers = map[string]login.OAuthIdentifier{
+	models.OAuthProviderWecom:           login.OAuthIdentifierUserID,
+	models.OAuthProviderGoogle:          login.OAuthIdentifierEmail,
+	models.OAuthProviderMicrosoftOnline: login.OAuthIdentifierEmail,
+	models.OAuthProviderGithub:          login.OAuthIdentifierEmail,
+	models.OAuthProviderDingtalk:        login.OAuthIdentifierNickName,
+}
+
 func getCurrentUser(r *http.Request) (u *models.User) {
 	u, ok := login.GetCurrentUser(r).(*models.User)
 	if !ok {
@@ -104,47 +114,45 @@ func initLoginSessionBuilder(db *gorm.DB, pb *presets.Builder, ab *activity.Buil
 				})
 			}
 
+			providers = append(providers, oidcLoginProviders()...)
+			providers = append(providers, samlLoginProviders()...)
+
 			return providers
 		}()...).
 		HomeURLFunc(func(r *http.Request, user interface{}) string {
 			return "/"
 		}).
-		MaxRetryCount(5).
-		// TODO online  to set  true
+		// 连续输错密码后锁定账号，渐进延迟见login_throttle.go
+		MaxRetryCount(loginMaxRetryCount).
+		// 中国大陆无法访问reCAPTCHA，使用本地生成的验证码，见login_captcha.go
 		Recaptcha(false, login.RecaptchaConfig{
 			SiteKey:   recaptchaSiteKey,
 			SecretKey: recaptchaSecret,
 		}).
-		WrapBeforeSetPassword(func(in login.HookFunc) login.HookFunc {
-			return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
-				if err := in(r, user, extraVals...); err != nil {
-					return err
-				}
-				password := extraVals[0].(string)
-				if len(password) < 12 {
-					return &login.NoticeError{
-						Level:   login.NoticeLevel_Error,
-						Message: "Password cannot be less than 12 characters",
-					}
-				}
-				return nil
-			}
-		}).
-		OAuthIdentifier(models.OAuthProviderWecom, login.OAuthIdentifierUserID).
-		OAuthIdentifier(models.OAuthProviderGoogle, login.OAuthIdentifierEmail).
-		OAuthIdentifier(models.OAuthProviderMicrosoftOnline, login.OAuthIdentifierEmail).
-		OAuthIdentifier(models.OAuthProviderGithub, login.OAuthIdentifierEmail).
-		OAuthIdentifier(models.OAuthProviderDingtalk, login.OAuthIdentifierNickName).
-		AfterOAuthComplete(func(r *http.Request, user interface{}, extraVals ...interface{}) error {
-			return nil
-		}).
-		WrapAfterOAuthComplete(func(in login.HookFunc) login.HookFunc {
-			return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
-				return nil
-			}
-		}).TOTP(false).MaxRetryCount(0)
+		WrapBeforeSetPassword(enforcePasswordPolicy(db)).
+		WrapAfterChangePassword(recordPasswordChange(db)).
+		WrapAfterResetPassword(recordPasswordChange(db)).
+		// 没有配置SMTP时无法发送重置密码的邮件，见password_reset.go
+		NoForgetPasswordLink(currentMailer == nil).
+		WrapAfterConfirmSendResetPasswordLink(sendResetPasswordMail(db)).
+		// 后注册的钩子先执行：先检查邮箱域名，再创建SAML用户，最后按规则分配角色
+		WrapAfterOAuthComplete(syncSAMLUser(db)).
+		WrapAfterOAuthComplete(applyOAuthRoleRules(db)).
+		WrapAfterOAuthComplete(checkOAuthDomain).
+		WrapAfterLogin(rejectInactiveUser).
+		WrapAfterLogin(recordLoginSuccess(db)).
+		WrapAfterLogin(resetLoginThrottle).
+		WrapAfterFailedToLogin(recordLoginFailure(db)).
+		WrapAfterFailedToLogin(recordLoginThrottle).
+		// 登录模块的TOTP对所有用户生效，按角色要求的双因素认证见totp.go
+		TOTP(false)
+	models.SetLockDuration(loginLockDuration)
 
-	loginBuilder.LoginPageFunc(plogin.NewAdvancedLoginPage(func(ctx *web.EventContext, config *plogin.AdvancedLoginPageConfig) (*plogin.AdvancedLoginPageConfig, error) {
+	for _, key := range oauthProviderKeys() {
+		loginBuilder.OAuthIdentifier(key, oauthIdentifier(key))
+	}
+
+	loginPage := plogin.NewAdvancedLoginPage(func(ctx *web.EventContext, config *plogin.AdvancedLoginPageConfig) (*plogin.AdvancedLoginPageConfig, error) {
 		// 从自定义消息中获取登录标题
 		adminMsg := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
 		config.TitleLabel = adminMsg.LoginTitleLabel
@@ -176,7 +184,9 @@ func initLoginSessionBuilder(db *gorm.DB, pb *presets.Builder, ab *activity.Buil
 			}
 		}
 		return config, nil
-	})(loginBuilder.ViewHelper(), pb))
+	})
+	loginBuilder.LoginPageFunc(withPasskeyLogin(loginBuilder.ViewHelper(), withLoginCaptcha(loginPage(loginBuilder.ViewHelper(), pb))))
+	loginBuilder.ResetPasswordLinkSentPageFunc(resetPasswordLinkSentPage(loginBuilder.ViewHelper(), pb))
 
 	genInitialUser(db)
 
@@ -185,10 +195,25 @@ func initLoginSessionBuilder(db *gorm.DB, pb *presets.Builder, ab *activity.Buil
 		IsPublicUser(func(u interface{}) bool {
 			return false
 		}).
-		TablePrefix("cms_").
+		TablePrefix(loginSessionTablePrefix).
 		AutoMigrate()
 }
 
+// rejectInactiveUser 登录后检查用户状态，停用的用户和还没有接受邀请的用户不能登录
+func rejectInactiveUser(in login.HookFunc) login.HookFunc {
+	return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+		if u, ok := user.(*models.User); ok {
+			switch u.Status {
+			case models.StatusInactive:
+				return errUserInactive
+			case models.StatusPending:
+				return errUserPending
+			}
+		}
+		return in(r, user, extraVals...)
+	}
+}
+
 func genInitialUser(db *gorm.DB) {
 	email := loginInitialUserEmail
 	password := loginInitialUserPassword
@@ -204,10 +229,6 @@ func genInitialUser(db *gorm.DB) {
 	if count > 0 {
 		return
 	}
-	if err := initDefaultRoles(db); err != nil {
-		panic(err)
-	}
-
 	user := &models.User{
 		Name:   email,
 		Status: models.StatusActive,
@@ -216,10 +237,20 @@ func genInitialUser(db *gorm.DB) {
 			Password: password,
 		},
 	}
+	if err := currentPasswordPolicy.validate(Messages_en_US, user, password); err != nil {
+		panic(fmt.Errorf("LOGIN_INITIAL_USER_PASSWORD不符合密码策略: %w", err))
+	}
+	if err := initDefaultRoles(db); err != nil {
+		panic(err)
+	}
+
 	user.EncryptPassword()
 	if err := db.Create(user).Error; err != nil {
 		panic(err)
 	}
+	if err := currentPasswordPolicy.recordHistory(db, user); err != nil {
+		panic(err)
+	}
 	if err := grantUserRole(db, user.ID, models.RoleAdmin); err != nil {
 		panic(err)
 	}
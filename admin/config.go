@@ -7,10 +7,9 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
 	"slices"
 	"strconv"
-	"syscall"
+	"time"
 
 	"github.com/iancoleman/strcase"
 	"github.com/pkg/errors"
@@ -43,6 +42,8 @@ var assets embed.FS
 var recurringJobManager *recurring.RecurringJobManager
 
 // StopRecurringJobManager 停止重复任务管理器
+// 停止触发新的任务，并在 RECURRING_SHUTDOWN_GRACE_PERIOD 内等待正在执行的任务结束，
+// 超时仍未结束的执行记录会被标记为中断
 func StopRecurringJobManager() {
 	if recurringJobManager != nil {
 		log.Println("正在关闭全局重复任务管理器...")
@@ -55,6 +56,7 @@ type Config struct {
 	pb                  *presets.Builder
 	loginSessionBuilder *plogin.SessionBuilder
 	db                  *gorm.DB
+	ab                  *activity.Builder
 }
 
 func (c *Config) GetPresetsBuilder() *presets.Builder {
@@ -68,6 +70,8 @@ func (c *Config) GetLoginSessionBuilder() *plogin.SessionBuilder {
 var (
 	dbReset                   = getEnvWithDefault("DB_RESET", "")
 	resetAndImportInitialData = getEnvWithDefaultBool("RESET_AND_IMPORT_INITIAL_DATA", false)
+	recurringArtifactDir      = getEnvWithDefault("RECURRING_ARTIFACT_DIR", "data/recurring-artifacts")
+	recurringShutdownGrace    = getEnvWithDefaultDuration("RECURRING_SHUTDOWN_GRACE_PERIOD", recurring.DefaultShutdownGracePeriod)
 )
 
 func getEnvWithDefaultBool(key string, defaultValue bool) bool {
@@ -82,16 +86,45 @@ func getEnvWithDefaultBool(key string, defaultValue bool) bool {
 	return boolValue
 }
 
-func NewConfig(db *gorm.DB, enableWork bool) Config {
-	// 初始化LDAP配置
-	initLDAP()
+func getEnvWithDefaultInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return intValue
+}
+
+func getEnvWithDefaultDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return duration
+}
 
+func NewConfig(db *gorm.DB, enableWork bool) Config {
 	if err := db.AutoMigrate(
 		&models.User{},
 		&role.Role{},
 		&perm.DefaultDBPolicy{},
 		&models.RecurringJob{},
 		&models.RecurringJobExecution{},
+		&models.RecurringJobArtifact{},
+		&models.LDAPSetting{},
+		&models.LoginEvent{},
+		&models.OAuthRoleRule{},
+		&models.PasswordHistory{},
+		&models.WebAuthnCredential{},
+		&models.RecoveryCode{},
+		&models.AccessToken{},
 	); err != nil {
 		panic(err)
 	}
@@ -99,6 +132,9 @@ func NewConfig(db *gorm.DB, enableWork bool) Config {
 	// @snippet_begin(ActivityExample)
 	ab := activity.New(db, func(ctx context.Context) (*activity.User, error) {
 		u := ctx.Value(login.UserKey).(*models.User)
+		if admin := impersonatorFromContext(ctx); admin != nil {
+			return impersonationActivityUser(u, admin), nil
+		}
 		return &activity.User{
 			ID:     fmt.Sprint(u.ID),
 			Name:   u.Name,
@@ -132,6 +168,10 @@ func NewConfig(db *gorm.DB, enableWork bool) Config {
 	// ab.Model(l).SkipDelete().SkipCreate()
 	// @snippet_end
 
+	// 初始化认证日志和LDAP配置
+	initAuthLog()
+	initLDAP(db, ab)
+
 	b := presets.New().DataOperator(gorm2op.DataOperator(db)).RightDrawerWidth("700")
 	defer b.Build()
 
@@ -205,32 +245,34 @@ func NewConfig(db *gorm.DB, enableWork bool) Config {
 		b.Use(w.Activity(ab))
 
 		// 添加重复任务支持
-		recurringJobManager = recurring.NewRecurringJobManager(db, b)
+		recurringJobManager = recurring.NewRecurringJobManager(db, b,
+			recurring.WithArtifactStorage(recurring.NewLocalArtifactStorage(recurringArtifactDir)),
+			recurring.WithShutdownGracePeriod(recurringShutdownGrace),
+			recurring.WithNotifier(sendRecurringJobNotification),
+		)
+		// 任务函数需要在启动调度前注册，启动时才能调度已有的任务
+		recurringJobManager.RegisterFunction(ldapSyncFunctionName, ldapSyncJob)
 		if err := recurringJobManager.Init(ab); err != nil {
 			log.Printf("启动重复任务管理器失败: %v", err)
 		}
 
-		// 程序退出时关闭管理器，但不立即关闭
-		// 这个方法不会阻塞，只会在程序收到信号时执行
-		go func() {
-			// 创建一个信号通道
-			c := make(chan os.Signal, 1)
-			signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-
-			// 等待信号
-			<-c
-			log.Println("正在停止重复任务管理器...")
-			recurringJobManager.Stop()
-		}()
+		// 程序收到退出信号时由 main 调用 StopRecurringJobManager 优雅关闭管理器
 	}
 
 	loginSessionBuilder := initLoginSessionBuilder(db, b, ab)
+	ldapSessions = loginSessionBuilder
 
 	configBrand(b)
+	b.LayoutFunc(withImpersonationBanner(b.GetLayoutFunc()))
+	b.DetailLayoutFunc(withImpersonationBanner(b.GetDetailLayoutFunc()))
 
 	profileBuilder := configProfile(db, ab, loginSessionBuilder)
 
 	configUser(b, ab, db, loginSessionBuilder)
+	configLDAPSettings(b, ab, db)
+	configOAuthRoleRules(b, ab, db)
+	configLoginEvents(b)
+	configLoginLockout(b, ab, db)
 	b.Use(
 		ab,
 		roleBuilder,
@@ -248,6 +290,7 @@ func NewConfig(db *gorm.DB, enableWork bool) Config {
 		pb:                  b,
 		loginSessionBuilder: loginSessionBuilder,
 		db:                  db,
+		ab:                  ab,
 	}
 }
 
@@ -259,6 +302,10 @@ func configMenuOrder(b *presets.Builder) {
 		b.MenuGroup("User Management").SubItems(
 			"User",
 			"Role",
+			"LDAPSetting",
+			"OAuthRoleRule",
+			"LoginEvent",
+			"LoginLockout",
 		).Icon("mdi-account-multiple"),
 		b.MenuGroup("TaskManagement").SubItems(
 			"Worker",
@@ -426,6 +473,40 @@ func configProfile(db *gorm.DB, ab *activity.Builder, lsb *plogin.SessionBuilder
 		// 将修改密码按钮插入到原有按钮之前
 		newButtons := append([]h.HTMLComponent{changePasswordBtn}, buttons...)
 
+		// 还没有启用双因素认证的本地用户可以自愿启用
+		if u := getCurrentUser(web.MustGetEventContext(ctx).R); u != nil && !u.IsOAuthUser() && !u.IsTOTPSetup {
+			setupTOTPBtn := v.VBtn(msgr.ProfileSetupTOTP).
+				Variant(v.VariantTonal).
+				Color(v.ColorPrimary).
+				Href(totpSetupPageURL)
+			newButtons = append([]h.HTMLComponent{setupTOTPBtn}, newButtons...)
+		}
+
+		// 所有用户都可以创建个人访问令牌，供脚本调用管理后台
+		accessTokensBtn := v.VBtn(msgr.AccessTokenTitle).
+			Variant(v.VariantTonal).
+			Color(v.ColorPrimary).
+			Href(accessTokensPageURL)
+		newButtons = append([]h.HTMLComponent{accessTokensBtn}, newButtons...)
+
+		// 启用了TOTP的用户可以查看剩余的恢复码并重新生成
+		if u := getCurrentUser(web.MustGetEventContext(ctx).R); u != nil && !u.IsOAuthUser() && u.IsTOTPSetup {
+			recoveryCodesBtn := v.VBtn(msgr.RecoveryCodesTitle).
+				Variant(v.VariantTonal).
+				Color(v.ColorPrimary).
+				Href(recoveryCodesPageURL)
+			newButtons = append([]h.HTMLComponent{recoveryCodesBtn}, newButtons...)
+		}
+
+		// 本地用户可以注册安全密钥，用于免密码登录和双因素认证
+		if u := getCurrentUser(web.MustGetEventContext(ctx).R); webauthnEligible(u) && webauthnEnabled() {
+			webauthnBtn := v.VBtn(msgr.WebAuthnTitle).
+				Variant(v.VariantTonal).
+				Color(v.ColorPrimary).
+				Href(webauthnCredentialsPageURL)
+			newButtons = append([]h.HTMLComponent{webauthnBtn}, newButtons...)
+		}
+
 		return newButtons, nil
 	})
 }
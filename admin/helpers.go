@@ -3,9 +3,17 @@ package admin
 import (
 	"crypto/sha256"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/admin/v3/role"
+	"gorm.io/gorm"
 )
 
 type DataTableHeader struct {
@@ -21,17 +29,81 @@ func getStringHash(v string, len int) string {
 	return fmt.Sprintf("%x", h.Sum(nil))[:len]
 }
 
-func ip(r *http.Request) string {
-	if p := proxy(r); len(p) > 0 {
-		return strings.TrimSpace(p[0])
+// splitList 解析逗号分隔的配置项，忽略空白项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
+
+// defaultTrustedProxies 默认只信任本机的反向代理
+// 内网中的其他主机也可能直接访问应用并伪造X-Forwarded-For，反向代理在其他主机上时需要设置TRUSTED_PROXIES
+const defaultTrustedProxies = "127.0.0.0/8,::1/128"
 
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+// trustedProxies 可信的反向代理，只有请求来自这些地址时才读取X-Forwarded-For
+var trustedProxies = parseTrustedProxies(getEnvWithDefault("TRUSTED_PROXIES", defaultTrustedProxies))
+
+// parseTrustedProxies 解析逗号分隔的IP地址和网段，忽略格式错误的项
+func parseTrustedProxies(s string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(s) {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				log.Printf("忽略无效的可信代理地址: %s", item)
+				continue
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			log.Printf("忽略无效的可信代理网段: %s", item)
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// isTrustedProxy 判断地址是否属于可信的反向代理
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(trustedProxies, func(p netip.Prefix) bool {
+		return p.Contains(addr)
+	})
+}
+
+// ip 返回客户端IP
+// 直接连接的地址是可信代理时，从右向左读取X-Forwarded-For，返回第一个不是可信代理的地址；
+// 否则X-Forwarded-For可能是客户端伪造的，直接使用连接的地址
+func ip(r *http.Request) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return r.RemoteAddr
+		client = r.RemoteAddr
 	}
 
-	return ip
+	hops := proxy(r)
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(client); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if host, _, err := net.SplitHostPort(hop); err == nil {
+			hop = host
+		}
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+	}
+
+	return client
 }
 
 func proxy(r *http.Request) []string {
@@ -41,3 +113,94 @@ func proxy(r *http.Request) []string {
 
 	return nil
 }
+
+// createUserWithRoles 在一个事务中创建自动开通的用户并授予默认角色
+// 参数：
+// - db: 数据库连接
+// - user: 要创建的用户，创建后会设置用户ID和注册时间
+// - roleNames: 默认角色名称，不存在的角色会被忽略并输出警告
+// 返回：
+// - error: 创建用户或授予角色失败时的错误信息
+func createUserWithRoles(db *gorm.DB, user *models.User, roleNames []string) error {
+	user.RegistrationDate = time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if len(roleNames) == 0 {
+			return nil
+		}
+		var roles []role.Role
+		if err := tx.Where("name IN ?", roleNames).Find(&roles).Error; err != nil {
+			return err
+		}
+		if len(roles) != len(roleNames) {
+			models.AuthLog().Warn("默认角色中的部分角色不存在", "roles", roleNames)
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+		return tx.Model(user).Association("Roles").Append(roles)
+	})
+}
+
+// syncMappedRoles 按映射同步用户角色：添加映射授予但用户还没有的角色，需要时移除映射中不再授予的角色
+// 没有出现在映射中的角色（例如管理员手动授予的角色）不受影响
+// 参数：
+// - db: 数据库连接
+// - user: 要同步的用户，需要预加载Roles
+// - granted: 映射授予用户的角色名称
+// - managed: 映射中出现的全部角色名称
+// - revoke: 是否移除managed中不再授予的角色
+// 返回：
+// - added: 添加的角色名称，不存在的角色会被忽略并输出警告
+// - revoked: 移除的角色名称
+// - err: 添加或移除角色失败时的错误信息
+func syncMappedRoles(db *gorm.DB, user *models.User, granted, managed []string, revoke bool) (added, revoked []string, err error) {
+	var current []string
+	for _, r := range user.Roles {
+		current = append(current, r.Name)
+	}
+	var missing []string
+	for _, name := range granted {
+		if !slices.Contains(current, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		var roles []role.Role
+		if err := db.Where("name IN ?", missing).Find(&roles).Error; err != nil {
+			return nil, nil, err
+		}
+		if len(roles) != len(missing) {
+			models.AuthLog().Warn("角色映射中的部分角色不存在", "roles", missing)
+		}
+		if len(roles) > 0 {
+			if err := db.Model(user).Association("Roles").Append(roles); err != nil {
+				return nil, nil, err
+			}
+			for _, r := range roles {
+				added = append(added, r.Name)
+			}
+		}
+	}
+
+	if !revoke {
+		return added, nil, nil
+	}
+	var removed []role.Role
+	for _, r := range user.Roles {
+		if slices.Contains(managed, r.Name) && !slices.Contains(granted, r.Name) {
+			removed = append(removed, r)
+		}
+	}
+	if len(removed) > 0 {
+		if err := db.Model(user).Association("Roles").Delete(removed); err != nil {
+			return added, nil, err
+		}
+		for _, r := range removed {
+			revoked = append(revoked, r.Name)
+		}
+	}
+	return added, revoked, nil
+}
@@ -0,0 +1,301 @@
+package admin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/v3/activity"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+模拟用户说明：
+
+管理员可以以其他用户的身份访问管理后台，排查权限问题时不需要用户截图：
+
+1. 配置方式
+   - IMPERSONATION_MAX_AGE: 模拟会话的最长时间，默认1h
+
+2. 使用方式
+   - 管理员在用户列表的行菜单中选择"模拟用户"，之后访问的页面使用被模拟用户的角色和权限
+   - 模拟期间每个页面顶部显示提示条，点击"停止模拟"回到管理员自己的身份
+   - 不能模拟管理员、已停用的用户和自己
+
+3. 安全限制
+   - 模拟状态保存在签名Cookie中，绑定管理员的密码更新时间和会话盐，管理员退出或修改密码后失效
+   - 每次请求都重新检查管理员和被模拟用户的角色，管理员的双因素认证过期后暂停模拟
+   - /auth/ 下的页面（修改密码、双因素认证、安全密钥、访问令牌等）始终使用管理员自己的身份，
+     管理员不能修改被模拟用户的登录凭据
+   - 令牌请求不支持模拟
+
+4. 审计
+   - 开始和停止模拟记录在操作日志和认证日志中
+   - 模拟期间的操作日志同时记录管理员和被模拟用户：
+     操作者ID为"被模拟用户ID@管理员ID"，操作者名称为"管理员 → 被模拟用户"
+*/
+
+// 模拟用户的Cookie和停止模拟的地址
+const (
+	impersonateCookieName = "qor5boot_impersonate"
+	impersonateStopURL    = "/auth/impersonate/stop"
+)
+
+// impersonateEvent 用户列表行菜单开始模拟的事件
+const impersonateEvent = "user_impersonate"
+
+// 模拟用户的操作日志类型
+const (
+	impersonateActionStart = "StartImpersonation"
+	impersonateActionStop  = "StopImpersonation"
+)
+
+var impersonationMaxAge = getEnvWithDefaultDuration("IMPERSONATION_MAX_AGE", time.Hour)
+
+var (
+	errImpersonateNotAdmin = errors.New("只有管理员可以模拟用户")
+	errImpersonateTarget   = errors.New("不能模拟管理员、已停用的用户或自己")
+)
+
+// impersonatorKey 模拟期间在请求上下文中保存管理员的键
+type impersonatorKey struct{}
+
+// impersonatorFromContext 返回正在模拟其他用户的管理员，没有模拟时返回nil
+func impersonatorFromContext(ctx context.Context) *models.User {
+	u, _ := ctx.Value(impersonatorKey{}).(*models.User)
+	return u
+}
+
+// canImpersonate 检查管理员是否可以模拟目标用户，需要先加载双方的角色
+func canImpersonate(admin, target *models.User) error {
+	if admin == nil || !slices.Contains(admin.GetRoles(), models.RoleAdmin) {
+		return errImpersonateNotAdmin
+	}
+	if target == nil || target.ID == 0 || target.ID == admin.ID || target.Status == models.StatusInactive ||
+		slices.Contains(target.GetRoles(), models.RoleAdmin) {
+		return errImpersonateTarget
+	}
+	return nil
+}
+
+// impersonationSignature 计算模拟Cookie的签名
+// 签名包含管理员的密码更新时间和会话盐，管理员修改密码或退出所有会话后已有的Cookie失效
+func impersonationSignature(admin *models.User, targetID uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(loginSecret))
+	fmt.Fprintf(mac, "impersonate|%d|%d|%d|%s|%s", admin.ID, targetID, expires, admin.PassUpdatedAt, admin.GetSecure())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setImpersonation 设置模拟用户的Cookie
+func setImpersonation(w http.ResponseWriter, admin *models.User, targetID uint, now time.Time) {
+	expires := now.Add(impersonationMaxAge).Unix()
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonateCookieName,
+		Value:    fmt.Sprintf("%d.%d.%d.%s", admin.ID, targetID, expires, impersonationSignature(admin, targetID, expires)),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearImpersonation 删除模拟用户的Cookie
+func clearImpersonation(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonateCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// impersonationTarget 读取当前管理员正在模拟的用户ID
+// 返回：
+// - uint: 被模拟用户的ID
+// - bool: Cookie存在、属于当前管理员、没有过期并且签名正确时为true
+func impersonationTarget(r *http.Request, admin *models.User, now time.Time) (uint, bool) {
+	c, err := r.Cookie(impersonateCookieName)
+	if err != nil {
+		return 0, false
+	}
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 4 || parts[0] != strconv.FormatUint(uint64(admin.ID), 10) {
+		return 0, false
+	}
+	targetID, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return 0, false
+	}
+	if !hmac.Equal([]byte(parts[3]), []byte(impersonationSignature(admin, uint(targetID), expires))) {
+		return 0, false
+	}
+	return uint(targetID), true
+}
+
+// withImpersonation 管理员正在模拟其他用户时，把请求的当前用户替换为被模拟的用户
+// 需要放在登录中间件之后、withRoles之前；/auth/ 下的页面和令牌请求不替换
+func withImpersonation(db *gorm.DB) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			admin := getCurrentUser(r)
+			if admin == nil || accessTokenFromContext(r) != nil || strings.HasPrefix(r.URL.Path, "/auth/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			now := time.Now()
+			targetID, ok := impersonationTarget(r, admin, now)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := loadUserRoles(db, admin); err != nil {
+				panic(err)
+			}
+			// 管理员自己的双因素认证过期后暂停模拟，由requireTOTP要求管理员重新验证
+			if totpEnforced(admin) && !totpValidated(r, admin, now) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var target models.User
+			if err := db.First(&target, targetID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				panic(err)
+			}
+			if err := loadUserRoles(db, &target); err != nil {
+				panic(err)
+			}
+			if err := canImpersonate(admin, &target); err != nil {
+				models.AuthLog().Warn("模拟用户已失效", "account", models.MaskAccount(admin.Account), "target", targetID, "error", err)
+				clearImpersonation(w)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), login.UserKey, &target)
+			ctx = context.WithValue(ctx, impersonatorKey{}, admin)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// startImpersonation 开始模拟用户，记录操作日志和认证日志
+// 参数：
+// - ctx: 管理员的请求
+// - db: 数据库连接
+// - ab: 操作日志
+// - id: 被模拟用户的ID
+// 返回：
+// - error: 用户不存在或者不能模拟时的错误信息
+func startImpersonation(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, id string) error {
+	admin := getCurrentUser(ctx.R)
+	if impersonatorFromContext(ctx.R.Context()) != nil {
+		return errImpersonateNotAdmin
+	}
+	var target models.User
+	if err := db.Preload("Roles").First(&target, "id = ?", id).Error; err != nil {
+		return err
+	}
+	if err := canImpersonate(admin, &target); err != nil {
+		return err
+	}
+
+	setImpersonation(ctx.W, admin, target.ID, time.Now())
+	models.AuthLog().Warn("开始模拟用户",
+		"account", models.MaskAccount(admin.Account),
+		"target", models.MaskAccount(target.Account),
+		"ip", ip(ctx.R),
+	)
+	if _, err := ab.Log(ctx.R.Context(), impersonateActionStart, &target, nil); err != nil {
+		models.AuthLog().Error("记录模拟用户的操作日志失败", "error", err)
+	}
+	return nil
+}
+
+// impersonateStop 停止模拟，回到管理员自己的身份
+// 停止地址在 /auth/ 下，请求的当前用户是管理员自己
+func impersonateStop(db *gorm.DB, ab *activity.Builder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := getCurrentUser(r)
+		if admin == nil || r.Method != http.MethodPost {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		clearImpersonation(w)
+
+		if targetID, ok := impersonationTarget(r, admin, time.Now()); ok {
+			var target models.User
+			if err := db.First(&target, targetID).Error; err == nil {
+				models.AuthLog().Info("停止模拟用户",
+					"account", models.MaskAccount(admin.Account),
+					"target", models.MaskAccount(target.Account),
+				)
+				if _, err := ab.Log(r.Context(), impersonateActionStop, &target, nil); err != nil {
+					models.AuthLog().Error("记录模拟用户的操作日志失败", "error", err)
+				}
+			}
+		}
+		http.Redirect(w, r, "/users", http.StatusFound)
+	}
+}
+
+// impersonationActivityUser 模拟期间操作日志的操作者，同时记录管理员和被模拟的用户
+func impersonationActivityUser(target, admin *models.User) *activity.User {
+	return &activity.User{
+		ID:   fmt.Sprintf("%d@%d", target.ID, admin.ID),
+		Name: admin.Name + " → " + target.Name,
+	}
+}
+
+// impersonationBanner 模拟期间显示在页面顶部的提示条，没有模拟时返回nil
+func impersonationBanner(ctx *web.EventContext) h.HTMLComponent {
+	admin := impersonatorFromContext(ctx.R.Context())
+	target := getCurrentUser(ctx.R)
+	if admin == nil || target == nil {
+		return nil
+	}
+	msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+	return v.VAlert(
+		h.Div(
+			h.Span(fmt.Sprintf(msgr.ImpersonationBanner, target.Name, target.Account)),
+			h.Form(
+				v.VBtn(msgr.ImpersonationStopBtn).Size(v.SizeSmall).Variant(v.VariantFlat).Color("warning").Attr("type", "submit"),
+			).Method(http.MethodPost).Action(impersonateStopURL),
+		).Class("d-flex justify-space-between align-center"),
+	).Type("warning").Variant(v.VariantTonal).Density(v.DensityCompact).Icon("mdi-account-switch").Class("ma-2")
+}
+
+// withImpersonationBanner 在布局中显示模拟用户的提示条
+func withImpersonationBanner(layout func(in web.PageFunc, cfg *presets.LayoutConfig) web.PageFunc) func(in web.PageFunc, cfg *presets.LayoutConfig) web.PageFunc {
+	return func(in web.PageFunc, cfg *presets.LayoutConfig) web.PageFunc {
+		return layout(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+			if r, err = in(ctx); err != nil {
+				return
+			}
+			if banner := impersonationBanner(ctx); banner != nil {
+				r.Body = h.Components(banner, r.Body)
+			}
+			return
+		}, cfg)
+	}
+}
@@ -0,0 +1,178 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/v3/activity"
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/login"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// createTestUserWithRole 创建带角色的用户
+func createTestUserWithRole(t *testing.T, db *gorm.DB, name, roleName string) *models.User {
+	t.Helper()
+	u := &models.User{Name: name, Status: models.StatusActive, LDAPUserPass: models.LDAPUserPass{Account: name + "@example.com"}}
+	if err := db.Create(u).Error; err != nil {
+		t.Fatal(err)
+	}
+	var r role.Role
+	db.FirstOrCreate(&r, role.Role{Name: roleName})
+	db.Exec("INSERT INTO user_role_join (user_id, role_id) VALUES (?, ?)", u.ID, r.ID)
+	return u
+}
+
+func TestCanImpersonate(t *testing.T) {
+	admin := &models.User{Model: gorm.Model{ID: 1}, Roles: []role.Role{{Name: models.RoleAdmin}}}
+	manager := &models.User{Model: gorm.Model{ID: 2}, Roles: []role.Role{{Name: models.RoleManager}}}
+	viewer := &models.User{Model: gorm.Model{ID: 3}, Status: models.StatusActive}
+	otherAdmin := &models.User{Model: gorm.Model{ID: 4}, Roles: []role.Role{{Name: models.RoleAdmin}}}
+	inactive := &models.User{Model: gorm.Model{ID: 5}, Status: models.StatusInactive}
+
+	if err := canImpersonate(admin, viewer); err != nil {
+		t.Errorf("管理员应该可以模拟普通用户: %v", err)
+	}
+	if err := canImpersonate(admin, manager); err != nil {
+		t.Errorf("管理员应该可以模拟经理: %v", err)
+	}
+	if err := canImpersonate(manager, viewer); err != errImpersonateNotAdmin {
+		t.Errorf("经理不能模拟用户，实际 %v", err)
+	}
+	for _, target := range []*models.User{otherAdmin, inactive, admin, {}} {
+		if err := canImpersonate(admin, target); err != errImpersonateTarget {
+			t.Errorf("不能模拟用户 %d，实际 %v", target.ID, err)
+		}
+	}
+}
+
+func TestImpersonationCookie(t *testing.T) {
+	admin := &models.User{Model: gorm.Model{ID: 1}}
+	now := time.Now()
+	w := httptest.NewRecorder()
+	setImpersonation(w, admin, 7, now)
+
+	request := func(cookies ...*http.Cookie) *http.Request {
+		r := httptest.NewRequest("GET", "/users", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return r
+	}
+	cookie := w.Result().Cookies()[0]
+	if id, ok := impersonationTarget(request(cookie), admin, now); !ok || id != 7 {
+		t.Fatalf("应该读取到被模拟的用户，实际 %d %v", id, ok)
+	}
+	if _, ok := impersonationTarget(request(cookie), admin, now.Add(impersonationMaxAge+time.Second)); ok {
+		t.Errorf("过期的模拟Cookie不能使用")
+	}
+	if _, ok := impersonationTarget(request(cookie), &models.User{Model: gorm.Model{ID: 2}}, now); ok {
+		t.Errorf("模拟Cookie只能由设置它的管理员使用")
+	}
+	// 修改密码后签名失效
+	changed := &models.User{Model: gorm.Model{ID: 1}}
+	changed.PassUpdatedAt = "changed"
+	if _, ok := impersonationTarget(request(cookie), changed, now); ok {
+		t.Errorf("管理员修改密码后模拟Cookie应该失效")
+	}
+	forged := *cookie
+	forged.Value = "1.8" + cookie.Value[3:]
+	if _, ok := impersonationTarget(request(&forged), admin, now); ok {
+		t.Errorf("修改被模拟用户后签名应该失效")
+	}
+}
+
+func TestImpersonationMiddleware(t *testing.T) {
+	withTOTPRequiredRoles(t)
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.WebAuthnCredential{}); err != nil {
+		t.Fatal(err)
+	}
+	admin := createTestUserWithRole(t, db, "admin", models.RoleAdmin)
+	viewer := createTestUserWithRole(t, db, "viewer", models.RoleViewer)
+	otherAdmin := createTestUserWithRole(t, db, "other", models.RoleAdmin)
+
+	var seen, impersonator *models.User
+	handler := withImpersonation(db)(withRoles(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = getCurrentUser(r)
+		impersonator = impersonatorFromContext(r.Context())
+	})))
+	serve := func(path string, targetID uint) *httptest.ResponseRecorder {
+		cw := httptest.NewRecorder()
+		setImpersonation(cw, admin, targetID, time.Now())
+		r := httptest.NewRequest("GET", path, nil)
+		r.AddCookie(cw.Result().Cookies()[0])
+		r = r.WithContext(context.WithValue(r.Context(), login.UserKey, &models.User{Model: admin.Model, Name: admin.Name}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	serve("/users", viewer.ID)
+	if seen == nil || seen.ID != viewer.ID || impersonator == nil || impersonator.ID != admin.ID {
+		t.Fatalf("模拟期间当前用户应该是被模拟的用户，实际 %+v %+v", seen, impersonator)
+	}
+	if roles := seen.GetRoles(); len(roles) != 1 || roles[0] != models.RoleViewer {
+		t.Errorf("应该使用被模拟用户的角色，实际 %v", roles)
+	}
+
+	serve("/auth/change-password", viewer.ID)
+	if seen.ID != admin.ID || impersonator != nil {
+		t.Errorf("/auth/ 下的页面应该使用管理员自己的身份")
+	}
+
+	w := serve("/users", otherAdmin.ID)
+	if seen.ID != admin.ID || impersonator != nil || hasCookie(w, impersonateCookieName) {
+		t.Errorf("不能模拟管理员，应该删除模拟Cookie")
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("失效的模拟Cookie应该被删除: %v", c)
+	}
+}
+
+func TestStartImpersonation(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.WebAuthnCredential{}); err != nil {
+		t.Fatal(err)
+	}
+	ab := newTestActivity(t, db)
+	admin := createTestUserWithRole(t, db, "admin", models.RoleAdmin)
+	viewer := createTestUserWithRole(t, db, "viewer", models.RoleViewer)
+	if err := loadUserRoles(db, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/users", nil)
+	r = r.WithContext(context.WithValue(r.Context(), login.UserKey, admin))
+	if err := startImpersonation(&web.EventContext{R: r, W: w}, db, ab, "999"); err == nil {
+		t.Errorf("不存在的用户不能模拟")
+	}
+	if err := startImpersonation(&web.EventContext{R: r, W: w}, db, ab, "1"); err != errImpersonateTarget {
+		t.Errorf("不能模拟自己，实际 %v", err)
+	}
+	if err := startImpersonation(&web.EventContext{R: r, W: w}, db, ab, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if !hasCookie(w, impersonateCookieName) {
+		t.Errorf("开始模拟后应该设置模拟Cookie")
+	}
+
+	var logs []activity.ActivityLog
+	db.Where("action = ?", impersonateActionStart).Find(&logs)
+	if len(logs) != 1 || logs[0].ModelKeys != fmt.Sprint(viewer.ID) || logs[0].UserID != fmt.Sprint(admin.ID) {
+		t.Errorf("开始模拟应该记录在操作日志中: %+v", logs)
+	}
+
+	u := impersonationActivityUser(viewer, admin)
+	if u.ID != "2@1" || u.Name != "admin → viewer" {
+		t.Errorf("模拟期间的操作者应该同时包含管理员和被模拟用户: %+v", u)
+	}
+}
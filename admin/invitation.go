@@ -0,0 +1,422 @@
+package admin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/v3/activity"
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	. "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+邀请新用户说明：
+
+管理员不需要为新用户设置密码，而是通过邮件发送邀请链接，由用户自己设置密码：
+
+1. 配置方式
+   - INVITATION_MAX_AGE: 邀请链接的有效期，默认72h
+   - 需要配置SMTP发送邮件，见mailer.go
+   - 邀请链接使用BASE_URL，没有配置时使用管理员访问后台的地址
+
+2. 邀请流程
+   - 管理员在用户列表点击"邀请用户"，填写姓名、邮箱和角色，创建状态为pending的用户并发送邀请邮件
+   - 邮件发送失败时不创建用户
+   - 还没有接受邀请的用户可以在行菜单中重新发送邀请，重新发送不会使之前的链接失效
+   - pending状态的用户不能登录，登录时提示使用邀请邮件中的链接设置密码
+
+3. 接受邀请
+   - 邀请链接在登录之前访问，需要跳过登录中间件
+   - 用户按密码策略设置密码后状态变为active，跳转到登录页面
+   - 用户可以选择登录后立即设置双因素认证；角色要求双因素认证的用户登录后总是需要设置
+   - 链接的签名包含用户的状态和密码更新时间，接受邀请后链接失效
+
+4. 审计
+   - 邀请、重新发送和接受邀请记录在操作日志和认证日志中
+*/
+
+// 邀请页面地址，在登录之前访问
+const invitationPageURL = "/auth/invitation"
+
+// loginContinueURLCookie 登录模块登录成功后跳转地址使用的Cookie
+const loginContinueURLCookie = "qor5_continue_url"
+
+// 用户列表的邀请操作和重新发送邀请的事件
+const (
+	inviteAction          = "Invite"
+	invitationResendEvent = "user_resend_invitation"
+)
+
+// 邀请用户的操作日志类型
+const (
+	invitationActionInvite = "InviteUser"
+	invitationActionResend = "ResendInvitation"
+	invitationActionAccept = "AcceptInvitation"
+)
+
+var invitationMaxAge = getEnvWithDefaultDuration("INVITATION_MAX_AGE", 72*time.Hour)
+
+var (
+	errInvitationInvalid  = errors.New("邀请链接无效或已过期")
+	errInvitationAccount  = errors.New("邮箱格式错误")
+	errInvitationExists   = errors.New("该邮箱已经存在用户")
+	errInvitationNotFound = errors.New("用户不存在或已经接受邀请")
+)
+
+// errUserPending 还没有接受邀请的用户登录时返回的错误，登录事件中记为用户已停用
+var errUserPending = &login.NoticeError{
+	Level:   login.NoticeLevel_Error,
+	Message: Messages_en_US.InvitationPendingLogin,
+}
+
+// invitationErrorKey 接受邀请失败时在请求上下文中保存错误提示的键
+type invitationErrorKey struct{}
+
+// invitationSignature 计算邀请链接的签名
+// 签名包含用户的账号、状态和密码更新时间，用户接受邀请后链接失效
+func invitationSignature(u *models.User, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(loginSecret))
+	fmt.Fprintf(mac, "invitation|%d|%d|%s|%s|%s", u.ID, expires, u.Account, u.Status, u.PassUpdatedAt)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// invitationToken 生成邀请链接中的凭证，格式为"用户ID.过期时间.签名"
+func invitationToken(u *models.User, now time.Time) string {
+	expires := now.Add(invitationMaxAge).Unix()
+	return fmt.Sprintf("%d.%d.%s", u.ID, expires, invitationSignature(u, expires))
+}
+
+// parseInvitationToken 验证邀请链接中的凭证
+// 参数：
+// - db: 数据库连接
+// - token: 邀请链接中的凭证
+// - now: 当前时间
+// 返回：
+// - *models.User: 被邀请的用户
+// - error: 凭证格式错误、签名错误、已过期或用户已经接受邀请时返回errInvitationInvalid
+func parseInvitationToken(db *gorm.DB, token string, now time.Time) (*models.User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvitationInvalid
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, errInvitationInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return nil, errInvitationInvalid
+	}
+
+	var u models.User
+	if err := db.First(&u, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvitationInvalid
+		}
+		return nil, err
+	}
+	if u.Status != models.StatusPending ||
+		!hmac.Equal([]byte(parts[2]), []byte(invitationSignature(&u, expires))) {
+		return nil, errInvitationInvalid
+	}
+	return &u, nil
+}
+
+// invitationLink 生成邀请链接，没有配置BASE_URL时使用当前请求的地址
+func invitationLink(r *http.Request, token string) string {
+	base := strings.TrimSuffix(baseURL, "/")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + invitationPageURL + "?token=" + url.QueryEscape(token)
+}
+
+// sendInvitation 向被邀请的用户发送邀请邮件，邮件使用管理员当前的语言
+func sendInvitation(r *http.Request, u *models.User, now time.Time) error {
+	msgr := i18n.MustGetModuleMessages(r, I18nAdminKey, Messages_zh_CN).(*Messages)
+	link := invitationLink(r, invitationToken(u, now))
+	return sendMail(r.Context(), &Mail{
+		To:      (&mail.Address{Name: u.Name, Address: u.Account}).String(),
+		Subject: msgr.InvitationMailSubject,
+		Body:    fmt.Sprintf(msgr.InvitationMailBody, u.Name, link, now.Add(invitationMaxAge).Format("2006-01-02 15:04")),
+	})
+}
+
+// inviteUser 创建状态为pending的用户并发送邀请邮件，邮件发送失败时不创建用户
+// 参数：
+// - ctx: 管理员的请求
+// - db: 数据库连接
+// - ab: 操作日志
+// - name: 用户姓名，为空时使用邮箱
+// - account: 用户邮箱
+// - roleNames: 授予的角色名称
+// 返回：
+// - *models.User: 创建的用户
+// - error: 邮箱格式错误、用户已存在、创建用户或发送邮件失败时的错误信息
+func inviteUser(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, name, account string, roleNames []string) (*models.User, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(account))
+	if err != nil {
+		return nil, errInvitationAccount
+	}
+	account = addr.Address
+	if name = strings.TrimSpace(name); name == "" {
+		name = account
+	}
+
+	var count int64
+	if err := db.Unscoped().Model(&models.User{}).Where("account = ?", account).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errInvitationExists
+	}
+
+	u := &models.User{
+		Name:         name,
+		Status:       models.StatusPending,
+		LDAPUserPass: models.LDAPUserPass{Account: account},
+	}
+	now := time.Now()
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := createUserWithRoles(tx, u, roleNames); err != nil {
+			return err
+		}
+		return sendInvitation(ctx.R, u, now)
+	}); err != nil {
+		return nil, err
+	}
+
+	models.AuthLog().Info("邀请新用户",
+		"account", models.MaskAccount(getCurrentUser(ctx.R).Account),
+		"target", models.MaskAccount(u.Account),
+	)
+	if _, err := ab.Log(ctx.R.Context(), invitationActionInvite, u, nil); err != nil {
+		models.AuthLog().Error("记录邀请用户的操作日志失败", "error", err)
+	}
+	return u, nil
+}
+
+// resendInvitation 向还没有接受邀请的用户重新发送邀请邮件
+func resendInvitation(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, id string) error {
+	var u models.User
+	if err := db.Where("status = ?", models.StatusPending).First(&u, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvitationNotFound
+		}
+		return err
+	}
+	if err := sendInvitation(ctx.R, &u, time.Now()); err != nil {
+		return err
+	}
+
+	models.AuthLog().Info("重新发送邀请",
+		"account", models.MaskAccount(getCurrentUser(ctx.R).Account),
+		"target", models.MaskAccount(u.Account),
+	)
+	if _, err := ab.Log(ctx.R.Context(), invitationActionResend, &u, nil); err != nil {
+		models.AuthLog().Error("记录重新发送邀请的操作日志失败", "error", err)
+	}
+	return nil
+}
+
+// acceptInvitation 按密码策略设置被邀请用户的密码并激活用户
+// 参数：
+// - db: 数据库连接
+// - msgr: 提示信息使用的消息
+// - token: 邀请链接中的凭证
+// - password: 新密码
+// - confirm: 确认密码
+// 返回：
+// - *models.User: 激活的用户
+// - error: 凭证无效、两次输入的密码不一致或不符合密码策略时返回登录模块可以显示的错误，保存失败时返回数据库错误
+func acceptInvitation(db *gorm.DB, msgr *Messages, token, password, confirm string) (*models.User, error) {
+	u, err := parseInvitationToken(db, token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if password != confirm {
+		return nil, passwordError(msgr.InvitationPasswordMismatch)
+	}
+	if err := currentPasswordPolicy.check(db, msgr, u, password); err != nil {
+		return nil, err
+	}
+
+	u.Password = password
+	u.EncryptPassword()
+	u.Status = models.StatusActive
+	// 只更新密码和状态，条件中的状态保证同一个链接只能使用一次
+	res := db.Model(&models.User{}).Where("id = ? AND status = ?", u.ID, models.StatusPending).Updates(map[string]interface{}{
+		"password":        u.Password,
+		"pass_updated_at": u.PassUpdatedAt,
+		"status":          u.Status,
+	})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, errInvitationInvalid
+	}
+	if err := currentPasswordPolicy.recordHistory(db, u); err != nil {
+		models.AuthLog().Error("保存密码历史失败", "account", models.MaskAccount(u.Account), "error", err)
+	}
+	return u, nil
+}
+
+// invitationHandler 显示邀请页面，处理设置密码的表单
+// 设置密码后跳转到登录页面；选择了立即设置双因素认证时，登录后跳转到TOTP设置页面
+func invitationHandler(db *gorm.DB, ab *activity.Builder, page http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			page.ServeHTTP(w, r)
+			return
+		}
+
+		msgr := passwordPolicyMessages(r)
+		u, err := acceptInvitation(db, msgr, r.FormValue("token"), r.FormValue("password"), r.FormValue("confirm_password"))
+		if err != nil {
+			var notice *login.NoticeError
+			switch {
+			case errors.As(err, &notice):
+				err = errors.New(notice.Message)
+			case errors.Is(err, errInvitationInvalid):
+				err = errors.New(msgr.InvitationInvalid)
+			default:
+				models.AuthLog().Error("接受邀请失败", "error", err)
+				err = errors.New(msgr.InvitationFailed)
+			}
+			page.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), invitationErrorKey{}, err.Error())))
+			return
+		}
+
+		models.AuthLog().Info("接受邀请", "account", models.MaskAccount(u.Account), "ip", ip(r))
+		if _, err := ab.Log(context.WithValue(r.Context(), login.UserKey, u), invitationActionAccept, u, nil); err != nil {
+			models.AuthLog().Error("记录接受邀请的操作日志失败", "error", err)
+		}
+
+		if r.FormValue("enroll_totp") != "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     loginContinueURLCookie,
+				Value:    totpSetupPageURL,
+				Path:     "/",
+				HttpOnly: true,
+			})
+		}
+		setLoginNotice(w, login.NoticeLevel_Info, Messages_en_US.InvitationAccepted)
+		http.Redirect(w, r, "/auth/login", http.StatusFound)
+	}
+}
+
+// invitationPage 邀请页面，显示设置密码的表单
+func invitationPage(pb *presets.Builder, vh *login.ViewHelper, db *gorm.DB) web.PageFunc {
+	return pb.PlainLayout(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		loginMsgr := i18n.MustGetModuleMessages(ctx.R, login.I18nLoginKey, login.Messages_en_US).(*login.Messages)
+		r.PageTitle = msgr.InvitationTitle
+
+		token := ctx.R.FormValue("token")
+		u, err := parseInvitationToken(db, token, time.Now())
+		if err != nil {
+			if !errors.Is(err, errInvitationInvalid) {
+				return r, err
+			}
+			r.Body = Div(
+				Div(
+					H1(msgr.InvitationTitle).Class(plogin.DefaultViewCommon.TitleClass),
+					plogin.DefaultViewCommon.ErrNotice(msgr.InvitationInvalid),
+					A(Text(msgr.Back)).Href("/auth/login").Class("d-block mt-4"),
+				).Class(plogin.DefaultViewCommon.WrapperClass).Style(plogin.DefaultViewCommon.WrapperStyle).Class("text-center"),
+			)
+			return r, nil
+		}
+		if err := loadUserRoles(db, u); err != nil {
+			return r, err
+		}
+
+		var errNotice HTMLComponent
+		if msg, ok := ctx.R.Context().Value(invitationErrorKey{}).(string); ok {
+			errNotice = plogin.DefaultViewCommon.ErrNotice(msg)
+		}
+		// 角色要求双因素认证的用户登录后总是需要设置，不显示选项
+		var enrollTOTP HTMLComponent
+		if !totpEnforced(u) {
+			enrollTOTP = v.VCheckbox().Name("enroll_totp").Value("1").Label(msgr.InvitationEnrollTOTP).HideDetails(true)
+		}
+
+		r.Body = Div(
+			plogin.DefaultViewCommon.Notice(vh, loginMsgr, ctx.W, ctx.R),
+			errNotice,
+			Div(
+				Div(
+					H1(msgr.InvitationTitle).Class(plogin.DefaultViewCommon.TitleClass),
+					Label(fmt.Sprintf(msgr.InvitationPrompt, u.Name, u.Account)),
+				),
+				Form(
+					Input("token").Type("hidden").Value(token),
+					Div(
+						Label(loginMsgr.ResetPasswordLabel).Class(plogin.DefaultViewCommon.LabelClass).For("password"),
+						plogin.DefaultViewCommon.PasswordInput("password", loginMsgr.ResetPasswordPlaceholder, "", true).
+							Tips(currentPasswordPolicy.hint(msgr)),
+					),
+					Div(
+						Label(loginMsgr.ResetPasswordConfirmLabel).Class(plogin.DefaultViewCommon.LabelClass).For("confirm_password"),
+						plogin.DefaultViewCommon.PasswordInput("confirm_password", loginMsgr.ResetPasswordConfirmPlaceholder, "", true),
+					).Class("mt-6"),
+					enrollTOTP,
+					plogin.DefaultViewCommon.FormSubmitBtn(msgr.InvitationSubmitBtn),
+				).Method(http.MethodPost).Action(invitationPageURL),
+			).Class(plogin.DefaultViewCommon.WrapperClass).Style(plogin.DefaultViewCommon.WrapperStyle),
+		)
+		return
+	})
+}
+
+// mountInvitation 挂载邀请页面
+func mountInvitation(mux *http.ServeMux, pb *presets.Builder, vh *login.ViewHelper, db *gorm.DB, ab *activity.Builder) {
+	page := vh.I18n().EnsureLanguage(web.New().Page(invitationPage(pb, vh, db)))
+	mux.Handle(invitationPageURL, vh.I18n().EnsureLanguage(invitationHandler(db, ab, page)))
+}
+
+// invitationDialog 用户列表邀请操作的对话框，提交失败时保留已经填写的内容
+func invitationDialog(db *gorm.DB) presets.ActionComponentFunc {
+	return func(id string, ctx *web.EventContext) HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		var roles []role.Role
+		if err := db.Order("id").Find(&roles).Error; err != nil {
+			panic(err)
+		}
+		var items []string
+		for _, r := range roles {
+			items = append(items, r.Name)
+		}
+		return Div(
+			Div(Text(msgr.InvitationDialogPrompt)).Class("mb-4"),
+			v.VTextField().Attr(web.VField("InviteName", ctx.R.FormValue("InviteName"))...).Label(msgr.Name),
+			v.VTextField().Attr(web.VField("InviteAccount", ctx.R.FormValue("InviteAccount"))...).Label(msgr.Account).Type("email"),
+			v.VAutocomplete().Attr(web.VField("InviteRoles", ctx.R.Form["InviteRoles"])...).
+				Label(msgr.Roles).Items(items).Multiple(true).Chips(true),
+		)
+	}
+}
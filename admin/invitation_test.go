@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/login"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// useMemoryMailer 测试期间把邮件保存在内存中
+func useMemoryMailer(t *testing.T) *memoryMailer {
+	t.Helper()
+	old := currentMailer
+	m := &memoryMailer{}
+	currentMailer = m
+	t.Cleanup(func() { currentMailer = old })
+	return m
+}
+
+// invitationTokenPattern 邀请邮件中的链接
+var invitationTokenPattern = regexp.MustCompile(`https?://\S+` + invitationPageURL + `\?token=(\S+)`)
+
+// newInviteContext 创建管理员邀请用户的请求
+func newInviteContext(t *testing.T, db *gorm.DB) *web.EventContext {
+	t.Helper()
+	admin := createTestUserWithRole(t, db, "admin", models.RoleAdmin)
+	r := httptest.NewRequest("POST", "/users", nil)
+	r = r.WithContext(context.WithValue(r.Context(), login.UserKey, admin))
+	return &web.EventContext{R: r, W: httptest.NewRecorder()}
+}
+
+func TestInviteUser(t *testing.T) {
+	mailer := useMemoryMailer(t)
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.WebAuthnCredential{}); err != nil {
+		t.Fatal(err)
+	}
+	ab := newTestActivity(t, db)
+	db.Create(&role.Role{Name: models.RoleEditor})
+	ctx := newInviteContext(t, db)
+
+	u, err := inviteUser(ctx, db, ab, " Alice ", "Alice <alice@example.com>", []string{models.RoleEditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "Alice" || u.Account != "alice@example.com" || u.Status != models.StatusPending || u.Password != "" {
+		t.Errorf("应该创建没有密码的pending用户: %+v", u)
+	}
+	if err := loadUserRoles(db, u); err != nil {
+		t.Fatal(err)
+	}
+	if roles := u.GetRoles(); len(roles) != 1 || roles[0] != models.RoleEditor {
+		t.Errorf("应该授予邀请时选择的角色，实际 %v", roles)
+	}
+
+	sent := mailer.Sent()
+	if len(sent) != 1 || !strings.Contains(sent[0].To, "alice@example.com") {
+		t.Fatalf("应该向被邀请的用户发送邮件: %+v", sent)
+	}
+	m := invitationTokenPattern.FindStringSubmatch(sent[0].Body)
+	if m == nil {
+		t.Fatalf("邮件中应该包含邀请链接: %s", sent[0].Body)
+	}
+	token, _ := url.QueryUnescape(m[1])
+	if got, err := parseInvitationToken(db, token, time.Now()); err != nil || got.ID != u.ID {
+		t.Errorf("邀请链接应该有效: %v", err)
+	}
+
+	if _, err := inviteUser(ctx, db, ab, "", "alice@example.com", nil); err != errInvitationExists {
+		t.Errorf("不能重复邀请已经存在的邮箱，实际 %v", err)
+	}
+	if _, err := inviteUser(ctx, db, ab, "", "not-an-email", nil); err != errInvitationAccount {
+		t.Errorf("邮箱格式错误时不能邀请，实际 %v", err)
+	}
+
+	// 邮件发送失败时不创建用户
+	currentMailer = nil
+	if _, err := inviteUser(ctx, db, ab, "", "bob@example.com", nil); !errors.Is(err, errMailerNotConfigured) {
+		t.Errorf("没有配置SMTP时应该返回错误，实际 %v", err)
+	}
+	var count int64
+	db.Model(&models.User{}).Where("account = ?", "bob@example.com").Count(&count)
+	if count != 0 {
+		t.Errorf("邮件发送失败时不应该创建用户")
+	}
+}
+
+func TestInvitationToken(t *testing.T) {
+	db := newTestDB(t)
+	u := &models.User{Name: "Alice", Status: models.StatusPending, LDAPUserPass: models.LDAPUserPass{Account: "alice@example.com"}}
+	if err := db.Create(u).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token := invitationToken(u, now)
+
+	if _, err := parseInvitationToken(db, token, now.Add(invitationMaxAge+time.Second)); err != errInvitationInvalid {
+		t.Errorf("过期的邀请链接不能使用，实际 %v", err)
+	}
+	parts := strings.SplitN(token, ".", 3)
+	for _, forged := range []string{"", "abc", parts[0] + "." + parts[1] + ".bad", "999." + parts[1] + "." + parts[2]} {
+		if _, err := parseInvitationToken(db, forged, now); err != errInvitationInvalid {
+			t.Errorf("无效的邀请链接 %q 不能使用，实际 %v", forged, err)
+		}
+	}
+	db.Model(u).Update("status", models.StatusActive)
+	if _, err := parseInvitationToken(db, token, now); err != errInvitationInvalid {
+		t.Errorf("已经激活的用户不能再使用邀请链接，实际 %v", err)
+	}
+}
+
+func TestInvitationHandler(t *testing.T) {
+	db := newTestDB(t)
+	ab := newTestActivity(t, db)
+	u := &models.User{Name: "Alice", Status: models.StatusPending, LDAPUserPass: models.LDAPUserPass{Account: "alice@example.com"}}
+	if err := db.Create(u).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := invitationToken(u, time.Now())
+
+	var pageErr string
+	page := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pageErr, _ = r.Context().Value(invitationErrorKey{}).(string)
+	})
+	handler := invitationHandler(db, ab, page)
+	post := func(values url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", invitationPageURL, strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		pageErr = ""
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	const password = "Correct-Horse-Battery"
+
+	post(url.Values{"token": {token}, "password": {password}, "confirm_password": {"other"}})
+	if pageErr != Messages_en_US.InvitationPasswordMismatch {
+		t.Errorf("两次输入的密码不一致时应该显示错误，实际 %q", pageErr)
+	}
+	post(url.Values{"token": {token}, "password": {"short"}, "confirm_password": {"short"}})
+	if pageErr == "" {
+		t.Errorf("不符合密码策略的密码不能设置")
+	}
+
+	w := post(url.Values{"token": {token}, "password": {password}, "confirm_password": {password}, "enroll_totp": {"1"}})
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/auth/login" {
+		t.Fatalf("设置密码后应该跳转到登录页面，实际 %d %q", w.Code, pageErr)
+	}
+	if !hasCookie(w, loginContinueURLCookie) || !hasCookie(w, loginNoticeFlashCookie) {
+		t.Errorf("选择设置双因素认证时应该设置登录后的跳转地址")
+	}
+	var saved models.User
+	db.First(&saved, u.ID)
+	if saved.Status != models.StatusActive || !saved.IsPasswordCorrect(password) {
+		t.Errorf("接受邀请后用户应该激活并使用新密码: %+v", saved)
+	}
+
+	post(url.Values{"token": {token}, "password": {password + "2"}, "confirm_password": {password + "2"}})
+	if pageErr != Messages_en_US.InvitationInvalid {
+		t.Errorf("邀请链接只能使用一次，实际 %q", pageErr)
+	}
+}
+
+func TestRejectPendingUser(t *testing.T) {
+	next := func(r *http.Request, user interface{}, extraVals ...interface{}) error { return nil }
+	r := httptest.NewRequest("POST", "/auth/userpass/login", nil)
+	if err := rejectInactiveUser(next)(r, &models.User{Status: models.StatusPending}); err != errUserPending {
+		t.Errorf("还没有接受邀请的用户不能登录，应该提示使用邀请邮件，实际 %v", err)
+	}
+	if err := rejectInactiveUser(next)(r, &models.User{Status: models.StatusInactive}); err != errUserInactive {
+		t.Errorf("停用的用户不能登录，实际 %v", err)
+	}
+	if err := rejectInactiveUser(next)(r, &models.User{Status: models.StatusActive}); err != nil {
+		t.Errorf("已激活的用户应该可以登录，实际 %v", err)
+	}
+}
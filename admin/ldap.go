@@ -1,14 +1,24 @@
 package admin
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/admin/v3/activity"
+	"github.com/qor5/x/v3/login"
+	"gorm.io/gorm"
 )
 
 /*
@@ -18,11 +28,13 @@ LDAP认证逻辑说明：
    - 从环境变量加载LDAP配置参数
    - 包括服务器地址、端口、绑定DN、搜索基础等
    - 支持TLS配置和证书验证
+   - 在LDAP设置页面保存过配置后，使用数据库中的配置代替环境变量（详见ldap_settings.go）
+   - 配置和连接池一起原子替换，保存设置后无需重启即可生效
 
 2. 认证流程
    a. 连接阶段
-      - 建立与LDAP服务器的TCP连接
-      - 如果配置了TLS，建立安全连接
+      - 从连接池获取连接，没有可用的空闲连接时按顺序连接配置的服务器
+      - 如果配置了LDAPS或StartTLS，建立安全连接
       - 使用服务账号或匿名绑定到LDAP服务器
 
    b. 用户搜索
@@ -34,6 +46,15 @@ LDAP认证逻辑说明：
       - 使用用户提供的凭据尝试绑定
       - 验证成功则返回true，失败返回false
 
+   d. 用户开通
+      - 开启自动开通后，本地不存在但目录中存在的用户首次认证成功时在本地创建
+      - 姓名、公司和邮箱取自目录，授予配置的默认角色，并记录到操作日志
+
+   e. 角色同步
+      - 从用户的memberOf属性或组搜索中获取用户所属的LDAP组
+      - 按照配置的组-角色映射，为用户添加对应的角色
+      - 如果开启了移除选项，移除映射中目录已不再授予的角色（未出现在映射中的角色不受影响）
+
 3. 错误处理
    - 连接错误：返回连接错误
    - 搜索错误：返回搜索错误
@@ -42,190 +63,704 @@ LDAP认证逻辑说明：
    - 绑定失败：返回false
 
 4. 日志记录
-   - 记录每个关键步骤的状态
-   - 记录错误信息和异常情况
-   - 记录用户属性和DN信息（用于调试）
+   - 认证过程使用结构化的认证日志（models.AuthLog），账号脱敏后输出
+   - info级别只记录认证结果、用户开通和角色变更
+   - 连接、搜索、绑定等步骤记录在debug级别，用户属性只记录属性名和值的数量
+   - 每次登录的结果另外保存在登录事件表中（详见login_event.go）
 
 5. 安全考虑
    - 支持TLS加密
    - 支持证书验证
    - 支持服务账号认证
    - 密码验证通过LDAP服务器进行
+   - 代入过滤器的账号和DN都经过转义（ldapFilter），防止特殊字符改变过滤器的含义
 
 6. 配置项说明
    - LDAP_ENABLED: 是否启用LDAP认证
    - LDAP_SERVER: LDAP服务器地址
    - LDAP_PORT: LDAP服务器端口
+   - LDAP_SERVERS: 按优先级排列的多个服务器地址，用逗号分隔，配置后代替LDAP_SERVER（详见ldap_pool.go）
    - LDAP_BIND_DN: 服务账号DN
    - LDAP_BIND_PASSWORD: 服务账号密码
    - LDAP_SEARCH_BASE: 搜索基础DN
    - LDAP_SEARCH_FILTER: 用户搜索过滤器
-   - LDAP_USE_TLS: 是否使用TLS
+   - LDAP_USE_TLS: 是否使用StartTLS，ldaps://地址总是使用隐式TLS
    - LDAP_SKIP_VERIFY: 是否跳过TLS证书验证
    - LDAP_CERT_FILE: TLS证书文件路径
+   - LDAP_DIAL_TIMEOUT、LDAP_REQUEST_TIMEOUT: 连接和请求的超时时间
+   - LDAP_POOL_SIZE、LDAP_POOL_IDLE_TIMEOUT: 连接池的最大空闲连接数和空闲连接保留时间
+   - LDAP_GROUP_ATTRIBUTE: 用户条目中表示所属组的属性，默认memberOf
+   - LDAP_GROUP_SEARCH_BASE: 组搜索基础DN，配置后通过组搜索获取用户所属的组
+   - LDAP_GROUP_SEARCH_FILTER: 组搜索过滤器，%s替换为用户DN，默认(member=%s)
+   - LDAP_GROUP_ROLE_MAPPING: 组到角色的映射，格式为"组:角色"，多个映射用分号分隔，
+     组可以是完整的DN或组的CN，例如"cn=admins,ou=groups,dc=example,dc=com:Admin;editors:Editor"
+   - LDAP_REVOKE_ROLES: 登录时是否移除目录不再授予的映射角色
+   - LDAP_JIT_PROVISIONING: 本地不存在的目录用户首次LDAP登录成功时是否自动创建
+   - LDAP_DEFAULT_ROLES: 自动创建的用户的默认角色，多个角色用逗号分隔，默认Viewer
+   - LDAP_NAME_ATTRIBUTE: 用户姓名属性，默认displayName，为空时使用cn
+   - LDAP_COMPANY_ATTRIBUTE: 用户公司属性，默认company
 */
 
-// LDAP配置环境变量
+// LDAP配置的默认值
+const (
+	defaultLDAPSearchFilter      = "(mail=%s)" // 使用email作为默认搜索属性
+	defaultLDAPGroupAttribute    = "memberOf"
+	defaultLDAPGroupSearchFilter = "(member=%s)"
+	defaultLDAPNameAttribute     = "displayName"
+	defaultLDAPCompanyAttribute  = "company"
+)
+
+// LDAP配置环境变量，LDAP设置页面没有保存过配置时使用
 var (
 	// 基本配置
 	ldapEnabled      = getEnvWithDefault("LDAP_ENABLED", "false") == "true"
 	ldapServer       = getEnvWithDefault("LDAP_SERVER", "")
 	ldapPort         = getEnvWithDefault("LDAP_PORT", "389")
+	ldapServers      = getEnvWithDefault("LDAP_SERVERS", "")
 	ldapBindDN       = getEnvWithDefault("LDAP_BIND_DN", "")
 	ldapBindPassword = getEnvWithDefault("LDAP_BIND_PASSWORD", "")
 	ldapSearchBase   = getEnvWithDefault("LDAP_SEARCH_BASE", "")
-
-	// 使用email作为默认搜索属性
-	ldapSearchFilter = getEnvWithDefault("LDAP_SEARCH_FILTER", "(mail=%s)")
+	ldapSearchFilter = getEnvWithDefault("LDAP_SEARCH_FILTER", defaultLDAPSearchFilter)
 
 	// TLS配置
 	ldapUseTLS     = getEnvWithDefault("LDAP_USE_TLS", "false") == "true"
 	ldapSkipVerify = getEnvWithDefault("LDAP_SKIP_VERIFY", "false") == "true"
 	ldapCertFile   = getEnvWithDefault("LDAP_CERT_FILE", "")
+
+	// 连接池配置
+	ldapDialTimeout     = getEnvWithDefaultDuration("LDAP_DIAL_TIMEOUT", 5*time.Second)
+	ldapRequestTimeout  = getEnvWithDefaultDuration("LDAP_REQUEST_TIMEOUT", 10*time.Second)
+	ldapPoolSize        = getEnvWithDefaultInt("LDAP_POOL_SIZE", 5)
+	ldapPoolIdleTimeout = getEnvWithDefaultDuration("LDAP_POOL_IDLE_TIMEOUT", 5*time.Minute)
+
+	// 组与角色映射配置
+	ldapGroupAttribute    = getEnvWithDefault("LDAP_GROUP_ATTRIBUTE", defaultLDAPGroupAttribute)
+	ldapGroupSearchBase   = getEnvWithDefault("LDAP_GROUP_SEARCH_BASE", "")
+	ldapGroupSearchFilter = getEnvWithDefault("LDAP_GROUP_SEARCH_FILTER", defaultLDAPGroupSearchFilter)
+	ldapGroupRoleMapping  = getEnvWithDefault("LDAP_GROUP_ROLE_MAPPING", "")
+	ldapRevokeRoles       = getEnvWithDefault("LDAP_REVOKE_ROLES", "false") == "true"
+
+	// 首次登录自动开通用户配置
+	ldapJITProvisioning = getEnvWithDefault("LDAP_JIT_PROVISIONING", "false") == "true"
+	ldapDefaultRoles    = getEnvWithDefault("LDAP_DEFAULT_ROLES", models.RoleViewer)
+	ldapNameAttribute   = getEnvWithDefault("LDAP_NAME_ATTRIBUTE", defaultLDAPNameAttribute)
+	ldapCompanyAttr     = getEnvWithDefault("LDAP_COMPANY_ATTRIBUTE", defaultLDAPCompanyAttribute)
+
+	// 用于登录时同步角色的数据库连接
+	ldapDB *gorm.DB
+	// 用于记录自动开通用户的操作日志
+	ldapActivity *activity.Builder
+
+	// 当前生效的LDAP配置和连接池，在initLDAP和保存LDAP设置时替换
+	ldapCurrent atomic.Pointer[ldapState]
 )
 
-// 初始化LDAP配置，在应用启动时调用
-func initLDAP() {
-	// 检查LDAP配置
-	if ldapEnabled {
-		log.Printf("初始化LDAP配置: 启用LDAP认证")
-		log.Printf("LDAP服务器: %s:%s", ldapServer, ldapPort)
-		log.Printf("LDAP搜索基础: %s", ldapSearchBase)
-		log.Printf("LDAP搜索过滤器: %s", ldapSearchFilter)
-		log.Printf("LDAP使用TLS: %v", ldapUseTLS)
-
-		// 验证必要配置
-		if ldapServer == "" {
-			log.Printf("警告: LDAP已启用，但服务器地址为空")
-			ldapEnabled = false
+// ldapConfig LDAP认证配置，来自环境变量或LDAP设置页面保存的配置
+type ldapConfig struct {
+	Enabled      bool
+	Servers      []ldapServerAddr
+	BindDN       string
+	BindPassword string
+	SearchBase   string
+	SearchFilter string
+
+	UseTLS     bool
+	SkipVerify bool
+	CertFile   string
+
+	GroupAttribute    string
+	GroupSearchBase   string
+	GroupSearchFilter string
+	GroupRoleMapping  []ldapGroupRole
+	RevokeRoles       bool
+
+	JITProvisioning  bool
+	DefaultRoles     []string
+	NameAttribute    string
+	CompanyAttribute string
+
+	SyncFilter string
+}
+
+// ldapEnvConfig 从环境变量读取LDAP配置
+func ldapEnvConfig() ldapConfig {
+	servers := ldapServers
+	if servers == "" {
+		servers = ldapServer
+	}
+	return ldapConfig{
+		Enabled:           ldapEnabled,
+		Servers:           parseLDAPServers(servers, ldapPort),
+		BindDN:            ldapBindDN,
+		BindPassword:      ldapBindPassword,
+		SearchBase:        ldapSearchBase,
+		SearchFilter:      ldapSearchFilter,
+		UseTLS:            ldapUseTLS,
+		SkipVerify:        ldapSkipVerify,
+		CertFile:          ldapCertFile,
+		GroupAttribute:    ldapGroupAttribute,
+		GroupSearchBase:   ldapGroupSearchBase,
+		GroupSearchFilter: ldapGroupSearchFilter,
+		GroupRoleMapping:  parseLDAPGroupRoleMapping(ldapGroupRoleMapping),
+		RevokeRoles:       ldapRevokeRoles,
+		JITProvisioning:   ldapJITProvisioning,
+		DefaultRoles:      splitList(ldapDefaultRoles),
+		NameAttribute:     ldapNameAttribute,
+		CompanyAttribute:  ldapCompanyAttr,
+		SyncFilter:        ldapSyncFilter,
+	}
+}
+
+// serverURLs 返回按优先级排列的服务器连接地址
+func (c *ldapConfig) serverURLs() []string {
+	urls := make([]string, 0, len(c.Servers))
+	for _, addr := range c.Servers {
+		urls = append(urls, addr.URL())
+	}
+	return urls
+}
+
+// syncFilter 返回目录同步时搜索用户的过滤器，未配置时将搜索过滤器中的%s替换为*
+func (c *ldapConfig) syncFilter() string {
+	if c.SyncFilter != "" {
+		return c.SyncFilter
+	}
+	return strings.ReplaceAll(c.SearchFilter, "%s", "*")
+}
+
+// validate 检查启用LDAP认证时必需的配置
+func (c *ldapConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Servers) == 0 {
+		return fmt.Errorf("LDAP已启用，但服务器地址为空")
+	}
+	if strings.Count(c.SearchFilter, "%s") != 1 {
+		return fmt.Errorf("用户搜索过滤器需要包含一个%%s: %s", c.SearchFilter)
+	}
+	if c.GroupSearchBase != "" && strings.Count(c.GroupSearchFilter, "%s") != 1 {
+		return fmt.Errorf("组搜索过滤器需要包含一个%%s: %s", c.GroupSearchFilter)
+	}
+	return nil
+}
+
+// tlsConfig 根据配置创建LDAPS和StartTLS使用的TLS配置
+func (c *ldapConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.SkipVerify,
+	}
+
+	// 如果指定了证书文件，加载证书
+	if c.CertFile != "" {
+		cert, err := os.ReadFile(c.CertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(cert) {
+			return nil, fmt.Errorf("无法解析LDAP证书")
+		}
+
+		tlsConfig.RootCAs = certPool
+	}
+	return tlsConfig, nil
+}
+
+// poolConfig 返回创建连接池使用的配置，超时和连接数量取自环境变量
+func (c *ldapConfig) poolConfig() (ldapPoolConfig, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return ldapPoolConfig{}, fmt.Errorf("加载LDAP TLS配置失败: %w", err)
+	}
+	return ldapPoolConfig{
+		Servers:        c.Servers,
+		BindDN:         c.BindDN,
+		BindPassword:   c.BindPassword,
+		StartTLS:       c.UseTLS,
+		TLSConfig:      tlsConfig,
+		DialTimeout:    ldapDialTimeout,
+		RequestTimeout: ldapRequestTimeout,
+		MaxIdle:        ldapPoolSize,
+		IdleTimeout:    ldapPoolIdleTimeout,
+	}, nil
+}
+
+// ldapState 当前生效的LDAP配置和对应的连接池
+// 一次认证或同步过程中使用同一个ldapState，不受中途保存设置的影响
+type ldapState struct {
+	cfg    ldapConfig
+	client *ldapPool
+}
+
+// newLDAPState 按配置创建连接池
+// 参数：
+// - cfg: LDAP配置，未启用时不创建连接池
+// 返回：
+// - *ldapState: 配置和连接池
+// - error: 配置无效或TLS配置加载失败时的错误信息
+func newLDAPState(cfg ldapConfig) (*ldapState, error) {
+	st := &ldapState{cfg: cfg}
+	if !cfg.Enabled {
+		return st, nil
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	poolConfig, err := cfg.poolConfig()
+	if err != nil {
+		return nil, err
+	}
+	st.client = newLDAPPool(poolConfig)
+	return st, nil
+}
+
+// currentLDAP 返回当前生效的LDAP配置和连接池
+func currentLDAP() *ldapState {
+	if st := ldapCurrent.Load(); st != nil {
+		return st
+	}
+	return &ldapState{}
+}
+
+// enabled 判断LDAP认证是否可用
+func (s *ldapState) enabled() bool {
+	return s.cfg.Enabled && s.client != nil
+}
+
+// conn 从连接池获取以服务账号绑定的连接
+// 调用方使用完后调用 Close 将连接放回连接池
+func (s *ldapState) conn() (*ldapConn, error) {
+	if !s.enabled() {
+		return nil, fmt.Errorf("LDAP认证未启用")
+	}
+	return s.client.Get()
+}
+
+// setLDAPState 替换当前生效的LDAP配置和连接池，并关闭旧的连接池
+// 正在使用旧连接池的请求不受影响，归还的连接会被直接关闭
+func setLDAPState(st *ldapState) {
+	if old := ldapCurrent.Swap(st); old != nil && old.client != nil {
+		old.client.Close()
+	}
+
+	// 设置LDAP配置到models包中
+	models.SetLDAPConfig(st.enabled(), strings.Join(st.cfg.serverURLs(), ","), authenticateWithLDAP)
+	if st.enabled() && st.cfg.JITProvisioning {
+		models.SetLDAPProvisioning(lookupLDAPUser, provisionLDAPUser)
+	} else {
+		models.SetLDAPProvisioning(nil, nil)
+	}
+}
+
+// ldapGroupRole LDAP组到角色的映射
+type ldapGroupRole struct {
+	Group string // 组的DN或CN
+	Role  string // 角色名称
+}
+
+// parseLDAPGroupRoleMapping 解析组到角色的映射配置
+// 格式为"组:角色"，多个映射用分号分隔，组中可以包含逗号和等号
+func parseLDAPGroupRoleMapping(s string) []ldapGroupRole {
+	var mappings []ldapGroupRole
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, ":")
+		if i <= 0 || i == len(item)-1 {
+			log.Printf("警告: 忽略格式错误的LDAP组角色映射: %s", item)
+			continue
+		}
+		mappings = append(mappings, ldapGroupRole{
+			Group: strings.TrimSpace(item[:i]),
+			Role:  strings.TrimSpace(item[i+1:]),
+		})
+	}
+	return mappings
+}
+
+// formatLDAPGroupRoleMapping 将组到角色的映射格式化为配置字符串，是 parseLDAPGroupRoleMapping 的逆操作
+func formatLDAPGroupRoleMapping(mappings []ldapGroupRole) string {
+	items := make([]string, 0, len(mappings))
+	for _, m := range mappings {
+		items = append(items, m.Group+":"+m.Role)
+	}
+	return strings.Join(items, ";")
+}
+
+// matches 判断映射中的组是否与用户所属的组匹配，DN和CN均不区分大小写
+func (m ldapGroupRole) matches(groupDN string) bool {
+	if strings.EqualFold(m.Group, groupDN) {
+		return true
+	}
+	dn, err := ldap.ParseDN(groupDN)
+	if err != nil || len(dn.RDNs) == 0 {
+		return false
+	}
+	for _, attr := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") && strings.EqualFold(attr.Value, m.Group) {
+			return true
 		}
+	}
+	return false
+}
 
-		if ldapSearchBase == "" {
+// mapLDAPGroupsToRoles 根据映射计算用户应拥有的角色
+// 返回：
+// - granted: 用户所属的组授予的角色
+// - managed: 映射中出现的所有角色，只有这些角色会被同步移除
+func mapLDAPGroupsToRoles(mappings []ldapGroupRole, groups []string) (granted, managed []string) {
+	for _, m := range mappings {
+		if !slices.Contains(managed, m.Role) {
+			managed = append(managed, m.Role)
+		}
+		for _, group := range groups {
+			if m.matches(group) && !slices.Contains(granted, m.Role) {
+				granted = append(granted, m.Role)
+				break
+			}
+		}
+	}
+	return granted, managed
+}
+
+// initLDAP 初始化LDAP配置，在应用启动时调用
+// LDAP设置页面保存过配置时使用数据库中的配置，否则使用环境变量
+func initLDAP(db *gorm.DB, ab *activity.Builder) {
+	ldapDB = db
+	ldapActivity = ab
+
+	cfg := ldapEnvConfig()
+	source := "环境变量"
+	var setting models.LDAPSetting
+	if err := db.First(&setting).Error; err == nil {
+		source = "LDAP设置"
+		if cfg, err = ldapConfigFromSetting(&setting); err != nil {
+			log.Printf("警告: 读取LDAP设置失败，LDAP认证未启用，请在LDAP设置页面重新填写服务账号密码: %v", err)
+			cfg.Enabled = false
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("读取LDAP设置失败，使用环境变量中的配置: %v", err)
+	}
+
+	// 检查LDAP配置
+	if cfg.Enabled {
+		log.Printf("初始化LDAP配置: 启用LDAP认证, 配置来源: %s", source)
+		log.Printf("LDAP服务器: %s", strings.Join(cfg.serverURLs(), ", "))
+		log.Printf("LDAP搜索基础: %s", cfg.SearchBase)
+		log.Printf("LDAP搜索过滤器: %s", cfg.SearchFilter)
+		log.Printf("LDAP使用StartTLS: %v", cfg.UseTLS)
+		log.Printf("LDAP连接池: 空闲连接数=%d, 连接超时=%s, 请求超时=%s", ldapPoolSize, ldapDialTimeout, ldapRequestTimeout)
+		log.Printf("LDAP组角色映射: %d条, 移除未授予的角色: %v", len(cfg.GroupRoleMapping), cfg.RevokeRoles)
+		log.Printf("LDAP自动开通用户: %v, 默认角色: %v", cfg.JITProvisioning, cfg.DefaultRoles)
+
+		if cfg.SearchBase == "" {
 			log.Printf("警告: LDAP已启用，但搜索基础为空")
 		}
 	} else {
 		log.Printf("LDAP认证未启用")
 	}
 
-	// 设置LDAP配置到models包中
-	models.SetLDAPConfig(ldapEnabled, ldapServer, authenticateWithLDAP)
+	st, err := newLDAPState(cfg)
+	if err != nil {
+		log.Printf("警告: %v，LDAP认证未启用", err)
+		cfg.Enabled = false
+		st = &ldapState{cfg: cfg}
+	}
+	setLDAPState(st)
 }
 
 // authenticateWithLDAP 通过LDAP认证用户
 func authenticateWithLDAP(email, password string) (bool, error) {
-	if !ldapEnabled || ldapServer == "" {
-		log.Printf("LDAP认证未启用: enabled=%v, server=%s", ldapEnabled, ldapServer)
+	st := currentLDAP()
+	if !st.enabled() {
 		return false, fmt.Errorf("LDAP认证未启用")
 	}
+	cfg := &st.cfg
+	authLog := models.AuthLog().With("account", models.MaskAccount(email))
+	authLog.Debug("开始LDAP认证", "search_base", cfg.SearchBase)
 
-	log.Printf("开始LDAP认证: 用户=%s, 服务器=%s:%s, 搜索基础=%s, 过滤器=%s, 使用TLS=%v",
-		email, ldapServer, ldapPort, ldapSearchBase, ldapSearchFilter, ldapUseTLS)
-
-	// 连接LDAP服务器
-	log.Printf("尝试连接LDAP服务器: %s:%s", ldapServer, ldapPort)
-	conn, err := ldap.Dial("tcp", fmt.Sprintf("%s:%s", ldapServer, ldapPort))
+	conn, err := st.conn()
 	if err != nil {
-		log.Printf("LDAP连接错误: %v", err)
 		return false, err
 	}
 	defer conn.Close()
 
-	// 启用TLS (如果配置为使用TLS)
-	if ldapUseTLS {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: ldapSkipVerify,
-		}
-
-		// 如果指定了证书文件，加载证书
-		if ldapCertFile != "" {
-			cert, err := os.ReadFile(ldapCertFile)
-			if err != nil {
-				log.Printf("读取LDAP证书文件错误: %v", err)
-				return false, err
-			}
+	entry, err := searchLDAPUser(conn, cfg, email)
+	if err != nil {
+		return false, err
+	}
+	if entry == nil {
+		return false, nil
+	}
 
-			certPool := x509.NewCertPool()
-			if !certPool.AppendCertsFromPEM(cert) {
-				log.Printf("解析LDAP证书错误")
-				return false, fmt.Errorf("无法解析LDAP证书")
-			}
+	userDN := entry.DN
+	if models.AuthLogEnabled(slog.LevelDebug) {
+		authLog.Debug("找到LDAP用户", "dn", userDN, "attributes", redactLDAPAttributes(entry))
+	}
 
-			tlsConfig.RootCAs = certPool
+	// 尝试使用用户凭据绑定
+	err = conn.Bind(userDN, password)
+	if err != nil {
+		// 密码错误或其他绑定问题
+		authLog.Debug("LDAP用户绑定失败", "dn", userDN, "error", err)
+		return false, nil
+	}
+	authLog.Debug("LDAP用户绑定成功", "dn", userDN)
+
+	// 记录用户在目录中的DN，用于目录同步
+	if ldapDB != nil {
+		if err := ldapDB.Model(&models.User{}).
+			Where("account = ?", email).
+			UpdateColumn("directory_dn", userDN).Error; err != nil {
+			authLog.Warn("保存LDAP用户DN失败", "error", err)
 		}
+	}
 
-		// 启动TLS
-		log.Printf("开始TLS连接, 跳过验证=%v", ldapSkipVerify)
-		err = conn.StartTLS(tlsConfig)
+	// 按组角色映射同步用户角色，同步失败不影响登录
+	if len(cfg.GroupRoleMapping) > 0 {
+		groups, err := ldapUserGroups(conn, cfg, entry)
 		if err != nil {
-			log.Printf("LDAP StartTLS错误: %v", err)
-			return false, err
+			authLog.Warn("获取LDAP用户组失败", "error", err)
+		} else if err := syncLDAPRoles(ldapDB, cfg, email, groups); err != nil {
+			authLog.Warn("同步LDAP用户角色失败", "error", err)
 		}
 	}
 
-	// 绑定服务账号（如果配置了）
-	if ldapBindDN != "" && ldapBindPassword != "" {
-		log.Printf("使用服务账号绑定: %s", ldapBindDN)
-		err = conn.Bind(ldapBindDN, ldapBindPassword)
-		if err != nil {
-			log.Printf("LDAP服务账号绑定错误: %v", err)
-			return false, err
-		}
-		log.Printf("服务账号绑定成功")
-	} else {
-		log.Printf("未配置服务账号，将使用匿名绑定")
+	return true, nil
+}
+
+// ldapFilter 将值转义后代入过滤器模板中的%s
+// 账号中的 *、(、)、\ 等字符会被转义，不能用来改变过滤器的含义，例如用 * 匹配任意用户
+// 参数：
+// - template: 包含一个%s的过滤器模板，例如(mail=%s)
+// - value: 要代入的值，例如用户输入的账号或用户DN
+// 返回：
+// - string: 代入后的过滤器
+func ldapFilter(template, value string) string {
+	return fmt.Sprintf(template, ldap.EscapeFilter(value))
+}
+
+// redactLDAPAttributes 返回用户条目的属性名和值的数量，用于在日志中记录条目而不输出属性值
+func redactLDAPAttributes(entry *ldap.Entry) []string {
+	attrs := make([]string, 0, len(entry.Attributes))
+	for _, attr := range entry.Attributes {
+		attrs = append(attrs, fmt.Sprintf("%s(%d)", attr.Name, len(attr.Values)))
 	}
+	return attrs
+}
 
+// searchLDAPEntries 按配置的搜索过滤器查找匹配账号的全部用户条目
+func searchLDAPEntries(conn *ldapConn, cfg *ldapConfig, email string) ([]*ldap.Entry, error) {
 	// 使用email搜索用户
-	searchFilter := fmt.Sprintf(ldapSearchFilter, email)
+	searchFilter := ldapFilter(cfg.SearchFilter, email)
 	searchReq := ldap.NewSearchRequest(
-		ldapSearchBase,
+		cfg.SearchBase,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 		searchFilter,
-		[]string{"dn", "mail", "sAMAccountName"}, // 获取DN和其他一些属性
+		// 获取DN、所属组、开通用户需要的属性和其他一些属性
+		[]string{"dn", "mail", "sAMAccountName", "cn", cfg.NameAttribute, cfg.CompanyAttribute, cfg.GroupAttribute},
 		nil,
 	)
 
-	log.Printf("搜索LDAP用户: 过滤器=%s, 搜索基础=%s", searchFilter, ldapSearchBase)
+	result, err := conn.Search(searchReq)
+	if err != nil {
+		models.AuthLog().Warn("搜索LDAP用户失败", "account", models.MaskAccount(email), "search_base", cfg.SearchBase, "error", err)
+		return nil, err
+	}
+	models.AuthLog().Debug("搜索LDAP用户", "account", models.MaskAccount(email), "search_base", cfg.SearchBase, "entries", len(result.Entries))
+	return result.Entries, nil
+}
+
+// searchLDAPUser 按配置的搜索过滤器查找用户条目
+// 未找到或找到多个用户时返回nil
+func searchLDAPUser(conn *ldapConn, cfg *ldapConfig, email string) (*ldap.Entry, error) {
+	entries, err := searchLDAPEntries(conn, cfg, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
 
+	if len(entries) > 1 {
+		// 搜索过滤器配置不当时多个用户会匹配同一账号，需要管理员处理
+		models.AuthLog().Warn("找到多个匹配的LDAP用户，拒绝认证", "account", models.MaskAccount(email), "entries", len(entries))
+		return nil, nil
+	}
+
+	return entries[0], nil
+}
+
+// ldapUserGroups 获取LDAP用户所属的组DN
+// 配置了组搜索基础时使用组搜索，否则读取用户条目的组属性
+// 调用前连接需要以有搜索权限的身份绑定
+func ldapUserGroups(conn *ldapConn, cfg *ldapConfig, entry *ldap.Entry) ([]string, error) {
+	if cfg.GroupSearchBase == "" {
+		return entry.GetAttributeValues(cfg.GroupAttribute), nil
+	}
+
+	// 用户绑定后可能没有搜索组的权限，重新使用服务账号绑定
+	if cfg.BindDN != "" && cfg.BindPassword != "" {
+		if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	searchReq := ldap.NewSearchRequest(
+		cfg.GroupSearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		ldapFilter(cfg.GroupSearchFilter, entry.DN),
+		[]string{"dn"},
+		nil,
+	)
 	result, err := conn.Search(searchReq)
 	if err != nil {
-		log.Printf("LDAP搜索错误: %v", err)
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(result.Entries))
+	for _, group := range result.Entries {
+		groups = append(groups, group.DN)
+	}
+	return groups, nil
+}
+
+// ldapUserName 返回目录条目中的用户姓名，依次使用姓名属性和cn
+func ldapUserName(cfg *ldapConfig, entry *ldap.Entry) string {
+	if name := entry.GetAttributeValue(cfg.NameAttribute); name != "" {
+		return name
+	}
+	return entry.GetAttributeValue("cn")
+}
+
+// syncLDAPRoles 按组角色映射同步用户角色
+// 参数：
+// - db: 数据库连接
+// - cfg: LDAP配置，使用其中的组角色映射和移除选项
+// - account: 用户账号
+// - groups: 用户所属的LDAP组DN
+// 返回：
+// - error: 同步过程中的错误信息
+func syncLDAPRoles(db *gorm.DB, cfg *ldapConfig, account string, groups []string) error {
+	if db == nil {
+		return fmt.Errorf("未设置数据库连接")
+	}
+
+	var user models.User
+	if err := db.Preload("Roles").Where("account = ?", account).First(&user).Error; err != nil {
+		// 本地还没有该用户，不需要同步
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	granted, managed := mapLDAPGroupsToRoles(cfg.GroupRoleMapping, groups)
+	added, revoked, err := syncMappedRoles(db, &user, granted, managed, cfg.RevokeRoles)
+	if err != nil {
+		return err
+	}
+	if len(added) > 0 {
+		models.AuthLog().Info("添加LDAP映射角色", "account", models.MaskAccount(account), "roles", added)
+	}
+	if len(revoked) > 0 {
+		models.AuthLog().Info("移除不再由LDAP授予的角色", "account", models.MaskAccount(account), "count", len(revoked))
+	}
+	return nil
+}
+
+// lookupLDAPUser 在目录中查找本地不存在的用户，并用目录中的属性填充用户
+// 参数：
+// - account: 用户登录时输入的账号
+// - user: 要填充的用户
+// 返回：
+// - bool: 目录中是否存在该用户
+// - error: 查找过程中的错误信息
+func lookupLDAPUser(account string, user *models.User) (bool, error) {
+	st := currentLDAP()
+	conn, err := st.conn()
+	if err != nil {
 		return false, err
 	}
+	defer conn.Close()
 
-	if len(result.Entries) == 0 {
-		log.Printf("未找到匹配的LDAP用户")
-		return false, nil
+	entry, err := searchLDAPUser(conn, &st.cfg, account)
+	if err != nil || entry == nil {
+		return false, err
+	}
+
+	user.Name = ldapUserName(&st.cfg, entry)
+	if user.Name == "" {
+		user.Name = account
+	}
+	user.Company = entry.GetAttributeValue(st.cfg.CompanyAttribute)
+	user.Status = models.StatusActive
+	user.DirectoryDN = entry.DN
+	// 账号即邮箱，优先使用目录中的邮箱
+	user.Account = account
+	if mail := entry.GetAttributeValue("mail"); mail != "" && strings.EqualFold(mail, account) {
+		user.Account = mail
+	}
+	return true, nil
+}
+
+// provisionLDAPUser 在本地创建首次通过LDAP登录的用户
+// 创建用户后授予配置的默认角色，按组角色映射同步角色，并记录操作日志
+// 参数：
+// - db: 数据库连接
+// - user: 由 lookupLDAPUser 填充的用户，创建后会设置用户ID
+// 返回：
+// - error: 创建用户或授予默认角色失败时的错误信息
+func provisionLDAPUser(db *gorm.DB, user *models.User) error {
+	st := currentLDAP()
+	defaultRoles := st.cfg.DefaultRoles
+
+	if err := createUserWithRoles(db, user, defaultRoles); err != nil {
+		return err
 	}
+	models.AuthLog().Info("已自动创建LDAP用户", "account", models.MaskAccount(user.Account), "roles", defaultRoles)
 
-	if len(result.Entries) > 1 {
-		log.Printf("找到多个匹配的LDAP用户: %d个", len(result.Entries))
-		for i, entry := range result.Entries {
-			log.Printf("  用户 %d: DN=%s", i+1, entry.DN)
+	// 按组角色映射同步角色，同步失败不影响登录
+	if len(st.cfg.GroupRoleMapping) > 0 {
+		if err := syncLDAPDirectoryRoles(db, st, user.Account); err != nil {
+			models.AuthLog().Warn("同步LDAP用户角色失败", "account", models.MaskAccount(user.Account), "error", err)
 		}
-		return false, nil
 	}
 
-	userDN := result.Entries[0].DN
-	log.Printf("找到LDAP用户, DN: %s", userDN)
+	if ldapActivity != nil {
+		// 用户由自己的首次登录创建，操作人记为该用户
+		ctx := context.WithValue(context.Background(), login.UserKey, user)
+		if _, err := ldapActivity.OnCreate(ctx, user); err != nil {
+			log.Printf("记录LDAP用户开通日志失败: %v", err)
+		}
+	}
+	return nil
+}
 
-	// 输出找到的用户的所有属性，便于调试
-	entry := result.Entries[0]
-	log.Printf("用户属性:")
-	for _, attr := range entry.Attributes {
-		log.Printf("  %s: %v", attr.Name, attr.Values)
+// syncLDAPDirectoryRoles 从目录中获取用户所属的组并同步角色
+func syncLDAPDirectoryRoles(db *gorm.DB, st *ldapState, account string) error {
+	conn, err := st.conn()
+	if err != nil {
+		return err
 	}
+	defer conn.Close()
 
-	// 尝试使用用户凭据绑定
-	log.Printf("尝试使用用户凭据绑定")
-	err = conn.Bind(userDN, password)
+	entry, err := searchLDAPUser(conn, &st.cfg, account)
 	if err != nil {
-		// 密码错误或其他绑定问题
-		log.Printf("LDAP用户绑定失败: %v", err)
-		return false, nil
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("未找到LDAP用户: %s", account)
 	}
 
-	// 认证成功
-	log.Printf("LDAP认证成功")
-	return true, nil
+	groups, err := ldapUserGroups(conn, &st.cfg, entry)
+	if err != nil {
+		return err
+	}
+	return syncLDAPRoles(db, &st.cfg, account, groups)
 }
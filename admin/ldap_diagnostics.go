@@ -0,0 +1,267 @@
+package admin
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+/*
+LDAP诊断说明：
+
+LDAP设置页面提供三种诊断，使用页面上填写的配置（不需要先保存），每次诊断都建立新的连接，不使用连接池中的连接：
+
+1. 测试连接：依次检查每个服务器的连接、TLS握手和服务账号绑定
+2. 查找用户：连接第一个可用的服务器，搜索用户并显示用户属性、所属的组和映射的角色
+3. 测试登录：在查找用户的基础上使用用户密码绑定，验证用户能否通过LDAP登录
+
+诊断结果按步骤显示是否成功、详细信息和耗时，某一步失败时停止后续依赖该步骤的检查。
+*/
+
+// ldapDiagnosticStep 诊断中的一个步骤
+type ldapDiagnosticStep struct {
+	Name     string        // 步骤名称
+	OK       bool          // 是否成功
+	Detail   string        // 详细信息，失败时为错误信息
+	Duration time.Duration // 耗时
+}
+
+// ldapDiagnostics 按执行顺序记录的诊断步骤
+type ldapDiagnostics struct {
+	Steps []ldapDiagnosticStep
+}
+
+// step 执行一个诊断步骤并记录结果和耗时
+// 返回：
+// - bool: 步骤是否成功
+func (d *ldapDiagnostics) step(name string, fn func() (string, error)) bool {
+	start := time.Now()
+	detail, err := fn()
+	s := ldapDiagnosticStep{Name: name, OK: err == nil, Detail: detail, Duration: time.Since(start)}
+	if err != nil {
+		s.Detail = err.Error()
+	}
+	d.Steps = append(d.Steps, s)
+	return s.OK
+}
+
+// OK 所有步骤都成功时返回true
+func (d *ldapDiagnostics) OK() bool {
+	for _, s := range d.Steps {
+		if !s.OK {
+			return false
+		}
+	}
+	return len(d.Steps) > 0
+}
+
+// diagnoseLDAPConnection 测试与所有服务器的连接、TLS握手和服务账号绑定
+func diagnoseLDAPConnection(cfg ldapConfig, msgr *Messages) *ldapDiagnostics {
+	d := &ldapDiagnostics{}
+	pool := diagnosticLDAPPool(d, cfg, msgr)
+	if pool == nil {
+		return d
+	}
+	for _, server := range cfg.Servers {
+		if conn := diagnoseLDAPServer(d, pool, server, msgr); conn != nil {
+			conn.Close()
+		}
+	}
+	return d
+}
+
+// diagnoseLDAPLookup 在目录中查找用户，显示用户属性、所属的组和映射的角色
+func diagnoseLDAPLookup(cfg ldapConfig, account string, msgr *Messages) *ldapDiagnostics {
+	d := &ldapDiagnostics{}
+	conn := diagnosticLDAPConn(d, cfg, msgr)
+	if conn == nil {
+		return d
+	}
+	defer conn.Close()
+
+	entry := diagnoseLDAPSearch(d, conn, &cfg, account, msgr)
+	if entry == nil {
+		return d
+	}
+	diagnoseLDAPGroups(d, conn, &cfg, entry, msgr)
+	return d
+}
+
+// diagnoseLDAPBind 查找用户并使用用户密码绑定，验证用户能否通过LDAP登录
+func diagnoseLDAPBind(cfg ldapConfig, account, password string, msgr *Messages) *ldapDiagnostics {
+	d := &ldapDiagnostics{}
+	conn := diagnosticLDAPConn(d, cfg, msgr)
+	if conn == nil {
+		return d
+	}
+	defer conn.Close()
+
+	entry := diagnoseLDAPSearch(d, conn, &cfg, account, msgr)
+	if entry == nil {
+		return d
+	}
+	if !d.step(msgr.LDAPStepUserBind, func() (string, error) {
+		// 空密码会被服务器当作未认证绑定而返回成功，需要提前拒绝
+		if password == "" {
+			return "", errors.New(msgr.LDAPTestPasswordRequired)
+		}
+		if err := conn.Bind(entry.DN, password); err != nil {
+			return "", err
+		}
+		return entry.DN, nil
+	}) {
+		return d
+	}
+	diagnoseLDAPGroups(d, conn, &cfg, entry, msgr)
+	return d
+}
+
+// diagnosticLDAPPool 检查配置并创建诊断使用的连接池，连接池不保留空闲连接
+func diagnosticLDAPPool(d *ldapDiagnostics, cfg ldapConfig, msgr *Messages) *ldapPool {
+	var pool *ldapPool
+	d.step(msgr.LDAPStepConfig, func() (string, error) {
+		// 未启用LDAP时也允许诊断，便于启用前检查配置
+		cfg.Enabled = true
+		if err := cfg.validate(); err != nil {
+			return "", err
+		}
+		poolConfig, err := cfg.poolConfig()
+		if err != nil {
+			return "", err
+		}
+		poolConfig.MaxIdle = 0
+		pool = newLDAPPool(poolConfig)
+		return strings.Join(cfg.serverURLs(), ", "), nil
+	})
+	return pool
+}
+
+// diagnosticLDAPConn 按顺序尝试服务器，返回第一个连接并绑定成功的连接，失败的服务器也记录在诊断步骤中
+func diagnosticLDAPConn(d *ldapDiagnostics, cfg ldapConfig, msgr *Messages) *ldapConn {
+	pool := diagnosticLDAPPool(d, cfg, msgr)
+	if pool == nil {
+		return nil
+	}
+	for _, server := range cfg.Servers {
+		if conn := diagnoseLDAPServer(d, pool, server, msgr); conn != nil {
+			return conn
+		}
+	}
+	return nil
+}
+
+// diagnoseLDAPServer 测试单个服务器的连接、StartTLS和服务账号绑定
+// 返回：
+// - *ldapConn: 所有步骤都成功时返回以服务账号绑定的连接，否则返回nil
+func diagnoseLDAPServer(d *ldapDiagnostics, pool *ldapPool, server ldapServerAddr, msgr *Messages) *ldapConn {
+	var c *ldap.Conn
+	if !d.step(fmt.Sprintf("%s %s", msgr.LDAPStepConnect, server.URL()), func() (string, error) {
+		var err error
+		if c, err = pool.connect(server); err != nil {
+			return "", err
+		}
+		return ldapTLSDetail(c, msgr), nil
+	}) {
+		return nil
+	}
+
+	if pool.needStartTLS(server) && !d.step(msgr.LDAPStepStartTLS, func() (string, error) {
+		if err := c.StartTLS(pool.tlsConfig(server)); err != nil {
+			return "", err
+		}
+		return ldapTLSDetail(c, msgr), nil
+	}) {
+		c.Close()
+		return nil
+	}
+
+	conn := &ldapConn{Conn: c, pool: pool, server: server}
+	if !d.step(msgr.LDAPStepServiceBind, func() (string, error) {
+		if pool.cfg.BindDN == "" {
+			return msgr.LDAPAnonymousBind, nil
+		}
+		if err := conn.Bind(pool.cfg.BindDN, pool.cfg.BindPassword); err != nil {
+			return "", err
+		}
+		return pool.cfg.BindDN, nil
+	}) {
+		c.Close()
+		return nil
+	}
+	return conn
+}
+
+// ldapTLSDetail 返回连接的TLS版本和加密套件，未加密时返回提示
+func ldapTLSDetail(c *ldap.Conn, msgr *Messages) string {
+	state, ok := c.TLSConnectionState()
+	if !ok {
+		return msgr.LDAPNotEncrypted
+	}
+	return fmt.Sprintf("%s, %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+}
+
+// diagnoseLDAPSearch 按搜索过滤器查找用户，找到唯一的用户时记录用户属性
+func diagnoseLDAPSearch(d *ldapDiagnostics, conn *ldapConn, cfg *ldapConfig, account string, msgr *Messages) *ldap.Entry {
+	var entry *ldap.Entry
+	if !d.step(msgr.LDAPStepSearchUser, func() (string, error) {
+		if account == "" {
+			return "", errors.New(msgr.LDAPTestAccountRequired)
+		}
+		entries, err := searchLDAPEntries(conn, cfg, account)
+		if err != nil {
+			return "", err
+		}
+		switch len(entries) {
+		case 0:
+			return "", fmt.Errorf(msgr.LDAPUserNotFound, ldapFilter(cfg.SearchFilter, account))
+		case 1:
+			entry = entries[0]
+			return entry.DN, nil
+		default:
+			var dns []string
+			for _, e := range entries {
+				dns = append(dns, e.DN)
+			}
+			return "", fmt.Errorf(msgr.LDAPMultipleUsersFound, len(entries), strings.Join(dns, "; "))
+		}
+	}) {
+		return nil
+	}
+
+	d.step(msgr.LDAPStepAttributes, func() (string, error) {
+		return fmt.Sprintf("%s=%q, %s=%q, mail=%q",
+			cfg.NameAttribute, ldapUserName(cfg, entry),
+			cfg.CompanyAttribute, entry.GetAttributeValue(cfg.CompanyAttribute),
+			entry.GetAttributeValue("mail")), nil
+	})
+	return entry
+}
+
+// diagnoseLDAPGroups 获取用户所属的组，并按组角色映射计算用户登录时获得的角色
+func diagnoseLDAPGroups(d *ldapDiagnostics, conn *ldapConn, cfg *ldapConfig, entry *ldap.Entry, msgr *Messages) {
+	var groups []string
+	if !d.step(msgr.LDAPStepGroups, func() (string, error) {
+		var err error
+		if groups, err = ldapUserGroups(conn, cfg, entry); err != nil {
+			return "", err
+		}
+		if len(groups) == 0 {
+			return msgr.LDAPNone, nil
+		}
+		return strings.Join(groups, "; "), nil
+	}) {
+		return
+	}
+
+	d.step(msgr.LDAPStepRoles, func() (string, error) {
+		granted, _ := mapLDAPGroupsToRoles(cfg.GroupRoleMapping, groups)
+		if len(granted) == 0 {
+			return msgr.LDAPNone, nil
+		}
+		return strings.Join(granted, ", "), nil
+	})
+}
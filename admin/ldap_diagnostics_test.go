@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimlambrt/gldap/testdirectory"
+)
+
+// stepNames 返回诊断步骤的名称和结果，便于在测试失败时输出
+func stepNames(d *ldapDiagnostics) string {
+	var names []string
+	for _, s := range d.Steps {
+		names = append(names, s.Name+"="+strconv.FormatBool(s.OK)+"("+s.Detail+")")
+	}
+	return strings.Join(names, "; ")
+}
+
+// findStep 按名称前缀查找诊断步骤
+func findStep(t *testing.T, d *ldapDiagnostics, name string) ldapDiagnosticStep {
+	t.Helper()
+	for _, s := range d.Steps {
+		if strings.HasPrefix(s.Name, name) {
+			return s
+		}
+	}
+	t.Fatalf("没有找到诊断步骤 %s: %s", name, stepNames(d))
+	return ldapDiagnosticStep{}
+}
+
+func TestDiagnoseLDAPConnection(t *testing.T) {
+	msgr := Messages_en_US
+	d := startTestDirectory(t)
+	cfg := testLDAPConfig(t, d)
+	// 第一个服务器没有监听，诊断应该记录失败并继续检查第二个服务器
+	down := ldapServerAddr{Host: "localhost", Port: strconv.Itoa(testdirectory.FreePort(t)), LDAPS: true}
+	cfg.Servers = append([]ldapServerAddr{down}, cfg.Servers...)
+	// 未启用LDAP时也可以诊断
+	cfg.Enabled = false
+
+	result := diagnoseLDAPConnection(cfg, msgr)
+	if result.OK() {
+		t.Fatalf("有服务器不可用时诊断不应该通过: %s", stepNames(result))
+	}
+	if s := findStep(t, result, msgr.LDAPStepConnect+" "+down.URL()); s.OK {
+		t.Errorf("不可用的服务器应该连接失败: %+v", s)
+	}
+	if s := findStep(t, result, msgr.LDAPStepConnect+" "+cfg.Servers[1].URL()); !s.OK || !strings.HasPrefix(s.Detail, "TLS") {
+		t.Errorf("期望通过LDAPS连接第二个服务器: %+v", s)
+	}
+	if s := findStep(t, result, msgr.LDAPStepServiceBind); !s.OK || s.Detail != testServiceDN {
+		t.Errorf("服务账号绑定结果错误: %+v", s)
+	}
+}
+
+func TestDiagnoseLDAPConnectionInvalidConfig(t *testing.T) {
+	msgr := Messages_en_US
+	result := diagnoseLDAPConnection(ldapConfig{SearchFilter: defaultLDAPSearchFilter}, msgr)
+	if result.OK() || len(result.Steps) != 1 || result.Steps[0].Name != msgr.LDAPStepConfig {
+		t.Errorf("没有服务器时应该只有配置检查失败: %s", stepNames(result))
+	}
+}
+
+func TestDiagnoseLDAPLookupAndBind(t *testing.T) {
+	msgr := Messages_en_US
+	adminGroup := "cn=admins,ou=groups,dc=example,dc=org"
+	users := testdirectory.NewUsers(t, []string{"svc", "bob"})
+	users = append(users, testdirectory.NewUsers(t, []string{"alice"}, testdirectory.WithMembersOf(t, adminGroup))...)
+	d := testdirectory.Start(t,
+		testdirectory.WithLogger(t, hclog.NewNullLogger()),
+		testdirectory.WithDefaults(t, &testdirectory.Defaults{Users: users}),
+	)
+	cfg := testLDAPConfig(t, d)
+	cfg.GroupRoleMapping = parseLDAPGroupRoleMapping("admins:Admin")
+
+	lookup := diagnoseLDAPLookup(cfg, "alice", msgr)
+	if !lookup.OK() {
+		t.Fatalf("查找用户失败: %s", stepNames(lookup))
+	}
+	if s := findStep(t, lookup, msgr.LDAPStepSearchUser); s.Detail != "cn=alice,"+testdirectory.DefaultUserDN {
+		t.Errorf("用户DN错误: %s", s.Detail)
+	}
+	if s := findStep(t, lookup, msgr.LDAPStepGroups); s.Detail != adminGroup {
+		t.Errorf("用户组错误: %s", s.Detail)
+	}
+	if s := findStep(t, lookup, msgr.LDAPStepRoles); s.Detail != "Admin" {
+		t.Errorf("映射的角色错误: %s", s.Detail)
+	}
+
+	if bind := diagnoseLDAPBind(cfg, "alice", "password", msgr); !bind.OK() {
+		t.Errorf("正确的密码应该绑定成功: %s", stepNames(bind))
+	}
+
+	for _, password := range []string{"wrong", ""} {
+		bind := diagnoseLDAPBind(cfg, "bob", password, msgr)
+		if bind.OK() {
+			t.Errorf("密码 %q 不应该绑定成功: %s", password, stepNames(bind))
+		}
+		if s := findStep(t, bind, msgr.LDAPStepUserBind); s.OK {
+			t.Errorf("密码 %q 的用户绑定步骤应该失败", password)
+		}
+	}
+
+	if missing := diagnoseLDAPLookup(cfg, "", msgr); missing.OK() {
+		t.Error("没有填写测试账号时诊断不应该通过")
+	}
+}
@@ -0,0 +1,319 @@
+package admin
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+LDAP连接池说明：
+
+1. 服务器列表
+   - LDAP_SERVERS 按优先级配置多个服务器，用逗号分隔，例如"ldaps://ldap1:636,ldap://ldap2:389"
+   - 未写协议的地址使用LDAP_PORT作为默认端口，端口为636时使用LDAPS
+   - 未配置LDAP_SERVERS时使用LDAP_SERVER和LDAP_PORT
+   - 建立连接时按顺序尝试，前面的服务器连接或绑定失败时自动切换到下一个
+
+2. 加密方式
+   - ldaps:// 使用隐式TLS（LDAPS），连接建立时即完成TLS握手
+   - ldap:// 在LDAP_USE_TLS为true时使用StartTLS升级连接
+
+3. 连接复用
+   - 连接使用完后放回连接池，空闲连接数不超过LDAP_POOL_SIZE
+   - 取出空闲连接时进行健康检查：已关闭或空闲超过LDAP_POOL_IDLE_TIMEOUT的连接被丢弃，
+     配置了服务账号时重新绑定服务账号，绑定失败的连接被丢弃
+   - 以用户身份绑定过的连接在没有服务账号可以重新绑定时不会放回连接池
+
+4. 超时
+   - LDAP_DIAL_TIMEOUT: 建立连接（包括TLS握手）的超时时间，默认5s
+   - LDAP_REQUEST_TIMEOUT: 单个LDAP请求的超时时间，默认10s
+*/
+
+// ldapServerAddr LDAP服务器地址
+type ldapServerAddr struct {
+	Host  string // 主机名或IP
+	Port  string // 端口
+	LDAPS bool   // 是否使用隐式TLS（LDAPS）
+}
+
+// URL 返回服务器的连接地址
+func (s ldapServerAddr) URL() string {
+	scheme := "ldap"
+	if s.LDAPS {
+		scheme = "ldaps"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(s.Host, s.Port))
+}
+
+// parseLDAPServers 解析服务器列表
+// 参数：
+// - servers: 逗号分隔的服务器地址，支持ldap://、ldaps://和不带协议的host[:port]
+// - defaultPort: 地址中没有端口时使用的端口
+// 返回：
+// - []ldapServerAddr: 按配置顺序排列的服务器地址，忽略格式错误的地址
+func parseLDAPServers(servers, defaultPort string) []ldapServerAddr {
+	var addrs []ldapServerAddr
+	for _, item := range strings.Split(servers, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var addr ldapServerAddr
+		if strings.Contains(item, "://") {
+			u, err := url.Parse(item)
+			if err != nil || u.Hostname() == "" || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+				log.Printf("警告: 忽略格式错误的LDAP服务器地址: %s", item)
+				continue
+			}
+			addr.Host = u.Hostname()
+			addr.Port = u.Port()
+			addr.LDAPS = u.Scheme == "ldaps"
+			if addr.Port == "" {
+				addr.Port = "389"
+				if addr.LDAPS {
+					addr.Port = "636"
+				}
+			}
+		} else {
+			host, port, err := net.SplitHostPort(item)
+			if err != nil {
+				host, port = item, defaultPort
+			}
+			addr.Host = host
+			addr.Port = port
+			addr.LDAPS = port == "636"
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// ldapPoolConfig LDAP连接池配置
+type ldapPoolConfig struct {
+	Servers        []ldapServerAddr // 按优先级排列的服务器
+	BindDN         string           // 服务账号DN，和密码都不为空时才使用服务账号绑定，否则使用匿名绑定
+	BindPassword   string           // 服务账号密码
+	StartTLS       bool             // 非LDAPS连接是否使用StartTLS
+	TLSConfig      *tls.Config      // LDAPS和StartTLS使用的TLS配置
+	DialTimeout    time.Duration    // 建立连接的超时时间
+	RequestTimeout time.Duration    // 单个请求的超时时间
+	MaxIdle        int              // 最大空闲连接数
+	IdleTimeout    time.Duration    // 空闲连接的最长保留时间
+}
+
+// serviceBind 是否使用服务账号绑定，与LDAP认证的判断一致：DN和密码都不为空时才绑定
+// 只配置了DN时不能绑定，否则服务器会把空密码当作未认证绑定
+func (c ldapPoolConfig) serviceBind() bool {
+	return c.BindDN != "" && c.BindPassword != ""
+}
+
+// ldapPool LDAP连接池，支持多服务器故障切换
+type ldapPool struct {
+	cfg ldapPoolConfig
+
+	mu     sync.Mutex
+	idle   []*ldapConn
+	closed bool
+}
+
+// ldapConn 从连接池中取出的LDAP连接
+type ldapConn struct {
+	*ldap.Conn
+	pool      *ldapPool
+	server    ldapServerAddr
+	idleSince time.Time
+	// userBound 表示连接当前以服务账号以外的身份绑定
+	userBound bool
+}
+
+// errLDAPPoolClosed 表示连接池已关闭
+var errLDAPPoolClosed = errors.New("LDAP连接池已关闭")
+
+// newLDAPPool 创建LDAP连接池
+func newLDAPPool(cfg ldapPoolConfig) *ldapPool {
+	if cfg.MaxIdle < 0 {
+		cfg.MaxIdle = 0
+	}
+	return &ldapPool{cfg: cfg}
+}
+
+// Get 获取一个以服务账号绑定的连接，优先复用通过健康检查的空闲连接
+// 调用方使用完后需要调用 Put 放回连接池
+func (p *ldapPool) Get() (*ldapConn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errLDAPPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		// 后放回的连接最近使用过，优先复用
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if err := p.check(conn); err != nil {
+			models.AuthLog().Debug("丢弃不可用的LDAP连接", "server", conn.server.URL(), "error", err)
+			conn.Conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+
+	return p.dial()
+}
+
+// Put 将连接放回连接池，已关闭、无法恢复服务账号身份或超出空闲数量的连接会被关闭
+func (p *ldapPool) Put(conn *ldapConn) {
+	if conn == nil {
+		return
+	}
+	if conn.IsClosing() || (conn.userBound && !p.cfg.serviceBind()) {
+		conn.Conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.cfg.MaxIdle {
+		conn.Conn.Close()
+		return
+	}
+	conn.idleSince = time.Now()
+	p.idle = append(p.idle, conn)
+}
+
+// Close 关闭连接池和所有空闲连接，之后 Get 会返回错误
+func (p *ldapPool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.Conn.Close()
+	}
+}
+
+// check 检查空闲连接是否可用，并恢复服务账号身份
+func (p *ldapPool) check(conn *ldapConn) error {
+	if conn.IsClosing() {
+		return errors.New("连接已关闭")
+	}
+	if p.cfg.IdleTimeout > 0 && time.Since(conn.idleSince) > p.cfg.IdleTimeout {
+		return errors.New("空闲时间过长")
+	}
+	if p.cfg.serviceBind() {
+		// 重新绑定服务账号，同时验证连接仍然可用
+		return conn.Bind(p.cfg.BindDN, p.cfg.BindPassword)
+	}
+	return nil
+}
+
+// dial 按顺序尝试连接服务器，返回第一个连接并绑定成功的连接
+func (p *ldapPool) dial() (*ldapConn, error) {
+	if len(p.cfg.Servers) == 0 {
+		return nil, errors.New("未配置LDAP服务器")
+	}
+
+	var errs []error
+	for _, server := range p.cfg.Servers {
+		conn, err := p.dialServer(server)
+		if err != nil {
+			models.AuthLog().Warn("连接LDAP服务器失败", "server", server.URL(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", server.URL(), err))
+			continue
+		}
+		return conn, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dialServer 连接单个服务器，按配置启用TLS并绑定服务账号
+func (p *ldapPool) dialServer(server ldapServerAddr) (*ldapConn, error) {
+	models.AuthLog().Debug("连接LDAP服务器", "server", server.URL())
+	c, err := p.connect(server)
+	if err != nil {
+		return nil, err
+	}
+
+	// LDAPS已经加密，只有普通连接才需要StartTLS
+	if p.needStartTLS(server) {
+		models.AuthLog().Debug("开始StartTLS", "server", server.URL(), "skip_verify", p.tlsConfig(server).InsecureSkipVerify)
+		if err := c.StartTLS(p.tlsConfig(server)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("StartTLS失败: %w", err)
+		}
+	}
+
+	conn := &ldapConn{Conn: c, pool: p, server: server}
+	if p.cfg.serviceBind() {
+		models.AuthLog().Debug("使用服务账号绑定", "bind_dn", p.cfg.BindDN)
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("服务账号绑定失败: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// connect 建立到单个服务器的连接，LDAPS连接同时完成TLS握手
+func (p *ldapPool) connect(server ldapServerAddr) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.cfg.DialTimeout}
+	opts := []ldap.DialOpt{ldap.DialWithDialer(dialer)}
+	if server.LDAPS {
+		opts = append(opts, ldap.DialWithTLSConfig(p.tlsConfig(server)))
+	}
+
+	c, err := ldap.DialURL(server.URL(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.RequestTimeout > 0 {
+		c.SetTimeout(p.cfg.RequestTimeout)
+	}
+	return c, nil
+}
+
+// needStartTLS 判断连接服务器后是否需要使用StartTLS升级连接
+func (p *ldapPool) needStartTLS(server ldapServerAddr) bool {
+	return !server.LDAPS && p.cfg.StartTLS
+}
+
+// tlsConfig 返回连接指定服务器使用的TLS配置，未设置ServerName时使用服务器主机名
+func (p *ldapPool) tlsConfig(server ldapServerAddr) *tls.Config {
+	cfg := &tls.Config{}
+	if p.cfg.TLSConfig != nil {
+		cfg = p.cfg.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = server.Host
+	}
+	return cfg
+}
+
+// Bind 绑定到指定身份，并记录连接是否还是服务账号身份
+func (c *ldapConn) Bind(username, password string) error {
+	c.userBound = !c.pool.cfg.serviceBind() || username != c.pool.cfg.BindDN
+	return c.Conn.Bind(username, password)
+}
+
+// Close 将连接放回连接池
+func (c *ldapConn) Close() {
+	c.pool.Put(c)
+}
@@ -0,0 +1,308 @@
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimlambrt/gldap/testdirectory"
+)
+
+const testServiceDN = "cn=svc," + testdirectory.DefaultUserDN
+
+// startTestDirectory 启动进程内的LDAP服务器，包含服务账号svc和用户alice、bob，密码都是password
+func startTestDirectory(t *testing.T, opt ...testdirectory.Option) *testdirectory.Directory {
+	t.Helper()
+	users := testdirectory.NewUsers(t, []string{"svc", "alice", "bob"})
+	opts := append([]testdirectory.Option{
+		testdirectory.WithLogger(t, hclog.NewNullLogger()),
+		testdirectory.WithDefaults(t, &testdirectory.Defaults{Users: users}),
+	}, opt...)
+	return testdirectory.Start(t, opts...)
+}
+
+// testPoolConfig 返回连接测试服务器的连接池配置
+func testPoolConfig(t *testing.T, d *testdirectory.Directory, ldaps bool) ldapPoolConfig {
+	t.Helper()
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(d.Cert())) {
+		t.Fatal("无法加载测试服务器证书")
+	}
+	return ldapPoolConfig{
+		Servers:        []ldapServerAddr{{Host: d.Host(), Port: strconv.Itoa(d.Port()), LDAPS: ldaps}},
+		BindDN:         testServiceDN,
+		BindPassword:   "password",
+		TLSConfig:      &tls.Config{RootCAs: roots},
+		DialTimeout:    time.Second,
+		RequestTimeout: time.Second,
+		MaxIdle:        2,
+		IdleTimeout:    time.Minute,
+	}
+}
+
+// testLDAPConfig 返回使用LDAPS连接测试服务器的LDAP配置，用户按cn搜索
+func testLDAPConfig(t *testing.T, d *testdirectory.Directory) ldapConfig {
+	t.Helper()
+	certFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(certFile, []byte(d.Cert()), 0o600); err != nil {
+		t.Fatalf("写入测试服务器证书失败: %v", err)
+	}
+	return ldapConfig{
+		Enabled:           true,
+		Servers:           []ldapServerAddr{{Host: d.Host(), Port: strconv.Itoa(d.Port()), LDAPS: true}},
+		BindDN:            testServiceDN,
+		BindPassword:      "password",
+		CertFile:          certFile,
+		SearchBase:        testdirectory.DefaultUserDN,
+		SearchFilter:      "(cn=%s)",
+		GroupAttribute:    defaultLDAPGroupAttribute,
+		GroupSearchFilter: defaultLDAPGroupSearchFilter,
+		NameAttribute:     defaultLDAPNameAttribute,
+		CompanyAttribute:  defaultLDAPCompanyAttribute,
+	}
+}
+
+// useTestLDAPState 在测试期间替换当前的LDAP配置和连接池，测试结束后恢复
+func useTestLDAPState(t *testing.T, st *ldapState) {
+	t.Helper()
+	old, oldDB := ldapCurrent.Load(), ldapDB
+	ldapCurrent.Store(st)
+	ldapDB = nil
+	t.Cleanup(func() {
+		if st.client != nil {
+			st.client.Close()
+		}
+		ldapCurrent.Store(old)
+		ldapDB = oldDB
+	})
+}
+
+// searchUser 在测试服务器中按cn搜索用户
+func searchUser(t *testing.T, conn *ldapConn, cn string) *ldap.Entry {
+	t.Helper()
+	result, err := conn.Search(ldap.NewSearchRequest(
+		testdirectory.DefaultUserDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(cn=%s)", cn), []string{"email"}, nil,
+	))
+	if err != nil {
+		t.Fatalf("搜索用户失败: %v", err)
+	}
+	if len(result.Entries) != 1 {
+		t.Fatalf("期望找到1个用户，实际 %d 个", len(result.Entries))
+	}
+	return result.Entries[0]
+}
+
+func TestParseLDAPServers(t *testing.T) {
+	got := parseLDAPServers(" ldaps://ldap1.example.com, ldap://ldap2.example.com:3389,ldap3.example.com,10.0.0.1:636,ftp://bad ", "389")
+	want := []ldapServerAddr{
+		{Host: "ldap1.example.com", Port: "636", LDAPS: true},
+		{Host: "ldap2.example.com", Port: "3389"},
+		{Host: "ldap3.example.com", Port: "389"},
+		{Host: "10.0.0.1", Port: "636", LDAPS: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("期望 %d 个服务器，实际 %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("服务器 %d: 期望 %+v，实际 %+v", i, want[i], got[i])
+		}
+	}
+	if url := got[0].URL(); url != "ldaps://ldap1.example.com:636" {
+		t.Errorf("URL错误: %s", url)
+	}
+}
+
+func TestLDAPPoolLDAPS(t *testing.T) {
+	d := startTestDirectory(t)
+	pool := newLDAPPool(testPoolConfig(t, d, true))
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	if _, ok := conn.TLSConnectionState(); !ok {
+		t.Error("LDAPS连接应该使用TLS")
+	}
+	entry := searchUser(t, conn, "alice")
+	if got := entry.GetAttributeValue("email"); got != "alice@example.com" {
+		t.Errorf("用户属性错误: %s", got)
+	}
+	conn.Close()
+
+	// 放回的连接应该被复用
+	again, err := pool.Get()
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	defer again.Close()
+	if again != conn {
+		t.Error("期望复用空闲连接")
+	}
+}
+
+func TestLDAPPoolStartTLS(t *testing.T) {
+	d := startTestDirectory(t, testdirectory.WithNoTLS(t))
+	cfg := testPoolConfig(t, d, false)
+	cfg.StartTLS = true
+	pool := newLDAPPool(cfg)
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.TLSConnectionState(); !ok {
+		t.Error("StartTLS之后连接应该使用TLS")
+	}
+	searchUser(t, conn, "bob")
+}
+
+func TestLDAPPoolFailover(t *testing.T) {
+	d := startTestDirectory(t)
+	cfg := testPoolConfig(t, d, true)
+	// 第一个服务器没有监听，应该切换到第二个
+	down := ldapServerAddr{Host: "localhost", Port: strconv.Itoa(testdirectory.FreePort(t)), LDAPS: true}
+	cfg.Servers = append([]ldapServerAddr{down}, cfg.Servers...)
+	pool := newLDAPPool(cfg)
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("故障切换失败: %v", err)
+	}
+	defer conn.Close()
+	if conn.server != cfg.Servers[1] {
+		t.Errorf("期望连接到 %s，实际 %s", cfg.Servers[1].URL(), conn.server.URL())
+	}
+
+	// 所有服务器都不可用时返回错误
+	cfg.Servers = cfg.Servers[:1]
+	if _, err := newLDAPPool(cfg).Get(); err == nil {
+		t.Error("所有服务器都不可用时应该返回错误")
+	}
+}
+
+func TestLDAPPoolHealthCheck(t *testing.T) {
+	d := startTestDirectory(t)
+	pool := newLDAPPool(testPoolConfig(t, d, true))
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	conn.Close()
+	// 模拟空闲连接被服务器断开
+	conn.Conn.Close()
+
+	fresh, err := pool.Get()
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	defer fresh.Close()
+	if fresh == conn {
+		t.Error("已断开的空闲连接不应该被复用")
+	}
+	searchUser(t, fresh, "alice")
+}
+
+func TestLDAPPoolRebindsAfterUserBind(t *testing.T) {
+	d := startTestDirectory(t)
+	pool := newLDAPPool(testPoolConfig(t, d, true))
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	if err := conn.Bind("cn=alice,"+testdirectory.DefaultUserDN, "password"); err != nil {
+		t.Fatalf("用户绑定失败: %v", err)
+	}
+	if !conn.userBound {
+		t.Error("用户绑定后应该标记连接")
+	}
+	conn.Close()
+
+	// 复用连接时重新绑定服务账号
+	again, err := pool.Get()
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	defer again.Close()
+	if again != conn || again.userBound {
+		t.Error("期望复用连接并恢复服务账号身份")
+	}
+}
+
+func TestLDAPPoolAnonymousDiscardsUserBoundConn(t *testing.T) {
+	users := testdirectory.NewUsers(t, []string{"alice"})
+	d := testdirectory.Start(t,
+		testdirectory.WithLogger(t, hclog.NewNullLogger()),
+		testdirectory.WithDefaults(t, &testdirectory.Defaults{Users: users, AllowAnonymousBind: true}),
+	)
+	// 只配置了服务账号DN而没有密码时同样使用匿名绑定
+	for _, bindDN := range []string{"", testServiceDN} {
+		cfg := testPoolConfig(t, d, true)
+		cfg.BindDN, cfg.BindPassword = bindDN, ""
+		pool := newLDAPPool(cfg)
+		defer pool.Close()
+
+		conn, err := pool.Get()
+		if err != nil {
+			t.Fatalf("%q: 获取连接失败: %v", bindDN, err)
+		}
+		if err := conn.Bind("cn=alice,"+testdirectory.DefaultUserDN, "password"); err != nil {
+			t.Fatalf("%q: 用户绑定失败: %v", bindDN, err)
+		}
+		conn.Close()
+
+		// 没有服务账号无法恢复匿名身份，连接不应该放回连接池
+		if len(pool.idle) != 0 {
+			t.Errorf("%q: 期望空闲连接为0，实际 %d", bindDN, len(pool.idle))
+		}
+		if !conn.IsClosing() {
+			t.Errorf("%q: 以用户身份绑定的连接应该被关闭", bindDN)
+		}
+	}
+}
+
+func TestAuthenticateWithLDAPUsesPool(t *testing.T) {
+	d := startTestDirectory(t)
+	pool := newLDAPPool(testPoolConfig(t, d, true))
+	useTestLDAPState(t, &ldapState{cfg: testLDAPConfig(t, d), client: pool})
+
+	cases := []struct {
+		account, password string
+		want              bool
+	}{
+		{"alice", "password", true},
+		{"alice", "wrong", false},
+		{"bob", "password", true},
+	}
+	for _, c := range cases {
+		got, err := authenticateWithLDAP(c.account, c.password)
+		if err != nil {
+			t.Fatalf("%s: 认证出错: %v", c.account, err)
+		}
+		if got != c.want {
+			t.Errorf("%s/%s: 期望 %v，实际 %v", c.account, c.password, c.want, got)
+		}
+	}
+
+	// 所有认证共用同一个连接
+	if len(pool.idle) != 1 {
+		t.Errorf("期望1个空闲连接，实际 %d", len(pool.idle))
+	}
+}
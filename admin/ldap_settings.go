@@ -0,0 +1,315 @@
+package admin
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/v3/activity"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/perm"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+LDAP设置页面说明：
+
+1. 只有管理员可以访问，权限配置见perm.go
+2. 没有保存过设置时，页面显示环境变量中的LDAP配置；第一次保存后使用数据库中的配置，环境变量中的LDAP配置不再生效
+3. 服务账号密码使用SETTINGS_SECRET（默认为LOGIN_SECRET）加密后保存，页面不显示已保存的密码，留空表示不修改
+4. 保存时先按新配置创建连接池，配置无效时不保存；保存成功后立即替换当前的配置和连接池，不需要重启
+5. 页面下方的诊断使用页面上填写的配置，不需要先保存，详见ldap_diagnostics.go
+*/
+
+// LDAP设置页面的事件和portal名称
+const (
+	ldapTestConnectionEvent   = "ldap_test_connection"
+	ldapLookupUserEvent       = "ldap_lookup_user"
+	ldapTestBindEvent         = "ldap_test_bind"
+	ldapDiagnosticsPortalName = "ldapDiagnosticsPortal"
+
+	// 诊断使用的测试账号和密码表单字段，不属于LDAP设置
+	ldapTestAccountField  = "LDAPTestAccount"
+	ldapTestPasswordField = "LDAPTestPassword"
+)
+
+func configLDAPSettings(b *presets.Builder, ab *activity.Builder, db *gorm.DB) {
+	mb := b.Model(&models.LDAPSetting{}).Singleton(true).MenuIcon("mdi-server-security")
+	defer func() {
+		// 加密后的密码每次保存都会变化，不记录到操作日志中
+		ab.RegisterModel(mb).AddIgnoredFields("BindPasswordEncrypted")
+	}()
+
+	ed := mb.Editing(
+		"Enabled", "Servers", "BindDN", "BindPassword", "SearchBase", "SearchFilter",
+		"UseTLS", "SkipVerify", "CertFile",
+		"GroupAttribute", "GroupSearchBase", "GroupSearchFilter", "GroupRoleMapping", "RevokeRoles",
+		"JITProvisioning", "DefaultRoles", "NameAttribute", "CompanyAttribute", "SyncFilter",
+		"Diagnostics",
+	)
+
+	// 设置只有一条记录，忽略ID；还没有保存过设置时返回环境变量中的配置，避免自动创建空白的设置
+	ed.FetchFunc(func(obj interface{}, id string, ctx *web.EventContext) (interface{}, error) {
+		return fetchLDAPSetting(db)
+	})
+
+	ed.Field("BindPassword").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return v.VTextField().
+			Attr(web.VField(field.Name, "")...).
+			Label(field.Label).
+			Type("password").
+			Placeholder(msgr.LDAPBindPasswordPlaceholder).
+			ErrorMessages(field.Errors...)
+	}).SetterFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) (err error) {
+		s := obj.(*models.LDAPSetting)
+		if v := ctx.R.FormValue(field.Name); v != "" {
+			if s.BindPasswordEncrypted, err = encryptSetting(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	ed.Field("GroupRoleMapping").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return v.VTextarea().
+			Attr(web.VField(field.Name, field.Value(obj))...).
+			Label(field.Label).
+			Hint(msgr.LDAPGroupRoleMappingHint).
+			PersistentHint(true).
+			Rows(3).
+			ErrorMessages(field.Errors...)
+	})
+
+	ed.Field("Diagnostics").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		diagnosticBtn := func(label, eventFunc string) h.HTMLComponent {
+			return v.VBtn(label).
+				Variant(v.VariantTonal).
+				Class("mr-2").
+				Attr("@click", web.Plaid().EventFunc(eventFunc).Go())
+		}
+		return h.Div(
+			h.Div(h.Text(msgr.LDAPDiagnostics)).Class("text-subtitle-1 mb-1"),
+			h.Div(h.Text(msgr.LDAPDiagnosticsHint)).Class("text-caption text-grey mb-2"),
+			v.VRow(
+				v.VCol(
+					v.VTextField().Attr(web.VField(ldapTestAccountField, "")...).Label(msgr.LDAPTestAccount),
+				),
+				v.VCol(
+					v.VTextField().Attr(web.VField(ldapTestPasswordField, "")...).Label(msgr.LDAPTestPassword).Type("password"),
+				),
+			),
+			h.Div(
+				diagnosticBtn(msgr.LDAPTestConnection, ldapTestConnectionEvent),
+				diagnosticBtn(msgr.LDAPLookupUser, ldapLookupUserEvent),
+				diagnosticBtn(msgr.LDAPTestBind, ldapTestBindEvent),
+			),
+			web.Portal().Name(ldapDiagnosticsPortalName),
+		).Class("mt-4")
+	})
+
+	ed.ValidateFunc(func(obj interface{}, ctx *web.EventContext) (err web.ValidationErrors) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		s := obj.(*models.LDAPSetting)
+
+		// 格式错误的服务器地址和映射在解析时会被忽略，保存前提示管理员
+		if len(parseLDAPServers(s.Servers, ldapPort)) != len(splitList(s.Servers)) {
+			err.FieldError("Servers", msgr.LDAPInvalidServers)
+		}
+		if s.Enabled && strings.TrimSpace(s.Servers) == "" {
+			err.FieldError("Servers", msgr.LDAPServersRequired)
+		}
+		var mappings int
+		for _, item := range strings.Split(s.GroupRoleMapping, ";") {
+			if strings.TrimSpace(item) != "" {
+				mappings++
+			}
+		}
+		if len(parseLDAPGroupRoleMapping(s.GroupRoleMapping)) != mappings {
+			err.FieldError("GroupRoleMapping", msgr.LDAPInvalidGroupRoleMapping)
+		}
+		// 有服务账号但没有密码时会变成未认证绑定
+		if s.BindDN != "" && s.BindPasswordEncrypted == "" {
+			err.FieldError("BindPassword", msgr.LDAPBindPasswordRequired)
+		}
+		return
+	})
+
+	ed.SaveFunc(func(obj interface{}, id string, ctx *web.EventContext) error {
+		s := obj.(*models.LDAPSetting)
+		cfg, err := ldapConfigFromSetting(s)
+		if err != nil {
+			return err
+		}
+		// 先按新配置创建连接池，配置无效时不保存
+		st, err := newLDAPState(cfg)
+		if err != nil {
+			return err
+		}
+		if err := db.Save(s).Error; err != nil {
+			if st.client != nil {
+				st.client.Close()
+			}
+			return err
+		}
+		setLDAPState(st)
+		log.Printf("LDAP设置已保存并生效: 启用=%v, 服务器=%s", cfg.Enabled, strings.Join(cfg.serverURLs(), ", "))
+		return nil
+	})
+
+	// 诊断使用页面上填写的配置，不保存
+	diagnosticEvent := func(diagnose func(cfg ldapConfig, ctx *web.EventContext, msgr *Messages) *ldapDiagnostics) web.EventFunc {
+		return func(ctx *web.EventContext) (r web.EventResponse, err error) {
+			msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+			if mb.Info().Verifier().Do(presets.PermUpdate).WithReq(ctx.R).IsAllowed() != nil {
+				return r, perm.PermissionDenied
+			}
+
+			s, err := fetchLDAPSetting(db)
+			if err != nil {
+				return r, err
+			}
+			if vErr := ed.RunSetterFunc(ctx, false, s); vErr.HaveErrors() {
+				presets.ShowMessage(&r, vErr.Error(), "error")
+				return r, nil
+			}
+			cfg, err := ldapConfigFromSetting(s)
+			if err != nil {
+				presets.ShowMessage(&r, err.Error(), "error")
+				return r, nil
+			}
+
+			r.UpdatePortals = append(r.UpdatePortals, &web.PortalUpdate{
+				Name: ldapDiagnosticsPortalName,
+				Body: ldapDiagnosticsComponent(diagnose(cfg, ctx, msgr), msgr),
+			})
+			return r, nil
+		}
+	}
+	mb.RegisterEventFunc(ldapTestConnectionEvent, diagnosticEvent(func(cfg ldapConfig, ctx *web.EventContext, msgr *Messages) *ldapDiagnostics {
+		return diagnoseLDAPConnection(cfg, msgr)
+	}))
+	mb.RegisterEventFunc(ldapLookupUserEvent, diagnosticEvent(func(cfg ldapConfig, ctx *web.EventContext, msgr *Messages) *ldapDiagnostics {
+		return diagnoseLDAPLookup(cfg, strings.TrimSpace(ctx.R.FormValue(ldapTestAccountField)), msgr)
+	}))
+	mb.RegisterEventFunc(ldapTestBindEvent, diagnosticEvent(func(cfg ldapConfig, ctx *web.EventContext, msgr *Messages) *ldapDiagnostics {
+		return diagnoseLDAPBind(cfg, strings.TrimSpace(ctx.R.FormValue(ldapTestAccountField)), ctx.R.FormValue(ldapTestPasswordField), msgr)
+	}))
+}
+
+// fetchLDAPSetting 读取保存的LDAP设置，还没有保存过时返回由环境变量填充的未保存设置
+func fetchLDAPSetting(db *gorm.DB) (*models.LDAPSetting, error) {
+	var s models.LDAPSetting
+	err := db.First(&s).Error
+	if err == nil {
+		return &s, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return ldapSettingFromConfig(ldapEnvConfig()), nil
+}
+
+// ldapSettingFromConfig 使用LDAP配置填充设置，用于第一次打开设置页面时显示环境变量中的配置
+func ldapSettingFromConfig(cfg ldapConfig) *models.LDAPSetting {
+	s := &models.LDAPSetting{
+		Enabled:           cfg.Enabled,
+		Servers:           strings.Join(cfg.serverURLs(), ","),
+		BindDN:            cfg.BindDN,
+		SearchBase:        cfg.SearchBase,
+		SearchFilter:      cfg.SearchFilter,
+		UseTLS:            cfg.UseTLS,
+		SkipVerify:        cfg.SkipVerify,
+		CertFile:          cfg.CertFile,
+		GroupAttribute:    cfg.GroupAttribute,
+		GroupSearchBase:   cfg.GroupSearchBase,
+		GroupSearchFilter: cfg.GroupSearchFilter,
+		GroupRoleMapping:  formatLDAPGroupRoleMapping(cfg.GroupRoleMapping),
+		RevokeRoles:       cfg.RevokeRoles,
+		JITProvisioning:   cfg.JITProvisioning,
+		DefaultRoles:      strings.Join(cfg.DefaultRoles, ","),
+		NameAttribute:     cfg.NameAttribute,
+		CompanyAttribute:  cfg.CompanyAttribute,
+		SyncFilter:        cfg.SyncFilter,
+	}
+	// 沿用环境变量中的服务账号密码，第一次保存时不需要重新填写
+	encrypted, err := encryptSetting(cfg.BindPassword)
+	if err != nil {
+		log.Printf("加密LDAP服务账号密码失败，需要在LDAP设置页面重新填写: %v", err)
+	}
+	s.BindPasswordEncrypted = encrypted
+	return s
+}
+
+// ldapConfigFromSetting 将保存的LDAP设置转换为LDAP配置，未填写的属性和过滤器使用默认值
+// 参数：
+// - s: LDAP设置
+// 返回：
+// - ldapConfig: LDAP配置，解密失败时服务账号密码为空
+// - error: 解密服务账号密码失败时的错误信息
+func ldapConfigFromSetting(s *models.LDAPSetting) (ldapConfig, error) {
+	cfg := ldapConfig{
+		Enabled:           s.Enabled,
+		Servers:           parseLDAPServers(s.Servers, ldapPort),
+		BindDN:            strings.TrimSpace(s.BindDN),
+		SearchBase:        strings.TrimSpace(s.SearchBase),
+		SearchFilter:      cmp.Or(strings.TrimSpace(s.SearchFilter), defaultLDAPSearchFilter),
+		UseTLS:            s.UseTLS,
+		SkipVerify:        s.SkipVerify,
+		CertFile:          strings.TrimSpace(s.CertFile),
+		GroupAttribute:    cmp.Or(strings.TrimSpace(s.GroupAttribute), defaultLDAPGroupAttribute),
+		GroupSearchBase:   strings.TrimSpace(s.GroupSearchBase),
+		GroupSearchFilter: cmp.Or(strings.TrimSpace(s.GroupSearchFilter), defaultLDAPGroupSearchFilter),
+		GroupRoleMapping:  parseLDAPGroupRoleMapping(s.GroupRoleMapping),
+		RevokeRoles:       s.RevokeRoles,
+		JITProvisioning:   s.JITProvisioning,
+		DefaultRoles:      splitList(s.DefaultRoles),
+		NameAttribute:     cmp.Or(strings.TrimSpace(s.NameAttribute), defaultLDAPNameAttribute),
+		CompanyAttribute:  cmp.Or(strings.TrimSpace(s.CompanyAttribute), defaultLDAPCompanyAttribute),
+		SyncFilter:        strings.TrimSpace(s.SyncFilter),
+	}
+	password, err := decryptSetting(s.BindPasswordEncrypted)
+	if err != nil {
+		return cfg, fmt.Errorf("解密LDAP服务账号密码失败: %w", err)
+	}
+	cfg.BindPassword = password
+	return cfg, nil
+}
+
+// ldapDiagnosticsComponent 显示诊断结果，目录返回的内容不作为模板解析
+func ldapDiagnosticsComponent(d *ldapDiagnostics, msgr *Messages) h.HTMLComponent {
+	summary := v.VAlert(h.Text(msgr.LDAPDiagnosticsPassed)).Type("success")
+	if !d.OK() {
+		summary = v.VAlert(h.Text(msgr.LDAPDiagnosticsFailed)).Type("error")
+	}
+
+	var items []h.HTMLComponent
+	for _, s := range d.Steps {
+		icon, color := "mdi-check-circle", "success"
+		if !s.OK {
+			icon, color = "mdi-close-circle", "error"
+		}
+		items = append(items, v.VListItem(
+			web.Slot(v.VIcon(icon).Color(color)).Name("prepend"),
+			web.Slot(h.Span(s.Duration.Round(time.Millisecond).String()).Class("text-caption text-grey")).Name("append"),
+			v.VListItemTitle(h.Span(s.Name).Attr("v-pre", true)),
+			h.Div(h.Text(s.Detail)).Attr("v-pre", true).Class("text-body-2 text-medium-emphasis").Style("white-space: pre-wrap; word-break: break-all"),
+		))
+	}
+
+	return h.Div(
+		summary.Density(v.DensityCompact).Class("mb-2"),
+		v.VList(items...).Density(v.DensityCompact),
+	).Class("mt-4")
+}
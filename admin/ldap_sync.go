@@ -0,0 +1,249 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	plogin "github.com/qor5/admin/v3/login"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+LDAP目录同步说明：
+
+只有在LDAP登录时才会访问目录，用户从目录中删除后，本地账号和已登录的会话仍然有效。
+目录同步任务作为重复任务函数注册，可以在重复任务管理界面中按需创建定时任务：
+
+1. 使用分页搜索读取目录中的全部用户
+2. 对通过LDAP登录过的本地用户（DirectoryDN不为空），按DN或账号匹配目录中的条目
+   - 找到条目时同步姓名和公司，DN发生变化时一并更新
+   - 目录条目中没有公司属性时保留本地的公司
+   - 找不到条目时将用户状态设置为停用，并使该用户所有登录会话失效
+3. 对DirectoryDN为空的本地用户（例如记录DN之前就通过LDAP登录过的用户），按账号匹配目录条目的邮箱或登录属性
+   - 找到条目时补充记录DN并同步属性，之后按第2步处理
+   - 找不到条目时视为本地用户，不做处理；OAuth用户不参与匹配
+4. 所有变更都写入执行记录的输出中，最后输出汇总信息
+
+目录搜索没有返回任何用户时任务直接失败，避免配置错误导致所有用户被停用。
+停用的用户不会在重新出现在目录中时自动启用，需要管理员手动处理。
+
+配置项说明：
+- LDAP_SYNC_FILTER: 同步时搜索目录用户的过滤器，默认将LDAP_SEARCH_FILTER中的%s替换为*
+- LDAP_SYNC_PAGE_SIZE: 分页搜索的每页数量，默认500
+*/
+
+// ldapSyncFunctionName 目录同步任务的函数名称
+const ldapSyncFunctionName = "ldap_sync"
+
+var (
+	ldapSyncFilter   = getEnvWithDefault("LDAP_SYNC_FILTER", "")
+	ldapSyncPageSize = getEnvWithDefaultInt("LDAP_SYNC_PAGE_SIZE", 500)
+
+	// 用于使停用用户的登录会话失效
+	ldapSessions *plogin.SessionBuilder
+)
+
+// ldapSyncSummary 目录同步的变更统计
+type ldapSyncSummary struct {
+	Directory   int // 目录中的用户数
+	Checked     int // 检查的本地用户数
+	Linked      int // 补充记录DN的用户数
+	Updated     int // 更新了属性的用户数
+	Deactivated int // 停用的用户数
+	Failed      int // 处理失败的用户数
+}
+
+// ldapSyncJob 目录同步任务函数
+// 参数：
+// - ctx: 上下文，任务被取消时停止处理剩余的用户
+// - args: 任务参数，目录同步不需要参数
+// - execution: 执行记录，用于写入变更明细和汇总信息
+// 返回：
+// - error: 连接或搜索目录失败、任务被取消时返回错误
+func ldapSyncJob(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+	st := currentLDAP()
+	if !st.enabled() {
+		return fmt.Errorf("LDAP认证未启用")
+	}
+	if ldapDB == nil {
+		return fmt.Errorf("未设置数据库连接")
+	}
+
+	entries, err := ldapDirectoryEntries(st)
+	if err != nil {
+		return fmt.Errorf("读取LDAP目录失败: %w", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("LDAP目录中没有找到用户，请检查搜索基础 %s 和同步过滤器 %s", st.cfg.SearchBase, st.cfg.syncFilter())
+	}
+	execution.Info("从LDAP目录读取到 %d 个用户", len(entries))
+
+	loginAttr := st.cfg.loginAttribute()
+	byDN := make(map[string]*ldap.Entry, len(entries))
+	byAccount := make(map[string]*ldap.Entry, len(entries))
+	for _, entry := range entries {
+		byDN[strings.ToLower(entry.DN)] = entry
+		for _, attr := range []string{"mail", loginAttr} {
+			if v := entry.GetAttributeValue(attr); v != "" {
+				byAccount[strings.ToLower(v)] = entry
+			}
+		}
+	}
+
+	// 没有DN的用户只处理OAuth以外的用户，是否为目录用户在匹配时判断
+	var users []models.User
+	if err := ldapDB.Where("directory_dn <> '' OR (COALESCE(directory_dn, '') = '' AND COALESCE(o_auth_provider, '') = '')").
+		Order("id").Find(&users).Error; err != nil {
+		return err
+	}
+
+	summary := ldapSyncSummary{Directory: len(entries)}
+	for i := range users {
+		if err := ctx.Err(); err != nil {
+			execution.Warning("任务被取消，剩余 %d 个用户未处理", len(users)-i)
+			return err
+		}
+
+		user := &users[i]
+		entry, ok := byDN[strings.ToLower(user.DirectoryDN)]
+		if !ok {
+			entry, ok = byAccount[strings.ToLower(user.Account)]
+		}
+		if user.DirectoryDN == "" {
+			if !ok {
+				continue
+			}
+			summary.Linked++
+			execution.Info("用户 %s 在目录中的DN为 %s，已补充记录", user.Account, entry.DN)
+		}
+		summary.Checked++
+
+		if !ok {
+			if user.Status == models.StatusInactive {
+				continue
+			}
+			if err := deactivateLDAPUser(user); err != nil {
+				summary.Failed++
+				execution.LogError("停用用户 %s 失败: %v", user.Account, err)
+				continue
+			}
+			summary.Deactivated++
+			execution.Info("用户 %s 已不在目录中，已停用并使其登录会话失效", user.Account)
+			continue
+		}
+
+		changes, err := updateLDAPUserAttributes(&st.cfg, user, entry)
+		if err != nil {
+			summary.Failed++
+			execution.LogError("更新用户 %s 失败: %v", user.Account, err)
+			continue
+		}
+		if len(changes) > 0 {
+			summary.Updated++
+			execution.Info("更新用户 %s: %s", user.Account, strings.Join(changes, "，"))
+		}
+	}
+
+	execution.Info("同步完成: 目录用户 %d 个，检查本地用户 %d 个，补充DN %d 个，更新 %d 个，停用 %d 个，失败 %d 个",
+		summary.Directory, summary.Checked, summary.Linked, summary.Updated, summary.Deactivated, summary.Failed)
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d 个用户同步失败", summary.Failed)
+	}
+	return nil
+}
+
+// ldapDirectoryEntries 分页读取目录中的全部用户
+func ldapDirectoryEntries(st *ldapState) ([]*ldap.Entry, error) {
+	conn, err := st.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := st.cfg.syncFilter()
+	searchReq := ldap.NewSearchRequest(
+		st.cfg.SearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{"dn", "mail", "cn", st.cfg.loginAttribute(), st.cfg.NameAttribute, st.cfg.CompanyAttribute},
+		nil,
+	)
+	models.AuthLog().Debug("同步LDAP目录", "filter", filter, "search_base", st.cfg.SearchBase, "page_size", ldapSyncPageSize)
+
+	result, err := conn.SearchWithPaging(searchReq, uint32(ldapSyncPageSize))
+	if err != nil {
+		// 部分目录服务在没有匹配的条目时返回No Such Object，按没有用户处理
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+// ldapLoginAttributePattern 匹配搜索过滤器中代入账号的条件，例如(sAMAccountName=%s)
+var ldapLoginAttributePattern = regexp.MustCompile(`\(([^()=]+)=%s\)`)
+
+// loginAttribute 返回搜索过滤器中用来匹配账号的属性，无法识别时使用mail
+func (c *ldapConfig) loginAttribute() string {
+	if m := ldapLoginAttributePattern.FindStringSubmatch(c.SearchFilter); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return "mail"
+}
+
+// updateLDAPUserAttributes 按目录条目更新用户的姓名、公司和DN
+// 条目中没有姓名或公司属性时保留本地的值
+// 返回：
+// - []string: 变更说明，没有变更时为空
+// - error: 保存失败时的错误信息
+func updateLDAPUserAttributes(cfg *ldapConfig, user *models.User, entry *ldap.Entry) ([]string, error) {
+	name := ldapUserName(cfg, entry)
+	companies := entry.GetAttributeValues(cfg.CompanyAttribute)
+
+	var changes []string
+	updates := map[string]interface{}{}
+	if name != "" && name != user.Name {
+		changes = append(changes, fmt.Sprintf("姓名 %q -> %q", user.Name, name))
+		updates["name"] = name
+	}
+	if len(companies) > 0 && companies[0] != user.Company {
+		changes = append(changes, fmt.Sprintf("公司 %q -> %q", user.Company, companies[0]))
+		updates["company"] = companies[0]
+	}
+	if !strings.EqualFold(entry.DN, user.DirectoryDN) {
+		changes = append(changes, fmt.Sprintf("DN %q -> %q", user.DirectoryDN, entry.DN))
+		updates["directory_dn"] = entry.DN
+	}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	if err := ldapDB.Model(user).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// deactivateLDAPUser 停用用户，并使该用户所有的登录会话失效
+func deactivateLDAPUser(user *models.User) error {
+	if err := ldapDB.Model(user).Update("status", models.StatusInactive).Error; err != nil {
+		return err
+	}
+
+	uid := fmt.Sprint(user.ID)
+	// 更新会话密钥后，已签发的登录凭证都会失效
+	if err := user.UpdateSecure(ldapDB, &models.User{}, uid); err != nil {
+		return err
+	}
+	if ldapSessions != nil {
+		if err := ldapSessions.ExpireAllSessions(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimlambrt/gldap"
+	"github.com/jimlambrt/gldap/testdirectory"
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/x/v3/login"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// newTestDirectoryUser 创建带有邮箱、姓名和公司属性的目录用户，属性值为空时不设置该属性
+func newTestDirectoryUser(cn, mail, displayName, company string) *gldap.Entry {
+	attrs := map[string][]string{"cn": {cn}, "password": {"password"}}
+	if mail != "" {
+		attrs["mail"] = []string{mail}
+	}
+	if displayName != "" {
+		attrs["displayName"] = []string{displayName}
+	}
+	if company != "" {
+		attrs["company"] = []string{company}
+	}
+	return gldap.NewEntry("cn="+cn+","+testdirectory.DefaultUserDN, attrs)
+}
+
+func TestLDAPSyncJob(t *testing.T) {
+	d := startTestDirectory(t)
+	d.SetUsers(append(testdirectory.NewUsers(t, []string{"svc"}),
+		newTestDirectoryUser("alice", "alice@example.com", "Alice Liddell", "Acme"),
+		newTestDirectoryUser("bob", "", "Bob", ""),
+		newTestDirectoryUser("carol", "carol@example.com", "Carol", "Acme"),
+	)...)
+	useTestLDAPState(t, &ldapState{cfg: testLDAPConfig(t, d), client: newLDAPPool(testPoolConfig(t, d, true))})
+
+	db := newTestSessionDB(t)
+	ldapDB = db
+	oldSessions := ldapSessions
+	ldapSessions = plogin.NewSessionBuilder(login.New(), db).TablePrefix(loginSessionTablePrefix)
+	t.Cleanup(func() { ldapSessions = oldSessions })
+
+	newUser := func(account, name, company, dn string) *models.User {
+		u := &models.User{Name: name, Company: company, Status: models.StatusActive,
+			LDAPUserPass: models.LDAPUserPass{Account: account, DirectoryDN: dn}}
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	alice := newUser("alice", "alice", "Old Co", "cn=alice,"+testdirectory.DefaultUserDN)
+	bob := newUser("bob", "bob", "Local Co", "cn=bob,"+testdirectory.DefaultUserDN)
+	dave := newUser("dave", "dave", "", "cn=dave,"+testdirectory.DefaultUserDN)
+	// 记录DN之前登录过的目录用户，按邮箱匹配
+	carol := newUser("carol@example.com", "carol", "", "")
+	// 只有本地账号的用户不在目录中，不能被停用
+	erin := newUser("erin@example.com", "erin", "", "")
+	session := createTestSession(t, db, dave, "dave-token", time.Now().Add(time.Hour))
+
+	execution := &models.RecurringJobExecution{}
+	if err := ldapSyncJob(context.Background(), nil, execution); err != nil {
+		t.Fatalf("同步应该成功: %v\n%s", err, execution.Output)
+	}
+
+	load := func(u *models.User) models.User {
+		t.Helper()
+		var saved models.User
+		if err := db.First(&saved, u.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return saved
+	}
+	if u := load(alice); u.Name != "Alice Liddell" || u.Company != "Acme" {
+		t.Errorf("应该同步姓名和公司，实际 %q %q", u.Name, u.Company)
+	}
+	if u := load(bob); u.Name != "Bob" || u.Company != "Local Co" {
+		t.Errorf("目录中没有公司属性时应该保留本地的公司，实际 %q %q", u.Name, u.Company)
+	}
+	if u := load(carol); u.DirectoryDN != "cn=carol,"+testdirectory.DefaultUserDN || u.Name != "Carol" || u.Company != "Acme" {
+		t.Errorf("没有DN的目录用户应该补充记录DN并同步属性: %+v", u)
+	}
+	if u := load(erin); u.Status != models.StatusActive || u.DirectoryDN != "" {
+		t.Errorf("不在目录中的本地用户不应该被处理: %+v", u)
+	}
+	if u := load(dave); u.Status != models.StatusInactive {
+		t.Errorf("不在目录中的LDAP用户应该被停用，实际 %q", u.Status)
+	}
+	var expired plogin.LoginSession
+	if err := sessionDB(db).First(&expired, session.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expired.ExpiredAt.After(time.Now()) {
+		t.Errorf("停用用户的登录会话应该失效")
+	}
+	for _, want := range []string{
+		"从LDAP目录读取到 4 个用户",
+		"已补充记录",
+		"用户 dave 已不在目录中",
+		"同步完成: 目录用户 4 个，检查本地用户 4 个，补充DN 1 个，更新 3 个，停用 1 个，失败 0 个",
+	} {
+		if !strings.Contains(execution.Output, want) {
+			t.Errorf("执行记录中应该包含 %q:\n%s", want, execution.Output)
+		}
+	}
+
+	// 再次同步时没有变更，已停用的用户不重复处理
+	execution = &models.RecurringJobExecution{}
+	if err := ldapSyncJob(context.Background(), nil, execution); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(execution.Output, "检查本地用户 4 个，补充DN 0 个，更新 0 个，停用 0 个") {
+		t.Errorf("再次同步不应该有变更:\n%s", execution.Output)
+	}
+}
+
+func TestLDAPSyncJobEmptyDirectory(t *testing.T) {
+	d := startTestDirectory(t)
+	useTestLDAPState(t, &ldapState{cfg: testLDAPConfig(t, d), client: newLDAPPool(testPoolConfig(t, d, true))})
+	db := newTestDB(t)
+	ldapDB = db
+	alice := &models.User{Name: "alice", Status: models.StatusActive,
+		LDAPUserPass: models.LDAPUserPass{Account: "alice", DirectoryDN: "cn=alice," + testdirectory.DefaultUserDN}}
+	if err := db.Create(alice).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 过滤器配置错误时目录不返回任何用户
+	currentLDAP().cfg.SyncFilter = "(cn=nobody)"
+	err := ldapSyncJob(context.Background(), nil, &models.RecurringJobExecution{})
+	if err == nil || !strings.Contains(err.Error(), "没有找到用户") {
+		t.Fatalf("目录中没有用户时同步应该失败，实际 %v", err)
+	}
+	var saved models.User
+	db.First(&saved, alice.ID)
+	if saved.Status != models.StatusActive {
+		t.Errorf("同步失败时不应该停用用户")
+	}
+}
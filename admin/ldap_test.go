@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jimlambrt/gldap/testdirectory"
+	"github.com/qor5/admin/v3/role"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+func TestLDAPFilter(t *testing.T) {
+	cases := []struct {
+		template, value, want string
+	}{
+		{"(mail=%s)", "alice@example.com", "(mail=alice@example.com)"},
+		{"(mail=%s)", "*", `(mail=\2a)`},
+		{"(cn=%s)", "alice)(cn=*", `(cn=alice\29\28cn=\2a)`},
+		{"(member=%s)", `cn=a\,b,dc=example`, `(member=cn=a\5c,b,dc=example)`},
+		{"(cn=%s)", "100%", "(cn=100%)"},
+	}
+	for _, c := range cases {
+		if got := ldapFilter(c.template, c.value); got != c.want {
+			t.Errorf("ldapFilter(%q, %q): 期望 %q，实际 %q", c.template, c.value, c.want, got)
+		}
+	}
+}
+
+func TestSearchLDAPEntriesEscapesAccount(t *testing.T) {
+	d := startTestDirectory(t)
+	pool := newLDAPPool(testPoolConfig(t, d, true))
+	defer pool.Close()
+	cfg := testLDAPConfig(t, d)
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("获取连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	if entries, err := searchLDAPEntries(conn, &cfg, "alice"); err != nil || len(entries) != 1 {
+		t.Fatalf("期望找到alice，实际 %d 个, %v", len(entries), err)
+	}
+	// 账号中的通配符和括号不能改变过滤器的含义
+	for _, account := range []string{"*", "a*", "alice)(cn=*"} {
+		entries, err := searchLDAPEntries(conn, &cfg, account)
+		// 测试服务器没有匹配的条目时返回No Such Object
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			t.Fatalf("%q: 搜索出错: %v", account, err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%q: 不应该匹配任何用户，实际匹配 %d 个", account, len(entries))
+		}
+	}
+}
+
+func TestRedactLDAPAttributes(t *testing.T) {
+	entry := ldap.NewEntry("cn=alice,dc=example,dc=org", map[string][]string{
+		"mail":     {"alice@example.com"},
+		"memberOf": {"cn=admins,dc=example,dc=org", "cn=editors,dc=example,dc=org"},
+	})
+	got := redactLDAPAttributes(entry)
+	slices.Sort(got)
+	if want := []string{"mail(1)", "memberOf(2)"}; !slices.Equal(got, want) {
+		t.Errorf("期望 %v，实际 %v", want, got)
+	}
+}
+
+func TestParseLDAPGroupRoleMapping(t *testing.T) {
+	cases := []struct {
+		name, input string
+		want        []ldapGroupRole
+	}{
+		{"空配置", "", nil},
+		{"单个映射", "admins:admin", []ldapGroupRole{{"admins", "admin"}}},
+		{"多个映射并去除空白", " admins : admin ;editors:editor; ", []ldapGroupRole{{"admins", "admin"}, {"editors", "editor"}}},
+		{"组使用DN", "cn=admins,ou=groups,dc=example,dc=org:admin", []ldapGroupRole{{"cn=admins,ou=groups,dc=example,dc=org", "admin"}}},
+		{"组中包含冒号", "cn=a:b,dc=example:admin", []ldapGroupRole{{"cn=a:b,dc=example", "admin"}}},
+		{"忽略缺少冒号的项", "nocolon;editors:editor", []ldapGroupRole{{"editors", "editor"}}},
+		{"忽略缺少组的项", ":admin;editors:editor", []ldapGroupRole{{"editors", "editor"}}},
+		{"忽略缺少角色的项", "admins:;editors:editor", []ldapGroupRole{{"editors", "editor"}}},
+		{"全部格式错误", ";;nocolon;:", nil},
+	}
+	for _, c := range cases {
+		if got := parseLDAPGroupRoleMapping(c.input); !slices.Equal(got, c.want) {
+			t.Errorf("%s: 期望 %v，实际 %v", c.name, c.want, got)
+		}
+	}
+}
+
+// userRoleNames 返回用户当前拥有的角色名称，按名称排序
+func userRoleNames(t *testing.T, db *gorm.DB, id uint) []string {
+	t.Helper()
+	var u models.User
+	if err := db.Preload("Roles").First(&u, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(u.Roles))
+	for _, r := range u.Roles {
+		names = append(names, r.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestLDAPLoginSyncsRoles(t *testing.T) {
+	d := startTestDirectory(t)
+	setGroups := func(groups ...string) {
+		d.SetUsers(testdirectory.NewUsers(t, []string{"svc", "alice", "bob"},
+			testdirectory.WithMembersOf(t, testdirectory.NewMemberOf(t, groups)...))...)
+	}
+	cfg := testLDAPConfig(t, d)
+	cfg.GroupRoleMapping = parseLDAPGroupRoleMapping("admins:admin;editors:editor")
+	cfg.RevokeRoles = true
+	useTestLDAPState(t, &ldapState{cfg: cfg, client: newLDAPPool(testPoolConfig(t, d, true))})
+
+	db := newTestDB(t)
+	ldapDB = db
+	for _, name := range []string{"admin", "editor"} {
+		if err := db.Create(&role.Role{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 手动授予的角色不在映射中，不受同步影响
+	alice := createTestUserWithRole(t, db, "alice", "viewer")
+	if err := db.Model(alice).Update("account", "alice").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	login := func() {
+		t.Helper()
+		if ok, err := authenticateWithLDAP("alice", "password"); err != nil || !ok {
+			t.Fatalf("LDAP认证应该成功: %v %v", ok, err)
+		}
+	}
+
+	setGroups("admins")
+	login()
+	if got := userRoleNames(t, db, alice.ID); !slices.Equal(got, []string{"admin", "viewer"}) {
+		t.Errorf("属于admins组时应该授予admin角色，实际 %v", got)
+	}
+
+	setGroups("editors")
+	login()
+	if got := userRoleNames(t, db, alice.ID); !slices.Equal(got, []string{"editor", "viewer"}) {
+		t.Errorf("改为editors组后应该授予editor并移除admin，实际 %v", got)
+	}
+
+	setGroups()
+	login()
+	if got := userRoleNames(t, db, alice.ID); !slices.Equal(got, []string{"viewer"}) {
+		t.Errorf("不属于任何映射的组时应该移除映射角色，实际 %v", got)
+	}
+
+	// 不移除角色时只添加
+	st := currentLDAP()
+	st.cfg.RevokeRoles = false
+	setGroups("admins")
+	login()
+	setGroups()
+	login()
+	if got := userRoleNames(t, db, alice.ID); !slices.Equal(got, []string{"admin", "viewer"}) {
+		t.Errorf("关闭移除角色后不应该移除映射角色，实际 %v", got)
+	}
+}
+
+func TestLDAPJITProvisioning(t *testing.T) {
+	d := startTestDirectory(t, testdirectory.WithDefaults(t, &testdirectory.Defaults{
+		Users: testdirectory.NewUsers(t, []string{"svc", "alice", "bob"},
+			testdirectory.WithMembersOf(t, testdirectory.NewMemberOf(t, []string{"admins"})...)),
+	}))
+	cfg := testLDAPConfig(t, d)
+	cfg.JITProvisioning = true
+	cfg.DefaultRoles = []string{models.RoleViewer}
+	cfg.GroupRoleMapping = parseLDAPGroupRoleMapping("admins:admin")
+	useTestLDAPState(t, &ldapState{cfg: cfg, client: newLDAPPool(testPoolConfig(t, d, true))})
+	models.SetLDAPConfig(true, "ldaps://"+d.Host(), authenticateWithLDAP)
+	models.SetLDAPProvisioning(lookupLDAPUser, provisionLDAPUser)
+	t.Cleanup(func() {
+		models.SetLDAPConfig(false, "", nil)
+		models.SetLDAPProvisioning(nil, nil)
+	})
+
+	db := newTestDB(t)
+	ldapDB = db
+	for _, name := range []string{models.RoleViewer, "admin"} {
+		if err := db.Create(&role.Role{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// login 模拟登录模块：查找用户后验证密码
+	login := func(account, password string) (*models.User, bool) {
+		t.Helper()
+		found, err := (&models.User{}).FindUser(db, &models.User{}, account)
+		if err != nil {
+			t.Fatalf("%s: 目录中的用户应该可以找到: %v", account, err)
+		}
+		u := found.(*models.User)
+		return u, u.IsPasswordCorrect(password)
+	}
+	countUsers := func() int64 {
+		var n int64
+		db.Model(&models.User{}).Count(&n)
+		return n
+	}
+
+	if _, ok := login("alice", "wrong"); ok {
+		t.Fatalf("密码错误时不应该认证成功")
+	}
+	if n := countUsers(); n != 0 {
+		t.Fatalf("密码错误时不应该创建用户，实际 %d 个", n)
+	}
+	if _, err := (&models.User{}).FindUser(db, &models.User{}, "carol"); err == nil {
+		t.Errorf("目录中不存在的用户不应该找到")
+	}
+
+	first, ok := login("alice", "password")
+	if !ok || first.ID == 0 {
+		t.Fatalf("首次LDAP登录应该创建用户: %v %d", ok, first.ID)
+	}
+	var saved models.User
+	if err := db.First(&saved, first.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.Account != "alice" || saved.Name != "alice" || saved.Status != models.StatusActive || saved.DirectoryDN == "" {
+		t.Errorf("应该使用目录中的属性创建用户: %+v", saved)
+	}
+	if got := userRoleNames(t, db, first.ID); !slices.Equal(got, []string{models.RoleViewer, "admin"}) {
+		t.Errorf("应该授予默认角色和映射角色，实际 %v", got)
+	}
+
+	second, ok := login("alice", "password")
+	if !ok || second.ID != first.ID {
+		t.Errorf("再次登录应该使用已创建的用户，期望 %d，实际 %d", first.ID, second.ID)
+	}
+	if n := countUsers(); n != 1 {
+		t.Errorf("再次登录不应该重复创建用户，实际 %d 个", n)
+	}
+}
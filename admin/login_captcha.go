@@ -0,0 +1,351 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	mrand "math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	h "github.com/theplant/htmlgo"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+登录验证码说明：
+
+Google reCAPTCHA在中国大陆无法访问，登录模块的Recaptcha没有启用，这里使用本地生成的图片验证码，不访问任何外部服务：
+
+1. 何时需要验证码
+   - 同一IP或同一账号连续登录失败LOGIN_CAPTCHA_AFTER（默认2）次后，提交账号密码时必须填写验证码，0表示每次登录都需要
+   - LOGIN_CAPTCHA_ENABLED=false时不使用验证码
+   - 失败次数与渐进延迟共用，见login_throttle.go：账号登录成功或LOGIN_THROTTLE_RESET内没有新的失败后清零
+   - 登录页面按当前IP的失败次数决定是否显示验证码；只有账号需要验证码时，第一次提交返回登录页面并提示填写
+   - 使用通行密钥登录时页面用一次性凭证代替密码提交，没有验证码字段，凭证有效时不需要验证码，见webauthn.go
+
+2. 难度
+   - LOGIN_CAPTCHA_LENGTH: 字符数，默认5
+   - LOGIN_CAPTCHA_NOISE: 干扰线数量，默认6
+   - 字符不包含容易混淆的0/O、1/I/L，不区分大小写
+
+3. 显示
+   - 登录模块的登录页面没有添加表单字段的配置，验证码插入到渲染后的登录表单（id为login-form）中提交按钮之前
+   - 登录模块的页面结构变化导致找不到登录表单时，每次渲染都输出错误日志，验证码检查仍然有效，
+     需要验证码的账号密码登录都会被拒绝，直到修复页面结构；通行密钥登录不受影响
+
+4. 验证
+   - 答案不保存在服务器上：隐藏字段中的挑战包含过期时间、随机数和用LOGIN_SECRET计算的签名，多实例部署时任意实例都可以验证
+   - 挑战LOGIN_CAPTCHA_TTL（默认5m）后过期，每个挑战只能使用一次，已使用的挑战保存在内存中直到过期
+   - 验证码错误时不验证密码，也不增加失败次数
+*/
+
+var (
+	loginCaptchaEnabled = getEnvWithDefaultBool("LOGIN_CAPTCHA_ENABLED", true)
+	loginCaptchaAfter   = getEnvWithDefaultInt("LOGIN_CAPTCHA_AFTER", 2)
+	loginCaptchaLength  = getEnvWithDefaultInt("LOGIN_CAPTCHA_LENGTH", 5)
+	loginCaptchaNoise   = getEnvWithDefaultInt("LOGIN_CAPTCHA_NOISE", 6)
+	loginCaptchaTTL     = getEnvWithDefaultDuration("LOGIN_CAPTCHA_TTL", 5*time.Minute)
+)
+
+// 登录表单中验证码的字段和Cookie
+const (
+	captchaAnswerField    = "captcha"
+	captchaChallengeField = "captcha_challenge"
+	captchaRequiredCookie = "qor5_captcha_required"
+)
+
+// captchaChars 验证码使用的字符，去掉了容易混淆的字符
+const captchaChars = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// 验证码图片的尺寸，先按basicfont的字号绘制，再放大并扭曲
+const (
+	captchaCharWidth = 11
+	captchaHeight    = 22
+	captchaScale     = 3
+)
+
+// usedCaptchas 已经使用过的挑战
+var usedCaptchas = newCaptchaReplay()
+
+// loginFormPattern 渲染后的登录表单的开始标签
+var loginFormPattern = regexp.MustCompile(`<form\b[^>]*\bid=['"]login-form['"][^>]*>`)
+
+// captchaReplay 记录已经使用过的挑战，防止一个验证码重复使用，也用于WebAuthn的挑战
+type captchaReplay struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// newCaptchaReplay 创建已使用挑战的记录
+func newCaptchaReplay() *captchaReplay {
+	return &captchaReplay{used: map[string]time.Time{}}
+}
+
+// use 标记挑战已经使用，挑战之前已经使用过时返回false
+func (c *captchaReplay) use(now time.Time, nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, exp := range c.used {
+		if now.After(exp) {
+			delete(c.used, k)
+		}
+	}
+	if _, ok := c.used[nonce]; ok {
+		return false
+	}
+	c.used[nonce] = expires
+	return true
+}
+
+// captchaRequired 同一IP或账号的连续失败次数达到LOGIN_CAPTCHA_AFTER时需要验证码
+func captchaRequired(now time.Time, clientIP, account string) bool {
+	if !loginCaptchaEnabled {
+		return false
+	}
+	if loginCaptchaAfter <= 0 {
+		return true
+	}
+	keys := []string{throttleKey(throttleKindIP, clientIP)}
+	if account != "" {
+		keys = append(keys, throttleKey(throttleKindAccount, account))
+	}
+	return loginThrottler.failures(now, keys...) >= loginCaptchaAfter
+}
+
+// captchaSignature 计算挑战的签名，答案不区分大小写
+func captchaSignature(answer string, expires int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(loginSecret))
+	fmt.Fprintf(mac, "captcha|%d|%s|%s", expires, nonce, strings.ToUpper(answer))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newCaptcha 生成一个验证码
+// 返回：
+// - string: 放在隐藏字段中的挑战，格式为 过期时间.随机数.签名
+// - string: 验证码的答案，只用于生成图片
+func newCaptcha(now time.Time) (challenge, answer string) {
+	b := make([]byte, max(loginCaptchaLength, 1)+12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	code := make([]byte, len(b)-12)
+	for i := range code {
+		code[i] = captchaChars[int(b[i])%len(captchaChars)]
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b[len(code):])
+	expires := now.Add(loginCaptchaTTL).Unix()
+	return fmt.Sprintf("%d.%s.%s", expires, nonce, captchaSignature(string(code), expires, nonce)), string(code)
+}
+
+// verifyCaptcha 检查验证码的答案，挑战过期、签名不正确或已经使用过时返回false
+func verifyCaptcha(now time.Time, challenge, answer string) bool {
+	answer = strings.TrimSpace(answer)
+	parts := strings.Split(challenge, ".")
+	if answer == "" || len(parts) != 3 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(captchaSignature(answer, expires, parts[1]))) {
+		return false
+	}
+	return usedCaptchas.use(now, parts[1], time.Unix(expires, 0))
+}
+
+// captchaImage 绘制验证码图片：字符位置和颜色随机，放大后按正弦波扭曲，再加上干扰线和噪点
+func captchaImage(answer string) ([]byte, error) {
+	small := image.NewRGBA(image.Rect(0, 0, captchaCharWidth*len(answer)+8, captchaHeight))
+	draw.Draw(small, small.Bounds(), image.White, image.Point{}, draw.Src)
+	d := &font.Drawer{Dst: small, Face: basicfont.Face7x13}
+	for i, c := range answer {
+		d.Src = image.NewUniform(captchaColor())
+		d.Dot = fixed.P(4+captchaCharWidth*i+mrand.IntN(3), 15+mrand.IntN(5)-2)
+		d.DrawString(string(c))
+	}
+
+	bounds := small.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*captchaScale, bounds.Dy()*captchaScale))
+	amplitude := 2 + mrand.Float64()*3
+	period := 30 + mrand.Float64()*30
+	phase := mrand.Float64() * 2 * math.Pi
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			sy := (float64(y) + amplitude*math.Sin(float64(x)/period*2*math.Pi+phase)) / captchaScale
+			img.Set(x, y, small.At(x/captchaScale, int(sy)))
+		}
+	}
+
+	w, ht := img.Bounds().Dx(), img.Bounds().Dy()
+	for i := 0; i < loginCaptchaNoise; i++ {
+		c := captchaColor()
+		x0, y0 := float64(mrand.IntN(w)), float64(mrand.IntN(ht))
+		x1, y1 := float64(mrand.IntN(w)), float64(mrand.IntN(ht))
+		steps := int(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))) + 1
+		for s := 0; s <= steps; s++ {
+			x := int(x0 + (x1-x0)*float64(s)/float64(steps))
+			y := int(y0 + (y1-y0)*float64(s)/float64(steps))
+			img.Set(x, y, c)
+			img.Set(x, y+1, c)
+		}
+	}
+	for i := 0; i < w*ht/40; i++ {
+		img.Set(mrand.IntN(w), mrand.IntN(ht), captchaColor())
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// captchaColor 随机的深色，保证在白色背景上清晰可见
+func captchaColor() color.Color {
+	return color.RGBA{R: uint8(mrand.IntN(140)), G: uint8(mrand.IntN(140)), B: uint8(mrand.IntN(140)), A: 255}
+}
+
+// captchaField 登录表单中的验证码图片、输入框和挑战
+func captchaField(msgr *Messages, now time.Time) (h.HTMLComponent, error) {
+	challenge, answer := newCaptcha(now)
+	img, err := captchaImage(answer)
+	if err != nil {
+		return nil, err
+	}
+	return h.Div(
+		h.Div(
+			plogin.DefaultViewCommon.Input(captchaAnswerField, msgr.CaptchaPlaceholder, "").
+				Label(msgr.CaptchaLabel).
+				Attr("autocomplete", "off").
+				Class("flex-grow-1 mr-4"),
+			h.Img("data:image/png;base64,"+base64.StdEncoding.EncodeToString(img)).
+				Alt(msgr.CaptchaImageAlt).
+				Style("height: 44px; border-radius: 4px"),
+		).Class("d-flex align-end"),
+		h.A(h.Text(msgr.CaptchaRefresh)).Href("/auth/login").Class("align-self-end mt-1 text-subtitle-2"),
+		h.Input(captchaChallengeField).Type("hidden").Value(challenge),
+	).Class("d-flex flex-column mb-5"), nil
+}
+
+// withLoginCaptcha 需要验证码时在登录表单的提交按钮前加入验证码
+func withLoginCaptcha(page web.PageFunc) web.PageFunc {
+	return func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		if r, err = page(ctx); err != nil || !loginCaptchaEnabled {
+			return
+		}
+		now := time.Now()
+		if _, cerr := ctx.R.Cookie(captchaRequiredCookie); cerr != nil && !captchaRequired(now, ip(ctx.R), "") {
+			return
+		}
+		body, err := r.Body.MarshalHTML(ctx.R.Context())
+		if err != nil {
+			return
+		}
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		field, err := captchaField(msgr, now)
+		if err != nil {
+			return
+		}
+		html, err := field.MarshalHTML(ctx.R.Context())
+		if err != nil {
+			return
+		}
+
+		page, ok := insertCaptchaField(body, html)
+		if !ok {
+			// 不能跳过验证码检查，否则攻击者只要让页面无法显示验证码就可以绕过
+			models.AuthLog().Error("登录页面中找不到登录表单，无法显示验证码，需要验证码的登录都会被拒绝，请检查登录模块的页面结构",
+				"ip", ip(ctx.R),
+			)
+			return
+		}
+		r.Body = h.RawHTML(page)
+		return
+	}
+}
+
+// insertCaptchaField 把验证码插入到渲染后的登录表单中
+// 参数：
+// - body: 渲染后的登录页面
+// - field: 渲染后的验证码
+// 返回：
+// - string: 插入验证码后的页面，验证码在表单最后一个按钮之前，没有按钮时在表单末尾
+// - bool: 是否找到了登录表单
+func insertCaptchaField(body, field []byte) (string, bool) {
+	loc := loginFormPattern.FindIndex(body)
+	if loc == nil {
+		return "", false
+	}
+	start := loc[1]
+	end := bytes.Index(body[start:], []byte("</form>"))
+	if end < 0 {
+		return "", false
+	}
+	i := start + end
+	if btn := bytes.LastIndex(body[start:start+end], []byte("<v-btn")); btn >= 0 {
+		i = start + btn
+	}
+	return string(body[:i]) + string(field) + string(body[i:]), true
+}
+
+// requireLoginCaptcha 需要验证码时检查提交的验证码，错误时返回登录页面并提示，不验证密码
+func requireLoginCaptcha(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != passwordLoginURL || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		clientIP := ip(r)
+		account := loginAccountKey(r)
+		if !captchaRequired(now, clientIP, account) || passkeyLogin(r) ||
+			verifyCaptcha(now, r.FormValue(captchaChallengeField), r.FormValue(captchaAnswerField)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		msg := Messages_en_US.CaptchaInvalid
+		if strings.TrimSpace(r.FormValue(captchaAnswerField)) == "" {
+			msg = Messages_en_US.CaptchaRequired
+		} else {
+			models.AuthLog().Warn("验证码错误，拒绝登录",
+				"account", models.MaskAccount(account),
+				"ip", clientIP,
+			)
+		}
+		// 只有账号需要验证码时登录页面无法判断，用Cookie通知登录页面显示验证码
+		http.SetCookie(w, &http.Cookie{
+			Name:     captchaRequiredCookie,
+			Value:    "1",
+			Path:     "/",
+			MaxAge:   int(loginThrottleReset.Seconds()),
+			HttpOnly: true,
+		})
+		setLoginNotice(w, login.NoticeLevel_Error, msg)
+		http.Redirect(w, r, "/auth/login", http.StatusFound)
+	})
+}
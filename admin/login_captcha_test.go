@@ -0,0 +1,228 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	h "github.com/theplant/htmlgo"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+func TestVerifyCaptcha(t *testing.T) {
+	old := usedCaptchas
+	usedCaptchas = newCaptchaReplay()
+	t.Cleanup(func() { usedCaptchas = old })
+
+	now := time.Now()
+	challenge, answer := newCaptcha(now)
+	if len(answer) != loginCaptchaLength || strings.Trim(answer, captchaChars) != "" {
+		t.Fatalf("验证码的字符数或字符集错误: %q", answer)
+	}
+	if verifyCaptcha(now, challenge, "") || verifyCaptcha(now, challenge, answer+"X") {
+		t.Errorf("错误的答案不应该通过")
+	}
+	if verifyCaptcha(now.Add(loginCaptchaTTL+time.Second), challenge, answer) {
+		t.Errorf("过期的验证码不应该通过")
+	}
+	parts := strings.Split(challenge, ".")
+	if verifyCaptcha(now, parts[0]+"9."+parts[1]+"."+parts[2], answer) {
+		t.Errorf("修改过期时间后签名应该不正确")
+	}
+	if !verifyCaptcha(now, challenge, " "+strings.ToLower(answer)+" ") {
+		t.Fatalf("正确的答案应该通过，不区分大小写")
+	}
+	if verifyCaptcha(now, challenge, answer) {
+		t.Errorf("验证码只能使用一次")
+	}
+}
+
+func TestCaptchaImage(t *testing.T) {
+	b, err := captchaImage("AB3K9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("应该生成PNG图片: %v", err)
+	}
+	if img.Bounds().Dx() != (captchaCharWidth*5+8)*captchaScale || img.Bounds().Dy() != captchaHeight*captchaScale {
+		t.Errorf("图片尺寸错误: %v", img.Bounds())
+	}
+}
+
+func TestWithLoginCaptcha(t *testing.T) {
+	useTestThrottle(t)
+	page := withLoginCaptcha(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = h.Form(
+			plogin.DefaultViewCommon.Input("account", "", ""),
+			plogin.DefaultViewCommon.FormSubmitBtn("Sign in"),
+		).Id("login-form").Method(http.MethodPost).Action(passwordLoginURL)
+		return
+	})
+	render := func(cookie bool) string {
+		r := httptest.NewRequest("GET", "/auth/login", nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+		if cookie {
+			r.AddCookie(&http.Cookie{Name: captchaRequiredCookie, Value: "1"})
+		}
+		resp, err := page(&web.EventContext{R: r, W: httptest.NewRecorder(), Injector: &web.PageInjector{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := resp.Body.MarshalHTML(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	if strings.Contains(render(false), captchaChallengeField) {
+		t.Errorf("没有失败记录时不应该显示验证码")
+	}
+	body := render(true)
+	if i := strings.Index(body, captchaChallengeField); i < 0 || i > strings.Index(body, "<v-btn") {
+		t.Errorf("账号需要验证码时应该在提交按钮前显示验证码: %s", body)
+	}
+	for i := 0; i < loginCaptchaAfter; i++ {
+		loginThrottler.fail(time.Now(), throttleKindIP, "203.0.113.7")
+	}
+	if !strings.Contains(render(false), "data:image/png;base64,") {
+		t.Errorf("IP连续失败后应该显示验证码")
+	}
+}
+
+// renderLoginPage 渲染登录页面，返回页面内容
+func renderLoginPage(t *testing.T, page web.PageFunc, r *http.Request) string {
+	t.Helper()
+	resp, err := page(&web.EventContext{R: r, W: httptest.NewRecorder(), Injector: &web.PageInjector{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := resp.Body.MarshalHTML(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestWithLoginCaptchaAdvancedLoginPage(t *testing.T) {
+	useTestThrottle(t)
+	vh := login.New().UserModel(&models.User{}).I18n(i18n.New()).ViewHelper()
+	page := withLoginCaptcha(plogin.NewAdvancedLoginPage(nil)(vh, presets.New()))
+
+	r := httptest.NewRequest("GET", "/auth/login", nil)
+	r.AddCookie(&http.Cookie{Name: captchaRequiredCookie, Value: "1"})
+	body := renderLoginPage(t, page, r)
+	form := loginFormPattern.FindStringIndex(body)
+	if form == nil {
+		t.Fatalf("登录模块的登录页面中应该有登录表单")
+	}
+	field := strings.Index(body, captchaChallengeField)
+	end := form[1] + strings.Index(body[form[1]:], "</form>")
+	btn := form[1] + strings.LastIndex(body[form[1]:end], "<v-btn")
+	if field < form[1] || field > btn {
+		t.Errorf("验证码应该在登录表单的提交按钮之前: %s", body[form[0]:end])
+	}
+}
+
+func TestLoginCaptchaUnavailable(t *testing.T) {
+	useTestThrottle(t)
+	page := withLoginCaptcha(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		r.Body = h.Div(h.Text("maintenance"))
+		return
+	})
+	r := httptest.NewRequest("GET", "/auth/login", nil)
+	r.AddCookie(&http.Cookie{Name: captchaRequiredCookie, Value: "1"})
+	if body := renderLoginPage(t, page, r); strings.Contains(body, captchaChallengeField) {
+		t.Fatalf("找不到登录表单时不应该插入验证码: %s", body)
+	}
+
+	for i := 0; i < loginCaptchaAfter; i++ {
+		loginThrottler.fail(time.Now(), throttleKindAccount, "alice@example.com")
+	}
+	var served int
+	handler := requireLoginCaptcha(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served++ }))
+	post := httptest.NewRequest("POST", passwordLoginURL, strings.NewReader(url.Values{"account": {"alice@example.com"}}.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, post)
+	if served != 0 || w.Code != http.StatusFound {
+		t.Errorf("无法显示验证码时仍然应该检查验证码，实际 %d", w.Code)
+	}
+}
+
+func TestRequireLoginCaptcha(t *testing.T) {
+	useTestThrottle(t)
+	var served int
+	handler := requireLoginCaptcha(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served++ }))
+	post := func(account string, form url.Values) *httptest.ResponseRecorder {
+		form.Set("account", account)
+		r := httptest.NewRequest("POST", passwordLoginURL, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	post("alice@example.com", url.Values{})
+	if served != 1 {
+		t.Fatalf("没有失败记录时不需要验证码")
+	}
+	for i := 0; i < loginCaptchaAfter; i++ {
+		loginThrottler.fail(time.Now(), throttleKindAccount, "alice@example.com")
+	}
+
+	w := post("Alice@example.com", url.Values{})
+	if served != 1 || w.Code != http.StatusFound || !hasCookie(w, captchaRequiredCookie) || !hasCookie(w, loginNoticeFlashCookie) {
+		t.Fatalf("账号连续失败后没有验证码应该返回登录页面，实际 %d", w.Code)
+	}
+	challenge, answer := newCaptcha(time.Now())
+	post("alice@example.com", url.Values{captchaChallengeField: {challenge}, captchaAnswerField: {"WRONG"}})
+	if served != 1 {
+		t.Errorf("验证码错误时不应该验证密码")
+	}
+	post("alice@example.com", url.Values{captchaChallengeField: {challenge}, captchaAnswerField: {answer}})
+	if served != 2 {
+		t.Errorf("验证码正确时应该继续登录")
+	}
+	post("bob@example.com", url.Values{})
+	if served != 3 {
+		t.Errorf("其他账号不需要验证码")
+	}
+
+	// 通行密钥登录的表单没有验证码字段，有效的一次性凭证不需要验证码
+	ticket, err := issueLoginTicket("alice@example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	post("alice@example.com", url.Values{"password": {ticket}})
+	if served != 4 {
+		t.Errorf("使用有效的登录凭证时不需要验证码")
+	}
+	if !verifyLoginTicket("alice@example.com", ticket) {
+		t.Errorf("检查验证码时不应该作废登录凭证")
+	}
+	post("alice@example.com", url.Values{"password": {ticket}})
+	post("alice@example.com", url.Values{"password": {webauthnTicketPrefix + "forged"}})
+	if served != 4 {
+		t.Errorf("已使用或伪造的登录凭证仍然需要验证码")
+	}
+	ticket, _ = issueLoginTicket("bob@example.com", time.Now())
+	post("alice@example.com", url.Values{"password": {ticket}})
+	if served != 4 {
+		t.Errorf("其他账号的登录凭证仍然需要验证码")
+	}
+}
@@ -0,0 +1,356 @@
+package admin
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	vx "github.com/qor5/x/v3/ui/vuetifyx"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+)
+
+/*
+登录事件说明：
+
+每次登录（本地密码、LDAP、OAuth、SAML、TOTP）的结果保存在login_events表中，管理员可以在"登录事件"页面查看和筛选：
+
+1. 记录方式
+   - 登录成功：通过登录模块的 AfterLogin 钩子记录，其他钩子（例如停用用户检查）拒绝登录时记为失败
+   - 登录失败：通过 AfterFailedToLogin 钩子记录，按登录模块返回的错误记录失败原因
+   - 保存失败只输出日志，不影响登录
+
+2. 记录内容
+   - 账号、用户ID（用户不存在时为空）、登录方式、OAuth或SAML提供商
+   - 是否成功、失败原因、客户端IP（取自 ip()）和User-Agent
+
+3. 权限
+   - 登录事件只能由系统写入，所有人都不能新建、修改和删除
+   - 只有管理员可以查看（详见perm.go）
+
+4. 日志级别
+   - AUTH_LOG_LEVEL: 认证日志的级别，可选debug、info、warn、error，默认info
+   - 排查LDAP问题时可以设置为debug，输出连接、搜索和绑定的每个步骤
+*/
+
+// 认证日志级别
+var authLogLevel = getEnvWithDefault("AUTH_LOG_LEVEL", "info")
+
+// loginEventUserAgentSize 保存的User-Agent最大长度，与LoginEvent.UserAgent的字段长度一致
+const loginEventUserAgentSize = 512
+
+// errUserInactive 停用的用户登录时返回的错误，登录事件中记为用户已停用
+// 登录模块通过Cookie传递提示，Cookie中不能包含中文，使用英文消息
+var errUserInactive = &login.NoticeError{
+	Level:   login.NoticeLevel_Error,
+	Message: Messages_en_US.LoginUserInactive,
+}
+
+// initAuthLog 按环境变量设置认证日志的级别
+func initAuthLog() {
+	if err := models.SetAuthLogLevel(authLogLevel); err != nil {
+		log.Printf("警告: AUTH_LOG_LEVEL无效，使用默认的info级别: %v", err)
+	}
+}
+
+// recordLoginSuccess 在登录成功后记录登录事件
+// 需要在其他 AfterLogin 钩子之后注册，其他钩子拒绝登录时由 recordLoginFailure 记为失败
+func recordLoginSuccess(db *gorm.DB) func(in login.HookFunc) login.HookFunc {
+	return func(in login.HookFunc) login.HookFunc {
+		return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+			if err := in(r, user, extraVals...); err != nil {
+				return err
+			}
+			saveLoginEvent(db, newLoginEvent(r, user, nil))
+			return nil
+		}
+	}
+}
+
+// recordLoginFailure 在登录失败后记录登录事件，失败原因取自登录模块传入的错误
+func recordLoginFailure(db *gorm.DB) func(in login.HookFunc) login.HookFunc {
+	return func(in login.HookFunc) login.HookFunc {
+		return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+			var err error
+			if len(extraVals) > 0 {
+				err, _ = extraVals[0].(error)
+			}
+			if err == nil {
+				err = errors.New("unknown login error")
+			}
+			saveLoginEvent(db, newLoginEvent(r, user, err))
+			return in(r, user, extraVals...)
+		}
+	}
+}
+
+// newLoginEvent 根据登录请求创建登录事件
+// 参数：
+// - r: 登录请求
+// - user: 登录的用户，用户不存在时为nil
+// - err: 登录失败的原因，登录成功时为nil
+// 返回：
+// - *models.LoginEvent: 尚未保存的登录事件
+func newLoginEvent(r *http.Request, user interface{}, err error) *models.LoginEvent {
+	event := &models.LoginEvent{
+		Account:   r.FormValue("account"),
+		Method:    loginMethod(r, user),
+		Success:   err == nil,
+		IP:        ip(r),
+		UserAgent: truncateUTF8(r.UserAgent(), loginEventUserAgentSize),
+	}
+	if u, ok := user.(*models.User); ok {
+		if u.ID != 0 {
+			id := u.ID
+			event.UserID = &id
+		}
+		event.Account = cmp.Or(u.Account, event.Account, u.OAuthIdentifier)
+	}
+	if event.Method == models.LoginMethodOAuth || event.Method == models.LoginMethodSAML {
+		event.Provider = r.FormValue("provider")
+		if u, ok := user.(*models.User); ok && event.Provider == "" {
+			event.Provider = u.OAuthProvider
+		}
+	}
+	if err != nil {
+		event.Reason = loginFailureReason(event.Method, err)
+	}
+	return event
+}
+
+// saveLoginEvent 保存登录事件并输出认证日志，保存失败不影响登录
+func saveLoginEvent(db *gorm.DB, event *models.LoginEvent) {
+	models.AuthLog().Info("登录",
+		"account", models.MaskAccount(event.Account),
+		"method", event.Method,
+		"success", event.Success,
+		"reason", event.Reason,
+		"ip", event.IP,
+	)
+	if err := db.Create(event).Error; err != nil {
+		models.AuthLog().Error("保存登录事件失败", "error", err)
+	}
+}
+
+// loginMethod 按登录请求的地址和用户验证密码的方式判断登录方式
+func loginMethod(r *http.Request, user interface{}) string {
+	switch {
+	case strings.Contains(r.URL.Path, "/totp/"):
+		return models.LoginMethodTOTP
+	case strings.Contains(r.URL.Path, "/webauthn/"):
+		return models.LoginMethodWebAuthn
+	case strings.Contains(r.URL.Path, "/callback"):
+		if _, ok := samlConfig(r.FormValue("provider")); ok {
+			return models.LoginMethodSAML
+		}
+		return models.LoginMethodOAuth
+	}
+	if u, ok := user.(*models.User); ok && u.AuthMethod() != "" {
+		return u.AuthMethod()
+	}
+	return models.LoginMethodPassword
+}
+
+// loginFailureReason 将登录模块返回的错误转换为登录事件的失败原因
+func loginFailureReason(method string, err error) string {
+	switch {
+	case errors.Is(err, login.ErrWrongPassword):
+		return models.LoginReasonWrongPassword
+	case errors.Is(err, login.ErrUserNotFound), errors.Is(err, gorm.ErrRecordNotFound):
+		return models.LoginReasonUserNotFound
+	case errors.Is(err, login.ErrUserLocked), errors.Is(err, login.ErrUserGetLocked):
+		return models.LoginReasonUserLocked
+	case errors.Is(err, login.ErrWrongTOTPCode):
+		return models.LoginReasonWrongTOTPCode
+	case errors.Is(err, login.ErrTOTPCodeHasBeenUsed):
+		return models.LoginReasonTOTPCodeUsed
+	case errors.Is(err, errWebAuthnFailed):
+		return models.LoginReasonWebAuthnFailed
+	case errors.Is(err, errWrongRecoveryCode):
+		return models.LoginReasonWrongRecoveryCode
+	case errors.Is(err, errUserInactive), errors.Is(err, errUserPending):
+		return models.LoginReasonUserInactive
+	case errors.Is(err, errOAuthDomainNotAllowed):
+		return models.LoginReasonDomainDenied
+	case method == models.LoginMethodOAuth:
+		return models.LoginReasonOAuthFailed
+	}
+	return models.LoginReasonError
+}
+
+// truncateUTF8 将字符串截断到最多n个字节，不会截断多字节字符
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// configLoginEvents 配置登录事件页面，登录事件只读，只有管理员可以查看
+func configLoginEvents(b *presets.Builder) {
+	mb := b.Model(&models.LoginEvent{}).MenuIcon("mdi-login-variant")
+
+	cl := mb.Listing("CreatedAt", "Account", "Method", "Provider", "Success", "Reason", "IP", "UserAgent").
+		SearchColumns("account", "ip").
+		PerPage(20)
+
+	cl.FilterDataFunc(func(ctx *web.EventContext) vx.FilterData {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+
+		return []*vx.FilterItem{
+			{
+				Key:          "created",
+				Label:        msgr.LoginEventTime,
+				ItemType:     vx.ItemTypeDatetimeRange,
+				SQLCondition: `created_at %s ?`,
+			},
+			{
+				Key:          "account",
+				Label:        msgr.Account,
+				ItemType:     vx.ItemTypeString,
+				SQLCondition: `account %s ?`,
+			},
+			{
+				Key:      "success",
+				Label:    msgr.LoginEventResult,
+				ItemType: vx.ItemTypeSelect,
+				Options: []*vx.SelectItem{
+					{Text: msgr.LoginEventSucceeded, Value: "true"},
+					{Text: msgr.LoginEventFailed, Value: "false"},
+				},
+				SQLCondition: `success %s ?`,
+			},
+			{
+				Key:      "method",
+				Label:    msgr.LoginEventMethod,
+				ItemType: vx.ItemTypeSelect,
+				Options: []*vx.SelectItem{
+					{Text: loginMethodLabel(msgr, models.LoginMethodPassword), Value: models.LoginMethodPassword},
+					{Text: loginMethodLabel(msgr, models.LoginMethodLDAP), Value: models.LoginMethodLDAP},
+					{Text: loginMethodLabel(msgr, models.LoginMethodOAuth), Value: models.LoginMethodOAuth},
+					{Text: loginMethodLabel(msgr, models.LoginMethodSAML), Value: models.LoginMethodSAML},
+					{Text: loginMethodLabel(msgr, models.LoginMethodTOTP), Value: models.LoginMethodTOTP},
+					{Text: loginMethodLabel(msgr, models.LoginMethodWebAuthn), Value: models.LoginMethodWebAuthn},
+					{Text: loginMethodLabel(msgr, models.LoginMethodRecoveryCode), Value: models.LoginMethodRecoveryCode},
+				},
+				SQLCondition: `method %s ?`,
+			},
+			{
+				Key:          "ip",
+				Label:        msgr.LoginEventIP,
+				ItemType:     vx.ItemTypeString,
+				SQLCondition: `ip %s ?`,
+			},
+		}
+	})
+
+	cl.FilterTabsFunc(func(ctx *web.EventContext) []*presets.FilterTab {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+
+		return []*presets.FilterTab{
+			{
+				Label: msgr.FilterTabsAll,
+				ID:    "all",
+				Query: url.Values{"all": []string{"1"}},
+			},
+			{
+				Label: msgr.LoginEventSucceeded,
+				ID:    "succeeded",
+				Query: url.Values{"success": []string{"true"}},
+			},
+			{
+				Label: msgr.LoginEventFailed,
+				ID:    "failed",
+				Query: url.Values{"success": []string{"false"}},
+			},
+		}
+	})
+
+	cl.Field("CreatedAt").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		return h.Td(h.Text(obj.(*models.LoginEvent).CreatedAt.Local().Format("2006-01-02 15:04:05")))
+	})
+
+	cl.Field("Method").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return h.Td(h.Text(loginMethodLabel(msgr, obj.(*models.LoginEvent).Method)))
+	})
+
+	cl.Field("Success").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if obj.(*models.LoginEvent).Success {
+			return h.Td(v.VChip(h.Text(msgr.LoginEventSucceeded)).Color("success").Size(v.SizeSmall))
+		}
+		return h.Td(v.VChip(h.Text(msgr.LoginEventFailed)).Color("error").Size(v.SizeSmall))
+	})
+
+	cl.Field("Reason").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return h.Td(h.Text(loginReasonLabel(msgr, obj.(*models.LoginEvent).Reason)))
+	})
+
+	cl.Field("UserAgent").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		ua := obj.(*models.LoginEvent).UserAgent
+		return h.Td(h.Div(h.Text(ua)).Class("text-truncate").Style("max-width: 240px").Attr("title", ua))
+	})
+
+	mb.Detailing("CreatedAt", "UserID", "Account", "Method", "Provider", "Success", "Reason", "IP", "UserAgent")
+}
+
+// loginMethodLabel 返回登录方式的显示名称
+func loginMethodLabel(msgr *Messages, method string) string {
+	switch method {
+	case models.LoginMethodPassword:
+		return msgr.LoginMethodPassword
+	case models.LoginMethodLDAP:
+		return msgr.LoginMethodLDAP
+	case models.LoginMethodOAuth:
+		return msgr.LoginMethodOAuth
+	case models.LoginMethodSAML:
+		return msgr.LoginMethodSAML
+	case models.LoginMethodTOTP:
+		return msgr.LoginMethodTOTP
+	case models.LoginMethodWebAuthn:
+		return msgr.LoginMethodWebAuthn
+	case models.LoginMethodRecoveryCode:
+		return msgr.LoginMethodRecoveryCode
+	}
+	return method
+}
+
+// loginReasonLabel 返回失败原因的显示名称
+func loginReasonLabel(msgr *Messages, reason string) string {
+	switch reason {
+	case models.LoginReasonWrongPassword:
+		return msgr.LoginReasonWrongPassword
+	case models.LoginReasonUserNotFound:
+		return msgr.LoginReasonUserNotFound
+	case models.LoginReasonUserLocked:
+		return msgr.LoginReasonUserLocked
+	case models.LoginReasonWrongTOTPCode:
+		return msgr.LoginReasonWrongTOTPCode
+	case models.LoginReasonTOTPCodeUsed:
+		return msgr.LoginReasonTOTPCodeUsed
+	case models.LoginReasonWebAuthnFailed:
+		return msgr.LoginReasonWebAuthnFailed
+	case models.LoginReasonWrongRecoveryCode:
+		return msgr.LoginReasonWrongRecoveryCode
+	case models.LoginReasonUserInactive:
+		return msgr.LoginReasonUserInactive
+	case models.LoginReasonOAuthFailed:
+		return msgr.LoginReasonOAuthFailed
+	case models.LoginReasonDomainDenied:
+		return msgr.LoginReasonDomainDenied
+	case models.LoginReasonError:
+		return msgr.LoginReasonError
+	}
+	return reason
+}
@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/x/v3/login"
+	"gorm.io/gorm"
+)
+
+func TestNewLoginEventPassword(t *testing.T) {
+	form := url.Values{"account": {"alice@example.com"}, "password": {"secret"}}
+	r := httptest.NewRequest("POST", "/auth/userpass/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("User-Agent", "test-agent")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.RemoteAddr = "127.0.0.1:1234"
+
+	// 用户不存在
+	event := newLoginEvent(r, nil, login.ErrUserNotFound)
+	if event.Success || event.Reason != models.LoginReasonUserNotFound || event.UserID != nil {
+		t.Errorf("用户不存在的登录事件错误: %+v", event)
+	}
+	if event.Account != "alice@example.com" || event.Method != models.LoginMethodPassword {
+		t.Errorf("账号或登录方式错误: %+v", event)
+	}
+	if event.IP != "203.0.113.7" || event.UserAgent != "test-agent" {
+		t.Errorf("IP或User-Agent错误: %+v", event)
+	}
+
+	// 通过LDAP登录成功
+	user := &models.User{Model: gorm.Model{ID: 3}, LDAPUserPass: models.LDAPUserPass{Account: "alice@example.com"}}
+	models.SetLDAPConfig(true, "ldap://localhost", func(email, password string) (bool, error) { return true, nil })
+	t.Cleanup(func() { models.SetLDAPConfig(false, "", nil) })
+	if !user.IsPasswordCorrect("secret") {
+		t.Fatal("LDAP认证应该成功")
+	}
+	event = newLoginEvent(r, user, nil)
+	if !event.Success || event.Reason != "" || event.Method != models.LoginMethodLDAP {
+		t.Errorf("LDAP登录成功的登录事件错误: %+v", event)
+	}
+	if event.UserID == nil || *event.UserID != 3 {
+		t.Errorf("用户ID错误: %v", event.UserID)
+	}
+
+	// 停用的用户被拒绝登录
+	if event := newLoginEvent(r, user, errUserInactive); event.Reason != models.LoginReasonUserInactive {
+		t.Errorf("期望失败原因 %s，实际 %s", models.LoginReasonUserInactive, event.Reason)
+	}
+}
+
+func TestNewLoginEventOAuthAndTOTP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/auth/callback?provider=github&code=x", nil)
+	event := newLoginEvent(r, nil, errors.New("oauth2: token expired"))
+	if event.Method != models.LoginMethodOAuth || event.Provider != "github" || event.Reason != models.LoginReasonOAuthFailed {
+		t.Errorf("OAuth登录失败的登录事件错误: %+v", event)
+	}
+	if event := newLoginEvent(r, nil, gorm.ErrRecordNotFound); event.Reason != models.LoginReasonUserNotFound {
+		t.Errorf("OAuth用户不存在时期望 %s，实际 %s", models.LoginReasonUserNotFound, event.Reason)
+	}
+
+	user := &models.User{Model: gorm.Model{ID: 5}}
+	user.OAuthProvider = models.OAuthProviderGoogle
+	user.OAuthIdentifier = "bob@example.com"
+	r = httptest.NewRequest("GET", "/auth/callback-complete", nil)
+	event = newLoginEvent(r, user, nil)
+	if !event.Success || event.Provider != models.OAuthProviderGoogle || event.Account != "bob@example.com" {
+		t.Errorf("OAuth登录成功的登录事件错误: %+v", event)
+	}
+
+	r = httptest.NewRequest("POST", "/auth/2fa/totp/do", strings.NewReader("otp=123456"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	event = newLoginEvent(r, user, login.ErrWrongTOTPCode)
+	if event.Method != models.LoginMethodTOTP || event.Reason != models.LoginReasonWrongTOTPCode {
+		t.Errorf("TOTP登录失败的登录事件错误: %+v", event)
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	s := strings.Repeat("浏", 200)
+	got := truncateUTF8(s, loginEventUserAgentSize)
+	if len(got) > loginEventUserAgentSize || !utf8.ValidString(got) {
+		t.Errorf("截断结果错误: 长度 %d, 有效UTF-8 %v", len(got), utf8.ValidString(got))
+	}
+	if got := truncateUTF8("short", loginEventUserAgentSize); got != "short" {
+		t.Errorf("短字符串不应该截断: %q", got)
+	}
+}
+
+func TestMaskAccount(t *testing.T) {
+	cases := map[string]string{
+		"alice@example.com": "a***@example.com",
+		"bob":               "b***",
+		"张三":                "张***",
+		"@example.com":      "***@example.com",
+		"":                  "",
+	}
+	for account, want := range cases {
+		if got := models.MaskAccount(account); got != want {
+			t.Errorf("MaskAccount(%q): 期望 %q，实际 %q", account, want, got)
+		}
+	}
+}
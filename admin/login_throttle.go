@@ -0,0 +1,508 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qor5/admin/v3/activity"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	"github.com/qor5/x/v3/perm"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+登录防暴力破解说明：
+
+1. 账号锁定
+   - LOGIN_MAX_RETRY_COUNT: 连续输错密码多少次后锁定账号，默认5，0表示不锁定
+   - LOGIN_LOCK_DURATION: 锁定时长，默认1h，过期后自动解锁并重新计数，见models.LDAPUserPass
+
+2. 渐进延迟
+   - 同一账号连续失败超过LOGIN_THROTTLE_ACCOUNT_FREE（默认3）次、同一IP连续失败超过LOGIN_THROTTLE_IP_FREE（默认10）次后，
+     每次失败后必须等待一段时间才能再次提交，等待时间从1秒开始逐次翻倍，最长LOGIN_THROTTLE_MAX_DELAY（默认15m）
+   - 等待期间提交登录直接返回登录页面并提示剩余时间，不验证密码，也不增加账号的失败次数
+   - 使用有效的通行密钥一次性凭证登录时不需要等待，通行密钥已经验证过用户，见webauthn.go
+   - 最后一次失败后LOGIN_THROTTLE_RESET（默认1h）内没有新的失败时清零；账号登录成功后清零该账号的计数
+   - 双因素认证的验证码和恢复码按用户ID计数，免费次数与账号相同，见totp.go
+   - 账号不存在时同样计数，计数保存在内存中，多实例部署时每个实例单独计数
+   - 最多保存10万条计数，超过时丢弃最后一次失败最早的计数；过长的账号按摘要计数；过期的计数每分钟清理一次
+
+3. 客户端IP
+   - TRUSTED_PROXIES: 可信的反向代理，多个IP或网段用逗号分隔，默认只信任本机，见helpers.go中的ip()
+   - 反向代理或负载均衡不在本机时必须设置TRUSTED_PROXIES为代理的地址，否则所有请求都按代理的IP计数
+   - 只有请求来自可信代理时才读取X-Forwarded-For，避免客户端伪造IP绕过限制
+
+4. 管理页面
+   - 登录锁定页面列出锁定中的账号和受限的IP、账号，只有管理员可以查看和手动解锁
+*/
+
+// passwordLoginURL 登录模块提交账号密码的地址
+const passwordLoginURL = "/auth/userpass/login"
+
+var (
+	loginMaxRetryCount       = getEnvWithDefaultInt("LOGIN_MAX_RETRY_COUNT", 5)
+	loginLockDuration        = getEnvWithDefaultDuration("LOGIN_LOCK_DURATION", time.Hour)
+	loginThrottleAccountFree = getEnvWithDefaultInt("LOGIN_THROTTLE_ACCOUNT_FREE", 3)
+	loginThrottleIPFree      = getEnvWithDefaultInt("LOGIN_THROTTLE_IP_FREE", 10)
+	loginThrottleMaxDelay    = getEnvWithDefaultDuration("LOGIN_THROTTLE_MAX_DELAY", 15*time.Minute)
+	loginThrottleReset       = getEnvWithDefaultDuration("LOGIN_THROTTLE_RESET", time.Hour)
+)
+
+// loginThrottleBaseDelay 超过免费次数后第一次失败的等待时间
+const loginThrottleBaseDelay = time.Second
+
+// 计数的数量和账号长度限制，避免大量不同的账号或超长的账号占用内存
+const (
+	throttleMaxEntries    = 100000
+	throttleMaxValueLen   = 128
+	throttleSweepInterval = time.Minute
+)
+
+// 受限的类型，也是计数键的前缀
+const (
+	throttleKindIP      = "ip"
+	throttleKindAccount = "account"
+	throttleKindTOTP    = "totp" // 双因素认证，按用户ID计数，见totp.go
+)
+
+// 登录锁定页面的事件
+const (
+	loginUnlockAccountEvent  = "login_unlock_account"
+	loginUnlockThrottleEvent = "login_unlock_throttle"
+)
+
+// 手动解锁的操作日志类型
+const loginActionUnlock = "UnlockLogin"
+
+// LoginLockout 登录锁定页面，没有对应的数据表
+type LoginLockout struct{}
+
+// loginThrottler 登录失败的渐进延迟
+var loginThrottler = newThrottle()
+
+// throttleEntry 一个IP或账号的连续失败记录
+type throttleEntry struct {
+	Kind         string
+	Value        string
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+// throttle 按IP和账号记录连续失败次数，超过免费次数后每次失败的等待时间翻倍
+type throttle struct {
+	mu        sync.Mutex
+	entries   map[string]*throttleEntry
+	sweepOnce sync.Once
+}
+
+// newThrottle 创建渐进延迟
+func newThrottle() *throttle {
+	return &throttle{entries: map[string]*throttleEntry{}}
+}
+
+// throttleKey 计数键
+func throttleKey(kind, value string) string {
+	return kind + ":" + throttleValue(value)
+}
+
+// throttleValue 计数的IP或账号，超过长度限制时保留前一部分并加上完整值的摘要
+func throttleValue(value string) string {
+	if len(value) <= throttleMaxValueLen {
+		return value
+	}
+	sum := sha256.Sum256([]byte(value))
+	return value[:throttleMaxValueLen-33] + "#" + hex.EncodeToString(sum[:16])
+}
+
+// throttleFree 该类型允许的免费失败次数
+func throttleFree(kind string) int {
+	if kind == throttleKindIP {
+		return loginThrottleIPFree
+	}
+	return loginThrottleAccountFree
+}
+
+// throttleDelay 连续失败failures次后需要等待的时间
+func throttleDelay(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	n := failures - free - 1
+	if n >= 32 {
+		return loginThrottleMaxDelay
+	}
+	delay := loginThrottleBaseDelay * time.Duration(1<<n)
+	if delay <= 0 || delay > loginThrottleMaxDelay {
+		return loginThrottleMaxDelay
+	}
+	return delay
+}
+
+// wait 返回还需要等待的时间，多个计数键取最长的
+func (t *throttle) wait(now time.Time, keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if e, ok := t.entries[key]; ok && e.BlockedUntil.After(now) {
+			wait = max(wait, e.BlockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// failures 返回还没有清零的最多连续失败次数，多个计数键取最多的
+func (t *throttle) failures(now time.Time, keys ...string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var n int
+	for _, key := range keys {
+		if e, ok := t.entries[key]; ok && now.Sub(e.LastFailure) <= loginThrottleReset {
+			n = max(n, e.Failures)
+		}
+	}
+	return n
+}
+
+// fail 记录一次失败，超过免费次数后设置等待时间
+func (t *throttle) fail(now time.Time, kind, value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := throttleKey(kind, value)
+	e, ok := t.entries[key]
+	if !ok {
+		if len(t.entries) >= throttleMaxEntries {
+			t.evict(now)
+		}
+		e = &throttleEntry{Kind: kind, Value: throttleValue(value)}
+		t.entries[key] = e
+	}
+	e.Failures++
+	e.LastFailure = now
+	if delay := throttleDelay(e.Failures, throttleFree(kind)); delay > 0 {
+		e.BlockedUntil = now.Add(delay)
+	}
+}
+
+// evict 计数达到数量限制时先清理过期的计数，仍然没有空间时丢弃最后一次失败最早的计数，调用时需要持有锁
+func (t *throttle) evict(now time.Time) {
+	t.sweepLocked(now)
+	if len(t.entries) < throttleMaxEntries {
+		return
+	}
+	var oldest string
+	for key, e := range t.entries {
+		if oldest == "" || e.LastFailure.Before(t.entries[oldest].LastFailure) {
+			oldest = key
+		}
+	}
+	delete(t.entries, oldest)
+	models.AuthLog().Warn("登录失败计数达到数量限制，丢弃最早的计数", "limit", throttleMaxEntries)
+}
+
+// sweep 清理最后一次失败超过LOGIN_THROTTLE_RESET的计数
+func (t *throttle) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweepLocked(now)
+}
+
+// sweepLocked 清理过期的计数，调用时需要持有锁
+func (t *throttle) sweepLocked(now time.Time) {
+	for key, e := range t.entries {
+		if now.Sub(e.LastFailure) > loginThrottleReset {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// startSweep 在后台定期清理过期的计数，多次调用只启动一次
+func (t *throttle) startSweep(interval time.Duration) {
+	t.sweepOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				t.sweep(now)
+			}
+		}()
+	})
+}
+
+// reset 清除计数，返回是否存在该计数
+func (t *throttle) reset(kind, value string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := throttleKey(kind, value)
+	_, ok := t.entries[key]
+	delete(t.entries, key)
+	return ok
+}
+
+// throttled 返回失败次数超过免费次数并且还没有清零的记录，等待时间最长的在前
+func (t *throttle) throttled(now time.Time) []throttleEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var entries []throttleEntry
+	for _, e := range t.entries {
+		if e.Failures > throttleFree(e.Kind) && now.Sub(e.LastFailure) <= loginThrottleReset {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].BlockedUntil.After(entries[j].BlockedUntil)
+	})
+	return entries
+}
+
+// loginAccountKey 登录表单中的账号，不区分大小写
+func loginAccountKey(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.FormValue("account")))
+}
+
+// throttleLogin 等待期间拒绝提交账号密码，返回登录页面并提示剩余时间，通行密钥登录不受限制
+func throttleLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != passwordLoginURL || r.Method != http.MethodPost || passkeyLogin(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		clientIP := ip(r)
+		keys := []string{throttleKey(throttleKindIP, clientIP)}
+		if account := loginAccountKey(r); account != "" {
+			keys = append(keys, throttleKey(throttleKindAccount, account))
+		}
+		wait := loginThrottler.wait(time.Now(), keys...)
+		if wait <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		models.AuthLog().Warn("登录失败次数过多，拒绝登录",
+			"account", models.MaskAccount(loginAccountKey(r)),
+			"ip", clientIP,
+			"wait", wait.Round(time.Second).String(),
+		)
+		setLoginNotice(w, login.NoticeLevel_Error, fmt.Sprintf(Messages_en_US.LoginThrottled, int(math.Ceil(wait.Seconds()))))
+		http.Redirect(w, r, "/auth/login", http.StatusFound)
+	})
+}
+
+// recordLoginThrottle 账号密码登录失败后增加IP和账号的失败次数
+func recordLoginThrottle(in login.HookFunc) login.HookFunc {
+	return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+		var err error
+		if len(extraVals) > 0 {
+			err, _ = extraVals[0].(error)
+		}
+		if r.URL.Path == passwordLoginURL && (errors.Is(err, login.ErrWrongPassword) || errors.Is(err, login.ErrUserNotFound) ||
+			errors.Is(err, login.ErrUserLocked) || errors.Is(err, login.ErrUserGetLocked)) {
+			now := time.Now()
+			loginThrottler.fail(now, throttleKindIP, ip(r))
+			if account := loginAccountKey(r); account != "" {
+				loginThrottler.fail(now, throttleKindAccount, account)
+			}
+		}
+		return in(r, user, extraVals...)
+	}
+}
+
+// resetLoginThrottle 账号密码登录成功后清零该账号的失败次数
+func resetLoginThrottle(in login.HookFunc) login.HookFunc {
+	return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+		if err := in(r, user, extraVals...); err != nil {
+			return err
+		}
+		if r.URL.Path == passwordLoginURL {
+			if account := loginAccountKey(r); account != "" {
+				loginThrottler.reset(throttleKindAccount, account)
+			}
+		}
+		return nil
+	}
+}
+
+// lockedUsers 返回锁定中的用户，最近锁定的在前
+func lockedUsers(db *gorm.DB, now time.Time) ([]models.User, error) {
+	var users []models.User
+	err := db.Where("locked = ? AND locked_at > ?", true, now.Add(-models.LockDuration())).
+		Order("locked_at DESC").
+		Find(&users).Error
+	return users, err
+}
+
+// unlockUser 管理员手动解锁账号，同时清除该账号的渐进延迟
+func unlockUser(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, id string) error {
+	var u models.User
+	if err := db.First(&u, id).Error; err != nil {
+		return err
+	}
+	if err := u.UnlockUser(db, &models.User{}); err != nil {
+		return err
+	}
+	loginThrottler.reset(throttleKindAccount, strings.ToLower(u.Account))
+	loginThrottler.reset(throttleKindTOTP, fmt.Sprint(u.ID))
+	models.AuthLog().Info("管理员解锁了账号",
+		"account", models.MaskAccount(u.Account),
+		"operator", models.MaskAccount(getCurrentUser(ctx.R).Account),
+	)
+	if _, err := ab.Log(ctx.R.Context(), loginActionUnlock, &u, nil); err != nil {
+		models.AuthLog().Error("记录解锁账号的操作日志失败", "error", err)
+	}
+	return nil
+}
+
+// configLoginLockout 配置登录锁定页面，只有管理员可以查看和解锁
+func configLoginLockout(b *presets.Builder, ab *activity.Builder, db *gorm.DB) {
+	mb := b.Model(&LoginLockout{}).MenuIcon("mdi-lock-alert")
+
+	mb.Listing().PageFunc(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if !slices.Contains(getCurrentUser(ctx.R).GetRoles(), models.RoleAdmin) {
+			return r, perm.PermissionDenied
+		}
+		r.PageTitle = msgr.LoginLockoutTitle
+		r.Body, err = loginLockoutBody(db, msgr, time.Now())
+		return
+	})
+
+	mb.RegisterEventFunc(loginUnlockAccountEvent, func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if !slices.Contains(getCurrentUser(ctx.R).GetRoles(), models.RoleAdmin) {
+			return r, perm.PermissionDenied
+		}
+		if err = unlockUser(ctx, db, ab, ctx.R.FormValue("id")); err != nil {
+			ctx.Flash = msgr.LoginUnlockFailed + err.Error()
+		} else {
+			ctx.Flash = msgr.LoginUnlocked
+		}
+		r.Reload = true
+		return r, nil
+	})
+
+	mb.RegisterEventFunc(loginUnlockThrottleEvent, func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if !slices.Contains(getCurrentUser(ctx.R).GetRoles(), models.RoleAdmin) {
+			return r, perm.PermissionDenied
+		}
+		kind, value := ctx.R.FormValue("kind"), ctx.R.FormValue("value")
+		if loginThrottler.reset(kind, value) {
+			models.AuthLog().Info("管理员清除了登录限制",
+				"kind", kind,
+				"value", value,
+				"operator", models.MaskAccount(getCurrentUser(ctx.R).Account),
+			)
+		}
+		ctx.Flash = msgr.LoginUnlocked
+		r.Reload = true
+		return r, nil
+	})
+}
+
+// loginLockoutBody 登录锁定页面的内容：锁定中的账号和受限的IP、账号
+func loginLockoutBody(db *gorm.DB, msgr *Messages, now time.Time) (h.HTMLComponent, error) {
+	users, err := lockedUsers(db, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var accountRows []h.HTMLComponent
+	for _, u := range users {
+		accountRows = append(accountRows, h.Tr(
+			h.Td(h.Text(u.Account)),
+			h.Td(h.Text(u.Name)),
+			h.Td(h.Text(u.LockedAt.Local().Format(time.DateTime))),
+			h.Td(h.Text(u.LockedAt.Add(models.LockDuration()).Local().Format(time.DateTime))),
+			h.Td(v.VBtn(msgr.LoginUnlockBtn).
+				Size("small").
+				Variant(v.VariantTonal).
+				Color("warning").
+				Attr("@click", web.Plaid().
+					EventFunc(loginUnlockAccountEvent).
+					Query("id", fmt.Sprint(u.ID)).
+					Go())),
+		))
+	}
+
+	var throttleRows []h.HTMLComponent
+	for _, e := range loginThrottler.throttled(now) {
+		kind := msgr.LoginThrottleKindIP
+		switch e.Kind {
+		case throttleKindAccount:
+			kind = msgr.LoginThrottleKindAccount
+		case throttleKindTOTP:
+			kind = msgr.LoginThrottleKindTOTP
+		}
+		blockedUntil := "-"
+		if e.BlockedUntil.After(now) {
+			blockedUntil = e.BlockedUntil.Local().Format(time.DateTime)
+		}
+		throttleRows = append(throttleRows, h.Tr(
+			h.Td(h.Text(kind)),
+			h.Td(h.Text(e.Value)),
+			h.Td(h.Text(fmt.Sprint(e.Failures))),
+			h.Td(h.Text(blockedUntil)),
+			h.Td(v.VBtn(msgr.LoginUnlockBtn).
+				Size("small").
+				Variant(v.VariantTonal).
+				Color("warning").
+				Attr("@click", web.Plaid().
+					EventFunc(loginUnlockThrottleEvent).
+					Query("kind", e.Kind).
+					Query("value", e.Value).
+					Go())),
+		))
+	}
+
+	return h.Div(
+		loginLockoutTable(msgr.LoginLockedAccounts,
+			[]string{msgr.Account, msgr.Name, msgr.LoginLockedAt, msgr.LoginUnlocksAt, ""},
+			accountRows, msgr.LoginLockoutNone),
+		loginLockoutTable(msgr.LoginThrottledClients,
+			[]string{msgr.LoginThrottleKind, msgr.LoginThrottleValue, msgr.LoginThrottleFailures, msgr.LoginThrottleBlockedUntil, ""},
+			throttleRows, msgr.LoginLockoutNone),
+	).Class("pa-4"), nil
+}
+
+// loginLockoutTable 登录锁定页面中的一个表格，没有数据时显示提示
+func loginLockoutTable(title string, headers []string, rows []h.HTMLComponent, empty string) h.HTMLComponent {
+	var ths []h.HTMLComponent
+	for _, header := range headers {
+		ths = append(ths, h.Th(header))
+	}
+	if len(rows) == 0 {
+		rows = append(rows, h.Tr(h.Td(h.Text(empty)).Attr("colspan", fmt.Sprint(len(headers)))))
+	}
+	return v.VCard(
+		v.VCardTitle(h.Text(title)),
+		v.VCardText(
+			v.VTable(
+				h.Thead(h.Tr(ths...)),
+				h.Tbody(rows...),
+			),
+		),
+	).Class("mb-4")
+}
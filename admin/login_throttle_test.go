@@ -0,0 +1,262 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/login"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// useTestThrottle 测试期间使用新的渐进延迟计数
+func useTestThrottle(t *testing.T) {
+	t.Helper()
+	old := loginThrottler
+	loginThrottler = newThrottle()
+	t.Cleanup(func() { loginThrottler = old })
+}
+
+func TestClientIP(t *testing.T) {
+	// 默认只信任本机，内网地址伪造的X-Forwarded-For不生效
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if got := ip(r); got != "10.0.0.2" {
+		t.Errorf("默认不应该信任内网地址，实际 %s", got)
+	}
+
+	old := trustedProxies
+	trustedProxies = parseTrustedProxies(defaultTrustedProxies + ",10.0.0.0/8")
+	t.Cleanup(func() { trustedProxies = old })
+	cases := []struct {
+		remote, forwarded, want string
+	}{
+		{"203.0.113.9:1234", "198.51.100.1", "203.0.113.9"},
+		{"10.0.0.2:1234", "", "10.0.0.2"},
+		{"10.0.0.2:1234", "203.0.113.7, 10.0.0.1", "203.0.113.7"},
+		{"10.0.0.2:1234", "198.51.100.1, 203.0.113.7, 10.0.0.1", "203.0.113.7"},
+		{"127.0.0.1:1234", "192.168.1.5, 10.0.0.1", "192.168.1.5"},
+		{"10.0.0.2:1234", "unknown, 10.0.0.1", "10.0.0.1"},
+		{"10.0.0.2:1234", "203.0.113.7:5555", "203.0.113.7"},
+		{"[::1]:1234", "2001:db8::1", "2001:db8::1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := ip(r); got != c.want {
+			t.Errorf("来自 %s 的请求，X-Forwarded-For为 %q 时客户端IP应该是 %s，实际 %s", c.remote, c.forwarded, c.want, got)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes := parseTrustedProxies("10.0.0.1, 192.168.0.0/16, bad, 10.0.0.0/33")
+	if len(prefixes) != 2 {
+		t.Fatalf("应该忽略格式错误的项，实际 %v", prefixes)
+	}
+	if prefixes[0].String() != "10.0.0.1/32" || prefixes[1].String() != "192.168.0.0/16" {
+		t.Errorf("解析结果错误: %v", prefixes)
+	}
+}
+
+func TestThrottleDelay(t *testing.T) {
+	if d := throttleDelay(3, 3); d != 0 {
+		t.Errorf("没有超过免费次数时不需要等待，实际 %v", d)
+	}
+	if d := throttleDelay(4, 3); d != time.Second {
+		t.Errorf("超过免费次数后第一次等待1秒，实际 %v", d)
+	}
+	if d := throttleDelay(6, 3); d != 4*time.Second {
+		t.Errorf("等待时间应该逐次翻倍，实际 %v", d)
+	}
+	if d := throttleDelay(100, 3); d != loginThrottleMaxDelay {
+		t.Errorf("等待时间不能超过最大值，实际 %v", d)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle()
+	now := time.Now()
+	key := throttleKey(throttleKindAccount, "alice@example.com")
+
+	for i := 0; i < loginThrottleAccountFree; i++ {
+		th.fail(now, throttleKindAccount, "alice@example.com")
+	}
+	if w := th.wait(now, key); w != 0 {
+		t.Errorf("免费次数内不需要等待，实际 %v", w)
+	}
+	if len(th.throttled(now)) != 0 {
+		t.Errorf("免费次数内不应该列为受限")
+	}
+	th.fail(now, throttleKindAccount, "alice@example.com")
+	if w := th.wait(now, key); w != loginThrottleBaseDelay {
+		t.Errorf("超过免费次数后应该等待，实际 %v", w)
+	}
+	if w := th.wait(now.Add(loginThrottleBaseDelay), key); w != 0 {
+		t.Errorf("等待时间过去后可以再次登录，实际 %v", w)
+	}
+	if entries := th.throttled(now); len(entries) != 1 || entries[0].Value != "alice@example.com" {
+		t.Errorf("应该列出受限的账号: %+v", entries)
+	}
+
+	// 长时间没有新的失败后清零
+	later := now.Add(loginThrottleReset + time.Second)
+	th.fail(later, throttleKindIP, "203.0.113.7")
+	if w := th.wait(later, key); w != 0 || th.failures(later, key) != 0 {
+		t.Errorf("过期的计数不应该再生效: %v", w)
+	}
+	th.sweep(later)
+	if len(th.entries) != 1 {
+		t.Errorf("过期的计数应该被清理: %v", th.entries)
+	}
+
+	if !th.reset(throttleKindIP, "203.0.113.7") || th.reset(throttleKindIP, "203.0.113.7") {
+		t.Errorf("清除计数的结果错误")
+	}
+}
+
+func TestThrottleLimits(t *testing.T) {
+	th := newThrottle()
+	now := time.Now()
+
+	// 过长的账号按摘要计数，不同的长账号分别计数
+	long := strings.Repeat("a", 10000)
+	th.fail(now, throttleKindAccount, long+"1")
+	th.fail(now, throttleKindAccount, long+"2")
+	if len(th.entries) != 2 || th.failures(now, throttleKey(throttleKindAccount, long+"1")) != 1 {
+		t.Fatalf("过长的账号应该分别计数: %d", len(th.entries))
+	}
+	for _, e := range th.entries {
+		if len(e.Value) > throttleMaxValueLen {
+			t.Errorf("保存的账号超过长度限制: %d", len(e.Value))
+		}
+	}
+	if !th.reset(throttleKindAccount, long+"1") {
+		t.Errorf("应该可以按原始账号清除计数")
+	}
+
+	// 达到数量限制时丢弃最后一次失败最早的计数
+	for i := len(th.entries); i < throttleMaxEntries; i++ {
+		th.fail(now.Add(time.Duration(i)*time.Millisecond), throttleKindIP, fmt.Sprint(i))
+	}
+	th.fail(now.Add(time.Hour), throttleKindAccount, "alice@example.com")
+	if len(th.entries) != throttleMaxEntries {
+		t.Errorf("计数数量不应该超过限制: %d", len(th.entries))
+	}
+	if th.failures(now, throttleKey(throttleKindAccount, long+"2")) != 0 {
+		t.Errorf("应该丢弃最早的计数")
+	}
+	if th.failures(now.Add(time.Hour), throttleKey(throttleKindAccount, "alice@example.com")) != 1 {
+		t.Errorf("新的计数应该保存")
+	}
+}
+
+func TestThrottleLogin(t *testing.T) {
+	useTestThrottle(t)
+	nop := func(r *http.Request, user interface{}, extraVals ...interface{}) error { return nil }
+	newRequest := func(account, password string) *http.Request {
+		body := url.Values{"account": {account}, "password": {password}}.Encode()
+		r := httptest.NewRequest("POST", passwordLoginURL, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	var served int
+	handler := throttleLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served++ }))
+
+	for i := 0; i <= loginThrottleAccountFree; i++ {
+		recordLoginThrottle(nop)(newRequest("Alice@example.com", "wrong"), nil, login.ErrWrongPassword)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newRequest("alice@example.com", "wrong"))
+	if served != 0 || w.Code != http.StatusFound || w.Header().Get("Location") != "/auth/login" || !hasCookie(w, loginNoticeFlashCookie) {
+		t.Fatalf("等待期间应该拒绝登录并提示，实际 %d", w.Code)
+	}
+
+	// 通行密钥登录使用的有效凭证不受限制，无效的凭证仍然受限
+	ticket, err := issueLoginTicket("alice@example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), newRequest("alice@example.com", webauthnTicketPrefix+"forged"))
+	if served != 0 {
+		t.Fatalf("无效的登录凭证应该受限")
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), newRequest("alice@example.com", ticket))
+	if served != 1 {
+		t.Fatalf("有效的登录凭证不应该受限")
+	}
+
+	// 其他账号不受影响，IP还没有超过免费次数
+	handler.ServeHTTP(httptest.NewRecorder(), newRequest("bob@example.com", "wrong"))
+	if served != 2 {
+		t.Errorf("其他账号不应该受限")
+	}
+
+	// OAuth等其他登录方式的失败不计数
+	r := httptest.NewRequest("GET", "/auth/callback", nil)
+	recordLoginThrottle(nop)(r, nil, login.ErrWrongPassword)
+	if len(loginThrottler.entries) != 2 {
+		t.Errorf("只有账号密码登录的失败才计数: %v", loginThrottler.entries)
+	}
+
+	resetLoginThrottle(nop)(newRequest("alice@example.com", "wrong"), nil)
+	if w := loginThrottler.wait(time.Now(), throttleKey(throttleKindAccount, "alice@example.com")); w != 0 {
+		t.Errorf("登录成功后应该清零账号的计数")
+	}
+}
+
+func TestAutomaticUnlock(t *testing.T) {
+	db := newTestDB(t)
+	ab := newTestActivity(t, db)
+	lockedAt := time.Now().Add(-models.LockDuration() - time.Minute)
+	expired := &models.User{Name: "expired", LDAPUserPass: models.LDAPUserPass{Account: "expired@example.com", Locked: true, LockedAt: &lockedAt, LoginRetryCount: 5}}
+	now := time.Now()
+	locked := &models.User{Name: "locked", LDAPUserPass: models.LDAPUserPass{Account: "locked@example.com", Locked: true, LockedAt: &now, LoginRetryCount: 5}}
+	for _, u := range []*models.User{expired, locked} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if expired.GetLocked() || !locked.GetLocked() {
+		t.Errorf("超过锁定时长后应该自动解锁")
+	}
+	if err := expired.IncreaseRetryCount(db, &models.User{}); err != nil {
+		t.Fatal(err)
+	}
+	var saved models.User
+	db.First(&saved, expired.ID)
+	if saved.Locked || saved.LoginRetryCount != 1 {
+		t.Errorf("锁定过期后应该重新计数: %+v", saved.LDAPUserPass)
+	}
+
+	users, err := lockedUsers(db, time.Now())
+	if err != nil || len(users) != 1 || users[0].ID != locked.ID {
+		t.Fatalf("应该只列出锁定中的账号: %v %v", users, err)
+	}
+
+	useTestThrottle(t)
+	loginThrottler.fail(time.Now(), throttleKindAccount, "locked@example.com")
+	admin := createTestUserWithRole(t, db, "admin", models.RoleAdmin)
+	r := httptest.NewRequest("POST", "/login-lockouts", nil)
+	r = r.WithContext(context.WithValue(r.Context(), login.UserKey, admin))
+	if err := unlockUser(&web.EventContext{R: r, W: httptest.NewRecorder()}, db, ab, fmt.Sprint(locked.ID)); err != nil {
+		t.Fatal(err)
+	}
+	var unlocked models.User
+	db.First(&unlocked, locked.ID)
+	if unlocked.GetLocked() || unlocked.LoginRetryCount != 0 || len(loginThrottler.entries) != 0 {
+		t.Errorf("手动解锁后应该清除锁定和渐进延迟: %+v", unlocked.LDAPUserPass)
+	}
+}
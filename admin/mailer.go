@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+邮件发送说明：
+
+邀请新用户、找回密码、定时任务的执行通知等功能通过Mailer发送邮件，Mailer是可替换的接口：
+
+1. 配置方式
+   - SMTP_HOST: SMTP服务器地址，不配置时不发送邮件，需要发送邮件的功能会提示未配置
+   - SMTP_PORT: SMTP服务器端口，默认587；465端口使用SMTPS直接建立TLS连接，其他端口在服务器支持时使用STARTTLS
+   - SMTP_USERNAME / SMTP_PASSWORD: SMTP认证的用户名和密码，不配置时不认证
+   - SMTP_FROM: 发件人，例如"qor5boot <noreply@example.com>"，默认使用SMTP_USERNAME
+
+2. 实现
+   - smtpMailer: 通过SMTP发送，每封邮件建立一个连接
+   - memoryMailer: 保存在内存中，用于测试
+*/
+
+// smtpTimeout 连接SMTP服务器和发送邮件的超时时间
+const smtpTimeout = 30 * time.Second
+
+var errMailerNotConfigured = errors.New("没有配置SMTP_HOST，无法发送邮件")
+
+// Mail 要发送的纯文本邮件
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 发送邮件的接口
+type Mailer interface {
+	Send(ctx context.Context, m *Mail) error
+}
+
+// currentMailer 当前使用的邮件发送方式，没有配置SMTP时为nil
+var currentMailer = mailerFromEnv()
+
+// mailerFromEnv 从环境变量创建SMTP邮件发送方式
+func mailerFromEnv() Mailer {
+	host := getEnvWithDefault("SMTP_HOST", "")
+	if host == "" {
+		return nil
+	}
+	username := getEnvWithDefault("SMTP_USERNAME", "")
+	return &smtpMailer{
+		Host:     host,
+		Port:     getEnvWithDefaultInt("SMTP_PORT", 587),
+		Username: username,
+		Password: getEnvWithDefault("SMTP_PASSWORD", ""),
+		From:     getEnvWithDefault("SMTP_FROM", username),
+	}
+}
+
+// sendMail 使用当前的邮件发送方式发送邮件
+func sendMail(ctx context.Context, m *Mail) error {
+	if currentMailer == nil {
+		return errMailerNotConfigured
+	}
+	return currentMailer.Send(ctx, m)
+}
+
+// smtpMailer 通过SMTP发送邮件
+type smtpMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send 连接SMTP服务器发送邮件
+func (s *smtpMailer) Send(ctx context.Context, m *Mail) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("SMTP_FROM格式错误: %w", err)
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("收件人格式错误: %w", err)
+	}
+	msg, err := buildMailMessage(from, to, m, time.Now())
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	if s.Port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && s.Port != 465 {
+		if err = c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err = c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMailMessage 生成UTF-8编码的纯文本邮件，主题使用RFC 2047编码，正文使用quoted-printable编码
+func buildMailMessage(from, to *mail.Address, m *Mail, now time.Time) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(m.Body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// recurringNotifyOutputLimit 执行通知邮件中输出的最大长度，超过时只保留最后的部分
+const recurringNotifyOutputLimit = 4000
+
+// sendRecurringJobNotification 把定时任务的执行结果发送给任务的通知收件人
+// 后台执行没有请求的语言，使用默认的中文
+func sendRecurringJobNotification(ctx context.Context, job *models.RecurringJob, execution *models.RecurringJobExecution) error {
+	msgr := Messages_zh_CN
+	result := msgr.RecurringNotifySucceeded
+	if !execution.Success {
+		result = msgr.RecurringNotifyFailed
+	}
+	output := execution.Output
+	if len(output) > recurringNotifyOutputLimit {
+		output = "...\n" + strings.ToValidUTF8(output[len(output)-recurringNotifyOutputLimit:], "")
+	}
+
+	var errs []error
+	for _, to := range job.NotifyEmailList() {
+		if err := sendMail(ctx, &Mail{
+			To:      to,
+			Subject: fmt.Sprintf(msgr.RecurringNotifyMailSubject, job.Name, result),
+			Body: fmt.Sprintf(msgr.RecurringNotifyMailBody, job.Name, result,
+				execution.StartedAt.Local().Format(time.DateTime), execution.Duration, execution.Error, output),
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", to, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// memoryMailer 把邮件保存在内存中，用于测试
+type memoryMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+// Send 保存邮件
+func (m *memoryMailer) Send(ctx context.Context, mail *Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, *mail)
+	return nil
+}
+
+// Sent 返回已经发送的邮件
+func (m *memoryMailer) Sent() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail(nil), m.sent...)
+}
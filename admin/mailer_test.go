@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+func TestBuildMailMessage(t *testing.T) {
+	from := &mail.Address{Name: "qor5boot", Address: "noreply@example.com"}
+	to := &mail.Address{Name: "张三", Address: "zhangsan@example.com"}
+	body := "您好，请打开下面的链接设置密码：\nhttps://example.com/auth/invitation?token=1.2.abc=="
+	msg, err := buildMailMessage(from, to, &Mail{To: to.String(), Subject: "邀请您加入QOR5Boot", Body: body}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("邮件格式错误: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != "邀请您加入QOR5Boot" {
+		t.Errorf("主题编码错误: %q %v", subject, err)
+	}
+	if addr, err := m.Header.AddressList("To"); err != nil || len(addr) != 1 || addr[0].Name != "张三" {
+		t.Errorf("收件人编码错误: %v %v", addr, err)
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+	// 邮件正文的换行是CRLF
+	if err != nil || string(decoded) != strings.ReplaceAll(body, "\n", "\r\n") {
+		t.Errorf("正文编码错误: %q %v", decoded, err)
+	}
+}
+
+func TestSendMailNotConfigured(t *testing.T) {
+	old := currentMailer
+	currentMailer = nil
+	t.Cleanup(func() { currentMailer = old })
+	if err := sendMail(context.Background(), &Mail{To: "a@example.com"}); err != errMailerNotConfigured {
+		t.Errorf("没有配置SMTP时应该返回错误，实际 %v", err)
+	}
+}
+
+func TestSendRecurringJobNotification(t *testing.T) {
+	mailer := useMemoryMailer(t)
+	job := &models.RecurringJob{Name: "nightly-report"}
+	job.SetNotifyEmails([]string{"ops@example.com", "dev@example.com"})
+	execution := &models.RecurringJobExecution{
+		StartedAt: time.Now(),
+		Error:     "boom",
+		Output:    strings.Repeat("x", recurringNotifyOutputLimit) + "tail",
+	}
+	if err := sendRecurringJobNotification(context.Background(), job, execution); err != nil {
+		t.Fatal(err)
+	}
+	sent := mailer.Sent()
+	if len(sent) != 2 || sent[0].To != "ops@example.com" || sent[1].To != "dev@example.com" {
+		t.Fatalf("应该发送给每个通知收件人: %+v", sent)
+	}
+	if !strings.Contains(sent[0].Subject, "nightly-report") || !strings.Contains(sent[0].Subject, Messages_zh_CN.RecurringNotifyFailed) {
+		t.Errorf("邮件标题应该包含任务名称和执行结果，实际 %q", sent[0].Subject)
+	}
+	if !strings.Contains(sent[0].Body, "boom") || !strings.HasSuffix(strings.TrimSpace(sent[0].Body), "tail") ||
+		len(sent[0].Body) > recurringNotifyOutputLimit+500 {
+		t.Errorf("邮件内容应该包含错误信息和输出的最后部分")
+	}
+}
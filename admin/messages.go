@@ -22,6 +22,7 @@ type Messages struct {
 	EditAlt           string
 	Show              string
 	ExtraExport       string
+	Back              string
 
 	// 通用过滤器标签
 	FilterTabsAll            string
@@ -46,10 +47,148 @@ type Messages struct {
 	OAuthCompleteInfoPositionLabel string
 	OAuthCompleteInfoAgreeLabel    string
 	OAuthCompleteInfoBackLabel     string
-	PasswordMinLengthHint          string
-	PasswordMinLengthError         string
 	PasswordPlaceholder            string
 
+	// 密码策略
+	PasswordPolicyHint        string
+	PasswordPolicyHintClasses string
+	PasswordCharClassUpper    string
+	PasswordCharClassLower    string
+	PasswordCharClassDigit    string
+	PasswordCharClassSymbol   string
+	PasswordListSeparator     string
+	PasswordTooShort          string
+	PasswordMissingCharClass  string
+	PasswordTooCommon         string
+	PasswordContainsAccount   string
+	PasswordReused            string
+	PasswordExpired           string
+
+	// 双因素认证
+	UserTOTP             string
+	UserTOTPEnabled      string
+	UserTOTPNotEnabled   string
+	UserTOTPRequired     string
+	UserTOTPResetBtn     string
+	UserTOTPResetSuccess string
+	ProfileSetupTOTP     string
+
+	WebAuthnTitle             string
+	WebAuthnPrompt            string
+	WebAuthnNone              string
+	WebAuthnNamePlaceholder   string
+	WebAuthnRegisterBtn       string
+	WebAuthnRevokeBtn         string
+	WebAuthnRegisteredAt      string
+	WebAuthnLastUsedAt        string
+	WebAuthnNeverUsed         string
+	WebAuthnLoginBtn          string
+	WebAuthnVerifyBtn         string
+	WebAuthnVerifyPrompt      string
+	WebAuthnSetupInstead      string
+	WebAuthnUnsupported       string
+	WebAuthnFailed            string
+	WebAuthnNameRequired      string
+	UserWebAuthnRevokeSuccess string
+
+	RecoveryCodesTitle          string
+	RecoveryCodesPrompt         string
+	RecoveryCodesSaveWarning    string
+	RecoveryCodesRemaining      string
+	RecoveryCodesRegenerateBtn  string
+	RecoveryCodesRegenerateHint string
+	RecoveryCodesContinue       string
+	RecoveryCodesValidateHint   string
+
+	AccessTokenTitle           string
+	AccessTokenPrompt          string
+	AccessTokenNone            string
+	AccessTokenNamePlaceholder string
+	AccessTokenNameRequired    string
+	AccessTokenScope           string
+	AccessTokenScopeRead       string
+	AccessTokenScopeWrite      string
+	AccessTokenExpiry          string
+	AccessTokenExpiryDays      string
+	AccessTokenCreateBtn       string
+	AccessTokenCreated         string
+	AccessTokenRevokeBtn       string
+	AccessTokenExpiresAt       string
+	AccessTokenExpired         string
+	AccessTokenNeverExpires    string
+	AccessTokenLastUsedAt      string
+	AccessTokenNeverUsed       string
+
+	ImpersonateBtn       string
+	ImpersonateFailed    string
+	ImpersonationBanner  string
+	ImpersonationStopBtn string
+
+	InvitationDialogPrompt     string
+	InvitationSent             string
+	InvitationResent           string
+	InvitationSendFailed       string
+	InvitationResendBtn        string
+	InvitationMailSubject      string
+	InvitationMailBody         string
+	InvitationTitle            string
+	InvitationPrompt           string
+	InvitationEnrollTOTP       string
+	InvitationSubmitBtn        string
+	InvitationPasswordMismatch string
+	InvitationInvalid          string
+	InvitationFailed           string
+	InvitationAccepted         string
+	InvitationPendingLogin     string
+
+	PasswordResetMailSubject     string
+	PasswordResetMailBody        string
+	PasswordResetDirectoryHint   string
+	PasswordResetUnavailable     string
+	PasswordResetTooManyRequests string
+
+	SessionTitle           string
+	SessionNone            string
+	SessionTimes           string
+	SessionRevokeBtn       string
+	SessionRevokeUserBtn   string
+	SessionRevokeAllPrompt string
+	SessionRevoked         string
+	SessionUserRevoked     string
+	SessionAllRevoked      string
+	SessionRevokeFailed    string
+
+	LoginThrottled            string
+	LoginUserInactive         string
+	LoginLockoutTitle         string
+	LoginLockedAccounts       string
+	LoginThrottledClients     string
+	LoginLockedAt             string
+	LoginUnlocksAt            string
+	LoginThrottleKind         string
+	LoginThrottleKindIP       string
+	LoginThrottleKindAccount  string
+	LoginThrottleKindTOTP     string
+	LoginThrottleValue        string
+	LoginThrottleFailures     string
+	LoginThrottleBlockedUntil string
+	LoginLockoutNone          string
+	LoginUnlockBtn            string
+	LoginUnlocked             string
+	LoginUnlockFailed         string
+
+	CaptchaLabel       string
+	CaptchaPlaceholder string
+	CaptchaImageAlt    string
+	CaptchaRefresh     string
+	CaptchaRequired    string
+	CaptchaInvalid     string
+
+	RecurringNotifyMailSubject string
+	RecurringNotifyMailBody    string
+	RecurringNotifySucceeded   string
+	RecurringNotifyFailed      string
+
 	// 用户信息
 	Name           string
 	Email          string
@@ -133,6 +272,7 @@ type Messages struct {
 	UserStatus5            string
 	UserStatusActive       string
 	UserStatusInactive     string
+	UserStatusPending      string
 	UserStatusUnset        string
 	UserDeletedFilter      string
 	DeletedUsersTab        string
@@ -152,6 +292,74 @@ type Messages struct {
 	CantEditDeletedUser    string
 	InvalidUserObject      string
 
+	// LDAP Settings
+	LDAPBindPasswordPlaceholder string
+	LDAPBindPasswordRequired    string
+	LDAPGroupRoleMappingHint    string
+	LDAPInvalidServers          string
+	LDAPServersRequired         string
+	LDAPInvalidGroupRoleMapping string
+	LDAPDiagnostics             string
+	LDAPDiagnosticsHint         string
+	LDAPDiagnosticsPassed       string
+	LDAPDiagnosticsFailed       string
+	LDAPTestAccount             string
+	LDAPTestPassword            string
+	LDAPTestConnection          string
+	LDAPLookupUser              string
+	LDAPTestBind                string
+	LDAPTestAccountRequired     string
+	LDAPTestPasswordRequired    string
+	LDAPStepConfig              string
+	LDAPStepConnect             string
+	LDAPStepStartTLS            string
+	LDAPStepServiceBind         string
+	LDAPStepSearchUser          string
+	LDAPStepAttributes          string
+	LDAPStepUserBind            string
+	LDAPStepGroups              string
+	LDAPStepRoles               string
+	LDAPAnonymousBind           string
+	LDAPNotEncrypted            string
+	LDAPNone                    string
+	LDAPUserNotFound            string
+	LDAPMultipleUsersFound      string
+
+	// Login Events
+	LoginEventTime               string
+	LoginEventResult             string
+	LoginEventMethod             string
+	LoginEventIP                 string
+	LoginEventSucceeded          string
+	LoginEventFailed             string
+	LoginMethodPassword          string
+	LoginMethodLDAP              string
+	LoginMethodOAuth             string
+	LoginMethodSAML              string
+	LoginMethodTOTP              string
+	LoginMethodWebAuthn          string
+	LoginMethodRecoveryCode      string
+	LoginReasonWrongPassword     string
+	LoginReasonUserNotFound      string
+	LoginReasonUserLocked        string
+	LoginReasonWrongTOTPCode     string
+	LoginReasonTOTPCodeUsed      string
+	LoginReasonWebAuthnFailed    string
+	LoginReasonWrongRecoveryCode string
+	LoginReasonUserInactive      string
+	LoginReasonOAuthFailed       string
+	LoginReasonDomainDenied      string
+	LoginReasonError             string
+
+	// OAuth Role Rules
+	OAuthRoleRuleAny           string
+	OAuthRoleRuleProviderHint  string
+	OAuthRoleRuleDomainHint    string
+	OAuthRoleRuleGroupHint     string
+	OAuthRoleRuleInvalidDomain string
+	OAuthRoleRuleRolesRequired string
+	OAuthDomainNotAllowed      string
+
 	// SEO Admin
 	SEOSettings string
 	SEO         string
@@ -186,6 +394,7 @@ var Messages_en_US = &Messages{
 	EditAlt:           "Edit",
 	Show:              "Show",
 	ExtraExport:       "Export",
+	Back:              "Back",
 
 	// 通用过滤器标签
 	FilterTabsAll:            "All",
@@ -210,10 +419,148 @@ var Messages_en_US = &Messages{
 	OAuthCompleteInfoPositionLabel: "Position",
 	OAuthCompleteInfoAgreeLabel:    "Agree",
 	OAuthCompleteInfoBackLabel:     "Back",
-	PasswordMinLengthHint:          "Password Min Length Hint",
-	PasswordMinLengthError:         "Password Min Length Error",
 	PasswordPlaceholder:            "Password Placeholder",
 
+	// 密码策略
+	PasswordPolicyHint:        "At least %d characters",
+	PasswordPolicyHintClasses: ", including %s",
+	PasswordCharClassUpper:    "uppercase letters",
+	PasswordCharClassLower:    "lowercase letters",
+	PasswordCharClassDigit:    "digits",
+	PasswordCharClassSymbol:   "symbols",
+	PasswordListSeparator:     ", ",
+	PasswordTooShort:          "Password must be at least %d characters",
+	PasswordMissingCharClass:  "Password must include %s",
+	PasswordTooCommon:         "Password is too common, please choose another one",
+	PasswordContainsAccount:   "Password must not contain your account or name",
+	PasswordReused:            "Password must not be one of your last %d passwords",
+	PasswordExpired:           "Your password has expired, please change it",
+
+	// 双因素认证
+	UserTOTP:             "Two-factor authentication",
+	UserTOTPEnabled:      "Enabled",
+	UserTOTPNotEnabled:   "Not enabled",
+	UserTOTPRequired:     "Required by role, must be set up at next login",
+	UserTOTPResetBtn:     "Reset two-factor",
+	UserTOTPResetSuccess: "Two-factor authentication has been reset",
+	ProfileSetupTOTP:     "Set up two-factor",
+
+	WebAuthnTitle:             "Security keys",
+	WebAuthnPrompt:            "Passkeys and security keys let you sign in without a password and can be used as a second factor.",
+	WebAuthnNone:              "No security keys registered",
+	WebAuthnNamePlaceholder:   "Key name, e.g. Office laptop",
+	WebAuthnRegisterBtn:       "Register a security key",
+	WebAuthnRevokeBtn:         "Revoke",
+	WebAuthnRegisteredAt:      "Registered %s",
+	WebAuthnLastUsedAt:        "last used %s",
+	WebAuthnNeverUsed:         "never used",
+	WebAuthnLoginBtn:          "Sign in with a passkey",
+	WebAuthnVerifyBtn:         "Use a security key",
+	WebAuthnVerifyPrompt:      "Verify with one of your registered security keys",
+	WebAuthnSetupInstead:      "Use a security key instead",
+	WebAuthnUnsupported:       "This browser does not support passkeys or security keys",
+	WebAuthnFailed:            "Verification failed, please try again",
+	WebAuthnNameRequired:      "Please enter a name for the key",
+	UserWebAuthnRevokeSuccess: "Security key has been revoked",
+
+	RecoveryCodesTitle:          "Recovery codes",
+	RecoveryCodesPrompt:         "If you lose your authenticator, you can sign in with a recovery code instead of a verification code. Each code can only be used once.",
+	RecoveryCodesSaveWarning:    "Save these codes somewhere safe. They will not be shown again.",
+	RecoveryCodesRemaining:      "%d unused recovery codes left",
+	RecoveryCodesRegenerateBtn:  "Generate new codes",
+	RecoveryCodesRegenerateHint: "Generating new codes invalidates all existing codes.",
+	RecoveryCodesContinue:       "Continue",
+	RecoveryCodesValidateHint:   "Lost your authenticator? Enter a recovery code instead.",
+
+	AccessTokenTitle:           "Access tokens",
+	AccessTokenPrompt:          "Scripts can call the admin with a personal access token in the Authorization: Bearer header. A token has the same permissions as your account.",
+	AccessTokenNone:            "No access tokens",
+	AccessTokenNamePlaceholder: "Token name, e.g. Export script",
+	AccessTokenNameRequired:    "Please enter a name for the token",
+	AccessTokenScope:           "Scope",
+	AccessTokenScopeRead:       "Read only",
+	AccessTokenScopeWrite:      "Read and write",
+	AccessTokenExpiry:          "Expires in",
+	AccessTokenExpiryDays:      "%d days",
+	AccessTokenCreateBtn:       "Create token",
+	AccessTokenCreated:         "Copy the new token now. It will not be shown again.",
+	AccessTokenRevokeBtn:       "Revoke",
+	AccessTokenExpiresAt:       "Expires %s",
+	AccessTokenExpired:         "Expired",
+	AccessTokenNeverExpires:    "Never expires",
+	AccessTokenLastUsedAt:      "last used %s",
+	AccessTokenNeverUsed:       "never used",
+
+	ImpersonateBtn:       "Impersonate",
+	ImpersonateFailed:    "Failed to impersonate user: ",
+	ImpersonationBanner:  "You are impersonating %s (%s). Actions are recorded under your account.",
+	ImpersonationStopBtn: "Stop impersonating",
+
+	InvitationDialogPrompt:     "An invitation link will be emailed to the user so they can set their own password.",
+	InvitationSent:             "Invitation sent to %s",
+	InvitationResent:           "Invitation has been resent",
+	InvitationSendFailed:       "Failed to send invitation: ",
+	InvitationResendBtn:        "Resend invitation",
+	InvitationMailSubject:      "You have been invited to QOR5Boot",
+	InvitationMailBody:         "Hello %s,\n\nAn account has been created for you. Open the link below to set your password:\n\n%s\n\nThe link expires at %s. If you were not expecting this invitation, you can ignore this email.\n",
+	InvitationTitle:            "Accept invitation",
+	InvitationPrompt:           "Set a password for %s (%s)",
+	InvitationEnrollTOTP:       "Set up two-factor authentication after signing in",
+	InvitationSubmitBtn:        "Set password",
+	InvitationPasswordMismatch: "Passwords do not match",
+	InvitationInvalid:          "The invitation link is invalid or has expired. Please ask an administrator to resend it.",
+	InvitationFailed:           "Failed to accept the invitation, please try again later",
+	InvitationAccepted:         "Your password has been set. Please sign in.",
+	InvitationPendingLogin:     "Your account has not been activated yet. Please use the link in your invitation email to set a password, or ask an administrator to resend the invitation.",
+
+	PasswordResetMailSubject:     "Reset your QOR5Boot password",
+	PasswordResetMailBody:        "Hello %s,\n\nWe received a request to reset your password. Open the link below to set a new password:\n\n%s\n\nThe link expires at %s. If you did not request a password reset, you can ignore this email and your password will not change.\n",
+	PasswordResetDirectoryHint:   "Accounts that sign in with the company directory do not receive a reset email. Please reset your password through the directory service.",
+	PasswordResetUnavailable:     "Unable to send the password reset email. Please contact an administrator.",
+	PasswordResetTooManyRequests: "Too many password reset requests. Please try again later.",
+
+	SessionTitle:           "Active sessions",
+	SessionNone:            "No active sessions",
+	SessionTimes:           "Signed in %s, last active %s",
+	SessionRevokeBtn:       "Revoke",
+	SessionRevokeUserBtn:   "Revoke all sessions for this user",
+	SessionRevokeAllPrompt: "All users will be signed out, except your current session. Continue?",
+	SessionRevoked:         "Session has been revoked",
+	SessionUserRevoked:     "All sessions of the user have been revoked",
+	SessionAllRevoked:      "Revoked %d sessions",
+	SessionRevokeFailed:    "Failed to revoke session: ",
+
+	LoginThrottled:            "Too many failed sign-in attempts. Please try again in %d seconds.",
+	LoginUserInactive:         "Your account has been deactivated",
+	LoginLockoutTitle:         "Login lockouts",
+	LoginLockedAccounts:       "Locked accounts",
+	LoginThrottledClients:     "Throttled IPs and accounts",
+	LoginLockedAt:             "Locked at",
+	LoginUnlocksAt:            "Unlocks at",
+	LoginThrottleKind:         "Type",
+	LoginThrottleKindIP:       "IP",
+	LoginThrottleKindAccount:  "Account",
+	LoginThrottleKindTOTP:     "Two-factor user ID",
+	LoginThrottleValue:        "IP / Account",
+	LoginThrottleFailures:     "Failures",
+	LoginThrottleBlockedUntil: "Blocked until",
+	LoginLockoutNone:          "None",
+	LoginUnlockBtn:            "Unlock",
+	LoginUnlocked:             "Unlocked",
+	LoginUnlockFailed:         "Failed to unlock: ",
+
+	CaptchaLabel:       "Verification code",
+	CaptchaPlaceholder: "Enter the characters in the image",
+	CaptchaImageAlt:    "Verification code image",
+	CaptchaRefresh:     "Can't read it? Get another one",
+	CaptchaRequired:    "Too many failed attempts, please enter the verification code",
+	CaptchaInvalid:     "The verification code is incorrect or has expired, please try again",
+
+	RecurringNotifyMailSubject: "Recurring job %s %s",
+	RecurringNotifyMailBody:    "Job: %s\nResult: %s\nStarted at: %s\nDuration: %d ms\nError: %s\n\nOutput:\n%s\n",
+	RecurringNotifySucceeded:   "succeeded",
+	RecurringNotifyFailed:      "failed",
+
 	// 用户信息
 	Name:           "Name",
 	Email:          "Email",
@@ -297,6 +644,7 @@ var Messages_en_US = &Messages{
 	UserStatus5:            "Blocked",
 	UserStatusActive:       "Active",
 	UserStatusInactive:     "Inactive",
+	UserStatusPending:      "Pending",
 	UserStatusUnset:        "Unset",
 	UserDeletedFilter:      "Deleted",
 	DeletedUsersTab:        "Deleted Users",
@@ -316,6 +664,72 @@ var Messages_en_US = &Messages{
 	CantEditDeletedUser:    "Cannot edit deleted user",
 	InvalidUserObject:      "Invalid user object",
 
+	// LDAP Settings
+	LDAPBindPasswordPlaceholder: "Leave blank to keep the current password",
+	LDAPBindPasswordRequired:    "Bind password is required when a bind DN is set",
+	LDAPGroupRoleMappingHint:    "Format: group:role, separate multiple mappings with semicolons. The group can be a full DN or a CN",
+	LDAPInvalidServers:          "Some server addresses are invalid, use ldap://host:port, ldaps://host:port or host[:port]",
+	LDAPServersRequired:         "At least one server is required when LDAP is enabled",
+	LDAPInvalidGroupRoleMapping: "Some mappings are invalid, use group:role",
+	LDAPDiagnostics:             "Diagnostics",
+	LDAPDiagnosticsHint:         "Diagnostics use the settings in this form and do not save them",
+	LDAPDiagnosticsPassed:       "All checks passed",
+	LDAPDiagnosticsFailed:       "Some checks failed",
+	LDAPTestAccount:             "Test Account",
+	LDAPTestPassword:            "Test Password",
+	LDAPTestConnection:          "Test Connection",
+	LDAPLookupUser:              "Lookup User",
+	LDAPTestBind:                "Test Bind",
+	LDAPTestAccountRequired:     "Please enter a test account",
+	LDAPTestPasswordRequired:    "Please enter a test password",
+	LDAPStepConfig:              "Check settings",
+	LDAPStepConnect:             "Connect to",
+	LDAPStepStartTLS:            "StartTLS",
+	LDAPStepServiceBind:         "Service account bind",
+	LDAPStepSearchUser:          "Search user",
+	LDAPStepAttributes:          "User attributes",
+	LDAPStepUserBind:            "User bind",
+	LDAPStepGroups:              "Groups",
+	LDAPStepRoles:               "Mapped roles",
+	LDAPAnonymousBind:           "No bind DN, using anonymous access",
+	LDAPNotEncrypted:            "Not encrypted",
+	LDAPNone:                    "None",
+	LDAPUserNotFound:            "No user matches %s",
+	LDAPMultipleUsersFound:      "%d users match: %s",
+
+	LoginEventTime:               "Time",
+	LoginEventResult:             "Result",
+	LoginEventMethod:             "Method",
+	LoginEventIP:                 "IP",
+	LoginEventSucceeded:          "Succeeded",
+	LoginEventFailed:             "Failed",
+	LoginMethodPassword:          "Password",
+	LoginMethodLDAP:              "LDAP",
+	LoginMethodOAuth:             "OAuth",
+	LoginMethodSAML:              "SAML",
+	LoginMethodTOTP:              "Two-factor",
+	LoginMethodWebAuthn:          "Passkey / security key",
+	LoginMethodRecoveryCode:      "Recovery code",
+	LoginReasonWrongPassword:     "Wrong password",
+	LoginReasonUserNotFound:      "User not found",
+	LoginReasonUserLocked:        "User locked",
+	LoginReasonWrongTOTPCode:     "Wrong verification code",
+	LoginReasonTOTPCodeUsed:      "Verification code already used",
+	LoginReasonWebAuthnFailed:    "Security key verification failed",
+	LoginReasonWrongRecoveryCode: "Wrong or used recovery code",
+	LoginReasonUserInactive:      "User deactivated",
+	LoginReasonOAuthFailed:       "OAuth failed",
+	LoginReasonDomainDenied:      "Email domain not allowed",
+	LoginReasonError:             "Error",
+
+	OAuthRoleRuleAny:           "Any",
+	OAuthRoleRuleProviderHint:  "Leave empty to match all providers",
+	OAuthRoleRuleDomainHint:    "Email domain, e.g. example.com; *.example.com matches all subdomains; leave empty to match all domains",
+	OAuthRoleRuleGroupHint:     "Group returned by the OIDC or SAML provider; leave empty to match users in any group",
+	OAuthRoleRuleInvalidDomain: "Enter a domain such as example.com or *.example.com",
+	OAuthRoleRuleRolesRequired: "Select roles or set a company",
+	OAuthDomainNotAllowed:      "Your email domain is not allowed to sign in",
+
 	// SEO Admin
 	SEOSettings: "SEO Settings",
 	SEO:         "SEO",
@@ -350,6 +764,7 @@ var Messages_zh_CN = &Messages{
 	EditAlt:           "编辑",
 	Show:              "查看",
 	ExtraExport:       "导出",
+	Back:              "返回",
 
 	// 通用过滤器标签
 	FilterTabsAll:            "全部",
@@ -374,10 +789,148 @@ var Messages_zh_CN = &Messages{
 	OAuthCompleteInfoPositionLabel: "位置",
 	OAuthCompleteInfoAgreeLabel:    "同意",
 	OAuthCompleteInfoBackLabel:     "返回",
-	PasswordMinLengthHint:          "密码最小长度提示",
-	PasswordMinLengthError:         "密码最小长度错误",
 	PasswordPlaceholder:            "密码占位符",
 
+	// 密码策略
+	PasswordPolicyHint:        "至少%d个字符",
+	PasswordPolicyHintClasses: "，必须包含%s",
+	PasswordCharClassUpper:    "大写字母",
+	PasswordCharClassLower:    "小写字母",
+	PasswordCharClassDigit:    "数字",
+	PasswordCharClassSymbol:   "符号",
+	PasswordListSeparator:     "、",
+	PasswordTooShort:          "密码至少需要%d个字符",
+	PasswordMissingCharClass:  "密码必须包含%s",
+	PasswordTooCommon:         "密码过于常见，请换一个",
+	PasswordContainsAccount:   "密码不能包含账号或姓名",
+	PasswordReused:            "不能使用最近%d次用过的密码",
+	PasswordExpired:           "密码已过期，请修改密码",
+
+	// 双因素认证
+	UserTOTP:             "双因素认证",
+	UserTOTPEnabled:      "已启用",
+	UserTOTPNotEnabled:   "未启用",
+	UserTOTPRequired:     "角色要求启用，下次登录时需要设置",
+	UserTOTPResetBtn:     "重置双因素认证",
+	UserTOTPResetSuccess: "已重置双因素认证",
+	ProfileSetupTOTP:     "启用双因素认证",
+
+	WebAuthnTitle:             "安全密钥",
+	WebAuthnPrompt:            "通行密钥和安全密钥可以用于免密码登录，也可以作为双因素认证。",
+	WebAuthnNone:              "还没有注册安全密钥",
+	WebAuthnNamePlaceholder:   "密钥名称，例如办公室电脑",
+	WebAuthnRegisterBtn:       "注册安全密钥",
+	WebAuthnRevokeBtn:         "撤销",
+	WebAuthnRegisteredAt:      "注册于 %s",
+	WebAuthnLastUsedAt:        "最近使用 %s",
+	WebAuthnNeverUsed:         "从未使用",
+	WebAuthnLoginBtn:          "使用通行密钥登录",
+	WebAuthnVerifyBtn:         "使用安全密钥验证",
+	WebAuthnVerifyPrompt:      "使用已经注册的安全密钥验证",
+	WebAuthnSetupInstead:      "改用安全密钥",
+	WebAuthnUnsupported:       "当前浏览器不支持通行密钥和安全密钥",
+	WebAuthnFailed:            "验证失败，请重试",
+	WebAuthnNameRequired:      "请填写密钥名称",
+	UserWebAuthnRevokeSuccess: "已撤销安全密钥",
+
+	RecoveryCodesTitle:          "恢复码",
+	RecoveryCodesPrompt:         "丢失验证器时可以使用恢复码代替验证码登录，每个恢复码只能使用一次。",
+	RecoveryCodesSaveWarning:    "请妥善保存这些恢复码，离开本页面后将无法再次查看。",
+	RecoveryCodesRemaining:      "还有 %d 个未使用的恢复码",
+	RecoveryCodesRegenerateBtn:  "重新生成恢复码",
+	RecoveryCodesRegenerateHint: "重新生成后，原有的恢复码全部失效。",
+	RecoveryCodesContinue:       "继续",
+	RecoveryCodesValidateHint:   "丢失了验证器？可以输入恢复码代替验证码。",
+
+	AccessTokenTitle:           "访问令牌",
+	AccessTokenPrompt:          "脚本可以在Authorization: Bearer请求头中使用个人访问令牌调用管理后台，令牌的权限与你的账号相同。",
+	AccessTokenNone:            "还没有访问令牌",
+	AccessTokenNamePlaceholder: "令牌名称，例如导出脚本",
+	AccessTokenNameRequired:    "请填写令牌名称",
+	AccessTokenScope:           "权限范围",
+	AccessTokenScopeRead:       "只读",
+	AccessTokenScopeWrite:      "读写",
+	AccessTokenExpiry:          "有效期",
+	AccessTokenExpiryDays:      "%d 天",
+	AccessTokenCreateBtn:       "创建令牌",
+	AccessTokenCreated:         "请立即复制新的令牌，离开本页面后将无法再次查看。",
+	AccessTokenRevokeBtn:       "撤销",
+	AccessTokenExpiresAt:       "%s 过期",
+	AccessTokenExpired:         "已过期",
+	AccessTokenNeverExpires:    "永不过期",
+	AccessTokenLastUsedAt:      "最近使用 %s",
+	AccessTokenNeverUsed:       "从未使用",
+
+	ImpersonateBtn:       "模拟用户",
+	ImpersonateFailed:    "模拟用户失败：",
+	ImpersonationBanner:  "正在模拟 %s（%s），所有操作都会记录在您的账号下。",
+	ImpersonationStopBtn: "停止模拟",
+
+	InvitationDialogPrompt:     "系统会通过邮件向用户发送邀请链接，由用户自己设置密码。",
+	InvitationSent:             "已向 %s 发送邀请",
+	InvitationResent:           "已重新发送邀请",
+	InvitationSendFailed:       "发送邀请失败：",
+	InvitationResendBtn:        "重新发送邀请",
+	InvitationMailSubject:      "邀请您加入QOR5Boot",
+	InvitationMailBody:         "%s，您好：\n\n管理员为您创建了账号，请打开下面的链接设置密码：\n\n%s\n\n链接在 %s 之前有效。如果您不知道这个邀请，请忽略这封邮件。\n",
+	InvitationTitle:            "接受邀请",
+	InvitationPrompt:           "为 %s（%s）设置密码",
+	InvitationEnrollTOTP:       "登录后立即设置双因素认证",
+	InvitationSubmitBtn:        "设置密码",
+	InvitationPasswordMismatch: "两次输入的密码不一致",
+	InvitationInvalid:          "邀请链接无效或已过期，请联系管理员重新发送。",
+	InvitationFailed:           "接受邀请失败，请稍后重试",
+	InvitationAccepted:         "密码已设置，请登录。",
+	InvitationPendingLogin:     "您的账号还没有激活，请使用邀请邮件中的链接设置密码，或联系管理员重新发送邀请。",
+
+	PasswordResetMailSubject:     "重置您的QOR5Boot密码",
+	PasswordResetMailBody:        "%s，您好：\n\n我们收到了重置您密码的请求，请打开下面的链接设置新密码：\n\n%s\n\n链接在 %s 之前有效。如果您没有申请重置密码，请忽略这封邮件，您的密码不会改变。\n",
+	PasswordResetDirectoryHint:   "通过企业目录登录的账号不会收到重置邮件，请到目录服务中重置密码。",
+	PasswordResetUnavailable:     "无法发送重置密码邮件，请联系管理员。",
+	PasswordResetTooManyRequests: "找回密码的请求过于频繁，请稍后再试。",
+
+	SessionTitle:           "登录会话",
+	SessionNone:            "没有登录中的会话",
+	SessionTimes:           "登录于 %s，最后活动于 %s",
+	SessionRevokeBtn:       "撤销",
+	SessionRevokeUserBtn:   "撤销该用户的所有会话",
+	SessionRevokeAllPrompt: "除了您当前的会话，所有用户都会退出登录，确定继续吗？",
+	SessionRevoked:         "已撤销会话",
+	SessionUserRevoked:     "已撤销该用户的所有会话",
+	SessionAllRevoked:      "已撤销 %d 个会话",
+	SessionRevokeFailed:    "撤销会话失败：",
+
+	LoginThrottled:            "登录失败次数过多，请 %d 秒后再试。",
+	LoginUserInactive:         "您的账号已被停用",
+	LoginLockoutTitle:         "登录锁定",
+	LoginLockedAccounts:       "锁定的账号",
+	LoginThrottledClients:     "受限的IP和账号",
+	LoginLockedAt:             "锁定时间",
+	LoginUnlocksAt:            "自动解锁时间",
+	LoginThrottleKind:         "类型",
+	LoginThrottleKindIP:       "IP",
+	LoginThrottleKindAccount:  "账号",
+	LoginThrottleKindTOTP:     "双因素认证用户ID",
+	LoginThrottleValue:        "IP / 账号",
+	LoginThrottleFailures:     "失败次数",
+	LoginThrottleBlockedUntil: "限制到",
+	LoginLockoutNone:          "无",
+	LoginUnlockBtn:            "解锁",
+	LoginUnlocked:             "已解锁",
+	LoginUnlockFailed:         "解锁失败：",
+
+	CaptchaLabel:       "验证码",
+	CaptchaPlaceholder: "请输入图片中的字符",
+	CaptchaImageAlt:    "验证码图片",
+	CaptchaRefresh:     "看不清？换一张",
+	CaptchaRequired:    "登录失败次数过多，请输入验证码",
+	CaptchaInvalid:     "验证码错误或已过期，请重新输入",
+
+	RecurringNotifyMailSubject: "定时任务 %s %s",
+	RecurringNotifyMailBody:    "任务：%s\n结果：%s\n开始时间：%s\n耗时：%d毫秒\n错误：%s\n\n输出：\n%s\n",
+	RecurringNotifySucceeded:   "执行成功",
+	RecurringNotifyFailed:      "执行失败",
+
 	// 用户信息
 	Name:           "名称",
 	Email:          "电子邮件",
@@ -461,6 +1014,7 @@ var Messages_zh_CN = &Messages{
 	UserStatus5:            "已阻止",
 	UserStatusActive:       "活跃",
 	UserStatusInactive:     "未激活",
+	UserStatusPending:      "待接受邀请",
 	UserStatusUnset:        "未设置",
 	UserDeletedFilter:      "已删除",
 	DeletedUsersTab:        "已删除用户",
@@ -480,6 +1034,72 @@ var Messages_zh_CN = &Messages{
 	CantEditDeletedUser:    "不能编辑已删除的用户",
 	InvalidUserObject:      "无效的用户对象",
 
+	// LDAP Settings
+	LDAPBindPasswordPlaceholder: "留空表示不修改密码",
+	LDAPBindPasswordRequired:    "填写了服务账号DN时需要填写密码",
+	LDAPGroupRoleMappingHint:    "格式为\"组:角色\"，多个映射用分号分隔，组可以是完整的DN或组的CN",
+	LDAPInvalidServers:          "部分服务器地址格式错误，请使用ldap://host:port、ldaps://host:port或host[:port]",
+	LDAPServersRequired:         "启用LDAP认证时需要至少填写一个服务器",
+	LDAPInvalidGroupRoleMapping: "部分映射格式错误，请使用\"组:角色\"",
+	LDAPDiagnostics:             "诊断",
+	LDAPDiagnosticsHint:         "诊断使用表单中填写的配置，不会保存",
+	LDAPDiagnosticsPassed:       "所有检查都已通过",
+	LDAPDiagnosticsFailed:       "部分检查未通过",
+	LDAPTestAccount:             "测试账号",
+	LDAPTestPassword:            "测试密码",
+	LDAPTestConnection:          "测试连接",
+	LDAPLookupUser:              "查找用户",
+	LDAPTestBind:                "测试登录",
+	LDAPTestAccountRequired:     "请填写测试账号",
+	LDAPTestPasswordRequired:    "请填写测试密码",
+	LDAPStepConfig:              "检查配置",
+	LDAPStepConnect:             "连接",
+	LDAPStepStartTLS:            "StartTLS",
+	LDAPStepServiceBind:         "服务账号绑定",
+	LDAPStepSearchUser:          "搜索用户",
+	LDAPStepAttributes:          "用户属性",
+	LDAPStepUserBind:            "用户绑定",
+	LDAPStepGroups:              "所属组",
+	LDAPStepRoles:               "映射的角色",
+	LDAPAnonymousBind:           "未配置服务账号，使用匿名访问",
+	LDAPNotEncrypted:            "未加密",
+	LDAPNone:                    "无",
+	LDAPUserNotFound:            "没有匹配 %s 的用户",
+	LDAPMultipleUsersFound:      "找到 %d 个匹配的用户: %s",
+
+	LoginEventTime:               "时间",
+	LoginEventResult:             "结果",
+	LoginEventMethod:             "登录方式",
+	LoginEventIP:                 "IP",
+	LoginEventSucceeded:          "成功",
+	LoginEventFailed:             "失败",
+	LoginMethodPassword:          "本地密码",
+	LoginMethodLDAP:              "LDAP",
+	LoginMethodOAuth:             "OAuth",
+	LoginMethodSAML:              "SAML",
+	LoginMethodTOTP:              "双因素认证",
+	LoginMethodWebAuthn:          "通行密钥/安全密钥",
+	LoginMethodRecoveryCode:      "恢复码",
+	LoginReasonWrongPassword:     "密码错误",
+	LoginReasonUserNotFound:      "用户不存在",
+	LoginReasonUserLocked:        "用户已锁定",
+	LoginReasonWrongTOTPCode:     "验证码错误",
+	LoginReasonTOTPCodeUsed:      "验证码已使用",
+	LoginReasonWebAuthnFailed:    "安全密钥验证失败",
+	LoginReasonWrongRecoveryCode: "恢复码错误或已使用",
+	LoginReasonUserInactive:      "用户已停用",
+	LoginReasonOAuthFailed:       "OAuth认证失败",
+	LoginReasonDomainDenied:      "邮箱域名不允许登录",
+	LoginReasonError:             "其他错误",
+
+	OAuthRoleRuleAny:           "任意",
+	OAuthRoleRuleProviderHint:  "留空匹配所有提供商",
+	OAuthRoleRuleDomainHint:    "邮箱域名，例如example.com；*.example.com匹配所有子域名；留空匹配所有域名",
+	OAuthRoleRuleGroupHint:     "OIDC或SAML提供商返回的组；留空不限制组",
+	OAuthRoleRuleInvalidDomain: "请输入example.com或*.example.com格式的域名",
+	OAuthRoleRuleRolesRequired: "请选择角色或填写公司",
+	OAuthDomainNotAllowed:      "您的邮箱域名不允许登录",
+
 	// SEO Admin
 	SEOSettings: "SEO设置",
 	SEO:         "SEO",
@@ -503,7 +1123,10 @@ type Messages_ModelsI18nModuleKey struct {
 	QOR5Example  string
 	Roles        string
 	Users        string
-	Dashboard    string
+	UsersInvite  string
+
+	UsersRevokeAllSessions string
+	Dashboard              string
 
 	Posts          string
 	PostsID        string
@@ -556,6 +1179,9 @@ type Messages_ModelsI18nModuleKey struct {
 	RecurringJobsErrorCount     string
 	RecurringJobsActions        string
 	RecurringJobsRuns           string
+	RecurringJobsTags           string
+	RecurringJobsOwner          string
+	RecurringJobsOwnerRole      string
 
 	// 重复任务状态值
 	RecurringJobsStatusActive    string
@@ -575,6 +1201,7 @@ type Messages_ModelsI18nModuleKey struct {
 	RecurringJobsTabPaused    string
 	RecurringJobsTabCompleted string
 	RecurringJobsTabError     string
+	RecurringJobsTabMine      string
 
 	// 重复任务日志相关
 	RecurringJobLogsID         string
@@ -717,6 +1344,54 @@ type Messages_ModelsI18nModuleKey struct {
 	RecurringJobExecution string
 	Worker                string
 	WorkerJob             string
+
+	// LDAP设置
+	Ldapsettings                  string
+	Ldapsetting                   string
+	LdapsettingsEnabled           string
+	LdapsettingsServers           string
+	LdapsettingsBindDn            string
+	LdapsettingsBindPassword      string
+	LdapsettingsSearchBase        string
+	LdapsettingsSearchFilter      string
+	LdapsettingsUseTls            string
+	LdapsettingsSkipVerify        string
+	LdapsettingsCertFile          string
+	LdapsettingsGroupAttribute    string
+	LdapsettingsGroupSearchBase   string
+	LdapsettingsGroupSearchFilter string
+	LdapsettingsGroupRoleMapping  string
+	LdapsettingsRevokeRoles       string
+	LdapsettingsJitProvisioning   string
+	LdapsettingsDefaultRoles      string
+	LdapsettingsNameAttribute     string
+	LdapsettingsCompanyAttribute  string
+	LdapsettingsSyncFilter        string
+	LdapsettingsDiagnostics       string
+
+	// 登录事件
+	LoginEvents          string
+	LoginEvent           string
+	LoginEventsCreatedAt string
+	LoginEventsUserId    string
+	LoginEventsAccount   string
+	LoginEventsMethod    string
+	LoginEventsProvider  string
+	LoginEventsSuccess   string
+	LoginEventsReason    string
+	LoginEventsIp        string
+	LoginEventsUserAgent string
+
+	// OAuth角色规则
+	OauthRoleRules          string
+	OauthRoleRule           string
+	OauthRoleRulesProvider  string
+	OauthRoleRulesDomain    string
+	OauthRoleRulesGroup     string
+	OauthRoleRulesRoles     string
+	OauthRoleRulesCompany   string
+	OauthRoleRulesPriority  string
+	OauthRoleRulesCreatedAt string
 }
 
 var Messages_en_US_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
@@ -737,7 +1412,10 @@ var Messages_en_US_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
 	QOR5Example: "QOR5 Example",
 	Roles:       "Roles",
 	Users:       "Users",
-	Dashboard:   "Dashboard",
+	UsersInvite: "Invite user",
+
+	UsersRevokeAllSessions: "Revoke all sessions",
+	Dashboard:              "Dashboard",
 
 	PageBuilder:              "Page Builder Menu",
 	Pages:                    "Pages",
@@ -776,6 +1454,9 @@ var Messages_en_US_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
 	RecurringJobsErrorCount:     "Error Count",
 	RecurringJobsActions:        "Actions",
 	RecurringJobsRuns:           "Runs",
+	RecurringJobsTags:           "Tags",
+	RecurringJobsOwner:          "Owner",
+	RecurringJobsOwnerRole:      "Owner Role",
 
 	// 重复任务状态值
 	RecurringJobsStatusActive:    "Active",
@@ -795,6 +1476,7 @@ var Messages_en_US_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
 	RecurringJobsTabPaused:    "Paused Tasks",
 	RecurringJobsTabCompleted: "Completed Tasks",
 	RecurringJobsTabError:     "Error Tasks",
+	RecurringJobsTabMine:      "My Tasks",
 
 	// 重复任务日志相关
 	RecurringJobLogsID:         "ID",
@@ -937,6 +1619,51 @@ var Messages_en_US_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
 	RecurringJobExecution: "Recurring Job Execution",
 	Worker:                "Worker",
 	WorkerJob:             "Worker Job",
+
+	Ldapsettings:                  "LDAP Settings",
+	Ldapsetting:                   "LDAP Settings",
+	LdapsettingsEnabled:           "Enable LDAP Authentication",
+	LdapsettingsServers:           "Servers (comma separated, in priority order)",
+	LdapsettingsBindDn:            "Bind DN",
+	LdapsettingsBindPassword:      "Bind Password",
+	LdapsettingsSearchBase:        "Search Base",
+	LdapsettingsSearchFilter:      "User Search Filter",
+	LdapsettingsUseTls:            "Use StartTLS",
+	LdapsettingsSkipVerify:        "Skip TLS Certificate Verification",
+	LdapsettingsCertFile:          "CA Certificate File",
+	LdapsettingsGroupAttribute:    "Group Attribute",
+	LdapsettingsGroupSearchBase:   "Group Search Base",
+	LdapsettingsGroupSearchFilter: "Group Search Filter",
+	LdapsettingsGroupRoleMapping:  "Group to Role Mapping",
+	LdapsettingsRevokeRoles:       "Revoke Roles No Longer Granted",
+	LdapsettingsJitProvisioning:   "Create Users on First Login",
+	LdapsettingsDefaultRoles:      "Default Roles (comma separated)",
+	LdapsettingsNameAttribute:     "Name Attribute",
+	LdapsettingsCompanyAttribute:  "Company Attribute",
+	LdapsettingsSyncFilter:        "Directory Sync Filter",
+	LdapsettingsDiagnostics:       "Diagnostics",
+
+	LoginEvents:          "Login Events",
+	LoginEvent:           "Login Event",
+	LoginEventsCreatedAt: "Time",
+	LoginEventsUserId:    "User ID",
+	LoginEventsAccount:   "Account",
+	LoginEventsMethod:    "Method",
+	LoginEventsProvider:  "Provider",
+	LoginEventsSuccess:   "Result",
+	LoginEventsReason:    "Reason",
+	LoginEventsIp:        "IP",
+	LoginEventsUserAgent: "User Agent",
+
+	OauthRoleRules:          "OAuth Role Rules",
+	OauthRoleRule:           "OAuth Role Rule",
+	OauthRoleRulesProvider:  "Provider",
+	OauthRoleRulesDomain:    "Email Domain",
+	OauthRoleRulesGroup:     "Group",
+	OauthRoleRulesRoles:     "Roles",
+	OauthRoleRulesCompany:   "Company",
+	OauthRoleRulesPriority:  "Priority (lower matches first)",
+	OauthRoleRulesCreatedAt: "Created At",
 }
 
 var Messages_zh_CN_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
@@ -958,7 +1685,10 @@ var Messages_zh_CN_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
 	QOR5Example: "QOR5 示例",
 	Roles:       "权限管理",
 	Users:       "用户管理",
-	Dashboard:   "仪表盘",
+	UsersInvite: "邀请用户",
+
+	UsersRevokeAllSessions: "撤销所有会话",
+	Dashboard:              "仪表盘",
 
 	PageBuilder:              "页面管理菜单",
 	Pages:                    "页面管理",
@@ -1109,6 +1839,9 @@ var Messages_zh_CN_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
 	RecurringJobsErrorCount:     "错误次数",
 	RecurringJobsActions:        "操作",
 	RecurringJobsRuns:           "执行次数",
+	RecurringJobsTags:           "标签",
+	RecurringJobsOwner:          "负责人",
+	RecurringJobsOwnerRole:      "负责角色",
 
 	// 重复任务状态值
 	RecurringJobsStatusActive:    "活跃",
@@ -1128,6 +1861,7 @@ var Messages_zh_CN_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
 	RecurringJobsTabPaused:    "已暂停",
 	RecurringJobsTabCompleted: "已完成",
 	RecurringJobsTabError:     "错误任务",
+	RecurringJobsTabMine:      "我的任务",
 
 	// 重复任务日志相关
 	RecurringJobLogsID:         "ID",
@@ -1158,4 +1892,49 @@ var Messages_zh_CN_ModelsI18nModuleKey = &Messages_ModelsI18nModuleKey{
 	RecurringJobExecution: "重复任务执行",
 	Worker:                "后台工作",
 	WorkerJob:             "后台工作任务",
+
+	Ldapsettings:                  "LDAP设置",
+	Ldapsetting:                   "LDAP设置",
+	LdapsettingsEnabled:           "启用LDAP认证",
+	LdapsettingsServers:           "服务器（按优先级排列，用逗号分隔）",
+	LdapsettingsBindDn:            "服务账号DN",
+	LdapsettingsBindPassword:      "服务账号密码",
+	LdapsettingsSearchBase:        "搜索基础",
+	LdapsettingsSearchFilter:      "用户搜索过滤器",
+	LdapsettingsUseTls:            "使用StartTLS",
+	LdapsettingsSkipVerify:        "跳过TLS证书验证",
+	LdapsettingsCertFile:          "CA证书文件",
+	LdapsettingsGroupAttribute:    "组属性",
+	LdapsettingsGroupSearchBase:   "组搜索基础",
+	LdapsettingsGroupSearchFilter: "组搜索过滤器",
+	LdapsettingsGroupRoleMapping:  "组角色映射",
+	LdapsettingsRevokeRoles:       "移除目录不再授予的角色",
+	LdapsettingsJitProvisioning:   "首次登录时自动创建用户",
+	LdapsettingsDefaultRoles:      "默认角色（用逗号分隔）",
+	LdapsettingsNameAttribute:     "姓名属性",
+	LdapsettingsCompanyAttribute:  "公司属性",
+	LdapsettingsSyncFilter:        "目录同步过滤器",
+	LdapsettingsDiagnostics:       "诊断",
+
+	LoginEvents:          "登录事件",
+	LoginEvent:           "登录事件",
+	LoginEventsCreatedAt: "时间",
+	LoginEventsUserId:    "用户ID",
+	LoginEventsAccount:   "账号",
+	LoginEventsMethod:    "登录方式",
+	LoginEventsProvider:  "OAuth提供商",
+	LoginEventsSuccess:   "结果",
+	LoginEventsReason:    "失败原因",
+	LoginEventsIp:        "IP",
+	LoginEventsUserAgent: "浏览器",
+
+	OauthRoleRules:          "OAuth角色规则",
+	OauthRoleRule:           "OAuth角色规则",
+	OauthRoleRulesProvider:  "OAuth提供商",
+	OauthRoleRulesDomain:    "邮箱域名",
+	OauthRoleRulesGroup:     "组",
+	OauthRoleRulesRoles:     "角色",
+	OauthRoleRulesCompany:   "公司",
+	OauthRoleRulesPriority:  "优先级（数字小的先匹配）",
+	OauthRoleRulesCreatedAt: "创建时间",
 }
@@ -2,9 +2,12 @@ package admin
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/qor5/admin/v3/role"
 	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
 )
 
 func withRoles(db *gorm.DB) func(next http.Handler) http.Handler {
@@ -15,23 +18,48 @@ func withRoles(db *gorm.DB) func(next http.Handler) http.Handler {
 				next.ServeHTTP(w, r)
 				return
 			}
-
-			var roleIDs []uint
-			if err := db.Table("user_role_join").Select("role_id").Where("user_id=?", u.ID).Scan(&roleIDs).Error; err != nil {
+			if err := loadUserRoles(db, u); err != nil {
 				panic(err)
 			}
-			if len(roleIDs) > 0 {
-				var roles []role.Role
-				if err := db.Where("id in (?)", roleIDs).Find(&roles).Error; err != nil {
-					panic(err)
-				}
-				u.Roles = roles
-			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// loadUserRoles 加载用户的角色，本地用户同时加载安全密钥
+func loadUserRoles(db *gorm.DB, u *models.User) error {
+	var roleIDs []uint
+	if err := db.Table("user_role_join").Select("role_id").Where("user_id=?", u.ID).Scan(&roleIDs).Error; err != nil {
+		return err
+	}
+	if len(roleIDs) > 0 {
+		var roles []role.Role
+		if err := db.Where("id in (?)", roleIDs).Find(&roles).Error; err != nil {
+			return err
+		}
+		u.Roles = roles
+	}
+	// 双因素认证需要知道用户是否注册了安全密钥
+	if !u.IsOAuthUser() {
+		return loadWebAuthnCredentials(db, u)
+	}
+	return nil
+}
+
+// skipPathPrefix 对指定前缀的请求跳过中间件，例如登录之前访问的SAML端点
+func skipPathPrefix(prefix string, mw func(next http.Handler) http.Handler) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
 func securityMiddleware() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
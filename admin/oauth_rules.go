@@ -0,0 +1,345 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/qor5/admin/v3/activity"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	h "github.com/theplant/htmlgo"
+	"github.com/theplant/relay"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+OAuth角色分配规则说明：
+
+OAuth登录（包括通用OIDC和SAML）默认不授予任何角色，可以在"OAuth角色规则"页面按提供商、邮箱域名和组自动分配角色和公司：
+
+1. 规则匹配
+   - 提供商：为空时匹配所有提供商
+   - 域名：取自OAuth用户的邮箱，为空时匹配所有域名；通用OIDC提供商只有email_verified为true时才使用邮箱，"*.example.com"匹配example.com的所有子域名，不区分大小写
+   - 组：OIDC取自提供商配置的组claim，SAML取自断言中的组属性，为空时不限制组；其他提供商没有组，只能匹配组为空的规则
+   - 每次登录都会重新计算，授予所有匹配的规则中的角色；公司取优先级最高的设置了公司的匹配规则
+
+2. 角色同步
+   - 只对已经存在的本地用户生效（包括SAML自动开通的用户），本地不存在的用户仍由登录模块报告用户不存在
+   - OAUTH_REVOKE_ROLES: 为true时移除规则中出现但不再授予的角色，默认false；不在任何规则中的角色不受影响
+   - 同步失败只输出日志，不影响登录
+
+3. 域名白名单
+   - OAUTH_ALLOWED_DOMAINS: 允许登录的邮箱域名，多个用逗号分隔，格式与规则中的域名相同；为空时不限制
+   - 设置白名单后，没有邮箱或邮箱域名不在白名单中的OAuth和SAML登录都会被拒绝，登录事件中记为域名不允许登录
+   - 企业微信、钉钉等不返回邮箱的提供商在设置白名单后无法登录
+   - 通用OIDC提供商的用户可能可以自己填写邮箱，没有返回email_verified为true时按没有邮箱处理
+
+4. 权限
+   - 只有管理员可以查看和修改规则（详见perm.go）
+*/
+
+var (
+	oauthAllowedDomains = splitList(strings.ToLower(getEnvWithDefault("OAUTH_ALLOWED_DOMAINS", "")))
+	oauthRevokeRoles    = getEnvWithDefault("OAUTH_REVOKE_ROLES", "false") == "true"
+)
+
+// errOAuthDomainNotAllowed 邮箱域名不在白名单中的OAuth用户登录时返回的错误
+var errOAuthDomainNotAllowed = &login.NoticeError{
+	Level:   login.NoticeLevel_Error,
+	Message: Messages_en_US.OAuthDomainNotAllowed,
+}
+
+// emailDomain 返回邮箱的域名，转为小写，不是邮箱时返回空字符串
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[i+1:]))
+}
+
+// domainMatches 判断域名是否匹配规则或白名单中的域名
+// 参数：
+// - pattern: 规则中的域名，"*.example.com"匹配example.com的所有子域名，不匹配example.com本身
+// - domain: 邮箱的域名
+// 返回：
+// - bool: 是否匹配，不区分大小写
+func domainMatches(pattern, domain string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" || domain == "" {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(domain, suffix) && len(domain) > len(suffix)
+	}
+	return domain == pattern
+}
+
+// oauthVerifiedEmail 返回可以用来判断域名的邮箱
+// 通用OIDC提供商的用户可能可以自己填写未经验证的邮箱，只有email_verified为true时才使用邮箱，否则返回空字符串
+func oauthVerifiedEmail(ouser goth.User) string {
+	if _, ok := oidcConfig(ouser.Provider); !ok {
+		return ouser.Email
+	}
+	switch verified := ouser.RawData["email_verified"].(type) {
+	case bool:
+		if verified {
+			return ouser.Email
+		}
+	case string:
+		// 部分身份提供商把布尔值作为字符串返回
+		if strings.EqualFold(verified, "true") {
+			return ouser.Email
+		}
+	}
+	return ""
+}
+
+// oauthDomainAllowed 判断邮箱是否可以登录，没有设置白名单时都可以登录
+func oauthDomainAllowed(email string) bool {
+	if len(oauthAllowedDomains) == 0 {
+		return true
+	}
+	domain := emailDomain(email)
+	return slices.ContainsFunc(oauthAllowedDomains, func(pattern string) bool {
+		return domainMatches(pattern, domain)
+	})
+}
+
+// checkOAuthDomain 拒绝邮箱域名不在白名单中的OAuth用户，需要在其他 AfterOAuthComplete 钩子之后注册，使检查最先执行
+func checkOAuthDomain(in login.HookFunc) login.HookFunc {
+	return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+		if ouser, ok := user.(goth.User); ok && !oauthDomainAllowed(oauthVerifiedEmail(ouser)) {
+			models.AuthLog().Warn("邮箱域名不允许登录", "provider", ouser.Provider, "account", models.MaskAccount(ouser.Email))
+			return errOAuthDomainNotAllowed
+		}
+		return in(r, user, extraVals...)
+	}
+}
+
+// matchOAuthRoleRules 按规则计算OAuth用户应拥有的角色和公司
+// 参数：
+// - rules: 按优先级排序的规则
+// - provider: OAuth提供商标识
+// - domain: 用户邮箱的域名
+// - groups: 提供商返回的用户所属的组
+// 返回：
+// - granted: 匹配的规则授予的角色
+// - managed: 所有规则中出现的角色，用于移除不再授予的角色
+// - company: 第一个设置了公司的匹配规则中的公司
+func matchOAuthRoleRules(rules []models.OAuthRoleRule, provider, domain string, groups []string) (granted, managed []string, company string) {
+	for _, rule := range rules {
+		roles := splitList(rule.Roles)
+		for _, name := range roles {
+			if !slices.Contains(managed, name) {
+				managed = append(managed, name)
+			}
+		}
+
+		if rule.Provider != "" && rule.Provider != provider {
+			continue
+		}
+		if strings.TrimSpace(rule.Domain) != "" && !domainMatches(rule.Domain, domain) {
+			continue
+		}
+		if group := strings.TrimSpace(rule.Group); group != "" && !slices.ContainsFunc(groups, func(g string) bool {
+			return strings.EqualFold(g, group)
+		}) {
+			continue
+		}
+
+		for _, name := range roles {
+			if !slices.Contains(granted, name) {
+				granted = append(granted, name)
+			}
+		}
+		if company == "" {
+			company = strings.TrimSpace(rule.Company)
+		}
+	}
+	return granted, managed, company
+}
+
+// findOAuthUser 按提供商查找OAuth用户对应的本地用户，与登录模块一样先按用户ID查找，再按OAuth标识查找
+// 参数：
+// - db: 数据库连接
+// - provider: OAuth提供商标识
+// - userID: 提供商返回的用户ID
+// - identifier: 与本地用户的OAuth标识匹配的值
+// 返回：
+// - *models.User: 本地用户
+// - error: 用户不存在时返回 gorm.ErrRecordNotFound
+func findOAuthUser(db *gorm.DB, provider, userID, identifier string) (*models.User, error) {
+	var user models.User
+	err := db.Where("o_auth_provider = ? AND o_auth_user_id = ? AND o_auth_user_id != ''", provider, userID).First(&user).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return &user, err
+	}
+	err = db.Where("o_auth_provider = ? AND o_auth_identifier = ? AND o_auth_identifier != ''", provider, identifier).First(&user).Error
+	return &user, err
+}
+
+// applyOAuthRoleRules 在OAuth登录时按规则同步本地用户的角色和公司
+func applyOAuthRoleRules(db *gorm.DB) func(in login.HookFunc) login.HookFunc {
+	return func(in login.HookFunc) login.HookFunc {
+		return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+			if err := in(r, user, extraVals...); err != nil {
+				return err
+			}
+			ouser, ok := user.(goth.User)
+			if !ok {
+				return nil
+			}
+			if err := syncOAuthRoles(db, ouser); err != nil {
+				models.AuthLog().Warn("按OAuth角色规则同步用户失败", "provider", ouser.Provider, "error", err)
+			}
+			return nil
+		}
+	}
+}
+
+// syncOAuthRoles 按规则同步OAuth用户对应的本地用户的角色和公司
+// 参数：
+// - db: 数据库连接
+// - ouser: 提供商返回的用户
+// 返回：
+// - error: 查询规则、查询用户或更新角色失败时的错误信息；本地不存在该用户或没有规则时不需要同步
+func syncOAuthRoles(db *gorm.DB, ouser goth.User) error {
+	var rules []models.OAuthRoleRule
+	if err := db.Order("priority, id").Find(&rules).Error; err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	identifier := oauthIdentifierValue(oauthIdentifier(ouser.Provider), ouser)
+	user, err := findOAuthUser(db, ouser.Provider, ouser.UserID, identifier)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := db.Model(user).Association("Roles").Find(&user.Roles); err != nil {
+		return err
+	}
+
+	granted, managed, company := matchOAuthRoleRules(rules, ouser.Provider, emailDomain(oauthVerifiedEmail(ouser)), oauthUserGroups(ouser))
+	added, revoked, err := syncMappedRoles(db, user, granted, managed, oauthRevokeRoles)
+	if err != nil {
+		return err
+	}
+	if len(added) > 0 {
+		models.AuthLog().Info("添加OAuth规则角色", "provider", ouser.Provider, "account", models.MaskAccount(identifier), "roles", added)
+	}
+	if len(revoked) > 0 {
+		models.AuthLog().Info("移除不再由OAuth规则授予的角色", "provider", ouser.Provider, "account", models.MaskAccount(identifier), "roles", revoked)
+	}
+
+	if company != "" && company != user.Company {
+		if err := db.Model(user).Update("company", company).Error; err != nil {
+			return err
+		}
+		models.AuthLog().Info("已按OAuth规则更新用户公司", "provider", ouser.Provider, "account", models.MaskAccount(identifier))
+	}
+	return nil
+}
+
+// configOAuthRoleRules 配置OAuth角色规则页面，只有管理员可以访问
+func configOAuthRoleRules(b *presets.Builder, ab *activity.Builder, db *gorm.DB) {
+	mb := b.Model(&models.OAuthRoleRule{}).URIName("oauth-role-rules").MenuIcon("mdi-account-key")
+	defer func() { ab.RegisterModel(mb) }()
+
+	cl := mb.Listing("Priority", "Provider", "Domain", "Group", "Roles", "Company").
+		DefaultOrderBys(relay.OrderBy{Field: "Priority"}, relay.OrderBy{Field: "ID"}).
+		PerPage(20)
+
+	// 为空的条件匹配所有值
+	anyValue := func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if value := field.StringValue(obj); value != "" {
+			return h.Td(h.Text(value))
+		}
+		return h.Td(h.Text(msgr.OAuthRoleRuleAny)).Class("text-grey")
+	}
+	cl.Field("Provider").ComponentFunc(anyValue)
+	cl.Field("Domain").ComponentFunc(anyValue)
+	cl.Field("Group").ComponentFunc(anyValue)
+
+	ed := mb.Editing("Provider", "Domain", "Group", "Roles", "Company", "Priority")
+
+	ed.Field("Provider").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return v.VSelect().Attr(web.VField(field.Name, field.Value(obj))...).
+			Label(field.Label).
+			Items(oauthProviderKeys()).
+			Hint(msgr.OAuthRoleRuleProviderHint).
+			PersistentHint(true).
+			Clearable(true).
+			ErrorMessages(field.Errors...)
+	})
+
+	ed.Field("Domain").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return v.VTextField().Attr(web.VField(field.Name, field.Value(obj))...).
+			Label(field.Label).
+			Hint(msgr.OAuthRoleRuleDomainHint).
+			PersistentHint(true).
+			ErrorMessages(field.Errors...)
+	}).SetterFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) (err error) {
+		obj.(*models.OAuthRoleRule).Domain = strings.ToLower(strings.TrimSpace(ctx.R.FormValue(field.Name)))
+		return nil
+	})
+
+	ed.Field("Group").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return v.VTextField().Attr(web.VField(field.Name, field.Value(obj))...).
+			Label(field.Label).
+			Hint(msgr.OAuthRoleRuleGroupHint).
+			PersistentHint(true).
+			ErrorMessages(field.Errors...)
+	})
+
+	// 角色按名称保存，与LDAP设置中的默认角色一致
+	ed.Field("Roles").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		var names []string
+		db.Model(&role.Role{}).Order("id").Pluck("name", &names)
+		return v.VAutocomplete().Label(field.Label).Chips(true).
+			Items(names).
+			Multiple(true).Attr(web.VField(field.Name, splitList(obj.(*models.OAuthRoleRule).Roles))...).
+			ErrorMessages(field.Errors...)
+	}).SetterFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) (err error) {
+		var names []string
+		for _, name := range ctx.R.Form[field.Name] {
+			if name = strings.TrimSpace(name); name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		obj.(*models.OAuthRoleRule).Roles = strings.Join(names, ",")
+		return nil
+	})
+
+	ed.ValidateFunc(func(obj interface{}, ctx *web.EventContext) (err web.ValidationErrors) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		rule := obj.(*models.OAuthRoleRule)
+
+		if rule.Domain != "" && (strings.Contains(rule.Domain, "@") || strings.Contains(strings.TrimPrefix(rule.Domain, "*."), "*")) {
+			err.FieldError("Domain", msgr.OAuthRoleRuleInvalidDomain)
+		}
+		// 既不授予角色也不设置公司的规则没有作用
+		if rule.Roles == "" && strings.TrimSpace(rule.Company) == "" {
+			err.FieldError("Roles", msgr.OAuthRoleRuleRolesRequired)
+		}
+		return
+	})
+}
@@ -0,0 +1,258 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/x/v3/login"
+)
+
+func TestDomainMatches(t *testing.T) {
+	cases := []struct {
+		pattern, domain string
+		want            bool
+	}{
+		{"example.com", "example.com", true},
+		{"Example.COM", "example.com", true},
+		{"example.com", "sub.example.com", false},
+		{"*.example.com", "sub.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"example.com", "", false},
+		{"", "example.com", false},
+	}
+	for _, c := range cases {
+		if got := domainMatches(c.pattern, c.domain); got != c.want {
+			t.Errorf("domainMatches(%q, %q) = %v, 期望 %v", c.pattern, c.domain, got, c.want)
+		}
+	}
+
+	if d := emailDomain("Alice@Example.COM"); d != "example.com" {
+		t.Errorf("邮箱域名应该转为小写，实际 %q", d)
+	}
+	if d := emailDomain("alice"); d != "" {
+		t.Errorf("不是邮箱时应该返回空字符串，实际 %q", d)
+	}
+}
+
+func TestCheckOAuthDomain(t *testing.T) {
+	old := oauthAllowedDomains
+	defer func() { oauthAllowedDomains = old }()
+
+	called := false
+	hook := checkOAuthDomain(func(*http.Request, interface{}, ...interface{}) error {
+		called = true
+		return nil
+	})
+	r := httptest.NewRequest("GET", "/auth/callback?provider=google", nil)
+
+	// 没有设置白名单时不限制
+	oauthAllowedDomains = nil
+	if err := hook(r, goth.User{Provider: "google"}); err != nil || !called {
+		t.Fatalf("没有白名单时应该允许登录: %v", err)
+	}
+
+	oauthAllowedDomains = splitList("example.com,*.example.org")
+	for _, email := range []string{"alice@example.com", "bob@dept.example.org"} {
+		called = false
+		if err := hook(r, goth.User{Provider: "google", Email: email}); err != nil || !called {
+			t.Errorf("%s 应该允许登录: %v", email, err)
+		}
+	}
+	for _, email := range []string{"eve@evil.com", "eve@example.org", ""} {
+		called = false
+		err := hook(r, goth.User{Provider: "google", Email: email})
+		if !errors.Is(err, errOAuthDomainNotAllowed) || called {
+			t.Errorf("%q 应该被拒绝，实际 %v", email, err)
+		}
+	}
+
+	// 通用OIDC提供商的邮箱只有经过验证时才使用
+	useTestOIDCConfigs(t, oidcProviderConfig{Key: "corp"})
+	for _, verified := range []interface{}{true, "true"} {
+		called = false
+		ouser := goth.User{Provider: "corp", Email: "alice@example.com", RawData: map[string]interface{}{"email_verified": verified}}
+		if err := hook(r, ouser); err != nil || !called {
+			t.Errorf("email_verified为%v时应该允许登录: %v", verified, err)
+		}
+	}
+	for _, raw := range []map[string]interface{}{nil, {"email_verified": false}, {"email_verified": "false"}} {
+		called = false
+		err := hook(r, goth.User{Provider: "corp", Email: "alice@example.com", RawData: raw})
+		if !errors.Is(err, errOAuthDomainNotAllowed) || called {
+			t.Errorf("未验证的邮箱 %v 应该被拒绝，实际 %v", raw, err)
+		}
+	}
+
+	if reason := newLoginEvent(r, nil, errOAuthDomainNotAllowed).Reason; reason != models.LoginReasonDomainDenied {
+		t.Errorf("登录事件的失败原因错误: %s", reason)
+	}
+}
+
+func TestMatchOAuthRoleRules(t *testing.T) {
+	rules := []models.OAuthRoleRule{
+		{Provider: "keycloak", Group: "admins", Roles: models.RoleAdmin, Priority: 1},
+		{Domain: "example.com", Roles: models.RoleEditor, Company: "Example Inc", Priority: 2},
+		{Domain: "*.example.com", Roles: models.RoleViewer + "," + models.RoleEditor, Company: "Example Branch", Priority: 3},
+		{Provider: "google", Roles: models.RoleViewer, Priority: 4},
+	}
+
+	granted, managed, company := matchOAuthRoleRules(rules, "keycloak", "example.com", []string{"Admins", "staff"})
+	if !slices.Equal(granted, []string{models.RoleAdmin, models.RoleEditor}) {
+		t.Errorf("授予的角色错误: %v", granted)
+	}
+	if !slices.Equal(managed, []string{models.RoleAdmin, models.RoleEditor, models.RoleViewer}) {
+		t.Errorf("规则中的角色错误: %v", managed)
+	}
+	if company != "Example Inc" {
+		t.Errorf("公司应该取第一个匹配的规则，实际 %q", company)
+	}
+
+	granted, _, company = matchOAuthRoleRules(rules, "google", "hq.example.com", nil)
+	if !slices.Equal(granted, []string{models.RoleViewer, models.RoleEditor}) || company != "Example Branch" {
+		t.Errorf("子域名的匹配结果错误: %v, %q", granted, company)
+	}
+
+	granted, _, company = matchOAuthRoleRules(rules, "github", "other.com", []string{"admins"})
+	if len(granted) != 0 || company != "" {
+		t.Errorf("不应该匹配任何规则，实际 %v, %q", granted, company)
+	}
+}
+
+func TestSyncOAuthRoles(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.OAuthRoleRule{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{models.RoleAdmin, models.RoleEditor, models.RoleViewer, models.RoleManager} {
+		if err := db.Create(&role.Role{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := []models.OAuthRoleRule{
+		{Domain: "example.com", Roles: models.RoleEditor, Company: "Example Inc"},
+		{Domain: "example.com", Group: "admins", Roles: models.RoleAdmin},
+	}
+	if err := db.Create(&rules).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{Name: "Alice", Status: models.StatusActive}
+	user.OAuthProvider = models.OAuthProviderGoogle
+	user.OAuthIdentifier = "alice@example.com"
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 管理员手动授予的角色不受规则影响
+	var manager role.Role
+	db.Where("name = ?", models.RoleManager).First(&manager)
+	if err := db.Model(&user).Association("Roles").Append(&manager); err != nil {
+		t.Fatal(err)
+	}
+
+	roleNames := func() []string {
+		var u models.User
+		if err := db.Preload("Roles").First(&u, user.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range u.Roles {
+			names = append(names, r.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	// 第一次登录时按OAuth标识找到用户
+	ouser := goth.User{Provider: models.OAuthProviderGoogle, UserID: "g-1", Email: "alice@example.com"}
+	if err := syncOAuthRoles(db, ouser); err != nil {
+		t.Fatalf("同步角色失败: %v", err)
+	}
+	if got := roleNames(); !slices.Equal(got, []string{models.RoleEditor, models.RoleManager}) {
+		t.Errorf("期望授予Editor，实际 %v", got)
+	}
+	var saved models.User
+	db.First(&saved, user.ID)
+	if saved.Company != "Example Inc" {
+		t.Errorf("公司没有更新: %q", saved.Company)
+	}
+
+	// 规则不再授予的角色默认保留，开启移除后移除
+	if err := db.Model(&models.OAuthRoleRule{}).Where("id = ?", rules[0].ID).Update("domain", "example.org").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := syncOAuthRoles(db, ouser); err != nil {
+		t.Fatalf("同步角色失败: %v", err)
+	}
+	if got := roleNames(); !slices.Equal(got, []string{models.RoleEditor, models.RoleManager}) {
+		t.Errorf("没有开启移除时不应该移除角色，实际 %v", got)
+	}
+
+	old := oauthRevokeRoles
+	oauthRevokeRoles = true
+	defer func() { oauthRevokeRoles = old }()
+	if err := syncOAuthRoles(db, ouser); err != nil {
+		t.Fatalf("同步角色失败: %v", err)
+	}
+	if got := roleNames(); !slices.Equal(got, []string{models.RoleManager}) {
+		t.Errorf("期望移除Editor并保留手动授予的角色，实际 %v", got)
+	}
+
+	// 通用OIDC提供商未验证的邮箱不匹配域名规则
+	oauthRevokeRoles = false
+	useTestOIDCConfigs(t, oidcProviderConfig{Key: "corp", Identifier: login.OAuthIdentifierUserID})
+	corpUser := models.User{Name: "Carol", Status: models.StatusActive}
+	corpUser.OAuthProvider = "corp"
+	corpUser.OAuthIdentifier = "c-1"
+	if err := db.Create(&corpUser).Error; err != nil {
+		t.Fatal(err)
+	}
+	corp := goth.User{Provider: "corp", UserID: "c-1", Email: "carol@example.org"}
+	if err := syncOAuthRoles(db, corp); err != nil {
+		t.Fatalf("同步角色失败: %v", err)
+	}
+	if got := userRoleNames(t, db, corpUser.ID); len(got) != 0 {
+		t.Errorf("未验证的邮箱不应该匹配规则，实际 %v", got)
+	}
+	corp.RawData = map[string]interface{}{"email_verified": true}
+	if err := syncOAuthRoles(db, corp); err != nil {
+		t.Fatalf("同步角色失败: %v", err)
+	}
+	if got := userRoleNames(t, db, corpUser.ID); !slices.Equal(got, []string{models.RoleEditor}) {
+		t.Errorf("验证过的邮箱应该匹配规则，实际 %v", got)
+	}
+
+	// 本地不存在的用户不需要同步
+	if err := syncOAuthRoles(db, goth.User{Provider: models.OAuthProviderGoogle, UserID: "g-2", Email: "bob@example.com"}); err != nil {
+		t.Errorf("本地不存在的用户不应该返回错误: %v", err)
+	}
+}
+
+func TestOAuthIdentifierValue(t *testing.T) {
+	ouser := goth.User{UserID: "u-1", Email: "alice@example.com", Name: "Alice", NickName: "alice"}
+	cases := map[login.OAuthIdentifier]string{
+		login.OAuthIdentifierEmail:    "alice@example.com",
+		login.OAuthIdentifierName:     "Alice",
+		login.OAuthIdentifierNickName: "alice",
+		login.OAuthIdentifierUserID:   "u-1",
+	}
+	for idt, want := range cases {
+		if got := oauthIdentifierValue(idt, ouser); got != want {
+			t.Errorf("标识 %v 的值错误: %q", idt, got)
+		}
+	}
+	// 与登录模块一致，标识字段为空时使用用户ID
+	if got := oauthIdentifierValue(login.OAuthIdentifierEmail, goth.User{UserID: "u-2"}); got != "u-2" {
+		t.Errorf("邮箱为空时应该使用用户ID，实际 %q", got)
+	}
+	if idt := oauthIdentifier(models.OAuthProviderDingtalk); idt != login.OAuthIdentifierNickName {
+		t.Errorf("钉钉应该使用昵称作为标识，实际 %v", idt)
+	}
+}
@@ -0,0 +1,299 @@
+package admin
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/openidConnect"
+	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	h "github.com/theplant/htmlgo"
+)
+
+/*
+通用OpenID Connect登录说明：
+
+除了内置的Google、Microsoft、GitHub、企业微信和钉钉，可以通过环境变量配置任意多个支持OpenID Connect的身份提供商，
+例如Keycloak、Authentik、Okta：
+
+1. 配置方式
+   - OIDC_PROVIDERS: 提供商标识，多个用逗号分隔，例如"keycloak,okta"
+     标识只能包含小写字母、数字和连字符，用于回调地址和用户的OAuth提供商字段，不能与内置的提供商重复
+   - 每个提供商的配置使用 OIDC_<标识>_ 前缀，标识转为大写，连字符转为下划线，例如 OIDC_KEYCLOAK_ISSUER
+
+2. 提供商配置项
+   - ISSUER: 颁发者地址，启动时从 <ISSUER>/.well-known/openid-configuration 发现各个端点
+   - CLIENT_ID、CLIENT_SECRET: 在身份提供商注册的客户端
+   - SCOPES: 请求的scope，用逗号分隔，默认openid,email,profile
+   - IDENTIFIER: 与本地用户的OAuth标识匹配的字段，可选email、name、nickname、userid，默认email
+   - USER_ID_CLAIM、EMAIL_CLAIM、NAME_CLAIM: 用户ID、邮箱和姓名使用的claim，默认sub、email、name
+   - GROUPS_CLAIM: 用户所属组的claim，默认groups，身份提供商没有返回时视为不属于任何组
+   - LABEL: 登录按钮的文字，默认"Sign in with <标识>"
+   - LOGO: 登录按钮的图标，可以是图片地址或SVG代码，默认使用通用图标
+
+3. 回调地址
+   - 在身份提供商中登记的回调地址为 <BASE_URL>/auth/callback?provider=<标识>
+
+4. 错误处理
+   - 配置不完整或启动时无法访问发现地址的提供商会被跳过并输出警告，不影响其他登录方式
+   - 发现文档中的issuer必须与配置的ISSUER一致
+*/
+
+// OIDC配置的默认值
+const (
+	defaultOIDCScopes      = "openid,email,profile"
+	defaultOIDCGroupsClaim = "groups"
+)
+
+// oidcKeyPattern 提供商标识的格式
+var oidcKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// oidcIdentifiers 可以用作本地用户OAuth标识的字段
+var oidcIdentifiers = map[string]login.OAuthIdentifier{
+	"email":    login.OAuthIdentifierEmail,
+	"name":     login.OAuthIdentifierName,
+	"nickname": login.OAuthIdentifierNickName,
+	"userid":   login.OAuthIdentifierUserID,
+}
+
+// oidcProviderConfig 通用OIDC提供商配置
+type oidcProviderConfig struct {
+	Key          string                // 提供商标识
+	Issuer       string                // 颁发者地址
+	ClientID     string                // 客户端ID
+	ClientSecret string                // 客户端密钥
+	Scopes       []string              // 请求的scope
+	Identifier   login.OAuthIdentifier // 与本地用户的OAuth标识匹配的字段
+	UserIDClaim  string                // 用户ID使用的claim，为空时使用sub
+	EmailClaim   string                // 邮箱使用的claim，为空时使用email
+	NameClaim    string                // 姓名使用的claim，为空时使用name
+	GroupsClaim  string                // 用户所属组的claim
+	Label        string                // 登录按钮的文字
+	Logo         string                // 登录按钮的图标，图片地址或SVG代码
+}
+
+// oidcConfigs 从环境变量加载的OIDC提供商配置
+var oidcConfigs = oidcEnvConfigs(getEnvWithDefault("OIDC_PROVIDERS", ""))
+
+// oidcEnvConfigs 按提供商标识列表从环境变量加载OIDC提供商配置
+// 参数：
+// - keys: 用逗号分隔的提供商标识
+// 返回：
+// - []oidcProviderConfig: 提供商配置，创建登录提供商时再检查配置是否完整
+func oidcEnvConfigs(keys string) []oidcProviderConfig {
+	var configs []oidcProviderConfig
+	for _, key := range splitList(keys) {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_")) + "_"
+		env := func(name, defaultValue string) string {
+			return strings.TrimSpace(getEnvWithDefault(prefix+name, defaultValue))
+		}
+
+		identifier, ok := oidcIdentifiers[strings.ToLower(env("IDENTIFIER", "email"))]
+		if !ok {
+			log.Printf("警告: %sIDENTIFIER无效，使用email", prefix)
+			identifier = login.OAuthIdentifierEmail
+		}
+		configs = append(configs, oidcProviderConfig{
+			Key:          key,
+			Issuer:       env("ISSUER", ""),
+			ClientID:     env("CLIENT_ID", ""),
+			ClientSecret: env("CLIENT_SECRET", ""),
+			Scopes:       splitList(env("SCOPES", defaultOIDCScopes)),
+			Identifier:   identifier,
+			UserIDClaim:  env("USER_ID_CLAIM", ""),
+			EmailClaim:   env("EMAIL_CLAIM", ""),
+			NameClaim:    env("NAME_CLAIM", ""),
+			GroupsClaim:  env("GROUPS_CLAIM", defaultOIDCGroupsClaim),
+			Label:        env("LABEL", "Sign in with "+key),
+			Logo:         env("LOGO", ""),
+		})
+	}
+	return configs
+}
+
+// validate 检查提供商配置是否完整
+func (c *oidcProviderConfig) validate() error {
+	if !oidcKeyPattern.MatchString(c.Key) {
+		return fmt.Errorf("OIDC提供商标识只能包含小写字母、数字和连字符: %q", c.Key)
+	}
+	if slices.Contains(models.OAuthProviders, c.Key) {
+		return fmt.Errorf("OIDC提供商标识与内置的提供商重复: %s", c.Key)
+	}
+	if _, ok := samlConfig(c.Key); ok {
+		return fmt.Errorf("OIDC提供商标识与SAML提供商重复: %s", c.Key)
+	}
+	if c.Issuer == "" || c.ClientID == "" || c.ClientSecret == "" {
+		return fmt.Errorf("OIDC提供商 %s 缺少ISSUER、CLIENT_ID或CLIENT_SECRET", c.Key)
+	}
+	if !slices.Contains(c.Scopes, "openid") {
+		return fmt.Errorf("OIDC提供商 %s 的SCOPES需要包含openid", c.Key)
+	}
+	return nil
+}
+
+// discoveryURL 返回OIDC发现文档的地址
+func (c *oidcProviderConfig) discoveryURL() string {
+	return strings.TrimRight(c.Issuer, "/") + "/.well-known/openid-configuration"
+}
+
+// newOIDCProvider 发现提供商的端点并创建登录提供商
+// 参数：
+// - cfg: 提供商配置
+// - callbackURL: 登录回调地址
+// 返回：
+// - *login.Provider: 登录提供商
+// - error: 配置不完整、无法获取发现文档或issuer不一致时的错误信息
+func newOIDCProvider(cfg oidcProviderConfig, callbackURL string) (*login.Provider, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	p, err := openidConnect.NewNamed(cfg.Key, cfg.ClientID, cfg.ClientSecret, callbackURL, cfg.discoveryURL(), cfg.Scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("获取OIDC提供商 %s 的发现文档失败: %w", cfg.Key, err)
+	}
+	// 发现文档可能来自被篡改的地址，颁发者必须与配置一致
+	if strings.TrimRight(p.OpenIDConfig.Issuer, "/") != strings.TrimRight(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("OIDC提供商 %s 的发现文档中的issuer %q 与配置的 %q 不一致", cfg.Key, p.OpenIDConfig.Issuer, cfg.Issuer)
+	}
+	if p.OpenIDConfig.AuthEndpoint == "" || p.OpenIDConfig.TokenEndpoint == "" {
+		return nil, fmt.Errorf("OIDC提供商 %s 的发现文档缺少授权或令牌端点", cfg.Key)
+	}
+
+	// NewNamed 会在名称后加上-oidc，登录模块按提供商标识查找goth提供商，需要保持一致
+	p.SetName(cfg.Key)
+	if cfg.UserIDClaim != "" {
+		p.UserIdClaims = []string{cfg.UserIDClaim}
+	}
+	if cfg.EmailClaim != "" {
+		p.EmailClaims = []string{cfg.EmailClaim}
+	}
+	if cfg.NameClaim != "" {
+		p.NameClaims = []string{cfg.NameClaim}
+	}
+
+	return &login.Provider{
+		Goth: p,
+		Key:  cfg.Key,
+		Text: cfg.Label,
+		Logo: loginProviderLogo(cfg.Logo),
+	}, nil
+}
+
+// loginProviderLogo 返回OIDC或SAML登录按钮的图标，SVG代码直接输出，其他值作为图片地址
+func loginProviderLogo(logo string) h.HTMLComponent {
+	switch {
+	case logo == "":
+		return v.VIcon("mdi-shield-account").Size(24)
+	case strings.HasPrefix(logo, "<svg"):
+		return h.RawHTML(logo)
+	default:
+		return h.Img(logo).Attr("width", "24", "height", "24", "alt", "")
+	}
+}
+
+// oidcLoginProviders 创建所有配置的OIDC登录提供商，无法创建的提供商会被跳过
+func oidcLoginProviders() []*login.Provider {
+	var providers []*login.Provider
+	for i, cfg := range oidcConfigs {
+		if slices.ContainsFunc(oidcConfigs[:i], func(c oidcProviderConfig) bool { return c.Key == cfg.Key }) {
+			log.Printf("警告: 跳过重复的OIDC提供商 %s", cfg.Key)
+			continue
+		}
+		p, err := newOIDCProvider(cfg, baseURL+"/auth/callback?provider="+cfg.Key)
+		if err != nil {
+			log.Printf("警告: 跳过OIDC提供商 %s: %v", cfg.Key, err)
+			continue
+		}
+		log.Printf("启用OIDC提供商 %s: %s", cfg.Key, cfg.Issuer)
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// oidcConfig 按提供商标识查找OIDC提供商配置
+func oidcConfig(key string) (oidcProviderConfig, bool) {
+	for _, cfg := range oidcConfigs {
+		if cfg.Key == key {
+			return cfg, true
+		}
+	}
+	return oidcProviderConfig{}, false
+}
+
+// oauthProviderKeys 返回内置的和配置的全部OAuth提供商标识，包括SAML提供商
+func oauthProviderKeys() []string {
+	keys := slices.Clone(models.OAuthProviders)
+	for _, cfg := range oidcConfigs {
+		keys = append(keys, cfg.Key)
+	}
+	for _, cfg := range samlConfigs {
+		keys = append(keys, cfg.Key)
+	}
+	return keys
+}
+
+// oauthIdentifier 返回OAuth提供商与本地用户的OAuth标识匹配的字段，未知的提供商使用邮箱
+func oauthIdentifier(provider string) login.OAuthIdentifier {
+	if idt, ok := builtinOAuthIdentifiers[provider]; ok {
+		return idt
+	}
+	if cfg, ok := oidcConfig(provider); ok {
+		return cfg.Identifier
+	}
+	if cfg, ok := samlConfig(provider); ok {
+		return cfg.Identifier
+	}
+	return login.OAuthIdentifierEmail
+}
+
+// oauthIdentifierValue 返回OAuth用户与本地用户的OAuth标识匹配的值
+// 与登录模块一致，标识字段为空时使用用户ID
+func oauthIdentifierValue(idt login.OAuthIdentifier, ouser goth.User) string {
+	var val string
+	switch idt {
+	case login.OAuthIdentifierName:
+		val = ouser.Name
+	case login.OAuthIdentifierNickName:
+		val = ouser.NickName
+	case login.OAuthIdentifierUserID:
+		val = ouser.UserID
+	default:
+		val = ouser.Email
+	}
+	return cmp.Or(val, ouser.UserID)
+}
+
+// oauthUserGroups 返回OIDC或SAML用户所属的组
+// OIDC用户的组取自提供商配置的组claim，claim可以是字符串数组或用逗号分隔的字符串；
+// SAML用户的组取自断言中的组属性；其他提供商或身份提供商没有返回组时返回nil
+func oauthUserGroups(ouser goth.User) []string {
+	var claim string
+	if cfg, ok := oidcConfig(ouser.Provider); ok {
+		claim = cfg.GroupsClaim
+	} else if _, ok := samlConfig(ouser.Provider); ok {
+		claim = samlGroupsKey
+	}
+	if claim == "" {
+		return nil
+	}
+	switch groups := ouser.RawData[claim].(type) {
+	case string:
+		return splitList(groups)
+	case []interface{}:
+		var result []string
+		for _, g := range groups {
+			if s, ok := g.(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
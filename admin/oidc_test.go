@@ -0,0 +1,219 @@
+package admin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/qor5/x/v3/login"
+)
+
+// testIDToken 生成未签名的ID Token，goth只校验claims不校验签名
+func testIDToken(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("生成ID Token失败: %v", err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"none"}`)) + "." + enc(payload) + ".sig"
+}
+
+// startTestOIDCServer 启动提供发现文档、令牌和用户信息端点的测试身份提供商
+// 参数：
+// - issuer: 发现文档中的issuer，为空时使用测试服务器的地址
+// - claims: ID Token中的claims，iss、aud和exp会自动填充
+// - userInfo: 用户信息端点返回的claims
+func startTestOIDCServer(t *testing.T, issuer string, claims, userInfo map[string]interface{}) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	if issuer == "" {
+		issuer = srv.URL
+	}
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "test-code" {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+		idClaims := map[string]interface{}{"iss": issuer, "aud": "client", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range claims {
+			idClaims[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     testIDToken(t, idClaims),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(userInfo)
+	})
+	return srv
+}
+
+// useTestOIDCConfigs 在测试期间替换OIDC提供商配置，测试结束后恢复
+func useTestOIDCConfigs(t *testing.T, configs ...oidcProviderConfig) {
+	t.Helper()
+	old := oidcConfigs
+	oidcConfigs = configs
+	t.Cleanup(func() { oidcConfigs = old })
+}
+
+func TestOIDCEnvConfigs(t *testing.T) {
+	t.Setenv("OIDC_MY_IDP_ISSUER", "https://idp.example.com/realms/main")
+	t.Setenv("OIDC_MY_IDP_CLIENT_ID", "client")
+	t.Setenv("OIDC_MY_IDP_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_MY_IDP_SCOPES", "openid, email ,groups")
+	t.Setenv("OIDC_MY_IDP_IDENTIFIER", "UserID")
+	t.Setenv("OIDC_MY_IDP_GROUPS_CLAIM", "roles")
+	t.Setenv("OIDC_OKTA_IDENTIFIER", "unknown")
+
+	configs := oidcEnvConfigs(" my-idp, okta ,")
+	if len(configs) != 2 {
+		t.Fatalf("期望2个提供商，实际 %d", len(configs))
+	}
+	c := configs[0]
+	if c.Key != "my-idp" || c.Issuer != "https://idp.example.com/realms/main" || c.ClientID != "client" || c.ClientSecret != "secret" {
+		t.Errorf("提供商配置错误: %+v", c)
+	}
+	if !slices.Equal(c.Scopes, []string{"openid", "email", "groups"}) {
+		t.Errorf("scope错误: %v", c.Scopes)
+	}
+	if c.Identifier != login.OAuthIdentifierUserID || c.GroupsClaim != "roles" || c.Label != "Sign in with my-idp" {
+		t.Errorf("标识、组claim或按钮文字错误: %+v", c)
+	}
+
+	// 未配置的项使用默认值，无效的标识字段使用email
+	okta := configs[1]
+	if okta.Identifier != login.OAuthIdentifierEmail || okta.GroupsClaim != defaultOIDCGroupsClaim ||
+		!slices.Equal(okta.Scopes, []string{"openid", "email", "profile"}) {
+		t.Errorf("默认配置错误: %+v", okta)
+	}
+	if err := okta.validate(); err == nil {
+		t.Error("缺少ISSUER和客户端时配置检查应该失败")
+	}
+}
+
+func TestOIDCProviderConfigValidate(t *testing.T) {
+	base := oidcProviderConfig{Key: "keycloak", Issuer: "https://idp", ClientID: "c", ClientSecret: "s", Scopes: []string{"openid"}}
+	if err := base.validate(); err != nil {
+		t.Fatalf("完整的配置检查失败: %v", err)
+	}
+
+	invalid := map[string]func(c *oidcProviderConfig){
+		"标识包含大写字母":   func(c *oidcProviderConfig) { c.Key = "KeyCloak" },
+		"标识与内置提供商重复": func(c *oidcProviderConfig) { c.Key = "google" },
+		"缺少客户端密钥":    func(c *oidcProviderConfig) { c.ClientSecret = "" },
+		"缺少openid":   func(c *oidcProviderConfig) { c.Scopes = []string{"email"} },
+	}
+	for name, modify := range invalid {
+		c := base
+		modify(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: 配置检查应该失败", name)
+		}
+	}
+}
+
+func TestNewOIDCProviderLogin(t *testing.T) {
+	srv := startTestOIDCServer(t, "",
+		map[string]interface{}{"sub": "u-1", "upn": "alice@corp.example.com", "display": "Alice"},
+		map[string]interface{}{"sub": "u-1", "groups": []string{"admins", "staff"}},
+	)
+	cfg := oidcProviderConfig{
+		Key: "keycloak", Issuer: srv.URL + "/", ClientID: "client", ClientSecret: "secret",
+		Scopes: []string{"openid", "email"}, EmailClaim: "upn", NameClaim: "display",
+		GroupsClaim: defaultOIDCGroupsClaim, Label: "Keycloak",
+	}
+	useTestOIDCConfigs(t, cfg)
+
+	p, err := newOIDCProvider(cfg, "https://app.example.com/auth/callback?provider=keycloak")
+	if err != nil {
+		t.Fatalf("创建OIDC提供商失败: %v", err)
+	}
+	if p.Key != "keycloak" || p.Goth.Name() != "keycloak" || p.Text != "Keycloak" {
+		t.Errorf("登录提供商错误: key=%s, goth=%s, text=%s", p.Key, p.Goth.Name(), p.Text)
+	}
+
+	// 授权地址使用发现的端点和配置的scope
+	sess, err := p.Goth.BeginAuth("state")
+	if err != nil {
+		t.Fatalf("开始认证失败: %v", err)
+	}
+	authURL, _ := sess.GetAuthURL()
+	u, _ := url.Parse(authURL)
+	if !strings.HasPrefix(authURL, srv.URL+"/authorize") || u.Query().Get("scope") != "openid email" {
+		t.Errorf("授权地址错误: %s", authURL)
+	}
+
+	// 用授权码换取令牌，按配置的claim映射用户信息
+	if _, err := sess.Authorize(p.Goth, url.Values{"code": {"test-code"}}); err != nil {
+		t.Fatalf("换取令牌失败: %v", err)
+	}
+	user, err := p.Goth.FetchUser(sess)
+	if err != nil {
+		t.Fatalf("获取用户信息失败: %v", err)
+	}
+	if user.Provider != "keycloak" || user.UserID != "u-1" || user.Email != "alice@corp.example.com" || user.Name != "Alice" {
+		t.Errorf("用户信息映射错误: %+v", user)
+	}
+	if groups := oauthUserGroups(user); !slices.Equal(groups, []string{"admins", "staff"}) {
+		t.Errorf("用户组错误: %v", groups)
+	}
+}
+
+func TestNewOIDCProviderIssuerMismatch(t *testing.T) {
+	srv := startTestOIDCServer(t, "https://evil.example.com", nil, nil)
+	cfg := oidcProviderConfig{Key: "keycloak", Issuer: srv.URL, ClientID: "client", ClientSecret: "secret", Scopes: []string{"openid"}}
+	if _, err := newOIDCProvider(cfg, "https://app.example.com/auth/callback"); err == nil {
+		t.Error("发现文档中的issuer不一致时应该拒绝")
+	}
+
+	cfg.Issuer = srv.URL + "/missing"
+	if _, err := newOIDCProvider(cfg, "https://app.example.com/auth/callback"); err == nil {
+		t.Error("发现文档不存在时应该返回错误")
+	}
+}
+
+func TestOAuthUserGroups(t *testing.T) {
+	useTestOIDCConfigs(t, oidcProviderConfig{Key: "okta", GroupsClaim: "roles"})
+
+	cases := []struct {
+		user goth.User
+		want []string
+	}{
+		{goth.User{Provider: "okta", RawData: map[string]interface{}{"roles": []interface{}{"a", "", "b", 1}}}, []string{"a", "b"}},
+		{goth.User{Provider: "okta", RawData: map[string]interface{}{"roles": "a, b"}}, []string{"a", "b"}},
+		{goth.User{Provider: "okta", RawData: map[string]interface{}{"groups": []interface{}{"a"}}}, nil},
+		{goth.User{Provider: "google", RawData: map[string]interface{}{"roles": []interface{}{"a"}}}, nil},
+	}
+	for i, c := range cases {
+		if got := oauthUserGroups(c.user); !slices.Equal(got, c.want) {
+			t.Errorf("%d: 期望 %v，实际 %v", i, c.want, got)
+		}
+	}
+
+	if keys := oauthProviderKeys(); keys[len(keys)-1] != "okta" || !slices.Contains(keys, "google") {
+		t.Errorf("OAuth提供商标识错误: %v", keys)
+	}
+}
@@ -0,0 +1,381 @@
+package admin
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+密码策略说明：
+
+所有设置本地密码的地方（登录模块的修改密码和重置密码、用户编辑页面、初始用户）都使用同一个密码策略：
+
+1. 配置方式
+   - PASSWORD_MIN_LENGTH: 最小长度，按字符计算，默认12
+   - PASSWORD_CHAR_CLASSES: 必须包含的字符类型，多个用逗号分隔，可选upper、lower、digit、symbol，默认不限制
+   - PASSWORD_BLOCKLIST_FILE: 额外的禁用密码字典文件，每行一个，#开头的行是注释；内置的常见密码始终禁用
+   - PASSWORD_HISTORY: 不能重复使用最近多少个密码，默认0表示不限制
+   - PASSWORD_MAX_AGE: 密码有效期，例如2160h，默认0表示不过期
+
+2. 检查规则
+   - 密码不区分大小写地等于禁用密码，或去掉末尾的数字和符号后等于禁用密码（例如Password2024!）时视为常见密码
+   - 密码不能包含账号（邮箱只比较@之前的部分）或姓名，少于4个字符的账号和姓名不检查
+   - 最近的密码按哈希比较，只保存策略要求的条数，见models.PasswordHistory
+
+3. 密码过期
+   - 本地密码用户的密码超过有效期后，登录后的所有页面都会跳转到修改密码页面，修改密码后重新登录
+   - OAuth用户和通过LDAP登录过的用户不检查密码有效期
+
+4. 提示信息
+   - 提示信息取自messages.go；登录模块的表单通过Cookie传递提示，Cookie中不能包含中文，没有语言上下文的请求使用英文提示
+*/
+
+// 密码必须包含的字符类型
+const (
+	passwordCharUpper  = "upper"
+	passwordCharLower  = "lower"
+	passwordCharDigit  = "digit"
+	passwordCharSymbol = "symbol"
+)
+
+// 密码过期后跳转的登录模块修改密码页面，以及登录模块显示提示使用的Cookie
+const (
+	passwordChangePageURL  = "/auth/change-password"
+	loginNoticeFlashCookie = "qor5_notice_flash"
+)
+
+// setLoginNotice 设置登录模块的页面下一次显示的提示
+// 登录模块直接把Cookie的值作为提示显示，Cookie的值不能包含中文，而且重定向前的请求不一定有语言上下文，
+// 所以提示统一使用Messages_en_US中的英文消息
+func setLoginNotice(w http.ResponseWriter, level login.NoticeLevel, msg string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginNoticeFlashCookie,
+		Value:    fmt.Sprintf("%d#%s", level, msg),
+		Path:     "/",
+		HttpOnly: true,
+	})
+}
+
+// commonPasswords 内置的常见密码，始终禁用
+var commonPasswords = []string{
+	"password", "passw0rd", "p@ssw0rd", "p@ssword", "pass", "secret", "changeme", "default",
+	"admin", "administrator", "root", "guest", "test", "user", "login", "welcome", "letmein",
+	"qwerty", "qwertyuiop", "asdfghjkl", "zxcvbnm", "qazwsx", "1qaz2wsx", "zaq12wsx", "1q2w3e4r", "qwer1234",
+	"abc", "abcd", "abcdef", "abc123", "iloveyou", "monkey", "dragon", "master", "shadow", "sunshine",
+	"princess", "football", "baseball", "superman", "batman", "trustno1", "whatever", "starwars",
+	"woaini", "woaini1314", "5201314", "aini1314",
+	"123456", "1234567", "12345678", "123456789", "1234567890", "0123456789", "987654321",
+	"111111", "000000", "123123", "666666", "888888", "11111111", "66666666", "88888888",
+}
+
+// passwordCharClassPattern 字符类型的格式
+var passwordCharClassPattern = regexp.MustCompile(`^(upper|lower|digit|symbol)$`)
+
+// passwordPolicy 密码策略
+type passwordPolicy struct {
+	MinLength   int             // 最小长度
+	CharClasses []string        // 必须包含的字符类型
+	History     int             // 不能重复使用最近多少个密码
+	MaxAge      time.Duration   // 密码有效期，0表示不过期
+	Blocklist   map[string]bool // 禁用的密码，小写
+}
+
+// currentPasswordPolicy 从环境变量加载的密码策略
+var currentPasswordPolicy = passwordPolicyFromEnv()
+
+// passwordPolicyFromEnv 从环境变量加载密码策略，无效的字符类型和无法读取的字典文件会被忽略并输出警告
+func passwordPolicyFromEnv() *passwordPolicy {
+	p := &passwordPolicy{
+		MinLength: getEnvWithDefaultInt("PASSWORD_MIN_LENGTH", 12),
+		History:   getEnvWithDefaultInt("PASSWORD_HISTORY", 0),
+		MaxAge:    getEnvWithDefaultDuration("PASSWORD_MAX_AGE", 0),
+		Blocklist: make(map[string]bool),
+	}
+	for _, c := range splitList(strings.ToLower(getEnvWithDefault("PASSWORD_CHAR_CLASSES", ""))) {
+		if !passwordCharClassPattern.MatchString(c) {
+			log.Printf("警告: PASSWORD_CHAR_CLASSES中的字符类型无效，已忽略: %s", c)
+			continue
+		}
+		if !slices.Contains(p.CharClasses, c) {
+			p.CharClasses = append(p.CharClasses, c)
+		}
+	}
+	for _, word := range commonPasswords {
+		p.Blocklist[word] = true
+	}
+	if file := getEnvWithDefault("PASSWORD_BLOCKLIST_FILE", ""); file != "" {
+		n, err := p.loadBlocklist(file)
+		if err != nil {
+			log.Printf("警告: 无法读取密码字典文件%s，只使用内置的常见密码: %v", file, err)
+		} else {
+			log.Printf("已加载密码字典: %s, %d个", file, n)
+		}
+	}
+	return p
+}
+
+// loadBlocklist 从字典文件加载禁用的密码
+// 参数：
+// - file: 字典文件，每行一个密码，#开头的行是注释
+// 返回：
+// - int: 加载的密码数量
+// - error: 无法读取文件时的错误信息
+func (p *passwordPolicy) loadBlocklist(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		p.Blocklist[word] = true
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// passwordPolicyMessages 返回密码策略提示使用的消息
+// 登录模块的表单通过Cookie传递提示，Cookie中不能包含中文，没有语言上下文的请求使用英文
+func passwordPolicyMessages(r *http.Request) *Messages {
+	if msgr, ok := i18n.MustGetModuleMessages(r, I18nAdminKey, nil).(*Messages); ok {
+		return msgr
+	}
+	return Messages_en_US
+}
+
+// passwordError 返回登录模块可以显示的密码错误
+func passwordError(msg string) error {
+	return &login.NoticeError{
+		Level:   login.NoticeLevel_Error,
+		Message: msg,
+	}
+}
+
+// hint 返回密码要求的说明，用于密码输入框
+func (p *passwordPolicy) hint(msgr *Messages) string {
+	hint := fmt.Sprintf(msgr.PasswordPolicyHint, p.MinLength)
+	if len(p.CharClasses) > 0 {
+		hint += fmt.Sprintf(msgr.PasswordPolicyHintClasses, p.charClassNames(msgr, p.CharClasses))
+	}
+	return hint
+}
+
+// charClassNames 返回字符类型的显示名称
+func (p *passwordPolicy) charClassNames(msgr *Messages, classes []string) string {
+	names := make([]string, 0, len(classes))
+	for _, c := range classes {
+		switch c {
+		case passwordCharUpper:
+			names = append(names, msgr.PasswordCharClassUpper)
+		case passwordCharLower:
+			names = append(names, msgr.PasswordCharClassLower)
+		case passwordCharDigit:
+			names = append(names, msgr.PasswordCharClassDigit)
+		case passwordCharSymbol:
+			names = append(names, msgr.PasswordCharClassSymbol)
+		}
+	}
+	return strings.Join(names, msgr.PasswordListSeparator)
+}
+
+// validate 检查密码的长度、字符类型、常见密码和是否包含账号或姓名，不检查历史密码
+// 参数：
+// - msgr: 提示信息使用的消息
+// - user: 设置密码的用户，用于检查密码是否包含账号或姓名
+// - password: 新密码
+// 返回：
+// - error: 不符合策略时返回登录模块可以显示的错误
+func (p *passwordPolicy) validate(msgr *Messages, user *models.User, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return passwordError(fmt.Sprintf(msgr.PasswordTooShort, p.MinLength))
+	}
+
+	var missing []string
+	for _, c := range p.CharClasses {
+		if !strings.ContainsFunc(password, passwordCharClassFunc(c)) {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return passwordError(fmt.Sprintf(msgr.PasswordMissingCharClass, p.charClassNames(msgr, missing)))
+	}
+
+	lower := strings.ToLower(password)
+	trimmed := strings.TrimRightFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if p.Blocklist[lower] || p.Blocklist[trimmed] {
+		return passwordError(msgr.PasswordTooCommon)
+	}
+
+	if user != nil {
+		account, _, _ := strings.Cut(user.Account, "@")
+		for _, s := range []string{account, user.Name} {
+			s = strings.ToLower(strings.TrimSpace(s))
+			if utf8.RuneCountInString(s) >= 4 && strings.Contains(lower, s) {
+				return passwordError(msgr.PasswordContainsAccount)
+			}
+		}
+	}
+	return nil
+}
+
+// passwordCharClassFunc 返回判断字符是否属于字符类型的函数
+func passwordCharClassFunc(class string) func(rune) bool {
+	switch class {
+	case passwordCharUpper:
+		return unicode.IsUpper
+	case passwordCharLower:
+		return unicode.IsLower
+	case passwordCharDigit:
+		return unicode.IsDigit
+	}
+	return func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+	}
+}
+
+// checkHistory 检查新密码是否是用户最近用过的密码，包括当前密码
+// 参数：
+// - db: 数据库连接
+// - msgr: 提示信息使用的消息
+// - user: 设置密码的用户，Password是当前密码的哈希，新建的用户不检查
+// - password: 新密码
+// 返回：
+// - error: 最近用过该密码时返回登录模块可以显示的错误，查询失败时返回数据库错误
+func (p *passwordPolicy) checkHistory(db *gorm.DB, msgr *Messages, user *models.User, password string) error {
+	if p.History <= 0 || user == nil || user.ID == 0 {
+		return nil
+	}
+	var hashes []string
+	if err := db.Model(&models.PasswordHistory{}).Where("user_id = ?", user.ID).
+		Order("id DESC").Limit(p.History).Pluck("password", &hashes).Error; err != nil {
+		return err
+	}
+	if user.Password != "" {
+		hashes = append(hashes, user.Password)
+	}
+	for _, hash := range hashes {
+		if (&login.UserPass{Password: hash}).IsPasswordCorrect(password) {
+			return passwordError(fmt.Sprintf(msgr.PasswordReused, p.History))
+		}
+	}
+	return nil
+}
+
+// check 按密码策略检查新密码，包括历史密码
+func (p *passwordPolicy) check(db *gorm.DB, msgr *Messages, user *models.User, password string) error {
+	if err := p.validate(msgr, user, password); err != nil {
+		return err
+	}
+	return p.checkHistory(db, msgr, user, password)
+}
+
+// recordHistory 保存用户新密码的哈希，并删除超出策略要求条数的旧记录
+// 参数：
+// - db: 数据库连接
+// - user: 已经设置了新密码的用户，Password是新密码的哈希
+// 返回：
+// - error: 保存或删除失败时的错误信息
+func (p *passwordPolicy) recordHistory(db *gorm.DB, user *models.User) error {
+	if p.History <= 0 || user.ID == 0 || user.Password == "" {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.PasswordHistory{UserID: user.ID, Password: user.Password}).Error; err != nil {
+			return err
+		}
+		var keep []uint
+		if err := tx.Model(&models.PasswordHistory{}).Where("user_id = ?", user.ID).
+			Order("id DESC").Limit(p.History).Pluck("id", &keep).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND id NOT IN ?", user.ID, keep).Delete(&models.PasswordHistory{}).Error
+	})
+}
+
+// expired 判断用户的本地密码是否已过期
+// OAuth用户、通过LDAP登录过的用户和没有本地密码的用户不会过期；没有密码更新时间的密码视为已过期
+func (p *passwordPolicy) expired(user *models.User, now time.Time) bool {
+	if p.MaxAge <= 0 || user == nil || user.IsOAuthUser() || user.DirectoryDN != "" || user.Password == "" {
+		return false
+	}
+	updatedAt, err := strconv.ParseInt(user.PassUpdatedAt, 10, 64)
+	if err != nil {
+		return true
+	}
+	return now.Sub(time.Unix(0, updatedAt)) > p.MaxAge
+}
+
+// enforcePasswordPolicy 在登录模块修改或重置密码之前按密码策略检查新密码
+func enforcePasswordPolicy(db *gorm.DB) func(in login.HookFunc) login.HookFunc {
+	return func(in login.HookFunc) login.HookFunc {
+		return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+			if err := in(r, user, extraVals...); err != nil {
+				return err
+			}
+			u, _ := user.(*models.User)
+			password := extraVals[0].(string)
+			return currentPasswordPolicy.check(db, passwordPolicyMessages(r), u, password)
+		}
+	}
+}
+
+// recordPasswordChange 在登录模块修改或重置密码之后保存密码历史，保存失败只输出日志
+func recordPasswordChange(db *gorm.DB) func(in login.HookFunc) login.HookFunc {
+	return func(in login.HookFunc) login.HookFunc {
+		return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+			if err := in(r, user, extraVals...); err != nil {
+				return err
+			}
+			if u, ok := user.(*models.User); ok {
+				if err := currentPasswordPolicy.recordHistory(db, u); err != nil {
+					models.AuthLog().Error("保存密码历史失败", "account", models.MaskAccount(u.Account), "error", err)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// requirePasswordChange 密码过期的用户只能访问登录模块的页面，其他页面跳转到修改密码页面
+// 需要放在登录中间件之后
+func requirePasswordChange() func(next http.Handler) http.Handler {
+	staticFile := regexp.MustCompile(`\.(css|js|gif|jpg|jpeg|png|ico|svg|ttf|eot|woff|woff2|js\.map)$`)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := getCurrentUser(r)
+			// 模拟用户时不要求管理员修改被模拟用户的密码
+			if u == nil || strings.HasPrefix(r.URL.Path, "/auth/") || staticFile.MatchString(strings.ToLower(r.URL.Path)) ||
+				impersonatorFromContext(r.Context()) != nil ||
+				!currentPasswordPolicy.expired(u, time.Now()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			setLoginNotice(w, login.NoticeLevel_Warn, Messages_en_US.PasswordExpired)
+			http.Redirect(w, r, passwordChangePageURL, http.StatusFound)
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/x/v3/login"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	p := &passwordPolicy{
+		MinLength:   12,
+		CharClasses: []string{passwordCharUpper, passwordCharDigit, passwordCharSymbol},
+		Blocklist:   map[string]bool{"password": true, "qwertyuiop": true},
+	}
+	user := &models.User{Name: "Alice Wang", LDAPUserPass: models.LDAPUserPass{Account: "alice.w@example.com"}}
+
+	cases := []struct {
+		password string
+		want     string
+	}{
+		{"Short-1", fmt.Sprintf(Messages_en_US.PasswordTooShort, 12)},
+		{"中文短密码1A!", fmt.Sprintf(Messages_en_US.PasswordTooShort, 12)},
+		{"lowercase-only!", fmt.Sprintf(Messages_en_US.PasswordMissingCharClass, "uppercase letters, digits")},
+		{"Password2024!", Messages_en_US.PasswordTooCommon},
+		{"QWERTYUIOP-1", Messages_en_US.PasswordTooCommon},
+		{"Xy9-alice.w-secret", Messages_en_US.PasswordContainsAccount},
+		{"Xy9-ALICE WANG!", Messages_en_US.PasswordContainsAccount},
+		{"Correct-Horse-7", ""},
+	}
+	for _, c := range cases {
+		err := p.validate(Messages_en_US, user, c.password)
+		var got string
+		if err != nil {
+			var ne *login.NoticeError
+			if !errors.As(err, &ne) {
+				t.Fatalf("%q 应该返回登录模块可以显示的错误，实际 %T", c.password, err)
+			}
+			got = ne.Message
+		}
+		if got != c.want {
+			t.Errorf("%q 的检查结果错误: %q, 期望 %q", c.password, got, c.want)
+		}
+	}
+
+	if hint := p.hint(Messages_zh_CN); hint != "至少12个字符，必须包含大写字母、数字、符号" {
+		t.Errorf("密码要求的说明错误: %q", hint)
+	}
+}
+
+func TestPasswordPolicyBlocklistFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(file, []byte("# 注释\nCompanyName\n\n  summer  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := &passwordPolicy{MinLength: 6, Blocklist: map[string]bool{}}
+	n, err := p.loadBlocklist(file)
+	if err != nil || n != 2 {
+		t.Fatalf("加载字典失败: %d, %v", n, err)
+	}
+	for _, pw := range []string{"companyname", "Summer2024!!"} {
+		if err := p.validate(Messages_en_US, nil, pw); err == nil {
+			t.Errorf("%q 在字典中，应该被拒绝", pw)
+		}
+	}
+	if _, err := p.loadBlocklist(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("字典文件不存在时应该返回错误")
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.PasswordHistory{}); err != nil {
+		t.Fatal(err)
+	}
+	p := &passwordPolicy{MinLength: 8, History: 2, Blocklist: map[string]bool{}}
+
+	user := &models.User{Name: "Bob", LDAPUserPass: models.LDAPUserPass{Account: "bob@example.com"}}
+	setPassword := func(pw string) {
+		t.Helper()
+		if err := p.check(db, Messages_en_US, user, pw); err != nil {
+			t.Fatalf("%q 应该允许设置: %v", pw, err)
+		}
+		user.Password = pw
+		user.EncryptPassword()
+		if err := db.Save(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := p.recordHistory(db, user); err != nil {
+			t.Fatalf("保存密码历史失败: %v", err)
+		}
+	}
+
+	setPassword("first-secret")
+	setPassword("second-secret")
+	setPassword("third-secret")
+
+	var count int64
+	db.Model(&models.PasswordHistory{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("应该只保留2条密码历史，实际 %d", count)
+	}
+
+	want := fmt.Sprintf(Messages_en_US.PasswordReused, 2)
+	for _, pw := range []string{"second-secret", "third-secret"} {
+		if err := p.check(db, Messages_en_US, user, pw); err == nil || err.Error() != want {
+			t.Errorf("%q 是最近用过的密码，应该被拒绝，实际 %v", pw, err)
+		}
+	}
+	if err := p.check(db, Messages_en_US, user, "first-secret"); err != nil {
+		t.Errorf("超出历史条数的密码应该允许再次使用: %v", err)
+	}
+
+	// 没有开启历史检查时不保存也不检查
+	p.History = 0
+	if err := p.check(db, Messages_en_US, user, "third-secret"); err != nil {
+		t.Errorf("没有开启历史检查时不应该拒绝: %v", err)
+	}
+}
+
+func TestPasswordExpired(t *testing.T) {
+	now := time.Now()
+	p := &passwordPolicy{MaxAge: 24 * time.Hour}
+	local := func(updatedAt string) *models.User {
+		return &models.User{LDAPUserPass: models.LDAPUserPass{Password: "hash", PassUpdatedAt: updatedAt}}
+	}
+
+	if p.expired(local(fmt.Sprint(now.Add(-time.Hour).UnixNano())), now) {
+		t.Error("有效期内的密码不应该过期")
+	}
+	if !p.expired(local(fmt.Sprint(now.Add(-48*time.Hour).UnixNano())), now) {
+		t.Error("超过有效期的密码应该过期")
+	}
+	if !p.expired(local(""), now) {
+		t.Error("没有密码更新时间的密码应该视为过期")
+	}
+
+	ldapUser := local("")
+	ldapUser.DirectoryDN = "uid=alice,dc=example,dc=com"
+	oauthUser := local("")
+	oauthUser.OAuthProvider = models.OAuthProviderGoogle
+	for _, u := range []*models.User{ldapUser, oauthUser, {}} {
+		if p.expired(u, now) {
+			t.Errorf("LDAP、OAuth和没有本地密码的用户不应该过期: %+v", u.LDAPUserPass)
+		}
+	}
+	if (&passwordPolicy{}).expired(local(""), now) {
+		t.Error("没有设置有效期时密码不应该过期")
+	}
+}
+
+func TestRequirePasswordChange(t *testing.T) {
+	old := currentPasswordPolicy
+	currentPasswordPolicy = &passwordPolicy{MaxAge: time.Hour}
+	defer func() { currentPasswordPolicy = old }()
+
+	handler := requirePasswordChange()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(path string, u *models.User) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if u != nil {
+			r = r.WithContext(context.WithValue(r.Context(), login.UserKey, u))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	expired := &models.User{LDAPUserPass: models.LDAPUserPass{
+		Password:      "hash",
+		PassUpdatedAt: fmt.Sprint(time.Now().Add(-2 * time.Hour).UnixNano()),
+	}}
+	w := serve("/admin/users", expired)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != passwordChangePageURL {
+		t.Fatalf("密码过期的用户应该跳转到修改密码页面，实际 %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if cookie := w.Header().Get("Set-Cookie"); !strings.Contains(cookie, loginNoticeFlashCookie+"=") ||
+		!strings.Contains(cookie, "expired") {
+		t.Errorf("应该设置修改密码页面的提示，实际 %q", cookie)
+	}
+
+	for _, path := range []string{passwordChangePageURL, "/auth/logout", "/admin/assets/app.js"} {
+		if w := serve(path, expired); w.Code != http.StatusOK {
+			t.Errorf("%s 不应该跳转，实际 %d", path, w.Code)
+		}
+	}
+
+	valid := &models.User{LDAPUserPass: models.LDAPUserPass{
+		Password:      "hash",
+		PassUpdatedAt: fmt.Sprint(time.Now().UnixNano()),
+	}}
+	for _, u := range []*models.User{valid, nil} {
+		if w := serve("/admin/users", u); w.Code != http.StatusOK {
+			t.Errorf("密码没有过期或未登录时不应该跳转，实际 %d", w.Code)
+		}
+	}
+}
+
+func TestEnforcePasswordPolicyMessages(t *testing.T) {
+	old := currentPasswordPolicy
+	currentPasswordPolicy = &passwordPolicy{MinLength: 12, Blocklist: map[string]bool{}}
+	defer func() { currentPasswordPolicy = old }()
+
+	hook := enforcePasswordPolicy(nil)(func(*http.Request, interface{}, ...interface{}) error { return nil })
+	r := httptest.NewRequest("POST", "/auth/do-reset-password", nil)
+	// 登录模块的表单没有语言上下文，提示需要能放进Cookie
+	err := hook(r, &models.User{}, "short")
+	if err == nil || err.Error() != fmt.Sprintf(Messages_en_US.PasswordTooShort, 12) {
+		t.Errorf("没有语言上下文时应该使用英文提示，实际 %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	. "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+找回密码说明：
+
+本地密码用户在登录页面点击"忘记密码"，填写账号后通过邮件收到重置密码的链接：
+
+1. 发送邮件
+   - 登录模块生成重置令牌后调用sendResetPasswordMail，按请求的语言（中文或英文）发送邮件
+   - 链接使用BASE_URL，没有配置时使用请求的域名；链接的有效期由models.LDAPUserPass决定，默认10分钟
+   - 没有配置SMTP_HOST时不显示"忘记密码"链接
+   - 邮件发送失败时令牌立即失效，用户可以马上重试
+
+2. 目录用户
+   - 通过LDAP登录过的用户（DirectoryDN不为空）和还没有开通的目录用户不发送邮件，重置令牌立即失效
+   - 账号不是邮箱的用户同样不发送邮件
+   - 这些情况和发送成功显示相同的"已发送"页面，页面上提示目录用户到目录服务修改密码，避免通过提示区分账号类型
+
+3. 频率限制
+   - 同一账号和同一IP在PASSWORD_RESET_RATE_WINDOW（默认1小时）内分别最多提交PASSWORD_RESET_ACCOUNT_LIMIT（默认5）次
+     和PASSWORD_RESET_IP_LIMIT（默认20）次，超过后提示稍后再试
+   - 计数保存在内存中，多实例部署时每个实例单独计数
+   - 不存在的账号同样计数，并且和存在的账号返回相同的页面，避免通过找回密码探测账号
+*/
+
+// 登录模块找回密码的页面和提交地址
+const (
+	forgetPasswordPageURL    = "/auth/forget-password"
+	sendResetPasswordLinkURL = "/auth/send-reset-password-link"
+)
+
+var (
+	passwordResetRateWindow   = getEnvWithDefaultDuration("PASSWORD_RESET_RATE_WINDOW", time.Hour)
+	passwordResetAccountLimit = getEnvWithDefaultInt("PASSWORD_RESET_ACCOUNT_LIMIT", 5)
+	passwordResetIPLimit      = getEnvWithDefaultInt("PASSWORD_RESET_IP_LIMIT", 20)
+)
+
+// passwordResetLimiter 找回密码的频率限制
+var passwordResetLimiter = newRateLimiter(passwordResetRateWindow)
+
+// rateLimit 一个计数键和它在时间窗口内允许的次数
+type rateLimit struct {
+	Key string
+	Max int
+}
+
+// rateLimiter 按滑动时间窗口计数的内存频率限制
+type rateLimiter struct {
+	mu        sync.Mutex
+	window    time.Duration
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+// newRateLimiter 创建频率限制
+func newRateLimiter(window time.Duration) *rateLimiter {
+	return &rateLimiter{window: window, hits: map[string][]time.Time{}}
+}
+
+// allow 检查所有计数键是否都没有超过限制，都没有超过时为每个键记录一次
+// 参数：
+// - now: 当前时间
+// - limits: 计数键和允许的次数，Max小于等于0表示不限制
+// 返回：
+// - bool: 是否允许
+func (l *rateLimiter) allow(now time.Time, limits ...rateLimit) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	since := now.Add(-l.window)
+	if now.Sub(l.lastSweep) > l.window {
+		for key, hits := range l.hits {
+			if len(hits) == 0 || !hits[len(hits)-1].After(since) {
+				delete(l.hits, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	for _, limit := range limits {
+		if limit.Max <= 0 {
+			continue
+		}
+		hits := l.hits[limit.Key]
+		for len(hits) > 0 && !hits[0].After(since) {
+			hits = hits[1:]
+		}
+		l.hits[limit.Key] = hits
+		if len(hits) >= limit.Max {
+			return false
+		}
+	}
+	for _, limit := range limits {
+		if limit.Max > 0 {
+			l.hits[limit.Key] = append(l.hits[limit.Key], now)
+		}
+	}
+	return true
+}
+
+// limitPasswordReset 限制提交找回密码的频率，并为登录模块的提交接口设置语言，用于选择邮件的语言
+func limitPasswordReset(ib *i18n.Builder) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withLanguage := ib.EnsureLanguage(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != sendResetPasswordLinkURL || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			account := strings.ToLower(strings.TrimSpace(r.FormValue("account")))
+			if account != "" && !passwordResetLimiter.allow(time.Now(),
+				rateLimit{Key: "ip:" + ip(r), Max: passwordResetIPLimit},
+				rateLimit{Key: "account:" + account, Max: passwordResetAccountLimit},
+			) {
+				models.AuthLog().Warn("找回密码请求过于频繁", "account", models.MaskAccount(account), "ip", ip(r))
+				setLoginNotice(w, login.NoticeLevel_Error, Messages_en_US.PasswordResetTooManyRequests)
+				http.Redirect(w, r, forgetPasswordPageURL, http.StatusFound)
+				return
+			}
+			withLanguage.ServeHTTP(w, r)
+		})
+	}
+}
+
+// resetPasswordLink 使用BASE_URL替换登录模块按请求域名生成的重置密码链接
+func resetPasswordLink(link string) string {
+	base := strings.TrimSuffix(baseURL, "/")
+	if base == "" {
+		return link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return base + u.RequestURI()
+}
+
+// sendResetPasswordMail 登录模块生成重置令牌后，通过邮件发送重置密码的链接
+// 目录用户和账号不是邮箱的用户不发送邮件，令牌立即失效，仍然显示"已发送"页面
+// 发送失败时令牌立即失效，通过登录模块的提示告诉用户
+func sendResetPasswordMail(db *gorm.DB) func(in login.HookFunc) login.HookFunc {
+	return func(in login.HookFunc) login.HookFunc {
+		return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+			u, ok := user.(*models.User)
+			if !ok || len(extraVals) == 0 {
+				return in(r, user, extraVals...)
+			}
+			link, _ := extraVals[0].(string)
+			account := models.MaskAccount(u.Account)
+
+			if u.ID == 0 || u.DirectoryDN != "" {
+				models.AuthLog().Info("目录用户不能通过邮件找回密码", "account", account)
+				discardResetPasswordToken(db, u)
+				return in(r, user, extraVals...)
+			}
+
+			addr, err := mail.ParseAddress(u.Account)
+			if err != nil {
+				models.AuthLog().Warn("账号不是邮箱，无法发送找回密码邮件", "account", account)
+				discardResetPasswordToken(db, u)
+				return in(r, user, extraVals...)
+			}
+			addr.Name = u.Name
+
+			msgr := passwordPolicyMessages(r)
+			expiresAt := ""
+			if u.ResetPasswordTokenExpiredAt != nil {
+				expiresAt = u.ResetPasswordTokenExpiredAt.Format("2006-01-02 15:04")
+			}
+			if err := sendMail(r.Context(), &Mail{
+				To:      addr.String(),
+				Subject: msgr.PasswordResetMailSubject,
+				Body:    fmt.Sprintf(msgr.PasswordResetMailBody, u.Name, resetPasswordLink(link), expiresAt),
+			}); err != nil {
+				models.AuthLog().Error("发送找回密码邮件失败", "account", account, "error", err)
+				return expireResetPasswordToken(db, u, Messages_en_US.PasswordResetUnavailable)
+			}
+			models.AuthLog().Info("发送找回密码邮件", "account", account, "ip", ip(r))
+			return in(r, user, extraVals...)
+		}
+	}
+}
+
+// expireResetPasswordToken 使没有发送出去的重置令牌失效，返回登录模块显示的错误提示
+func expireResetPasswordToken(db *gorm.DB, u *models.User, msg string) error {
+	discardResetPasswordToken(db, u)
+	return &login.NoticeError{
+		Level:   login.NoticeLevel_Error,
+		Message: msg,
+	}
+}
+
+// discardResetPasswordToken 使没有发送出去的重置令牌失效
+func discardResetPasswordToken(db *gorm.DB, u *models.User) {
+	if u.ID == 0 {
+		return
+	}
+	if err := u.ConsumeResetPasswordToken(db, &models.User{}); err != nil {
+		models.AuthLog().Error("使重置密码令牌失效失败", "account", models.MaskAccount(u.Account), "error", err)
+	}
+}
+
+// resetPasswordLinkSentPage 提交找回密码后显示的页面
+// 所有账号都显示这个页面，在登录模块默认页面的基础上提示目录用户到目录服务修改密码
+func resetPasswordLinkSentPage(vh *login.ViewHelper, pb *presets.Builder) web.PageFunc {
+	return pb.PlainLayout(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		loginMsgr := i18n.MustGetModuleMessages(ctx.R, login.I18nLoginKey, login.Messages_en_US).(*login.Messages)
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_en_US).(*Messages)
+
+		r.PageTitle = loginMsgr.ResetPasswordLinkSentPageTitle
+		r.Body = Div(
+			plogin.DefaultViewCommon.Notice(vh, loginMsgr, ctx.W, ctx.R),
+			Div(
+				H1(fmt.Sprintf("%s %s.", loginMsgr.ResetPasswordLinkWasSentTo, ctx.R.URL.Query().Get("a"))).Class("text-h5"),
+				H2(loginMsgr.ResetPasswordLinkSentPrompt).Class("text-body-1 mt-2"),
+				P(Text(msgr.PasswordResetDirectoryHint)).Class("text-body-2 text-medium-emphasis mt-4"),
+			).Class(plogin.DefaultViewCommon.WrapperClass).Style(plogin.DefaultViewCommon.WrapperStyle),
+		)
+		return
+	})
+}
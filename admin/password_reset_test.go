@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(time.Hour)
+	now := time.Now()
+	ipLimit := rateLimit{Key: "ip:1.2.3.4", Max: 3}
+
+	for i := 0; i < 2; i++ {
+		if !l.allow(now, ipLimit, rateLimit{Key: "account:a", Max: 2}) {
+			t.Fatalf("第%d次请求应该允许", i+1)
+		}
+	}
+	if l.allow(now, ipLimit, rateLimit{Key: "account:a", Max: 2}) {
+		t.Errorf("超过账号的次数限制后应该拒绝")
+	}
+	// 被拒绝的请求不计数，IP还剩一次
+	if !l.allow(now, ipLimit, rateLimit{Key: "account:b", Max: 2}) {
+		t.Errorf("被拒绝的请求不应该计数")
+	}
+	if l.allow(now, ipLimit, rateLimit{Key: "account:c", Max: 2}) {
+		t.Errorf("超过IP的次数限制后应该拒绝")
+	}
+	if !l.allow(now.Add(time.Hour+time.Second), ipLimit, rateLimit{Key: "account:a", Max: 2}) {
+		t.Errorf("时间窗口过去后应该重新计数")
+	}
+	if len(l.hits) != 2 {
+		t.Errorf("过期的计数应该被清理，实际 %v", l.hits)
+	}
+}
+
+func TestLimitPasswordReset(t *testing.T) {
+	old := passwordResetLimiter
+	passwordResetLimiter = newRateLimiter(time.Hour)
+	t.Cleanup(func() { passwordResetLimiter = old })
+
+	var served int
+	handler := limitPasswordReset(i18n.New())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+	post := func(account string) *httptest.ResponseRecorder {
+		body := url.Values{"account": {account}}.Encode()
+		r := httptest.NewRequest("POST", sendResetPasswordLinkURL, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < passwordResetAccountLimit; i++ {
+		post("Alice@example.com")
+	}
+	if served != passwordResetAccountLimit {
+		t.Fatalf("没有超过限制的请求应该交给登录模块处理，实际 %d", served)
+	}
+	w := post(" alice@example.com ")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != forgetPasswordPageURL || !hasCookie(w, loginNoticeFlashCookie) {
+		t.Errorf("同一账号超过限制后应该返回找回密码页面并提示，实际 %d", w.Code)
+	}
+	if served != passwordResetAccountLimit {
+		t.Errorf("超过限制的请求不应该交给登录模块处理")
+	}
+
+	r := httptest.NewRequest("GET", forgetPasswordPageURL, nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if served != passwordResetAccountLimit+1 {
+		t.Errorf("其他请求不应该受限制")
+	}
+}
+
+func TestSendResetPasswordMail(t *testing.T) {
+	mailer := useMemoryMailer(t)
+	db := newTestDB(t)
+	next := func(r *http.Request, user interface{}, extraVals ...interface{}) error { return nil }
+	hook := sendResetPasswordMail(db)(next)
+
+	newUser := func(account, dn string) *models.User {
+		u := &models.User{Name: "Alice", Status: models.StatusActive, LDAPUserPass: models.LDAPUserPass{Account: account, DirectoryDN: dn}}
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+		if _, err := u.GenerateResetPasswordToken(db, &models.User{}); err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	tokenExpired := func(u *models.User) bool {
+		var saved models.User
+		db.First(&saved, u.ID)
+		_, createdAt, expired := saved.GetResetPasswordToken()
+		return expired || createdAt == nil
+	}
+	const link = "http://localhost/auth/reset-password?id=1&token=abc"
+	r := httptest.NewRequest("POST", sendResetPasswordLinkURL, nil)
+
+	local := newUser("alice@example.com", "")
+	if err := hook(r, local, link); err != nil {
+		t.Fatal(err)
+	}
+	sent := mailer.Sent()
+	if len(sent) != 1 || !strings.Contains(sent[0].To, "alice@example.com") || !strings.Contains(sent[0].Body, link) {
+		t.Fatalf("应该把重置密码链接发送到用户邮箱: %+v", sent)
+	}
+	if sent[0].Subject != Messages_en_US.PasswordResetMailSubject {
+		t.Errorf("没有语言上下文时应该使用英文邮件，实际 %q", sent[0].Subject)
+	}
+
+	// 目录用户和账号不是邮箱的用户与发送成功的结果相同，不能用来区分账号类型
+	directory := newUser("bob@example.com", "uid=bob,dc=example,dc=com")
+	if err := hook(r, directory, link); err != nil {
+		t.Errorf("目录用户应该和其他账号显示相同的页面，实际 %v", err)
+	}
+	if len(mailer.Sent()) != 1 || !tokenExpired(directory) {
+		t.Errorf("目录用户不应该收到邮件，重置令牌应该失效")
+	}
+	notMail := newUser("dave", "")
+	if err := hook(r, notMail, link); err != nil {
+		t.Errorf("账号不是邮箱的用户应该和其他账号显示相同的页面，实际 %v", err)
+	}
+	if len(mailer.Sent()) != 1 || !tokenExpired(notMail) {
+		t.Errorf("账号不是邮箱的用户不应该收到邮件，重置令牌应该失效")
+	}
+
+	currentMailer = nil
+	failed := newUser("carol@example.com", "")
+	if err := hook(r, failed, link); err == nil || !tokenExpired(failed) {
+		t.Errorf("邮件发送失败时应该提示，重置令牌应该失效，实际 %v", err)
+	}
+}
+
+func TestResetPasswordLink(t *testing.T) {
+	old := baseURL
+	t.Cleanup(func() { baseURL = old })
+
+	const link = "http://internal:9500/auth/reset-password?id=1&token=abc"
+	baseURL = ""
+	if got := resetPasswordLink(link); got != link {
+		t.Errorf("没有配置BASE_URL时应该使用原来的链接，实际 %q", got)
+	}
+	baseURL = "https://admin.example.com/"
+	if got := resetPasswordLink(link); got != "https://admin.example.com/auth/reset-password?id=1&token=abc" {
+		t.Errorf("应该使用BASE_URL生成链接，实际 %q", got)
+	}
+}
+
+func TestResetPasswordLinkSentPage(t *testing.T) {
+	page := resetPasswordLinkSentPage(login.New().ViewHelper(), presets.New())
+	r := httptest.NewRequest("GET", "/auth/reset-password-link-sent?a=bob@example.com", nil)
+	resp, err := page(&web.EventContext{R: r, W: httptest.NewRecorder(), Injector: &web.PageInjector{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := resp.Body.MarshalHTML(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "bob@example.com") || !strings.Contains(string(body), Messages_en_US.PasswordResetDirectoryHint) {
+		t.Errorf("已发送页面应该显示账号和目录用户的提示: %s", body)
+	}
+}
@@ -3,7 +3,9 @@ package admin
 import (
 	"fmt"
 	"net/http"
+	"slices"
 
+	"github.com/naokij/qor5boot/admin/recurring"
 	"github.com/naokij/qor5boot/models"
 	"github.com/ory/ladon"
 	"github.com/qor5/admin/v3/activity"
@@ -21,7 +23,7 @@ func initPermission(b *presets.Builder, db *gorm.DB) {
 				models.RoleViewer,
 				models.RoleEditor,
 				models.RoleManager,
-			).WhoAre(perm.Denied).ToDo(presets.PermCreate, presets.PermUpdate, presets.PermDelete).On("*:roles:*", "*:users:*"),
+			).WhoAre(perm.Denied).ToDo(presets.PermCreate, presets.PermUpdate, presets.PermDelete, presets.PermDoListingAction).On("*:roles:*", "*:users:*"),
 			perm.PolicyFor(models.RoleViewer).WhoAre(perm.Denied).ToDo(presets.PermCreate, presets.PermUpdate, presets.PermDelete).On(perm.Anything),
 			perm.PolicyFor(perm.Anybody).WhoAre(perm.Denied).ToDo(presets.PermCreate).On(":presets:recurring_job_executions:", ":presets:recurring_job_executions:*"),
 			perm.PolicyFor(models.RoleManager).WhoAre(perm.Denied).ToDo(perm.Anything).
@@ -29,6 +31,39 @@ func initPermission(b *presets.Builder, db *gorm.DB) {
 				Given(perm.Conditions{
 					"is_authorized": &ladon.BooleanCondition{},
 				}),
+			// Editor只能编辑、删除、暂停和执行自己负责的重复任务
+			perm.PolicyFor(models.RoleEditor).WhoAre(perm.Denied).
+				ToDo(presets.PermUpdate, presets.PermDelete, recurring.PermRunJob, recurring.PermPauseJob).
+				On("*:recurring_jobs:*").
+				Given(perm.Conditions{
+					"is_job_owner": &ladon.BooleanCondition{},
+				}),
+			// 只有管理员可以查看和修改LDAP设置，没有角色的用户也不能访问
+			perm.PolicyFor(perm.Anybody).WhoAre(perm.Denied).ToDo(perm.Anything).
+				On("*:ldap_settings").On("*:ldap_settings:*").
+				Given(perm.Conditions{
+					"is_admin": &ladon.BooleanCondition{},
+				}),
+			// 只有管理员可以查看和修改OAuth角色规则
+			perm.PolicyFor(perm.Anybody).WhoAre(perm.Denied).ToDo(perm.Anything).
+				On("*:oauth_role_rules").On("*:oauth_role_rules:*").
+				Given(perm.Conditions{
+					"is_admin": &ladon.BooleanCondition{},
+				}),
+			// 登录事件只能由系统写入，只有管理员可以查看
+			perm.PolicyFor(perm.Anybody).WhoAre(perm.Denied).ToDo(presets.PermCreate, presets.PermUpdate, presets.PermDelete).
+				On("*:login_events", "*:login_events:*"),
+			perm.PolicyFor(perm.Anybody).WhoAre(perm.Denied).ToDo(perm.Anything).
+				On("*:login_events").On("*:login_events:*").
+				Given(perm.Conditions{
+					"is_admin": &ladon.BooleanCondition{},
+				}),
+			// 只有管理员可以查看登录锁定和解锁
+			perm.PolicyFor(perm.Anybody).WhoAre(perm.Denied).ToDo(perm.Anything).
+				On("*:login_lockouts").On("*:login_lockouts:*").
+				Given(perm.Conditions{
+					"is_admin": &ladon.BooleanCondition{},
+				}),
 		).SubjectsFunc(func(r *http.Request) []string {
 			u := getCurrentUser(r)
 			if u == nil {
@@ -37,6 +72,8 @@ func initPermission(b *presets.Builder, db *gorm.DB) {
 			return u.GetRoles()
 		}).ContextFunc(func(r *http.Request, objs []interface{}) perm.Context {
 			c := make(perm.Context)
+			u := getCurrentUser(r)
+			c["is_admin"] = u != nil && slices.Contains(u.GetRoles(), models.RoleAdmin)
 			for _, obj := range objs {
 				switch v := obj.(type) {
 				case *activity.ActivityLog:
@@ -46,9 +83,30 @@ func initPermission(b *presets.Builder, db *gorm.DB) {
 					} else {
 						c["is_authorized"] = false
 					}
+				case *models.RecurringJob:
+					c["is_job_owner"] = isRecurringJobOwner(db, v, getCurrentUser(r))
 				}
 			}
 			return c
 		}).DBPolicy(perm.NewDBPolicy(db)),
 	)
 }
+
+// isRecurringJobOwner 判断用户是否可以管理重复任务
+// 负责人信息以数据库中保存的为准，避免通过提交的表单修改负责人绕过权限；
+// 新建的任务和管理员不受负责人限制
+func isRecurringJobOwner(db *gorm.DB, job *models.RecurringJob, u *models.User) bool {
+	if u == nil {
+		return false
+	}
+	roles := u.GetRoles()
+	if job.ID == 0 || slices.Contains(roles, models.RoleAdmin) {
+		return true
+	}
+
+	var saved models.RecurringJob
+	if err := db.Select("id", "owner_id", "owner_role").First(&saved, job.ID).Error; err != nil {
+		return false
+	}
+	return saved.IsOwnedBy(u.ID, roles)
+}
@@ -0,0 +1,243 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/v3/activity"
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	. "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+恢复码说明：
+
+启用TOTP的用户丢失验证器时，可以使用一次性恢复码代替验证码登录，不需要管理员重置：
+
+1. 生成
+   - 启用TOTP时自动生成一组恢复码，只在生成后显示一次
+   - 用户可以在个人资料中查看剩余数量并重新生成，重新生成后原有的恢复码全部失效
+   - 数据库只保存恢复码的哈希
+
+2. 使用
+   - 验证页面输入恢复码代替验证码，6位数字按验证码验证，其他输入按恢复码验证
+   - 每个恢复码只能使用一次，每次使用和重新生成都记录在操作日志中
+
+3. 重置
+   - 管理员重置用户的TOTP时同时删除恢复码
+*/
+
+// 恢复码页面地址，与TOTP页面使用相同的前缀
+const recoveryCodesPageURL = "/auth/2fa/recovery-codes"
+
+const (
+	recoveryCodeCount    = 10                                 // 每次生成的恢复码数量
+	recoveryCodeLength   = 10                                 // 恢复码长度，不包括显示时的分隔符
+	recoveryCodeAlphabet = "0123456789abcdefghjkmnpqrstvwxyz" // 去掉容易混淆的i、l、o、u
+)
+
+// 恢复码的操作日志类型
+const (
+	recoveryCodeActionUse        = "UseRecoveryCode"
+	recoveryCodeActionRegenerate = "RegenerateRecoveryCodes"
+)
+
+// errWrongRecoveryCode 恢复码错误或已经使用，登录事件中记为恢复码错误
+var errWrongRecoveryCode = errors.New("恢复码错误或已使用")
+
+// totpCodePattern TOTP验证码的格式，验证页面其他格式的输入按恢复码验证
+var totpCodePattern = regexp.MustCompile(`^\d{6}$`)
+
+// recoveryCodesKey 刚生成的恢复码在请求上下文中的键，页面据此显示恢复码
+type recoveryCodesKey struct{}
+
+// normalizeRecoveryCode 去掉用户输入中的空格和分隔符并转为小写
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// hashRecoveryCode 计算恢复码的哈希
+// 恢复码是随机生成的，熵足够高，不需要使用慢哈希
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCode 生成一个随机恢复码，显示格式为xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = recoveryCodeAlphabet[int(b[i])%len(recoveryCodeAlphabet)]
+	}
+	return string(b[:recoveryCodeLength/2]) + "-" + string(b[recoveryCodeLength/2:]), nil
+}
+
+// generateRecoveryCodes 为用户生成新的恢复码，原有的恢复码全部删除
+// 参数：
+// - db: 数据库连接
+// - userID: 用户ID
+// 返回：
+// - []string: 新的恢复码明文，只能显示这一次
+// - error: 生成或保存失败时的错误信息
+func generateRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteRecoveryCodes(tx, userID); err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// deleteRecoveryCodes 删除用户的全部恢复码
+func deleteRecoveryCodes(db *gorm.DB, userID uint) error {
+	return db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+// consumeRecoveryCode 验证并作废用户输入的恢复码，同一个恢复码并发使用时只有一次成功
+// 参数：
+// - db: 数据库连接
+// - userID: 用户ID
+// - code: 用户输入的恢复码
+// - now: 使用时间
+// 返回：
+// - error: 恢复码错误或已使用时返回 errWrongRecoveryCode
+func consumeRecoveryCode(db *gorm.DB, userID uint, code string, now time.Time) error {
+	if normalizeRecoveryCode(code) == "" {
+		return errWrongRecoveryCode
+	}
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errWrongRecoveryCode
+	}
+	return nil
+}
+
+// remainingRecoveryCodes 返回用户未使用的恢复码数量
+func remainingRecoveryCodes(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// logRecoveryCodeActivity 记录恢复码的操作日志，记录失败只输出日志
+func logRecoveryCodeActivity(ctx context.Context, ab *activity.Builder, action string, u *models.User) {
+	if _, err := ab.Log(ctx, action, u, nil); err != nil {
+		models.AuthLog().Error("记录恢复码操作日志失败", "action", action, "error", err)
+	}
+}
+
+// showRecoveryCodes 生成新的恢复码并在当前响应中显示
+// 恢复码不保存明文，因此不跳转，直接渲染恢复码页面
+func showRecoveryCodes(w http.ResponseWriter, r *http.Request, db *gorm.DB, ab *activity.Builder, page http.Handler) {
+	u := getCurrentUser(r)
+	codes, err := generateRecoveryCodes(db, u.ID)
+	if err != nil {
+		panic(err)
+	}
+	models.AuthLog().Info("已生成恢复码", "account", models.MaskAccount(u.Account))
+	logRecoveryCodeActivity(r.Context(), ab, recoveryCodeActionRegenerate, u)
+	page.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), recoveryCodesKey{}, codes)))
+}
+
+// recoveryCodesHandler 恢复码页面，GET查看剩余数量，POST重新生成
+// 只有启用了TOTP的用户可以访问，需要先通过双因素认证
+func recoveryCodesHandler(db *gorm.DB, ab *activity.Builder, page http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := getCurrentUser(r)
+		if u == nil || u.IsOAuthUser() || !u.IsTOTPSetup {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		if r.Method == http.MethodPost {
+			showRecoveryCodes(w, r, db, ab, page)
+			return
+		}
+		page.ServeHTTP(w, r)
+	}
+}
+
+// recoveryCodesPage 显示刚生成的恢复码，或者剩余数量和重新生成按钮
+func recoveryCodesPage(pb *presets.Builder, vh *login.ViewHelper, db *gorm.DB) web.PageFunc {
+	return pb.PlainLayout(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		loginMsgr := i18n.MustGetModuleMessages(ctx.R, login.I18nLoginKey, login.Messages_en_US).(*login.Messages)
+
+		var content HTMLComponent
+		if codes, ok := ctx.R.Context().Value(recoveryCodesKey{}).([]string); ok {
+			var items []HTMLComponent
+			for _, c := range codes {
+				items = append(items, Div(Text(c)).Class("v-col-6 py-1"))
+			}
+			content = Components(
+				Div(items...).Class("v-row my-4 font-weight-bold").Style("font-family: monospace"),
+				v.VAlert(Text(msgr.RecoveryCodesSaveWarning)).Type("warning").Variant(v.VariantTonal).Density(v.DensityCompact),
+				A(Text(msgr.RecoveryCodesContinue)).Href("/").Class("d-block mt-4"),
+			)
+		} else {
+			remaining, err := remainingRecoveryCodes(db, getCurrentUser(ctx.R).ID)
+			if err != nil {
+				return r, err
+			}
+			content = Components(
+				Div(Text(fmt.Sprintf(msgr.RecoveryCodesRemaining, remaining))).Class("my-4 font-weight-bold"),
+				Form(
+					Label(msgr.RecoveryCodesRegenerateHint),
+					plogin.DefaultViewCommon.FormSubmitBtn(msgr.RecoveryCodesRegenerateBtn),
+				).Method(http.MethodPost).Action(recoveryCodesPageURL),
+				A(Text(msgr.Back)).Href("/").Class("d-block mt-4"),
+			)
+		}
+
+		r.PageTitle = msgr.RecoveryCodesTitle
+		r.Body = Div(
+			plogin.DefaultViewCommon.Notice(vh, loginMsgr, ctx.W, ctx.R),
+			Div(
+				Div(
+					H1(msgr.RecoveryCodesTitle).Class(plogin.DefaultViewCommon.TitleClass),
+					Label(msgr.RecoveryCodesPrompt),
+				),
+				content,
+			).Class(plogin.DefaultViewCommon.WrapperClass).Style(plogin.DefaultViewCommon.WrapperStyle).Class("text-center"),
+		)
+		return
+	})
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/v3/activity"
+	"github.com/qor5/x/v3/login"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// newTestActivity 创建记录用户操作的操作日志
+func newTestActivity(t *testing.T, db *gorm.DB) *activity.Builder {
+	t.Helper()
+	ab := activity.New(db, func(ctx context.Context) (*activity.User, error) {
+		u := ctx.Value(login.UserKey).(*models.User)
+		return &activity.User{ID: fmt.Sprint(u.ID), Name: u.Name}, nil
+	}).AutoMigrate()
+	ab.RegisterModel(&models.User{})
+	return ab
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.RecoveryCode{}); err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := generateRecoveryCodes(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(normalizeRecoveryCode(c)) != recoveryCodeLength || seen[c] {
+			t.Fatalf("恢复码格式错误或重复: %v", codes)
+		}
+		seen[c] = true
+	}
+	var stored []models.RecoveryCode
+	db.Find(&stored)
+	for _, s := range stored {
+		if seen[s.CodeHash] || len(s.CodeHash) != 64 {
+			t.Fatalf("数据库中只能保存恢复码的哈希: %q", s.CodeHash)
+		}
+	}
+
+	now := time.Now()
+	if err := consumeRecoveryCode(db, 2, codes[0], now); err != errWrongRecoveryCode {
+		t.Errorf("恢复码不能用于其他用户，实际 %v", err)
+	}
+	// 输入时不区分大小写，可以省略分隔符
+	input := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+	if err := consumeRecoveryCode(db, 1, input, now); err != nil {
+		t.Fatalf("正确的恢复码应该验证通过: %v", err)
+	}
+	if err := consumeRecoveryCode(db, 1, codes[0], now); err != errWrongRecoveryCode {
+		t.Errorf("恢复码只能使用一次，实际 %v", err)
+	}
+	if err := consumeRecoveryCode(db, 1, "", now); err != errWrongRecoveryCode {
+		t.Errorf("空的恢复码应该验证失败，实际 %v", err)
+	}
+	if n, _ := remainingRecoveryCodes(db, 1); n != recoveryCodeCount-1 {
+		t.Errorf("剩余恢复码数量错误: %d", n)
+	}
+
+	// 重新生成后原有的恢复码失效
+	if _, err := generateRecoveryCodes(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := consumeRecoveryCode(db, 1, codes[1], now); err != errWrongRecoveryCode {
+		t.Errorf("重新生成后原有的恢复码应该失效，实际 %v", err)
+	}
+	if n, _ := remainingRecoveryCodes(db, 1); n != recoveryCodeCount {
+		t.Errorf("重新生成后剩余恢复码数量错误: %d", n)
+	}
+}
+
+func TestTOTPDoRecoveryCode(t *testing.T) {
+	useTestThrottle(t)
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.LoginEvent{}, &models.RecoveryCode{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Name: "Alice", LDAPUserPass: models.LDAPUserPass{Account: "alice@example.com", TOTPSecret: "SECRET", IsTOTPSetup: true}}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	codes, err := generateRecoveryCodes(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ab := newTestActivity(t, db)
+	handler := totpDo(db, ab, http.NotFoundHandler())
+	submit := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", totpDoURL, strings.NewReader(url.Values{"otp": {code}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = r.WithContext(context.WithValue(r.Context(), login.UserKey, user))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := submit("aaaaa-bbbbb"); w.Header().Get("Location") != totpValidatePageURL || hasCookie(w, totpCookieName) {
+		t.Errorf("恢复码错误时应该回到验证页面，实际 %s", w.Header().Get("Location"))
+	}
+	if w := submit(codes[3]); w.Header().Get("Location") != "/" || !hasCookie(w, totpCookieName) {
+		t.Fatalf("使用恢复码后应该通过双因素认证，实际 %s", w.Header().Get("Location"))
+	}
+	if w := submit(codes[3]); hasCookie(w, totpCookieName) {
+		t.Error("已经使用的恢复码不能再次使用")
+	}
+
+	var events []models.LoginEvent
+	db.Order("id").Find(&events)
+	if len(events) != 3 || events[0].Reason != models.LoginReasonWrongRecoveryCode || !events[1].Success ||
+		events[1].Method != models.LoginMethodRecoveryCode || events[2].Success {
+		t.Errorf("登录事件记录错误: %+v", events)
+	}
+
+	var logs []activity.ActivityLog
+	db.Where("action = ?", recoveryCodeActionUse).Find(&logs)
+	if len(logs) != 1 || logs[0].ModelKeys != fmt.Sprint(user.ID) || logs[0].UserID != fmt.Sprint(user.ID) {
+		t.Errorf("使用恢复码应该记录在操作日志中: %+v", logs)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"math/rand"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -29,15 +30,17 @@ func init() {
 
 // RecurringJobManager 处理重复任务的管理器
 type RecurringJobManager struct {
-	taskManager  *TaskManager
-	pb           *presets.Builder
-	modelBuilder *presets.ModelBuilder
-	db           *gorm.DB
+	taskManager      *TaskManager
+	pb               *presets.Builder
+	modelBuilder     *presets.ModelBuilder
+	executionBuilder *presets.ModelBuilder
+	db               *gorm.DB
 }
 
 // NewRecurringJobManager 创建重复任务管理器
-func NewRecurringJobManager(db *gorm.DB, b *presets.Builder) *RecurringJobManager {
-	taskManager := NewTaskManager(db)
+// opts 会传递给 NewTaskManager，例如通过 WithArtifactStorage 配置执行产物存储
+func NewRecurringJobManager(db *gorm.DB, b *presets.Builder, opts ...Option) *RecurringJobManager {
+	taskManager := NewTaskManager(db, opts...)
 
 	// 创建模型并设置标签，确保URI名称正确
 	modelBuilder := b.Model(&models.RecurringJob{})
@@ -60,6 +63,9 @@ func NewRecurringJobManager(db *gorm.DB, b *presets.Builder) *RecurringJobManage
 	// 注册管理界面
 	manager.registerAdminUI()
 
+	// 注册批量操作
+	manager.registerBulkActions()
+
 	// 注册执行记录管理界面
 	manager.registerExecutionUI()
 
@@ -86,6 +92,15 @@ func (m *RecurringJobManager) Init(ab *activity.Builder) error {
 	return nil
 }
 
+// RegisterFunction 注册任务函数
+// 需要在 Init 之前调用，启动调度时才能找到已有任务使用的函数
+// 参数：
+// - name: 函数名称，创建任务时使用
+// - fn: 任务函数
+func (m *RecurringJobManager) RegisterFunction(name string, fn JobFunc) {
+	m.taskManager.RegisterFunction(name, fn)
+}
+
 // Start 启动管理器
 func (m *RecurringJobManager) Start() error {
 	return m.taskManager.Start()
@@ -166,6 +181,7 @@ func (m *RecurringJobManager) registerSampleFunctions() {
 func (m *RecurringJobManager) registerExecutionUI() {
 	// 创建执行记录模型构建器
 	executionBuilder := m.pb.Model(&models.RecurringJobExecution{})
+	m.executionBuilder = executionBuilder
 	executionBuilder.Label("RecurringJobExecution")
 	executionBuilder.MenuIcon("mdi-history")
 
@@ -214,6 +230,15 @@ func (m *RecurringJobManager) registerExecutionUI() {
 				},
 				SQLCondition: `success %s ?`,
 			},
+			{
+				Key:      "interrupted",
+				Label:    "是否中断",
+				ItemType: vx.ItemTypeSelect,
+				Options: []*vx.SelectItem{
+					{Text: "已中断", Value: "true"},
+				},
+				SQLCondition: `interrupted %s ?`,
+			},
 		}
 	})
 
@@ -241,6 +266,11 @@ func (m *RecurringJobManager) registerExecutionUI() {
 				ID:    "error",
 				Query: url.Values{"success": []string{"false"}},
 			},
+			{
+				Label: "中断记录",
+				ID:    "interrupted",
+				Query: url.Values{"interrupted": []string{"true"}},
+			},
 		}
 
 		return tabs
@@ -269,10 +299,14 @@ func (m *RecurringJobManager) registerExecutionUI() {
 			color string
 		)
 
-		if execution.Success {
+		switch {
+		case execution.Success:
 			text = "成功"
 			color = "success"
-		} else {
+		case execution.Interrupted:
+			text = "已中断"
+			color = "warning"
+		default:
 			text = "失败"
 			color = "error"
 		}
@@ -296,7 +330,53 @@ func (m *RecurringJobManager) registerExecutionUI() {
 	})
 
 	// 配置详情视图
-	executionBuilder.Detailing("RecurringJobID", "StartedAt", "FinishedAt", "Duration", "Success", "Error", "Output")
+	executionBuilder.Detailing("RecurringJobID", "StartedAt", "FinishedAt", "Duration", "Success", "Error", "Artifacts", "Output")
+
+	// 执行产物以下载链接的形式显示
+	executionBuilder.Detailing().Field("Artifacts").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		execution := obj.(*models.RecurringJobExecution)
+
+		var artifacts []models.RecurringJobArtifact
+		if err := m.taskManager.db.Where("recurring_job_execution_id = ?", execution.ID).Order("id").Find(&artifacts).Error; err != nil {
+			return h.Div(h.Text("获取执行产物失败: " + err.Error())).Class("red--text")
+		}
+		if len(artifacts) == 0 {
+			return h.Div(h.Text("无执行产物")).Class("grey--text")
+		}
+
+		items := []h.HTMLComponent{}
+		for _, artifact := range artifacts {
+			items = append(items, v.VListItem(
+				web.Slot(v.VIcon("mdi-file-download-outline")).Name("prepend"),
+				v.VListItemTitle(
+					h.A(h.Text(artifact.Name)).
+						Href(fmt.Sprintf("%s%d", ArtifactPathPrefix, artifact.ID)).
+						Attr("download", artifact.Name),
+				),
+				v.VListItemSubtitle(h.Text(fmt.Sprintf("%s · %s", artifact.ContentType, formatBytes(artifact.Size)))),
+			))
+		}
+
+		return v.VCard(
+			v.VCardTitle(h.Text("执行产物")).Class("subtitle-1 py-2"),
+			v.VDivider(),
+			v.VList(items...).Density(v.DensityCompact),
+		).Elevation(1).Class("mb-4")
+	})
+
+	// 删除执行记录时一并删除其执行产物
+	executionBuilder.Editing().WrapDeleteFunc(func(in presets.DeleteFunc) presets.DeleteFunc {
+		return func(obj interface{}, id string, ctx *web.EventContext) (err error) {
+			if err = in(obj, id, ctx); err != nil {
+				return
+			}
+			executionID, err := strconv.ParseUint(id, 10, 32)
+			if err != nil {
+				return fmt.Errorf("执行记录ID格式错误: %w", err)
+			}
+			return m.taskManager.DeleteExecutionArtifacts(uint(executionID))
+		}
+	})
 
 	// 格式化输出字段的显示
 	executionBuilder.Detailing().Field("Output").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
@@ -372,11 +452,50 @@ func (m *RecurringJobManager) registerExecutionUI() {
 // 注册管理界面
 func (m *RecurringJobManager) registerAdminUI() {
 	// 配置列表视图
-	m.modelBuilder.Listing("ID", "Name", "FunctionName", "CronExpression", "Runs", "Status", "LastRunAt", "NextRunAt", "ErrorCount", "Actions")
+	m.modelBuilder.Listing("ID", "Name", "Tags", "Owner", "FunctionName", "CronExpression", "Runs", "Status", "LastRunAt", "NextRunAt", "ErrorCount", "Actions")
 
-	// 添加状态过滤功能
+	// 添加状态、标签和负责人过滤功能
 	m.modelBuilder.Listing().FilterDataFunc(func(ctx *web.EventContext) vx.FilterData {
+		tagOptions := []*vx.SelectItem{}
+		for _, tag := range m.allTags() {
+			tagOptions = append(tagOptions, &vx.SelectItem{Text: tag, Value: tag})
+		}
+
+		ownerOptions := []*vx.SelectItem{}
+		for _, u := range m.allUsers() {
+			ownerOptions = append(ownerOptions, &vx.SelectItem{Text: u.Name, Value: fmt.Sprintf("%d", u.ID)})
+		}
+
+		roleOptions := []*vx.SelectItem{}
+		for _, role := range models.DefaultRoles {
+			roleOptions = append(roleOptions, &vx.SelectItem{Text: role, Value: role})
+		}
+
 		return []*vx.FilterItem{
+			{
+				Key:          "tag",
+				Label:        "标签",
+				ItemType:     vx.ItemTypeSelect,
+				Options:      tagOptions,
+				SQLCondition: `(',' || tags || ',') LIKE ?`,
+				WrapInput: func(val string) interface{} {
+					return "%," + val + ",%"
+				},
+			},
+			{
+				Key:          "owner_id",
+				Label:        "负责人",
+				ItemType:     vx.ItemTypeSelect,
+				Options:      ownerOptions,
+				SQLCondition: `owner_id %s ?`,
+			},
+			{
+				Key:          "owner_role",
+				Label:        "负责角色",
+				ItemType:     vx.ItemTypeSelect,
+				Options:      roleOptions,
+				SQLCondition: `owner_role %s ?`,
+			},
 			{
 				Key:      "status",
 				Label:    "状态",
@@ -395,7 +514,7 @@ func (m *RecurringJobManager) registerAdminUI() {
 	// 添加过滤标签页
 	m.modelBuilder.Listing().FilterTabsFunc(func(ctx *web.EventContext) []*presets.FilterTab {
 		// TODO: 将来重构国际化实现，目前存在import循环依赖问题
-		return []*presets.FilterTab{
+		tabs := []*presets.FilterTab{
 			{
 				Label: "活跃任务",
 				ID:    "active",
@@ -422,10 +541,159 @@ func (m *RecurringJobManager) registerAdminUI() {
 				Query: url.Values{"status": []string{"error"}},
 			},
 		}
+
+		// 当前用户负责的任务
+		if u := currentUser(ctx.R); u != nil {
+			tabs = append(tabs, &presets.FilterTab{
+				Label: "我的任务",
+				ID:    "mine",
+				Query: url.Values{"owner_id": []string{fmt.Sprintf("%d", u.ID)}},
+			})
+		}
+
+		return tabs
 	})
 
 	// 配置编辑视图
-	m.modelBuilder.Editing("Name", "FunctionName", "CronExpression", "Times", "Args")
+	m.modelBuilder.Editing("Name", "FunctionName", "CronExpression", "Times", "Args", "Tags", "OwnerID", "OwnerRole", "NotifyOn", "NotifyEmails")
+
+	// 标签以逗号分隔的形式编辑
+	m.modelBuilder.Editing().Field("Tags").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		job, ok := obj.(*models.RecurringJob)
+		if !ok {
+			return nil
+		}
+
+		return v.VTextField().
+			Label("标签").
+			Hint("多个标签用逗号分隔，例如: 财务,报表").
+			Attr(web.VField("Tags", strings.Join(job.TagList(), ", "))...)
+	}).SetterFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) (err error) {
+		job := obj.(*models.RecurringJob)
+		job.SetTags(strings.Split(ctx.R.FormValue("Tags"), ","))
+		return nil
+	})
+
+	// 负责人从用户列表中选择
+	m.modelBuilder.Editing().Field("OwnerID").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		job, ok := obj.(*models.RecurringJob)
+		if !ok {
+			return nil
+		}
+
+		options := []v.DefaultOptionItem{}
+		for _, u := range m.allUsers() {
+			options = append(options, v.DefaultOptionItem{Text: u.Name, Value: fmt.Sprintf("%d", u.ID)})
+		}
+
+		value := ""
+		if job.OwnerID != nil {
+			value = fmt.Sprintf("%d", *job.OwnerID)
+		} else if job.ID == 0 {
+			// 新建任务默认由当前用户负责
+			if u := currentUser(ctx.R); u != nil {
+				value = fmt.Sprintf("%d", u.ID)
+			}
+		}
+
+		return v.VSelect().
+			Label("负责人").
+			Items(options).
+			ItemTitle("text").
+			ItemValue("value").
+			Clearable(true).
+			Attr(web.VField("OwnerID", value)...)
+	}).SetterFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) (err error) {
+		job := obj.(*models.RecurringJob)
+		job.OwnerID = nil
+		if value := ctx.R.FormValue("OwnerID"); value != "" {
+			ownerID, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("负责人ID格式错误: %w", err)
+			}
+			id := uint(ownerID)
+			job.OwnerID = &id
+		}
+		return nil
+	})
+
+	// 负责角色从系统角色中选择
+	m.modelBuilder.Editing().Field("OwnerRole").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		job, ok := obj.(*models.RecurringJob)
+		if !ok {
+			return nil
+		}
+
+		return v.VSelect().
+			Label("负责角色").
+			Hint("拥有该角色的用户都可以管理此任务").
+			Items(models.DefaultRoles).
+			Clearable(true).
+			Attr(web.VField("OwnerRole", job.OwnerRole)...)
+	})
+
+	// 通知时机从固定选项中选择
+	m.modelBuilder.Editing().Field("NotifyOn").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		job, ok := obj.(*models.RecurringJob)
+		if !ok {
+			return nil
+		}
+
+		return notifyOnSelect("NotifyOn", job.NotifyOn)
+	})
+
+	// 通知收件人以逗号分隔的形式编辑
+	m.modelBuilder.Editing().Field("NotifyEmails").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		job, ok := obj.(*models.RecurringJob)
+		if !ok {
+			return nil
+		}
+
+		return notifyEmailsField("NotifyEmails", strings.Join(job.NotifyEmailList(), ", "))
+	}).SetterFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) (err error) {
+		job := obj.(*models.RecurringJob)
+		job.SetNotifyEmails(strings.Split(ctx.R.FormValue("NotifyEmails"), ","))
+		return nil
+	})
+
+	// 标签以标签组的形式显示
+	m.modelBuilder.Listing().Field("Tags").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		job, ok := obj.(*models.RecurringJob)
+		if !ok {
+			return nil
+		}
+
+		chips := []h.HTMLComponent{}
+		for _, tag := range job.TagList() {
+			chips = append(chips, v.VChip(h.Text(tag)).Size("small").Class("mr-1"))
+		}
+		return h.Td(chips...)
+	})
+
+	// 负责人显示用户名和负责角色
+	m.modelBuilder.Listing().Field("Owner").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		job, ok := obj.(*models.RecurringJob)
+		if !ok {
+			return nil
+		}
+
+		var parts []string
+		if job.OwnerID != nil {
+			var owner models.User
+			if err := m.db.Select("id", "name").First(&owner, *job.OwnerID).Error; err == nil {
+				parts = append(parts, owner.Name)
+			} else {
+				parts = append(parts, fmt.Sprintf("#%d", *job.OwnerID))
+			}
+		}
+		if job.OwnerRole != "" {
+			parts = append(parts, job.OwnerRole)
+		}
+		if len(parts) == 0 {
+			return h.Td(h.Text("--"))
+		}
+		return h.Td(h.Text(strings.Join(parts, " / ")))
+	})
 
 	// 为CronExpression添加组件
 	m.modelBuilder.Editing().Field("CronExpression").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
@@ -602,7 +870,7 @@ func (m *RecurringJobManager) registerAdminUI() {
 		var buttons []h.HTMLComponent
 
 		// 立即执行按钮
-		if job.Status != "completed" {
+		if job.Status != "completed" && m.verifyJob(PermRunJob, job, ctx.R) == nil {
 			buttons = append(buttons, v.VBtn("").
 				Icon(true).
 				Color("primary").
@@ -619,6 +887,9 @@ func (m *RecurringJobManager) registerAdminUI() {
 		}
 
 		// 暂停/恢复按钮
+		if m.verifyJob(PermPauseJob, job, ctx.R) != nil {
+			return h.Td(h.Div(buttons...).Class("d-flex justify-center"))
+		}
 		switch job.Status {
 		case "active":
 			buttons = append(buttons, v.VBtn("").
@@ -666,7 +937,9 @@ func (m *RecurringJobManager) registerAdminUI() {
 
 		var job models.RecurringJob
 		if err := m.taskManager.db.First(&job, id).Error; err == nil {
-			if err := m.taskManager.RunJobNow(job.Name, ctx); err != nil {
+			if err := m.verifyJob(PermRunJob, &job, ctx.R); err != nil {
+				ctx.Flash = "没有权限操作此任务"
+			} else if err := m.taskManager.RunJobNow(job.Name, ctx); err != nil {
 				ctx.Flash = err.Error()
 			} else {
 				ctx.Flash = fmt.Sprintf("任务 %s 已加入执行队列", job.Name)
@@ -689,7 +962,9 @@ func (m *RecurringJobManager) registerAdminUI() {
 
 		var job models.RecurringJob
 		if err := m.taskManager.db.First(&job, id).Error; err == nil {
-			if err := m.taskManager.PauseJob(job.Name, ctx); err != nil {
+			if err := m.verifyJob(PermPauseJob, &job, ctx.R); err != nil {
+				ctx.Flash = "没有权限操作此任务"
+			} else if err := m.taskManager.PauseJob(job.Name, ctx); err != nil {
 				ctx.Flash = err.Error()
 			} else {
 				ctx.Flash = fmt.Sprintf("任务 %s 已暂停", job.Name)
@@ -712,7 +987,9 @@ func (m *RecurringJobManager) registerAdminUI() {
 
 		var job models.RecurringJob
 		if err := m.taskManager.db.First(&job, id).Error; err == nil {
-			if err := m.taskManager.ResumeJob(job.Name, ctx); err != nil {
+			if err := m.verifyJob(PermPauseJob, &job, ctx.R); err != nil {
+				ctx.Flash = "没有权限操作此任务"
+			} else if err := m.taskManager.ResumeJob(job.Name, ctx); err != nil {
 				ctx.Flash = err.Error()
 			} else {
 				ctx.Flash = fmt.Sprintf("任务 %s 已恢复", job.Name)
@@ -746,6 +1023,12 @@ func (m *RecurringJobManager) registerAdminUI() {
 			return
 		}
 
+		if err = m.verifyJob(presets.PermDelete, &fullJob, ctx.R); err != nil {
+			ctx.Flash = "没有权限删除此任务"
+			r.Reload = true
+			return r, nil
+		}
+
 		if err = m.taskManager.RemoveJob(fullJob.Name, ctx); err != nil {
 			ctx.Flash = "删除任务失败：" + err.Error()
 		} else {
@@ -818,6 +1101,20 @@ func (m *RecurringJobManager) registerAdminUI() {
 				return err
 			}
 
+			// 设置标签和负责人，未指定负责人时由当前用户负责
+			ownerID := job.OwnerID
+			if ownerID == nil && job.OwnerRole == "" {
+				if u := currentUser(ctx.R); u != nil {
+					ownerID = &u.ID
+				}
+			}
+			if jobObj, err = m.taskManager.SetJobOwnership(jobObj.ID, job.TagList(), ownerID, job.OwnerRole); err != nil {
+				return err
+			}
+			if jobObj, err = m.taskManager.SetJobNotification(jobObj.ID, job.NotifyOn, job.NotifyEmailList()); err != nil {
+				return err
+			}
+
 			// 在任务成功创建后记录操作日志
 			if m.taskManager.activitySupport != nil {
 				m.taskManager.activitySupport.OnCreate(ctx.R.Context(), jobObj)
@@ -869,6 +1166,14 @@ func (m *RecurringJobManager) registerAdminUI() {
 				return fmt.Errorf("更新任务失败: %w", err)
 			}
 
+			// 更新标签和负责人
+			if updatedJob, err = m.taskManager.SetJobOwnership(updatedJob.ID, job.TagList(), job.OwnerID, job.OwnerRole); err != nil {
+				return fmt.Errorf("更新任务负责人失败: %w", err)
+			}
+			if updatedJob, err = m.taskManager.SetJobNotification(updatedJob.ID, job.NotifyOn, job.NotifyEmailList()); err != nil {
+				return fmt.Errorf("更新任务通知设置失败: %w", err)
+			}
+
 			// 在任务成功更新后记录操作日志，传入原任务作为old参数，记录变更差异
 			if m.taskManager.activitySupport != nil {
 				m.taskManager.activitySupport.OnEdit(ctx.R.Context(), &originalJob, updatedJob)
@@ -900,6 +1205,203 @@ func (m *RecurringJobManager) registerAdminUI() {
 	})
 }
 
+// registerBulkActions 注册列表页的批量操作
+// 每个任务单独校验权限并通过 TaskManager 处理和记录操作日志，部分失败时在对话框中列出失败的任务
+func (m *RecurringJobManager) registerBulkActions() {
+	actions := []struct {
+		name  string
+		label string
+		verb  string
+		run   func(jobIDs []uint, ctx ...*web.EventContext) BulkResults
+	}{
+		{"BulkRunNow", "立即执行", PermRunJob, m.taskManager.BulkRunJobsNow},
+		{"BulkPause", "暂停", PermPauseJob, m.taskManager.BulkPauseJobs},
+		{"BulkResume", "恢复", PermPauseJob, m.taskManager.BulkResumeJobs},
+		{"BulkDelete", "删除", presets.PermDelete, m.taskManager.BulkRemoveJobs},
+	}
+
+	for _, action := range actions {
+		m.modelBuilder.Listing().BulkAction(action.name).
+			Label(action.label).
+			ComponentFunc(func(selectedIds []string, ctx *web.EventContext) h.HTMLComponent {
+				return h.Div(h.Text(fmt.Sprintf("确定要%s选中的 %d 个任务吗？", action.label, len(selectedIds))))
+			}).
+			UpdateFunc(func(selectedIds []string, ctx *web.EventContext, r *web.EventResponse) (err error) {
+				jobIDs, err := parseJobIDs(selectedIds)
+				if err != nil {
+					return err
+				}
+
+				jobIDs, denied := m.filterPermittedJobs(action.verb, jobIDs, ctx.R)
+				results := append(action.run(jobIDs, ctx), denied...)
+				m.reportBulkResults(ctx, r, action.label, action.name == "BulkDelete", results)
+				return nil
+			})
+	}
+
+	// 批量修改标签
+	m.modelBuilder.Listing().BulkAction("BulkTags").
+		Label("修改标签").
+		ComponentFunc(func(selectedIds []string, ctx *web.EventContext) h.HTMLComponent {
+			return h.Div(
+				h.Div(h.Text(fmt.Sprintf("修改选中的 %d 个任务的标签，多个标签用逗号分隔", len(selectedIds)))).Class("mb-4"),
+				v.VTextField().
+					Label("添加标签").
+					Attr(web.VField("AddTags", "")...),
+				v.VTextField().
+					Label("移除标签").
+					Attr(web.VField("RemoveTags", "")...),
+			)
+		}).
+		UpdateFunc(func(selectedIds []string, ctx *web.EventContext, r *web.EventResponse) (err error) {
+			jobIDs, err := parseJobIDs(selectedIds)
+			if err != nil {
+				return err
+			}
+
+			add := splitTags(ctx.R.FormValue("AddTags"))
+			remove := splitTags(ctx.R.FormValue("RemoveTags"))
+			if len(add) == 0 && len(remove) == 0 {
+				return fmt.Errorf("请输入要添加或移除的标签")
+			}
+
+			jobIDs, denied := m.filterPermittedJobs(presets.PermUpdate, jobIDs, ctx.R)
+			results := append(m.taskManager.BulkUpdateTags(jobIDs, add, remove, ctx), denied...)
+			m.reportBulkResults(ctx, r, "修改标签", false, results)
+			return nil
+		})
+
+	// 批量修改通知设置
+	m.modelBuilder.Listing().BulkAction("BulkNotify").
+		Label("修改通知").
+		ComponentFunc(func(selectedIds []string, ctx *web.EventContext) h.HTMLComponent {
+			return h.Div(
+				h.Div(h.Text(fmt.Sprintf("修改选中的 %d 个任务的通知设置，原有的通知时机和收件人将被替换", len(selectedIds)))).Class("mb-4"),
+				notifyOnSelect("BulkNotifyOn", models.NotifyOnFailure),
+				notifyEmailsField("BulkNotifyEmails", ""),
+			)
+		}).
+		UpdateFunc(func(selectedIds []string, ctx *web.EventContext, r *web.EventResponse) (err error) {
+			jobIDs, err := parseJobIDs(selectedIds)
+			if err != nil {
+				return err
+			}
+
+			notifyOn := ctx.R.FormValue("BulkNotifyOn")
+			emails := strings.Split(ctx.R.FormValue("BulkNotifyEmails"), ",")
+			if notifyOn != models.NotifyNever && strings.TrimSpace(ctx.R.FormValue("BulkNotifyEmails")) == "" {
+				return fmt.Errorf("请输入通知收件人")
+			}
+
+			jobIDs, denied := m.filterPermittedJobs(presets.PermUpdate, jobIDs, ctx.R)
+			results := append(m.taskManager.BulkUpdateNotifications(jobIDs, notifyOn, emails, ctx), denied...)
+			m.reportBulkResults(ctx, r, "修改通知", false, results)
+			return nil
+		})
+}
+
+// notifyOnSelect 通知时机的选择框
+func notifyOnSelect(name, value string) h.HTMLComponent {
+	return v.VSelect().
+		Label("通知时机").
+		Items([]v.DefaultOptionItem{
+			{Text: "不通知", Value: models.NotifyNever},
+			{Text: "执行失败时通知", Value: models.NotifyOnFailure},
+			{Text: "每次执行后通知", Value: models.NotifyAlways},
+		}).
+		ItemTitle("text").
+		ItemValue("value").
+		Attr(web.VField(name, value)...)
+}
+
+// notifyEmailsField 通知收件人的输入框
+func notifyEmailsField(name, value string) h.HTMLComponent {
+	return v.VTextField().
+		Label("通知收件人").
+		Hint("多个邮箱用逗号分隔，例如: ops@example.com,dev@example.com").
+		Attr(web.VField(name, value)...)
+}
+
+// reportBulkResults 通知列表页刷新，并显示批量操作的处理结果
+func (m *RecurringJobManager) reportBulkResults(ctx *web.EventContext, r *web.EventResponse, label string, deleted bool, results BulkResults) {
+	m.emitBulkResults(r, deleted, results)
+
+	if failed := results.Failed(); len(failed) > 0 {
+		ve := &web.ValidationErrors{}
+		ve.GlobalError(fmt.Sprintf("%d 个任务%s成功，%d 个任务失败：%s",
+			results.Succeeded(), label, len(failed), results.Error()))
+		ctx.Flash = ve
+		return
+	}
+
+	presets.ShowMessage(r, fmt.Sprintf("已%s %d 个任务", label, results.Succeeded()), "success")
+}
+
+// splitTags 将逗号分隔的标签拆分为列表
+func splitTags(s string) []string {
+	var job models.RecurringJob
+	job.SetTags(strings.Split(s, ","))
+	return job.TagList()
+}
+
+// allTags 返回所有任务使用过的标签，按名称排序
+func (m *RecurringJobManager) allTags() []string {
+	var values []string
+	if err := m.db.Model(&models.RecurringJob{}).Where("tags <> ''").Pluck("tags", &values).Error; err != nil {
+		log.Printf("获取任务标签失败: %v", err)
+		return nil
+	}
+
+	var tags []string
+	for _, value := range values {
+		tags = append(tags, splitTags(value)...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// allUsers 返回可选的负责人列表
+func (m *RecurringJobManager) allUsers() []models.User {
+	var users []models.User
+	if err := m.db.Select("id", "name").Order("name").Find(&users).Error; err != nil {
+		log.Printf("获取用户列表失败: %v", err)
+	}
+	return users
+}
+
+// emitBulkResults 通知列表页刷新处理成功的任务
+func (m *RecurringJobManager) emitBulkResults(r *web.EventResponse, deleted bool, results BulkResults) {
+	var ids []string
+	for _, result := range results {
+		if result.Err == nil {
+			ids = append(ids, fmt.Sprintf("%d", result.JobID))
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	if deleted {
+		r.Emit(m.modelBuilder.NotifModelsDeleted(), presets.PayloadModelsDeleted{Ids: ids})
+		return
+	}
+	r.Emit(m.modelBuilder.NotifModelsUpdated(), presets.PayloadModelsUpdated{Ids: ids})
+}
+
+// formatBytes 将字节数格式化为易读的文件大小
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
 // 在RecurringJob详情页添加最近执行记录
 func (m *RecurringJobManager) registerExtraUI() {
 	// TODO: 在未来版本实现
@@ -0,0 +1,297 @@
+package recurring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qor5/admin/v3/presets"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// ArtifactPathPrefix 执行产物下载地址的路径前缀
+const ArtifactPathPrefix = "/recurring-job-artifacts/"
+
+// ErrArtifactNotFound 表示找不到指定的执行产物
+var ErrArtifactNotFound = errors.New("找不到指定的执行产物")
+
+// ArtifactStorage 执行产物的存储后端
+// 键由任务管理器生成，存储后端只负责按键读写内容
+type ArtifactStorage interface {
+	// Save 保存内容，返回写入的字节数
+	Save(key string, content io.Reader) (int64, error)
+	// Open 打开已保存的内容
+	Open(key string) (io.ReadCloser, error)
+	// Delete 删除已保存的内容，内容不存在时不返回错误
+	Delete(key string) error
+}
+
+// LocalArtifactStorage 将执行产物保存在本地文件系统中
+type LocalArtifactStorage struct {
+	root string
+}
+
+// NewLocalArtifactStorage 创建本地文件系统存储
+// 参数：
+// - root: 存放执行产物的根目录，不存在时自动创建
+func NewLocalArtifactStorage(root string) *LocalArtifactStorage {
+	return &LocalArtifactStorage{root: root}
+}
+
+// path 将键转换为根目录下的文件路径，拒绝跳出根目录的键
+func (s *LocalArtifactStorage) path(key string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(key))
+	if clean == string(filepath.Separator) {
+		return "", fmt.Errorf("无效的执行产物键: %s", key)
+	}
+	return filepath.Join(s.root, clean), nil
+}
+
+// Save 将内容写入文件
+func (s *LocalArtifactStorage) Save(key string, content io.Reader) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(p)
+		return 0, err
+	}
+	return n, nil
+}
+
+// Open 打开文件
+func (s *LocalArtifactStorage) Open(key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrArtifactNotFound
+	}
+	return f, err
+}
+
+// Delete 删除文件
+func (s *LocalArtifactStorage) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MemoryArtifactStorage 将执行产物保存在内存中，主要用于测试
+type MemoryArtifactStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemoryArtifactStorage 创建内存存储
+func NewMemoryArtifactStorage() *MemoryArtifactStorage {
+	return &MemoryArtifactStorage{files: make(map[string][]byte)}
+}
+
+// Save 将内容保存到内存
+func (s *MemoryArtifactStorage) Save(key string, content io.Reader) (int64, error) {
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = b
+	return int64(len(b)), nil
+}
+
+// Open 读取内存中的内容
+func (s *MemoryArtifactStorage) Open(key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[key]
+	if !ok {
+		return nil, ErrArtifactNotFound
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Delete 删除内存中的内容
+func (s *MemoryArtifactStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, key)
+	return nil
+}
+
+// WithArtifactStorage 设置执行产物的存储后端，未设置时任务函数无法附加执行产物
+func WithArtifactStorage(storage ArtifactStorage) Option {
+	return func(m *TaskManager) {
+		m.artifactStorage = storage
+	}
+}
+
+// artifactKey 生成执行产物在存储后端中的键
+func artifactKey(executionID uint, seq int64, name string) string {
+	base := filepath.Base(filepath.Clean("/" + name))
+	if base == "/" || base == "." {
+		base = "artifact"
+	}
+	return fmt.Sprintf("%d/%d_%s", executionID, seq, base)
+}
+
+// newArtifactSaver 创建将执行产物写入存储后端的保存函数
+// db为nil时只保存内容，不写入执行产物记录（用于内存执行模式）
+func newArtifactSaver(db *gorm.DB, storage ArtifactStorage, seq func() int64) models.ArtifactSaver {
+	return func(execution *models.RecurringJobExecution, name, contentType string, content io.Reader) (*models.RecurringJobArtifact, error) {
+		if name == "" {
+			return nil, errors.New("执行产物名称不能为空")
+		}
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(name))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		key := artifactKey(execution.ID, seq(), name)
+		size, err := storage.Save(key, content)
+		if err != nil {
+			return nil, fmt.Errorf("保存执行产物失败: %w", err)
+		}
+
+		artifact := &models.RecurringJobArtifact{
+			RecurringJobExecutionID: execution.ID,
+			Name:                    name,
+			ContentType:             contentType,
+			Size:                    size,
+			StorageKey:              key,
+		}
+		if db != nil {
+			if err := db.Create(artifact).Error; err != nil {
+				storage.Delete(key)
+				return nil, err
+			}
+		}
+		return artifact, nil
+	}
+}
+
+// OpenArtifact 打开指定的执行产物
+// 参数：
+// - id: 执行产物ID
+// 返回：
+// - *models.RecurringJobArtifact: 执行产物记录
+// - io.ReadCloser: 执行产物内容，调用方负责关闭
+// - error: 打开过程中的错误信息
+func (m *TaskManager) OpenArtifact(id uint) (*models.RecurringJobArtifact, io.ReadCloser, error) {
+	if m.artifactStorage == nil {
+		return nil, nil, models.ErrArtifactStorageNotConfigured
+	}
+
+	var artifact models.RecurringJobArtifact
+	if err := m.db.First(&artifact, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrArtifactNotFound
+		}
+		return nil, nil, err
+	}
+
+	rc, err := m.artifactStorage.Open(artifact.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &artifact, rc, nil
+}
+
+// DeleteExecutionArtifacts 删除指定执行记录的所有执行产物，包括存储后端中的内容
+// 执行产物的保留期限与执行记录一致，执行记录被删除时调用
+// 参数：
+// - executionIDs: 执行记录ID
+// 返回：
+// - error: 删除过程中的错误信息
+func (m *TaskManager) DeleteExecutionArtifacts(executionIDs ...uint) error {
+	if len(executionIDs) == 0 {
+		return nil
+	}
+
+	var artifacts []models.RecurringJobArtifact
+	if err := m.db.Where("recurring_job_execution_id IN ?", executionIDs).Find(&artifacts).Error; err != nil {
+		return err
+	}
+
+	for _, artifact := range artifacts {
+		if m.artifactStorage != nil {
+			if err := m.artifactStorage.Delete(artifact.StorageKey); err != nil {
+				return fmt.Errorf("删除执行产物 %s 失败: %w", artifact.Name, err)
+			}
+		}
+		if err := m.db.Unscoped().Delete(&artifact).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// artifactHandler 提供执行产物下载，地址格式为 ArtifactPathPrefix + 执行产物ID
+// 下载前按执行记录的查看权限进行校验
+func (m *RecurringJobManager) artifactHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, ArtifactPathPrefix), 10, 32)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	artifact, rc, err := m.taskManager.OpenArtifact(uint(id))
+	if err != nil {
+		if errors.Is(err, ErrArtifactNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	execution := &models.RecurringJobExecution{}
+	execution.ID = artifact.RecurringJobExecutionID
+	if err := m.executionBuilder.Info().Verifier().Do(presets.PermGet).ObjectOn(execution).WithReq(r).IsAllowed(); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": artifact.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	io.Copy(w, rc)
+}
+
+// ArtifactHandler 返回执行产物下载处理器，应挂载在 ArtifactPathPrefix 下并置于登录中间件之后
+func (m *RecurringJobManager) ArtifactHandler() http.Handler {
+	return http.HandlerFunc(m.artifactHandler)
+}
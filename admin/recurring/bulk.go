@@ -0,0 +1,185 @@
+package recurring
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/qor5/web/v3"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// BulkResult 批量操作中单个任务的处理结果
+type BulkResult struct {
+	JobID uint   // 任务ID
+	Name  string // 任务名称，找不到任务时为空
+	Err   error  // 处理失败时的错误信息
+}
+
+// BulkResults 批量操作的处理结果列表
+type BulkResults []BulkResult
+
+// Succeeded 返回处理成功的任务数量
+func (rs BulkResults) Succeeded() int {
+	n := 0
+	for _, r := range rs {
+		if r.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed 返回处理失败的结果
+func (rs BulkResults) Failed() BulkResults {
+	var failed BulkResults
+	for _, r := range rs {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// Error 将失败的结果合并为一条错误信息，全部成功时返回空字符串
+func (rs BulkResults) Error() string {
+	var lines []string
+	for _, r := range rs.Failed() {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", r.JobID)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %v", name, r.Err))
+	}
+	return strings.Join(lines, "；")
+}
+
+// bulk 对每个任务依次执行操作，单个任务失败不会中断其余任务
+func (m *TaskManager) bulk(jobIDs []uint, fn func(job *models.RecurringJob) error) BulkResults {
+	results := make(BulkResults, 0, len(jobIDs))
+	for _, id := range jobIDs {
+		result := BulkResult{JobID: id}
+
+		var job models.RecurringJob
+		if err := m.db.First(&job, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = ErrJobNotFound
+			}
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+
+		result.Name = job.Name
+		result.Err = fn(&job)
+		results = append(results, result)
+	}
+	return results
+}
+
+// BulkPauseJobs 批量暂停任务，只处理处于活跃状态的任务
+// 参数：
+// - jobIDs: 要暂停的任务ID
+// - ctx: 操作上下文，用于为每个任务记录操作日志
+// 返回：
+// - BulkResults: 每个任务的处理结果
+func (m *TaskManager) BulkPauseJobs(jobIDs []uint, ctx ...*web.EventContext) BulkResults {
+	return m.bulk(jobIDs, func(job *models.RecurringJob) error {
+		if job.Status != "active" {
+			return errors.New("只能暂停处于活跃状态的任务")
+		}
+		return m.PauseJob(job.Name, ctx...)
+	})
+}
+
+// BulkResumeJobs 批量恢复已暂停的任务
+// 参数：
+// - jobIDs: 要恢复的任务ID
+// - ctx: 操作上下文，用于为每个任务记录操作日志
+// 返回：
+// - BulkResults: 每个任务的处理结果
+func (m *TaskManager) BulkResumeJobs(jobIDs []uint, ctx ...*web.EventContext) BulkResults {
+	return m.bulk(jobIDs, func(job *models.RecurringJob) error {
+		return m.ResumeJob(job.Name, ctx...)
+	})
+}
+
+// BulkRunJobsNow 批量立即执行任务，已完成的任务不会被执行
+// 参数：
+// - jobIDs: 要执行的任务ID
+// - ctx: 操作上下文，用于为每个任务记录操作日志
+// 返回：
+// - BulkResults: 每个任务的处理结果
+func (m *TaskManager) BulkRunJobsNow(jobIDs []uint, ctx ...*web.EventContext) BulkResults {
+	return m.bulk(jobIDs, func(job *models.RecurringJob) error {
+		if job.Status == "completed" {
+			return errors.New("已完成的任务不能立即执行")
+		}
+		return m.RunJobNow(job.Name, ctx...)
+	})
+}
+
+// BulkRemoveJobs 批量删除任务
+// 参数：
+// - jobIDs: 要删除的任务ID
+// - ctx: 操作上下文，用于为每个任务记录操作日志
+// 返回：
+// - BulkResults: 每个任务的处理结果
+func (m *TaskManager) BulkRemoveJobs(jobIDs []uint, ctx ...*web.EventContext) BulkResults {
+	return m.bulk(jobIDs, func(job *models.RecurringJob) error {
+		return m.RemoveJob(job.Name, ctx...)
+	})
+}
+
+// BulkUpdateTags 批量修改任务标签，负责人保持不变
+// 参数：
+// - jobIDs: 要修改的任务ID
+// - add: 要添加的标签
+// - remove: 要移除的标签
+// - ctx: 操作上下文，用于为每个任务记录操作日志
+// 返回：
+// - BulkResults: 每个任务的处理结果
+func (m *TaskManager) BulkUpdateTags(jobIDs []uint, add, remove []string, ctx ...*web.EventContext) BulkResults {
+	return m.bulk(jobIDs, func(job *models.RecurringJob) error {
+		var tags []string
+		for _, tag := range append(job.TagList(), add...) {
+			if !slices.Contains(remove, strings.TrimSpace(tag)) {
+				tags = append(tags, tag)
+			}
+		}
+		_, err := m.SetJobOwnership(job.ID, tags, job.OwnerID, job.OwnerRole, ctx...)
+		return err
+	})
+}
+
+// BulkUpdateNotifications 批量修改任务执行结果的通知设置，替换原有的通知时机和收件人
+// 参数：
+// - jobIDs: 要修改的任务ID
+// - notifyOn: 通知时机，models.NotifyNever、models.NotifyOnFailure 或 models.NotifyAlways
+// - emails: 通知收件人的邮箱
+// - ctx: 操作上下文，用于为每个任务记录操作日志
+// 返回：
+// - BulkResults: 每个任务的处理结果
+func (m *TaskManager) BulkUpdateNotifications(jobIDs []uint, notifyOn string, emails []string, ctx ...*web.EventContext) BulkResults {
+	return m.bulk(jobIDs, func(job *models.RecurringJob) error {
+		_, err := m.SetJobNotification(job.ID, notifyOn, emails, ctx...)
+		return err
+	})
+}
+
+// parseJobIDs 将列表页选中的ID转换为任务ID
+func parseJobIDs(selectedIds []string) ([]uint, error) {
+	ids := make([]uint, 0, len(selectedIds))
+	for _, s := range selectedIds {
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("任务ID格式错误: %s", s)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
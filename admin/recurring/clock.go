@@ -0,0 +1,18 @@
+package recurring
+
+import "time"
+
+// Clock 抽象了任务管理器使用的时间来源
+// 生产环境使用系统时间，测试中可以替换为可控的时钟，使执行记录的时间可预测
+type Clock interface {
+	// Now 返回当前时间
+	Now() time.Time
+}
+
+// systemClock 使用系统时间的默认时钟实现
+type systemClock struct{}
+
+// Now 返回系统当前时间
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
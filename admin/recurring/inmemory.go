@@ -0,0 +1,61 @@
+package recurring
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// RunInMemory 在内存中执行一次任务函数，不依赖数据库和调度器
+// 执行记录的字段与 TaskManager 调度执行时保持一致，便于在单元测试中验证自定义的 JobFunc
+// 参数：
+// - ctx: 上下文，传递给任务函数
+// - fn: 要执行的任务函数
+// - args: 任务参数，按照 RecurringJob.SetArgs 的规则序列化为JSON
+// - opts: 任务管理器的可选配置，只使用其中的时钟，如 WithClock
+// 返回：
+// - *models.RecurringJobExecution: 执行记录，包含输出、耗时、错误信息和执行产物
+// - error: 任务函数返回的错误或参数序列化错误
+func RunInMemory(ctx context.Context, fn JobFunc, args interface{}, opts ...Option) (*models.RecurringJobExecution, error) {
+	return RunInMemoryWithStorage(ctx, fn, args, NewMemoryArtifactStorage(), opts...)
+}
+
+// RunInMemoryWithStorage 与 RunInMemory 相同，但将任务函数附加的执行产物保存到指定的存储后端
+// 测试可以通过执行产物的 StorageKey 从存储后端读取内容
+func RunInMemoryWithStorage(ctx context.Context, fn JobFunc, args interface{}, storage ArtifactStorage, opts ...Option) (*models.RecurringJobExecution, error) {
+	m := &TaskManager{clock: systemClock{}}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	var rawArgs []byte
+	if args != nil {
+		b, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		rawArgs = b
+	}
+
+	execution := &models.RecurringJobExecution{
+		StartedAt: m.clock.Now(),
+	}
+	var seq int64
+	execution.SetArtifactSaver(newArtifactSaver(nil, storage, func() int64 {
+		seq++
+		return seq
+	}))
+
+	err := fn(ctx, rawArgs, execution)
+
+	finishTime := m.clock.Now()
+	execution.FinishedAt = &finishTime
+	execution.Duration = finishTime.Sub(execution.StartedAt).Milliseconds()
+	execution.Success = err == nil
+	if err != nil {
+		execution.Error = err.Error()
+	}
+
+	return execution, err
+}
@@ -15,7 +15,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/go-co-op/gocron"
 	"github.com/qor5/admin/v3/activity"
 	"github.com/qor5/web/v3"
 	"gorm.io/gorm"
@@ -47,37 +46,91 @@ type JobFunc func(ctx context.Context, args []byte, execution *models.RecurringJ
 // TaskManager 管理定时任务的调度和执行
 type TaskManager struct {
 	db              *gorm.DB
-	scheduler       *gocron.Scheduler
-	jobs            map[string]*gocron.Job
+	scheduler       Scheduler
+	clock           Clock
+	jobs            map[string]ScheduledJob
 	jobModels       map[string]*models.RecurringJob
 	functions       map[string]JobFunc
 	mu              sync.Mutex
 	isRunning       bool
 	defaultLogger   *log.Logger
 	activitySupport *activity.Builder // 用于记录操作日志
+	artifactStorage ArtifactStorage   // 执行产物存储后端
+	notifier        Notifier          // 执行结束后的通知方式
+
+	// 优雅关闭相关
+	baseCtx             context.Context                        // 所有任务执行上下文的父上下文，关闭时取消
+	cancel              context.CancelFunc                     // 取消 baseCtx
+	draining            bool                                   // 是否正在关闭，关闭后不再开始新的执行
+	running             sync.WaitGroup                         // 正在执行的任务
+	executionsMu        sync.Mutex                             // 保护 executions
+	executions          map[uint]*models.RecurringJobExecution // 正在执行的执行记录
+	shutdownGracePeriod time.Duration                          // 关闭时等待任务结束的宽限期
+}
+
+// DefaultShutdownGracePeriod 关闭时等待正在执行的任务结束的默认宽限期
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// InterruptedError 被中断的执行记录的错误信息
+const InterruptedError = "任务因系统关闭而中断"
+
+// Option 定义任务管理器的可选配置
+type Option func(m *TaskManager)
+
+// WithClock 设置任务管理器使用的时钟，默认使用系统时间
+func WithClock(clock Clock) Option {
+	return func(m *TaskManager) {
+		m.clock = clock
+	}
+}
+
+// WithScheduler 设置任务管理器使用的调度器，默认使用UTC时区的 gocron 调度器
+func WithScheduler(scheduler Scheduler) Option {
+	return func(m *TaskManager) {
+		m.scheduler = scheduler
+	}
+}
+
+// WithShutdownGracePeriod 设置关闭时等待正在执行的任务结束的宽限期，默认为 DefaultShutdownGracePeriod
+func WithShutdownGracePeriod(d time.Duration) Option {
+	return func(m *TaskManager) {
+		m.shutdownGracePeriod = d
+	}
 }
 
 // NewTaskManager 创建一个新的任务管理器
 // 参数：
 // - db: 数据库连接对象
+// - opts: 可选配置，如自定义时钟和调度器
 // 返回：
 // - *TaskManager: 任务管理器对象
-func NewTaskManager(db *gorm.DB) *TaskManager {
-	// 创建调度器，使用UTC时区
-	scheduler := gocron.NewScheduler(time.UTC)
-
-	// 启动调度器
-	scheduler.StartAsync()
-
-	return &TaskManager{
+func NewTaskManager(db *gorm.DB, opts ...Option) *TaskManager {
+	m := &TaskManager{
 		db:            db,
-		scheduler:     scheduler,
-		jobs:          make(map[string]*gocron.Job),
+		clock:         systemClock{},
+		jobs:          make(map[string]ScheduledJob),
 		jobModels:     make(map[string]*models.RecurringJob),
 		functions:     make(map[string]JobFunc),
 		defaultLogger: log.Default(),
 		isRunning:     false,
+		executions:    make(map[uint]*models.RecurringJobExecution),
+
+		shutdownGracePeriod: DefaultShutdownGracePeriod,
+	}
+	m.baseCtx, m.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(m)
 	}
+
+	// 未指定调度器时创建默认调度器，使用UTC时区
+	if m.scheduler == nil {
+		m.scheduler = NewGocronScheduler(time.UTC)
+	}
+
+	// 启动调度器
+	m.scheduler.StartAsync()
+
+	return m
 }
 
 // RegisterFunction 注册一个新的任务函数
@@ -98,6 +151,14 @@ func (m *TaskManager) Start() error {
 		return nil
 	}
 
+	// 关闭后重新启动时，恢复任务执行上下文
+	m.mu.Lock()
+	if m.draining {
+		m.baseCtx, m.cancel = context.WithCancel(context.Background())
+		m.draining = false
+	}
+	m.mu.Unlock()
+
 	// 从数据库加载所有活动的任务
 	var jobs []models.RecurringJob
 	err := m.db.Where("status = ?", "active").Find(&jobs).Error
@@ -120,20 +181,26 @@ func (m *TaskManager) Start() error {
 	return nil
 }
 
-// Stop 停止任务管理器，清理所有资源并等待任务完成
+// Stop 停止任务管理器，使用配置的宽限期等待正在执行的任务结束
 func (m *TaskManager) Stop() {
-	if !m.isRunning {
+	m.Shutdown(m.shutdownGracePeriod)
+}
+
+// Shutdown 优雅关闭任务管理器
+// 停止触发新的任务并取消正在执行的任务的上下文，然后在宽限期内等待任务结束；
+// 宽限期结束时仍在执行的执行记录会被标记为中断
+// 参数：
+// - gracePeriod: 等待正在执行的任务结束的最长时间
+func (m *TaskManager) Shutdown(gracePeriod time.Duration) {
+	m.mu.Lock()
+	if m.draining {
+		m.mu.Unlock()
 		return
 	}
-
 	log.Println("正在关闭重复任务管理器...")
 
-	// 获取锁以确保其他操作暂停
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	// 停止调度器并等待所有任务完成
-	m.scheduler.Stop()
+	// 标记为正在关闭，之后触发的任务不会再开始执行
+	m.draining = true
 
 	// 清理资源
 	for key := range m.jobs {
@@ -145,7 +212,75 @@ func (m *TaskManager) Stop() {
 	}
 
 	m.isRunning = false
-	log.Println("重复任务管理器已完全停止")
+	m.mu.Unlock()
+
+	// 通知正在执行的任务尽快结束
+	m.cancel()
+
+	// 停止调度器并等待任务结束，gocron 的 Stop 会等待由它触发的任务返回，因此不能持有锁
+	done := make(chan struct{})
+	go func() {
+		m.scheduler.Stop()
+		m.running.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(gracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		log.Println("重复任务管理器已完全停止")
+	case <-timer.C:
+		n := m.interruptExecutions()
+		log.Printf("等待任务结束超时，已将 %d 条执行记录标记为中断", n)
+	}
+}
+
+// trackExecution 记录正在执行的执行记录
+func (m *TaskManager) trackExecution(execution *models.RecurringJobExecution) {
+	m.executionsMu.Lock()
+	defer m.executionsMu.Unlock()
+	m.executions[execution.ID] = execution
+}
+
+// untrackExecution 移除正在执行的执行记录
+// 返回：
+// - bool: 执行记录已被标记为中断时返回false，调用方不应再保存执行结果
+func (m *TaskManager) untrackExecution(execution *models.RecurringJobExecution) bool {
+	m.executionsMu.Lock()
+	defer m.executionsMu.Unlock()
+	if _, ok := m.executions[execution.ID]; !ok {
+		return false
+	}
+	delete(m.executions, execution.ID)
+	return true
+}
+
+// interruptExecutions 将所有仍在执行的执行记录标记为中断
+// 任务函数可能仍在修改执行记录，因此只更新结束相关的字段
+// 返回：
+// - int: 标记为中断的执行记录数量
+func (m *TaskManager) interruptExecutions() int {
+	m.executionsMu.Lock()
+	defer m.executionsMu.Unlock()
+
+	now := m.clock.Now()
+	count := len(m.executions)
+	for id, execution := range m.executions {
+		err := m.db.Model(&models.RecurringJobExecution{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"finished_at": now,
+			"duration":    now.Sub(execution.StartedAt).Milliseconds(),
+			"success":     false,
+			"interrupted": true,
+			"error":       InterruptedError,
+		}).Error
+		if err != nil {
+			log.Printf("标记执行记录 #%d 为中断失败: %v", id, err)
+		}
+		delete(m.executions, id)
+	}
+	return count
 }
 
 // AddJob 添加一个新的重复任务
@@ -179,7 +314,7 @@ func (m *TaskManager) AddJob(name, functionName string, args interface{}, times
 	// 创建任务对象
 	job := models.RecurringJob{
 		Name:           name,
-		JobKey:         fmt.Sprintf("%s_%d", name, time.Now().UnixNano()),
+		JobKey:         fmt.Sprintf("%s_%d", name, m.clock.Now().UnixNano()),
 		FunctionName:   functionName,
 		CronExpression: cronExpression,
 		Times:          times,
@@ -208,9 +343,11 @@ func (m *TaskManager) AddJob(name, functionName string, args interface{}, times
 			return &job, err
 		}
 
-		// 获取下次执行时间
-		nextRun := scheduledJob.NextRun()
-		m.db.Model(&job).Update("next_run_at", nextRun)
+		// 获取下次执行时间（已达到执行次数限制的任务不会被调度）
+		if scheduledJob != nil {
+			nextRun := scheduledJob.NextRun()
+			m.db.Model(&job).Update("next_run_at", nextRun)
+		}
 	}
 
 	return &job, nil
@@ -238,7 +375,7 @@ func (m *TaskManager) RemoveJob(name string, ctx ...*web.EventContext) error {
 
 	// 从调度器中移除任务
 	if scheduledJob, exists := m.jobs[job.JobKey]; exists {
-		m.scheduler.RemoveByReference(scheduledJob)
+		m.scheduler.Remove(scheduledJob)
 		delete(m.jobs, job.JobKey)
 		delete(m.jobModels, job.JobKey)
 	}
@@ -278,7 +415,7 @@ func (m *TaskManager) PauseJob(name string, ctx ...*web.EventContext) error {
 
 	// 从调度器中移除任务
 	if scheduledJob, exists := m.jobs[job.JobKey]; exists {
-		m.scheduler.RemoveByReference(scheduledJob)
+		m.scheduler.Remove(scheduledJob)
 		delete(m.jobs, job.JobKey)
 	}
 
@@ -406,9 +543,9 @@ func (m *TaskManager) RunJobNow(name string, ctx ...*web.EventContext) error {
 // 参数：
 // - job: 要调度的任务对象
 // 返回：
-// - *gocron.Job: 调度后的任务对象
+// - ScheduledJob: 调度后的任务对象，任务已达到执行次数限制时为nil
 // - error: 调度过程中的错误信息
-func (m *TaskManager) scheduleJob(job *models.RecurringJob) (*gocron.Job, error) {
+func (m *TaskManager) scheduleJob(job *models.RecurringJob) (ScheduledJob, error) {
 	// 检查函数是否已注册
 	_, ok := m.functions[job.FunctionName]
 	if !ok {
@@ -425,21 +562,24 @@ func (m *TaskManager) scheduleJob(job *models.RecurringJob) (*gocron.Job, error)
 		return nil, fmt.Errorf("Cron表达式不能为空")
 	}
 
+	// 检查执行次数限制 - 考虑已执行的次数
+	remainingRuns := job.Times - job.TimesRun
+	if job.Times > 0 && remainingRuns <= 0 {
+		// 任务已完成，将状态更新为"completed"，不再调度
+		m.db.Model(&models.RecurringJob{}).Where("id = ?", job.ID).Update("status", "completed")
+		job.Status = "completed"
+		return nil, nil
+	}
+
 	// 使用Cron表达式调度
 	// 注意：使用标准5字段Cron表达式（分 时 日 月 周）
-	scheduledJob, err := m.scheduler.Cron(job.CronExpression).Do(execFn)
+	scheduledJob, err := m.scheduler.Schedule(job.CronExpression, execFn)
 	if err != nil {
 		return nil, fmt.Errorf("无效的Cron表达式: %w", err)
 	}
 
-	// 设置执行次数限制 - 考虑已执行的次数
+	// 设置执行次数限制
 	if job.Times > 0 {
-		remainingRuns := job.Times - job.TimesRun
-		if remainingRuns <= 0 {
-			// 任务已完成，将状态更新为"completed"
-			m.db.Model(&models.RecurringJob{}).Where("id = ?", job.ID).Update("status", "completed")
-			return scheduledJob, nil // 返回但不实际调度
-		}
 		scheduledJob.LimitRunsTo(remainingRuns)
 	}
 
@@ -456,6 +596,12 @@ func (m *TaskManager) scheduleJob(job *models.RecurringJob) (*gocron.Job, error)
 func (m *TaskManager) executeJob(job *models.RecurringJob) {
 	// 锁定任务执行，防止并发问题
 	m.mu.Lock()
+	// 正在关闭时不再开始新的执行
+	if m.draining {
+		log.Printf("重复任务管理器正在关闭，跳过任务 %s", job.Name)
+		m.mu.Unlock()
+		return
+	}
 	// 获取最新任务数据，避免使用过期数据
 	var updatedJob models.RecurringJob
 	if err := m.db.First(&updatedJob, job.ID).Error; err != nil {
@@ -479,38 +625,55 @@ func (m *TaskManager) executeJob(job *models.RecurringJob) {
 
 		// 从调度器中移除任务
 		if scheduledJob, exists := m.jobs[updatedJob.JobKey]; exists {
-			m.scheduler.RemoveByReference(scheduledJob)
+			m.scheduler.Remove(scheduledJob)
 			delete(m.jobs, updatedJob.JobKey)
 			delete(m.jobModels, updatedJob.JobKey)
 		}
 		m.mu.Unlock()
 		return
 	}
+	// 在持有锁时登记，保证关闭时等待的任务不会遗漏
+	m.running.Add(1)
+	ctx, cancel := context.WithTimeout(m.baseCtx, 30*time.Minute)
 	m.mu.Unlock()
+	defer m.running.Done()
+	defer cancel()
 
 	// 创建执行记录
 	execution := &models.RecurringJobExecution{
 		RecurringJobID: job.ID,
-		StartedAt:      time.Now(),
+		StartedAt:      m.clock.Now(),
 	}
 	m.db.Create(execution)
+	m.trackExecution(execution)
+
+	// 注入执行产物保存函数
+	if m.artifactStorage != nil {
+		execution.SetArtifactSaver(newArtifactSaver(m.db, m.artifactStorage, func() int64 {
+			return m.clock.Now().UnixNano()
+		}))
+	}
 
 	// 获取任务函数
 	fn, ok := m.functions[job.FunctionName]
 	if !ok {
-		finishExecution(m.db, execution, false, ErrInvalidFunction.Error(), "")
+		m.untrackExecution(execution)
+		m.finishExecution(execution, false, ErrInvalidFunction.Error(), "")
+		m.notify(&updatedJob, execution)
 		return
 	}
 
-	// 创建上下文
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-
 	// 执行任务函数
 	err := fn(ctx, []byte(job.Args), execution)
 
+	// 关闭时等待超时的执行记录已被标记为中断，不再保存执行结果
+	if !m.untrackExecution(execution) {
+		log.Printf("任务 %s 的执行记录已被标记为中断，忽略执行结果", job.Name)
+		return
+	}
+
 	// 更新执行记录
-	finishTime := time.Now()
+	finishTime := m.clock.Now()
 	duration := finishTime.Sub(execution.StartedAt).Milliseconds()
 	success := err == nil
 
@@ -525,6 +688,7 @@ func (m *TaskManager) executeJob(job *models.RecurringJob) {
 	execution.Success = success
 	execution.Error = errorMsg
 	m.db.Save(execution)
+	m.notify(&updatedJob, execution)
 
 	// 这里我们使用事务来确保原子更新
 	tx := m.db.Begin()
@@ -575,7 +739,7 @@ func (m *TaskManager) executeJob(job *models.RecurringJob) {
 		// 从调度器中移除任务 (在事务外执行，避免锁定)
 		m.mu.Lock()
 		if scheduledJob, exists := m.jobs[job.JobKey]; exists {
-			m.scheduler.RemoveByReference(scheduledJob)
+			m.scheduler.Remove(scheduledJob)
 			delete(m.jobs, job.JobKey)
 			delete(m.jobModels, job.JobKey)
 		}
@@ -588,19 +752,18 @@ func (m *TaskManager) executeJob(job *models.RecurringJob) {
 
 // finishExecution 内部方法，用于完成执行记录
 // 参数：
-// - db: 数据库连接
 // - execution: 执行记录对象
 // - success: 是否执行成功
 // - errorMsg: 错误信息
 // - output: 输出信息
-func finishExecution(db *gorm.DB, execution *models.RecurringJobExecution, success bool, errorMsg, output string) {
-	now := time.Now()
+func (m *TaskManager) finishExecution(execution *models.RecurringJobExecution, success bool, errorMsg, output string) {
+	now := m.clock.Now()
 	execution.FinishedAt = &now
 	execution.Duration = now.Sub(execution.StartedAt).Milliseconds()
 	execution.Success = success
 	execution.Error = errorMsg
 	execution.Output = output
-	db.Save(execution)
+	m.db.Save(execution)
 }
 
 // UpdateJob 更新现有任务的配置并重新调度，保留原有的统计信息和状态
@@ -634,7 +797,7 @@ func (m *TaskManager) UpdateJob(jobID uint, name, functionName string, args inte
 
 	// 从调度器中移除当前任务（如果存在）
 	if scheduledJob, exists := m.jobs[job.JobKey]; exists {
-		m.scheduler.RemoveByReference(scheduledJob)
+		m.scheduler.Remove(scheduledJob)
 		delete(m.jobs, job.JobKey)
 		delete(m.jobModels, job.JobKey)
 	}
@@ -678,9 +841,57 @@ func (m *TaskManager) UpdateJob(jobID uint, name, functionName string, args inte
 			return &job, err
 		}
 
-		// 获取下次执行时间
-		nextRun := scheduledJob.NextRun()
-		m.db.Model(&job).Update("next_run_at", nextRun)
+		// 获取下次执行时间（已达到执行次数限制的任务不会被调度）
+		if scheduledJob != nil {
+			nextRun := scheduledJob.NextRun()
+			m.db.Model(&job).Update("next_run_at", nextRun)
+		}
+	}
+
+	return &job, nil
+}
+
+// SetJobOwnership 设置任务的标签和负责人，不影响任务的调度
+// 参数：
+// - jobID: 任务ID
+// - tags: 标签列表
+// - ownerID: 负责人用户ID，nil表示没有负责人
+// - ownerRole: 负责角色，空字符串表示没有负责角色
+// - ctx: 操作上下文，用于记录操作日志
+// 返回：
+// - *models.RecurringJob: 更新后的任务对象
+// - error: 更新过程中的错误信息
+func (m *TaskManager) SetJobOwnership(jobID uint, tags []string, ownerID *uint, ownerRole string, ctx ...*web.EventContext) (*models.RecurringJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var job models.RecurringJob
+	if err := m.db.First(&job, jobID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	// 保存更新前的任务，用于记录差异
+	originalJob := job
+
+	job.SetTags(tags)
+	job.OwnerID = ownerID
+	job.OwnerRole = ownerRole
+
+	err := m.db.Model(&job).Updates(map[string]interface{}{
+		"tags":       job.Tags,
+		"owner_id":   job.OwnerID,
+		"owner_role": job.OwnerRole,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 记录操作日志
+	if m.activitySupport != nil && len(ctx) > 0 {
+		m.activitySupport.OnEdit(ctx[0].R.Context(), &originalJob, &job)
 	}
 
 	return &job, nil
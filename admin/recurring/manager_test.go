@@ -0,0 +1,745 @@
+package recurring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// fakeClock 可手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// manualJob 手动调度器中的任务
+type manualJob struct {
+	schedule cron.Schedule
+	clock    Clock
+	fn       func()
+	limit    int
+	runs     int
+	removed  bool
+}
+
+func (j *manualJob) NextRun() time.Time {
+	return j.schedule.Next(j.clock.Now())
+}
+
+func (j *manualJob) LimitRunsTo(n int) {
+	j.limit = n
+}
+
+// manualScheduler 只在测试调用 Tick 时同步执行任务的调度器
+type manualScheduler struct {
+	clock Clock
+	jobs  []*manualJob
+}
+
+func (s *manualScheduler) Schedule(cronExpression string, fn func()) (ScheduledJob, error) {
+	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+	schedule, err := parser.Parse(cronExpression)
+	if err != nil {
+		return nil, err
+	}
+	j := &manualJob{schedule: schedule, clock: s.clock, fn: fn}
+	s.jobs = append(s.jobs, j)
+	return j, nil
+}
+
+func (s *manualScheduler) Remove(job ScheduledJob) {
+	job.(*manualJob).removed = true
+}
+
+func (s *manualScheduler) StartAsync() {}
+
+func (s *manualScheduler) Stop() {}
+
+// Tick 触发一次所有仍在调度中的任务
+func (s *manualScheduler) Tick() {
+	for _, j := range append([]*manualJob(nil), s.jobs...) {
+		if j.removed || (j.limit > 0 && j.runs >= j.limit) {
+			continue
+		}
+		j.runs++
+		j.fn()
+	}
+}
+
+// active 返回仍在调度中的任务数量
+func (s *manualScheduler) active() int {
+	n := 0
+	for _, j := range s.jobs {
+		if !j.removed && (j.limit == 0 || j.runs < j.limit) {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestManager(t *testing.T, opts ...Option) (*TaskManager, *manualScheduler, *fakeClock) {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&models.RecurringJob{}, &models.RecurringJobExecution{}, &models.RecurringJobArtifact{}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	scheduler := &manualScheduler{clock: clock}
+	m := NewTaskManager(db, append([]Option{WithClock(clock), WithScheduler(scheduler)}, opts...)...)
+	return m, scheduler, clock
+}
+
+func mustGetJob(t *testing.T, m *TaskManager, name string) *models.RecurringJob {
+	t.Helper()
+	job, err := m.GetJob(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func countExecutions(t *testing.T, m *TaskManager, jobID uint) int64 {
+	t.Helper()
+	var count int64
+	if err := m.db.Model(&models.RecurringJobExecution{}).Where("recurring_job_id = ?", jobID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestTimesLimit(t *testing.T) {
+	m, s, clock := newTestManager(t)
+
+	calls := 0
+	m.RegisterFunction("count", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		calls++
+		clock.Advance(1500 * time.Millisecond)
+		return nil
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := m.AddJob("limited", "count", nil, 2, "*/5 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGetJob(t, m, "limited").NextRunAt; got == nil || !got.Equal(time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC)) {
+		t.Fatalf("NextRunAt = %v, want 00:05", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		s.Tick()
+	}
+
+	if calls != 2 {
+		t.Fatalf("calls = %d, want 2", calls)
+	}
+	got := mustGetJob(t, m, "limited")
+	if got.Status != "completed" || got.TimesRun != 2 {
+		t.Fatalf("status = %s, times_run = %d, want completed, 2", got.Status, got.TimesRun)
+	}
+	if s.active() != 0 || len(m.jobs) != 0 {
+		t.Fatalf("completed job is still scheduled")
+	}
+
+	var execution models.RecurringJobExecution
+	if err := m.db.Where("recurring_job_id = ?", job.ID).Order("id").First(&execution).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !execution.StartedAt.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || execution.Duration != 1500 || !execution.Success {
+		t.Fatalf("unexpected execution: started=%v duration=%d success=%v", execution.StartedAt, execution.Duration, execution.Success)
+	}
+}
+
+func TestTimesLimitAlreadyReachedOnStart(t *testing.T) {
+	m, s, _ := newTestManager(t)
+	m.RegisterFunction("noop", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		return nil
+	})
+
+	job := models.RecurringJob{Name: "done", JobKey: "done_1", FunctionName: "noop", CronExpression: "* * * * *", Times: 3, TimesRun: 3, Status: "active"}
+	if err := m.db.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.active() != 0 {
+		t.Fatalf("job that reached its limit must not be scheduled")
+	}
+	if got := mustGetJob(t, m, "done"); got.Status != "completed" {
+		t.Fatalf("status = %s, want completed", got.Status)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	m, s, _ := newTestManager(t)
+
+	calls := 0
+	m.RegisterFunction("count", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		calls++
+		return nil
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddJob("pausable", "count", nil, 0, "* * * * *"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Tick()
+	if err := m.PauseJob("pausable"); err != nil {
+		t.Fatal(err)
+	}
+	s.Tick()
+	s.Tick()
+	if calls != 1 {
+		t.Fatalf("calls while paused = %d, want 1", calls)
+	}
+	if got := mustGetJob(t, m, "pausable"); got.Status != "paused" {
+		t.Fatalf("status = %s, want paused", got.Status)
+	}
+
+	if err := m.ResumeJob("pausable"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ResumeJob("pausable"); err == nil {
+		t.Fatalf("resuming an active job should fail")
+	}
+	s.Tick()
+	if calls != 2 {
+		t.Fatalf("calls after resume = %d, want 2", calls)
+	}
+	if got := mustGetJob(t, m, "pausable"); got.Status != "active" || got.TimesRun != 2 {
+		t.Fatalf("status = %s, times_run = %d, want active, 2", got.Status, got.TimesRun)
+	}
+}
+
+func TestUpdateJobPreservesStats(t *testing.T) {
+	m, s, _ := newTestManager(t)
+
+	m.RegisterFunction("fail", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		return errors.New("boom")
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	job, err := m.AddJob("flaky", "fail", map[string]int{"n": 1}, 0, "* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Tick()
+	s.Tick()
+
+	before := mustGetJob(t, m, "flaky")
+	updated, err := m.UpdateJob(job.ID, "flaky-renamed", "fail", map[string]int{"n": 2}, 10, "0 * * * *", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.TimesRun != 2 || updated.ErrorCount != 2 || updated.LastError != "boom" {
+		t.Fatalf("stats not preserved: times_run=%d error_count=%d last_error=%q", updated.TimesRun, updated.ErrorCount, updated.LastError)
+	}
+	if updated.LastRunAt == nil || before.LastRunAt == nil || !updated.LastRunAt.Equal(*before.LastRunAt) {
+		t.Fatalf("LastRunAt not preserved: %v, want %v", updated.LastRunAt, before.LastRunAt)
+	}
+	if updated.Args != `{"n":2}` || updated.CronExpression != "0 * * * *" || updated.Times != 10 {
+		t.Fatalf("configuration not updated: %+v", updated)
+	}
+	if s.active() != 1 {
+		t.Fatalf("active scheduled jobs = %d, want 1", s.active())
+	}
+}
+
+func TestUpdateJobCompletionTransitions(t *testing.T) {
+	m, s, _ := newTestManager(t)
+
+	m.RegisterFunction("noop", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		return nil
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	job, err := m.AddJob("once", "noop", nil, 1, "* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Tick()
+	if got := mustGetJob(t, m, "once"); got.Status != "completed" {
+		t.Fatalf("status = %s, want completed", got.Status)
+	}
+
+	// 保持状态：已完成的任务不会被重新调度
+	updated, err := m.UpdateJob(job.ID, "once", "noop", nil, 3, "* * * * *", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status != "completed" || s.active() != 0 {
+		t.Fatalf("keepStatus: status = %s, scheduled = %d", updated.Status, s.active())
+	}
+
+	// 不保持状态但执行次数限制没有提高：仍然是已完成
+	updated, err = m.UpdateJob(job.ID, "once", "noop", nil, 1, "* * * * *", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status != "completed" || mustGetJob(t, m, "once").Status != "completed" || s.active() != 0 {
+		t.Fatalf("limit not raised: status = %s, scheduled = %d", updated.Status, s.active())
+	}
+
+	// 不保持状态并提高执行次数限制：重新激活并只执行剩余次数
+	updated, err = m.UpdateJob(job.ID, "once", "noop", nil, 3, "* * * * *", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status != "active" || s.active() != 1 {
+		t.Fatalf("limit raised: status = %s, scheduled = %d", updated.Status, s.active())
+	}
+	for i := 0; i < 5; i++ {
+		s.Tick()
+	}
+	got := mustGetJob(t, m, "once")
+	if got.Status != "completed" || got.TimesRun != 3 || countExecutions(t, m, job.ID) != 3 {
+		t.Fatalf("status = %s, times_run = %d, want completed, 3", got.Status, got.TimesRun)
+	}
+}
+
+func TestRunInMemory(t *testing.T) {
+	fn := func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		var in struct{ Name string }
+		var job models.RecurringJob
+		job.Args = string(args)
+		if err := job.GetArgs(&in); err != nil {
+			return err
+		}
+		execution.Info("hello %s", in.Name)
+		if in.Name == "" {
+			return errors.New("name is required")
+		}
+		return nil
+	}
+
+	execution, err := RunInMemory(context.Background(), fn, map[string]string{"Name": "qor5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !execution.Success || execution.FinishedAt == nil || !strings.Contains(execution.Output, "[INFO] hello qor5") {
+		t.Fatalf("unexpected execution: %+v", execution)
+	}
+
+	execution, err = RunInMemory(context.Background(), fn, nil)
+	if err == nil || execution.Success || execution.Error != "name is required" {
+		t.Fatalf("expected failed execution, got %+v, %v", execution, err)
+	}
+}
+
+func TestRunInMemoryClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	execution, err := RunInMemory(context.Background(), func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		clock.Advance(3 * time.Second)
+		return nil
+	}, nil, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !execution.StartedAt.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || execution.Duration != 3000 ||
+		!execution.FinishedAt.Equal(clock.Now()) {
+		t.Fatalf("in-memory run should use the injected clock: %+v", execution)
+	}
+}
+
+func TestExecutionArtifacts(t *testing.T) {
+	storage := NewMemoryArtifactStorage()
+	m, s, _ := newTestManager(t, WithArtifactStorage(storage))
+
+	m.RegisterFunction("report", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		if err := execution.AttachArtifactBytes("report.csv", "text/csv", []byte("id,name\n1,qor5\n")); err != nil {
+			return err
+		}
+		return execution.AttachArtifact("../../etc/passwd", "text/plain", strings.NewReader("hello"))
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	job, err := m.AddJob("report", "report", nil, 1, "* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Tick()
+
+	var execution models.RecurringJobExecution
+	if err := m.db.Preload("Artifacts").Where("recurring_job_id = ?", job.ID).First(&execution).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !execution.Success || len(execution.Artifacts) != 2 {
+		t.Fatalf("unexpected execution: success=%v error=%q artifacts=%d", execution.Success, execution.Error, len(execution.Artifacts))
+	}
+
+	csv := execution.Artifacts[0]
+	if csv.ContentType != "text/csv" || csv.Size != 15 {
+		t.Fatalf("unexpected artifact: %+v", csv)
+	}
+	artifact, rc, err := m.OpenArtifact(csv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if artifact.Name != "report.csv" || string(content) != "id,name\n1,qor5\n" {
+		t.Fatalf("unexpected artifact content: %s %q", artifact.Name, content)
+	}
+	if key := execution.Artifacts[1].StorageKey; strings.Contains(key, "..") || !strings.HasSuffix(key, "_passwd") {
+		t.Fatalf("unsafe storage key: %s", key)
+	}
+
+	if err := m.DeleteExecutionArtifacts(execution.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.OpenArtifact(csv.ID); !errors.Is(err, ErrArtifactNotFound) {
+		t.Fatalf("OpenArtifact after delete: %v", err)
+	}
+	if _, err := storage.Open(csv.StorageKey); !errors.Is(err, ErrArtifactNotFound) {
+		t.Fatalf("storage content not deleted: %v", err)
+	}
+}
+
+func TestExecutionArtifactsWithoutStorage(t *testing.T) {
+	m, s, _ := newTestManager(t)
+
+	var attachErr error
+	m.RegisterFunction("report", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		attachErr = execution.AttachArtifactBytes("report.txt", "", []byte("x"))
+		return nil
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddJob("report", "report", nil, 1, "* * * * *"); err != nil {
+		t.Fatal(err)
+	}
+	s.Tick()
+
+	if !errors.Is(attachErr, models.ErrArtifactStorageNotConfigured) {
+		t.Fatalf("attach error = %v, want ErrArtifactStorageNotConfigured", attachErr)
+	}
+}
+
+func TestRunInMemoryArtifacts(t *testing.T) {
+	storage := NewMemoryArtifactStorage()
+	execution, err := RunInMemoryWithStorage(context.Background(), func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		return execution.AttachArtifactBytes("result.json", "", []byte(`{"ok":true}`))
+	}, nil, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(execution.Artifacts) != 1 || execution.Artifacts[0].ContentType != "application/json" {
+		t.Fatalf("unexpected artifacts: %+v", execution.Artifacts)
+	}
+	rc, err := storage.Open(execution.Artifacts[0].StorageKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if content, _ := io.ReadAll(rc); string(content) != `{"ok":true}` {
+		t.Fatalf("unexpected content: %s", content)
+	}
+}
+
+func TestBulkOperations(t *testing.T) {
+	m, s, _ := newTestManager(t)
+	m.RegisterFunction("noop", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		return nil
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint
+	for _, name := range []string{"a", "b", "c"} {
+		job, err := m.AddJob(name, "noop", nil, 0, "* * * * *")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	if err := m.PauseJob("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	results := m.BulkPauseJobs(append(ids, 999))
+	if results.Succeeded() != 2 || len(results.Failed()) != 2 {
+		t.Fatalf("pause results: %+v", results)
+	}
+	if failed := results.Failed(); failed[0].Name != "c" || !errors.Is(failed[1].Err, ErrJobNotFound) {
+		t.Fatalf("unexpected failures: %+v", failed)
+	}
+	if s.active() != 0 {
+		t.Fatalf("paused jobs are still scheduled")
+	}
+
+	if results := m.BulkResumeJobs(ids); results.Succeeded() != 3 {
+		t.Fatalf("resume results: %s", results.Error())
+	}
+	if s.active() != 3 {
+		t.Fatalf("active = %d, want 3", s.active())
+	}
+
+	if results := m.BulkRemoveJobs(ids[:2]); results.Succeeded() != 2 {
+		t.Fatalf("remove results: %s", results.Error())
+	}
+	jobs, err := m.ListJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "c" || s.active() != 1 {
+		t.Fatalf("jobs after remove: %+v", jobs)
+	}
+}
+
+func TestJobOwnershipAndTags(t *testing.T) {
+	m, _, _ := newTestManager(t)
+	m.RegisterFunction("noop", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		return nil
+	})
+
+	a, err := m.AddJob("a", "noop", nil, 0, "* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.AddJob("b", "noop", nil, 0, "* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := uint(7)
+	a, err = m.SetJobOwnership(a.ID, []string{" billing", "reports ", "billing", ""}, &owner, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Tags != "billing,reports" {
+		t.Fatalf("tags = %q", a.Tags)
+	}
+	if _, err := m.SetJobOwnership(b.ID, []string{"reports"}, nil, models.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+
+	a = mustGetJob(t, m, "a")
+	b = mustGetJob(t, m, "b")
+	if !a.IsOwnedBy(7, nil) || a.IsOwnedBy(8, []string{models.RoleEditor}) {
+		t.Fatalf("unexpected ownership for a: %+v", a)
+	}
+	if !b.IsOwnedBy(8, []string{models.RoleEditor}) || b.IsOwnedBy(7, []string{models.RoleViewer}) {
+		t.Fatalf("unexpected ownership for b: %+v", b)
+	}
+
+	results := m.BulkUpdateTags([]uint{a.ID, b.ID}, []string{"nightly"}, []string{"reports"})
+	if results.Succeeded() != 2 {
+		t.Fatalf("update tags: %s", results.Error())
+	}
+	a = mustGetJob(t, m, "a")
+	b = mustGetJob(t, m, "b")
+	if a.Tags != "billing,nightly" || b.Tags != "nightly" {
+		t.Fatalf("tags after bulk update: a=%q b=%q", a.Tags, b.Tags)
+	}
+	if a.OwnerID == nil || *a.OwnerID != 7 || b.OwnerRole != models.RoleEditor {
+		t.Fatalf("bulk tag update changed owners: %+v %+v", a, b)
+	}
+}
+
+func TestJobNotifications(t *testing.T) {
+	var mu sync.Mutex
+	var notified []string
+	m, s, _ := newTestManager(t, WithNotifier(func(ctx context.Context, job *models.RecurringJob, execution *models.RecurringJobExecution) error {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, fmt.Sprintf("%s:%t:%s", job.Name, execution.Success, job.NotifyEmails))
+		return nil
+	}))
+	m.RegisterFunction("ok", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		return nil
+	})
+	m.RegisterFunction("fail", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		return errors.New("boom")
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint
+	for _, fn := range []string{"ok", "fail"} {
+		job, err := m.AddJob(fn, fn, nil, 0, "* * * * *")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+
+	if _, err := m.SetJobNotification(ids[0], "sometimes", nil); !errors.Is(err, ErrInvalidNotifyOn) {
+		t.Fatalf("expected invalid notify_on error, got %v", err)
+	}
+	if _, err := m.SetJobNotification(ids[0], models.NotifyAlways, []string{"not an email"}); err == nil {
+		t.Fatalf("expected invalid email error")
+	}
+	if _, err := m.SetJobNotification(ids[0], models.NotifyOnFailure, nil); err == nil {
+		t.Fatalf("expected missing recipients error")
+	}
+
+	results := m.BulkUpdateNotifications(append(ids, 999), models.NotifyOnFailure, []string{" ops@example.com", "OPS@example.com", "dev@example.com"})
+	if results.Succeeded() != 2 || len(results.Failed()) != 1 {
+		t.Fatalf("bulk notification results: %+v", results)
+	}
+	if job := mustGetJob(t, m, "ok"); job.NotifyOn != models.NotifyOnFailure || job.NotifyEmails != "ops@example.com,dev@example.com" {
+		t.Fatalf("notification settings = %q %q", job.NotifyOn, job.NotifyEmails)
+	}
+
+	s.Tick()
+	mu.Lock()
+	got := append([]string(nil), notified...)
+	notified = nil
+	mu.Unlock()
+	if len(got) != 1 || got[0] != "fail:false:ops@example.com,dev@example.com" {
+		t.Fatalf("only failed executions should notify, got %v", got)
+	}
+
+	if results := m.BulkUpdateNotifications(ids, models.NotifyAlways, []string{"ops@example.com"}); results.Succeeded() != 2 {
+		t.Fatalf("bulk notification results: %s", results.Error())
+	}
+	s.Tick()
+	mu.Lock()
+	if len(notified) != 2 {
+		t.Errorf("always should notify every execution, got %v", notified)
+	}
+	notified = nil
+	mu.Unlock()
+
+	if results := m.BulkUpdateNotifications(ids, models.NotifyNever, nil); results.Succeeded() != 2 {
+		t.Fatalf("bulk notification results: %s", results.Error())
+	}
+	s.Tick()
+	if len(notified) != 0 {
+		t.Errorf("disabled notifications should not be sent, got %v", notified)
+	}
+}
+
+func TestShutdownCancelsRunningJobs(t *testing.T) {
+	m, _, _ := newTestManager(t)
+
+	started := make(chan struct{})
+	m.RegisterFunction("wait", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	job, err := m.AddJob("wait", "wait", nil, 0, "* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RunJobNow("wait"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	m.Shutdown(time.Second)
+
+	var execution models.RecurringJobExecution
+	if err := m.db.Where("recurring_job_id = ?", job.ID).First(&execution).Error; err != nil {
+		t.Fatal(err)
+	}
+	if execution.Interrupted || execution.FinishedAt == nil || execution.Error != context.Canceled.Error() {
+		t.Fatalf("unexpected execution: %+v", execution)
+	}
+
+	// 关闭后不再开始新的执行
+	if err := m.RunJobNow("wait"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := countExecutions(t, m, job.ID); n != 1 {
+		t.Fatalf("executions = %d, want 1", n)
+	}
+}
+
+func TestShutdownMarksInterruptedExecutions(t *testing.T) {
+	m, _, _ := newTestManager(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	m.RegisterFunction("stuck", func(ctx context.Context, args []byte, execution *models.RecurringJobExecution) error {
+		defer close(finished)
+		close(started)
+		<-release
+		return nil
+	})
+	job, err := m.AddJob("stuck", "stuck", nil, 0, "* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RunJobNow("stuck"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	m.Shutdown(20 * time.Millisecond)
+
+	var execution models.RecurringJobExecution
+	if err := m.db.Where("recurring_job_id = ?", job.ID).First(&execution).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !execution.Interrupted || execution.Success || execution.FinishedAt == nil || execution.Error != InterruptedError {
+		t.Fatalf("unexpected execution: %+v", execution)
+	}
+
+	// 任务函数在宽限期之后才返回时，不会覆盖中断标记
+	close(release)
+	<-finished
+	m.running.Wait()
+	if err := m.db.First(&execution, execution.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !execution.Interrupted || execution.Success {
+		t.Fatalf("interrupted execution was overwritten: %+v", execution)
+	}
+	if got := mustGetJob(t, m, "stuck"); got.TimesRun != 0 {
+		t.Fatalf("times_run = %d, want 0", got.TimesRun)
+	}
+}
@@ -0,0 +1,114 @@
+package recurring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"time"
+
+	"github.com/qor5/web/v3"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// Notifier 在任务执行结束后发送通知，由使用方实现，例如发送邮件
+// 参数：
+// - ctx: 上下文，带有发送超时
+// - job: 任务对象，包含通知收件人
+// - execution: 执行记录，包含执行结果和输出
+// 返回：
+// - error: 发送失败时的错误信息，只记录日志，不影响执行结果
+type Notifier func(ctx context.Context, job *models.RecurringJob, execution *models.RecurringJobExecution) error
+
+// notifyTimeout 发送一次通知的超时时间
+const notifyTimeout = time.Minute
+
+// ErrInvalidNotifyOn 表示通知时机无效
+var ErrInvalidNotifyOn = errors.New("无效的通知时机")
+
+// WithNotifier 设置任务执行结束后的通知方式，未设置时不发送通知
+func WithNotifier(notifier Notifier) Option {
+	return func(m *TaskManager) {
+		m.notifier = notifier
+	}
+}
+
+// notify 按任务的通知设置发送执行结果，发送失败只记录日志
+func (m *TaskManager) notify(job *models.RecurringJob, execution *models.RecurringJobExecution) {
+	if m.notifier == nil || !job.ShouldNotify(execution.Success) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := m.notifier(ctx, job, execution); err != nil {
+		log.Printf("发送任务 %s 的执行通知失败: %v", job.Name, err)
+	}
+}
+
+// SetJobNotification 设置任务执行结果的通知时机和收件人，不影响任务的调度
+// 参数：
+// - jobID: 任务ID
+// - notifyOn: 通知时机，models.NotifyNever、models.NotifyOnFailure 或 models.NotifyAlways
+// - emails: 通知收件人的邮箱
+// - ctx: 操作上下文，用于记录操作日志
+// 返回：
+// - *models.RecurringJob: 更新后的任务对象
+// - error: 通知时机无效、邮箱格式错误或更新失败时的错误信息
+func (m *TaskManager) SetJobNotification(jobID uint, notifyOn string, emails []string, ctx ...*web.EventContext) (*models.RecurringJob, error) {
+	if notifyOn != models.NotifyNever && notifyOn != models.NotifyOnFailure && notifyOn != models.NotifyAlways {
+		return nil, ErrInvalidNotifyOn
+	}
+	if err := validateNotifyEmails(emails); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var job models.RecurringJob
+	if err := m.db.First(&job, jobID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	// 保存更新前的任务，用于记录差异
+	originalJob := job
+
+	job.NotifyOn = notifyOn
+	job.SetNotifyEmails(emails)
+	if job.NotifyOn != models.NotifyNever && job.NotifyEmails == "" {
+		return nil, errors.New("请输入通知收件人")
+	}
+
+	err := m.db.Model(&job).Updates(map[string]interface{}{
+		"notify_on":     job.NotifyOn,
+		"notify_emails": job.NotifyEmails,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 记录操作日志
+	if m.activitySupport != nil && len(ctx) > 0 {
+		m.activitySupport.OnEdit(ctx[0].R.Context(), &originalJob, &job)
+	}
+
+	return &job, nil
+}
+
+// validateNotifyEmails 检查通知收件人的邮箱格式
+func validateNotifyEmails(emails []string) error {
+	var job models.RecurringJob
+	job.SetNotifyEmails(emails)
+	for _, email := range job.NotifyEmailList() {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return fmt.Errorf("邮箱格式错误: %s", email)
+		}
+	}
+	return nil
+}
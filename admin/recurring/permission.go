@@ -0,0 +1,57 @@
+package recurring
+
+import (
+	"net/http"
+
+	"github.com/qor5/x/v3/login"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// 重复任务的操作权限，与 presets.PermUpdate、presets.PermDelete 一起用于权限策略
+const (
+	PermRunJob   = "recurring:run"   // 立即执行任务
+	PermPauseJob = "recurring:pause" // 暂停或恢复任务
+)
+
+// currentUser 获取当前登录用户，未登录时返回nil
+func currentUser(r *http.Request) *models.User {
+	u, ok := login.GetCurrentUser(r).(*models.User)
+	if !ok {
+		return nil
+	}
+	return u
+}
+
+// verifyJob 校验当前用户是否可以对任务执行指定操作
+// 参数：
+// - verb: 操作权限，例如 PermRunJob
+// - job: 要操作的任务
+// - r: 当前请求
+// 返回：
+// - error: 没有权限时返回错误
+func (m *RecurringJobManager) verifyJob(verb string, job *models.RecurringJob, r *http.Request) error {
+	return m.modelBuilder.Info().Verifier().Do(verb).ObjectOn(job).WithReq(r).IsAllowed()
+}
+
+// filterPermittedJobs 按当前用户的权限筛选任务ID
+// 返回有权限操作的任务ID，以及没有权限或找不到的任务的处理结果
+func (m *RecurringJobManager) filterPermittedJobs(verb string, jobIDs []uint, r *http.Request) ([]uint, BulkResults) {
+	var (
+		permitted []uint
+		denied    BulkResults
+	)
+	for _, id := range jobIDs {
+		var job models.RecurringJob
+		if err := m.db.First(&job, id).Error; err != nil {
+			denied = append(denied, BulkResult{JobID: id, Err: ErrJobNotFound})
+			continue
+		}
+		if err := m.verifyJob(verb, &job, r); err != nil {
+			denied = append(denied, BulkResult{JobID: id, Name: job.Name, Err: err})
+			continue
+		}
+		permitted = append(permitted, id)
+	}
+	return permitted, denied
+}
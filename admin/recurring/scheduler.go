@@ -0,0 +1,65 @@
+package recurring
+
+import (
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// ScheduledJob 表示已经加入调度器的任务
+// *gocron.Job 直接满足该接口
+type ScheduledJob interface {
+	// NextRun 返回下次执行时间
+	NextRun() time.Time
+	// LimitRunsTo 限制任务的剩余执行次数
+	LimitRunsTo(n int)
+}
+
+// Scheduler 抽象了任务管理器依赖的调度器操作
+// 默认实现基于 gocron，测试中可以替换为手动触发的调度器
+type Scheduler interface {
+	// Schedule 按Cron表达式调度函数
+	Schedule(cronExpression string, fn func()) (ScheduledJob, error)
+	// Remove 从调度器中移除任务
+	Remove(job ScheduledJob)
+	// StartAsync 异步启动调度器
+	StartAsync()
+	// Stop 停止调度器
+	Stop()
+}
+
+// gocronScheduler 基于 gocron 的调度器实现
+type gocronScheduler struct {
+	scheduler *gocron.Scheduler
+}
+
+// NewGocronScheduler 创建基于 gocron 的调度器
+// 参数：
+// - loc: 调度器使用的时区
+// 返回：
+// - Scheduler: 调度器对象
+func NewGocronScheduler(loc *time.Location) Scheduler {
+	return &gocronScheduler{scheduler: gocron.NewScheduler(loc)}
+}
+
+// Schedule 使用标准5字段Cron表达式（分 时 日 月 周）调度函数
+func (s *gocronScheduler) Schedule(cronExpression string, fn func()) (ScheduledJob, error) {
+	return s.scheduler.Cron(cronExpression).Do(fn)
+}
+
+// Remove 从 gocron 中移除任务
+func (s *gocronScheduler) Remove(job ScheduledJob) {
+	if j, ok := job.(*gocron.Job); ok {
+		s.scheduler.RemoveByReference(j)
+	}
+}
+
+// StartAsync 异步启动 gocron
+func (s *gocronScheduler) StartAsync() {
+	s.scheduler.StartAsync()
+}
+
+// Stop 停止 gocron
+func (s *gocronScheduler) Stop() {
+	s.scheduler.Stop()
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/qor5/x/v3/sitemap"
 	"gorm.io/gorm"
 
+	"github.com/naokij/qor5boot/admin/recurring"
 	"github.com/naokij/qor5boot/models"
 	"github.com/qor5/admin/v3/role"
 )
@@ -52,9 +53,16 @@ func TestHandlerWorker(db *gorm.DB, u *models.User) http.Handler {
 
 func Router(db *gorm.DB) http.Handler {
 	c := NewConfig(db, true)
+	loginThrottler.startSweep(throttleSweepInterval)
 
 	mux := http.NewServeMux()
 	c.loginSessionBuilder.Mount(mux)
+	lb := c.loginSessionBuilder.GetLoginBuilder()
+	mountTOTP(mux, c.pb, lb.ViewHelper(), db, c.ab)
+	mountWebAuthn(mux, c.pb, lb.ViewHelper(), db)
+	mountAccessTokens(mux, c.pb, lb.ViewHelper(), db)
+	mux.HandleFunc(impersonateStopURL, impersonateStop(db, c.ab))
+	mountInvitation(mux, c.pb, lb.ViewHelper(), db, c.ab)
 	//	mux.Handle("/frontstyle.css", c.pb.GetWebBuilder().PacksHandler("text/css", web.ComponentsPack(`
 	// :host {
 	//	all: initial;
@@ -65,6 +73,10 @@ func Router(db *gorm.DB) http.Handler {
 	// `)))
 
 	mux.Handle("/", c.pb)
+	mux.Handle(samlPathPrefix, samlHandler())
+	if recurringJobManager != nil {
+		mux.Handle(recurring.ArtifactPathPrefix, recurringJobManager.ArtifactHandler())
+	}
 	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		w.Write(favicon)
 		return
@@ -91,8 +103,15 @@ func Router(db *gorm.DB) http.Handler {
 
 	cr := chi.NewRouter()
 	cr.Use(
-		c.loginSessionBuilder.Middleware(),
+		limitPasswordReset(c.pb.GetI18n()),
+		throttleLogin,
+		requireLoginCaptcha,
+		withAccessToken(db, skipPathPrefix(samlPathPrefix, skipPathPrefix(webauthnLoginPathPrefix, skipPathPrefix(invitationPageURL, c.loginSessionBuilder.Middleware())))),
+		touchLoginSession(lb, db),
+		withImpersonation(db),
 		withRoles(db),
+		requireTOTP(lb.ViewHelper(), lb.LogoutURL),
+		requirePasswordChange(),
 		securityMiddleware(),
 	)
 	cr.Mount("/", mux)
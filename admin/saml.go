@@ -0,0 +1,680 @@
+package admin
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/x/v3/login"
+	dsig "github.com/russellhaering/goxmldsig"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+/*
+SAML 2.0单点登录说明：
+
+只支持SAML的身份提供商（例如ADFS、Shibboleth、部分Okta和Azure AD租户）可以通过环境变量配置为SAML登录提供商，
+登录按钮和OAuth提供商一起显示在登录页面：
+
+1. 服务提供商（SP）配置
+   - SAML_SP_CERT_FILE、SAML_SP_KEY_FILE: SP的证书和私钥（PEM格式，RSA或ECDSA），用于签名AuthnRequest，
+     所有SAML提供商共用；未配置时不启用SAML登录
+   - SAML_PROVIDERS: 提供商标识，多个用逗号分隔，规则与OIDC提供商标识相同，不能与内置的或OIDC提供商重复
+   - 每个提供商的配置使用 SAML_<标识>_ 前缀，标识转为大写，连字符转为下划线，例如 SAML_CORP_IDP_METADATA_URL
+
+2. 提供商配置项
+   - IDP_METADATA_URL: 身份提供商的元数据地址，启动时获取
+   - IDP_METADATA_FILE: 身份提供商的元数据文件，没有元数据地址时使用
+   - ENTITY_ID: SP的实体ID，默认为SP元数据地址
+   - NAME_ID_FORMAT: 请求的NameID格式，可选persistent、email、unspecified，默认persistent；
+     NameID会保存为用户的OAuth UserID，不支持每次登录都会变化的transient格式
+   - IDENTIFIER: 与本地用户的OAuth标识匹配的字段，可选email、name、userid（即NameID），默认email
+   - EMAIL_ATTRIBUTES、NAME_ATTRIBUTES、COMPANY_ATTRIBUTES、GROUPS_ATTRIBUTES: 邮箱、姓名、公司和组使用的属性，
+     多个候选属性用逗号分隔，按属性的Name或FriendlyName匹配，使用第一个有值的属性；没有邮箱属性时，格式为邮箱的NameID作为邮箱
+   - JIT_PROVISIONING: 本地不存在的用户首次SAML登录时是否自动创建，默认false
+   - DEFAULT_ROLES: 自动创建的用户的默认角色，用逗号分隔
+   - LABEL、LOGO: 登录按钮的文字和图标，与OIDC提供商相同
+
+3. 在身份提供商中登记的地址
+   - SP元数据: <BASE_URL>/auth/saml/<标识>/metadata，包含实体ID、证书和断言消费地址，可以直接导入身份提供商
+   - 断言消费地址（ACS）: <BASE_URL>/auth/saml/<标识>/acs，使用HTTP-POST绑定
+
+4. 登录流程
+   - SAML提供商实现为goth提供商，登录、用户匹配、登录钩子和登录事件与OAuth登录共用同一流程
+   - 登录时生成带签名的AuthnRequest（HTTP-Redirect绑定），请求ID保存在goth的登录会话中
+   - 身份提供商跨站POST到ACS时浏览器不会带上登录会话Cookie，ACS通过同站的表单把响应转交给 /auth/saml/<标识>/complete，
+     在那里校验签名、有效期、受众、目标地址，以及响应是否对应登录会话中的请求ID，然后跳转到OAuth回调完成登录
+   - 响应只能在签发后的短时间内使用，收到响应后请求ID即从登录会话中清除；不支持身份提供商发起的登录
+   - 每次登录用断言中的姓名和公司更新本地用户，组属性可以通过 oauthUserGroups 获取
+*/
+
+// SAML配置的默认值
+const (
+	defaultSAMLEmailAttributes   = "email,mail,http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"
+	defaultSAMLNameAttributes    = "displayName,cn,http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name"
+	defaultSAMLCompanyAttributes = "company,o"
+	defaultSAMLGroupsAttributes  = "groups,memberOf,http://schemas.microsoft.com/ws/2008/06/identity/claims/groups"
+)
+
+// SAML端点
+const (
+	samlPathPrefix         = "/auth/saml/"
+	samlMetadataPath       = "metadata"
+	samlACSPath            = "acs"
+	samlCompletePath       = "complete"
+	samlLoginCallbackURL   = "/auth/callback?provider="
+	samlMetadataFetchLimit = 10 * time.Second
+)
+
+// goth用户RawData中保存的SAML属性
+const (
+	samlGroupsKey  = "groups"
+	samlCompanyKey = "company"
+)
+
+var (
+	samlSPCertFile = getEnvWithDefault("SAML_SP_CERT_FILE", "")
+	samlSPKeyFile  = getEnvWithDefault("SAML_SP_KEY_FILE", "")
+)
+
+// samlNameIDFormats 可以请求的NameID格式
+var samlNameIDFormats = map[string]saml.NameIDFormat{
+	"persistent":  saml.PersistentNameIDFormat,
+	"email":       saml.EmailAddressNameIDFormat,
+	"unspecified": saml.UnspecifiedNameIDFormat,
+}
+
+// samlProviderConfig SAML提供商配置
+type samlProviderConfig struct {
+	Key               string                // 提供商标识
+	IDPMetadataURL    string                // 身份提供商的元数据地址
+	IDPMetadataFile   string                // 身份提供商的元数据文件
+	EntityID          string                // SP的实体ID，为空时使用SP元数据地址
+	NameIDFormat      saml.NameIDFormat     // 请求的NameID格式
+	Identifier        login.OAuthIdentifier // 与本地用户的OAuth标识匹配的字段
+	EmailAttributes   []string              // 邮箱的候选属性
+	NameAttributes    []string              // 姓名的候选属性
+	CompanyAttributes []string              // 公司的候选属性
+	GroupsAttributes  []string              // 组的候选属性
+	JITProvisioning   bool                  // 是否自动创建本地不存在的用户
+	DefaultRoles      []string              // 自动创建的用户的默认角色
+	Label             string                // 登录按钮的文字
+	Logo              string                // 登录按钮的图标，图片地址或SVG代码
+}
+
+// samlConfigs 从环境变量加载的SAML提供商配置
+var samlConfigs = samlEnvConfigs(getEnvWithDefault("SAML_PROVIDERS", ""))
+
+// samlEnvConfigs 按提供商标识列表从环境变量加载SAML提供商配置
+// 参数：
+// - keys: 用逗号分隔的提供商标识
+// 返回：
+// - []samlProviderConfig: 提供商配置，创建登录提供商时再检查配置是否完整
+func samlEnvConfigs(keys string) []samlProviderConfig {
+	var configs []samlProviderConfig
+	for _, key := range splitList(keys) {
+		prefix := "SAML_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_")) + "_"
+		env := func(name, defaultValue string) string {
+			return strings.TrimSpace(getEnvWithDefault(prefix+name, defaultValue))
+		}
+
+		nameIDFormat, ok := samlNameIDFormats[strings.ToLower(env("NAME_ID_FORMAT", "persistent"))]
+		if !ok {
+			log.Printf("警告: %sNAME_ID_FORMAT无效，使用persistent", prefix)
+			nameIDFormat = saml.PersistentNameIDFormat
+		}
+		identifier, ok := oidcIdentifiers[strings.ToLower(env("IDENTIFIER", "email"))]
+		if !ok || identifier == login.OAuthIdentifierNickName {
+			log.Printf("警告: %sIDENTIFIER无效，使用email", prefix)
+			identifier = login.OAuthIdentifierEmail
+		}
+		configs = append(configs, samlProviderConfig{
+			Key:               key,
+			IDPMetadataURL:    env("IDP_METADATA_URL", ""),
+			IDPMetadataFile:   env("IDP_METADATA_FILE", ""),
+			EntityID:          env("ENTITY_ID", ""),
+			NameIDFormat:      nameIDFormat,
+			Identifier:        identifier,
+			EmailAttributes:   splitList(env("EMAIL_ATTRIBUTES", defaultSAMLEmailAttributes)),
+			NameAttributes:    splitList(env("NAME_ATTRIBUTES", defaultSAMLNameAttributes)),
+			CompanyAttributes: splitList(env("COMPANY_ATTRIBUTES", defaultSAMLCompanyAttributes)),
+			GroupsAttributes:  splitList(env("GROUPS_ATTRIBUTES", defaultSAMLGroupsAttributes)),
+			JITProvisioning:   env("JIT_PROVISIONING", "false") == "true",
+			DefaultRoles:      splitList(env("DEFAULT_ROLES", "")),
+			Label:             env("LABEL", "Sign in with "+key),
+			Logo:              env("LOGO", ""),
+		})
+	}
+	return configs
+}
+
+// validate 检查提供商配置是否完整
+func (c *samlProviderConfig) validate() error {
+	if !oidcKeyPattern.MatchString(c.Key) {
+		return fmt.Errorf("SAML提供商标识只能包含小写字母、数字和连字符: %q", c.Key)
+	}
+	if slices.Contains(models.OAuthProviders, c.Key) {
+		return fmt.Errorf("SAML提供商标识与内置的提供商重复: %s", c.Key)
+	}
+	if _, ok := oidcConfig(c.Key); ok {
+		return fmt.Errorf("SAML提供商标识与OIDC提供商重复: %s", c.Key)
+	}
+	if c.IDPMetadataURL == "" && c.IDPMetadataFile == "" {
+		return fmt.Errorf("SAML提供商 %s 缺少IDP_METADATA_URL或IDP_METADATA_FILE", c.Key)
+	}
+	return nil
+}
+
+// idpMetadata 从元数据地址或文件加载身份提供商的元数据
+func (c *samlProviderConfig) idpMetadata() (*saml.EntityDescriptor, error) {
+	if c.IDPMetadataURL == "" {
+		data, err := os.ReadFile(c.IDPMetadataFile)
+		if err != nil {
+			return nil, err
+		}
+		return samlsp.ParseMetadata(data)
+	}
+
+	u, err := url.Parse(c.IDPMetadataURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), samlMetadataFetchLimit)
+	defer cancel()
+	return samlsp.FetchMetadata(ctx, http.DefaultClient, *u)
+}
+
+// samlKeyPair SP签名AuthnRequest使用的证书和私钥
+type samlKeyPair struct {
+	Key         crypto.Signer
+	Certificate *x509.Certificate
+}
+
+// loadSAMLKeyPair 加载SP的证书和私钥
+// 参数：
+// - certFile: PEM格式的证书文件
+// - keyFile: PEM格式的私钥文件，支持RSA和ECDSA
+// 返回：
+// - *samlKeyPair: 证书和私钥
+// - error: 文件无法读取、证书与私钥不匹配或私钥类型不支持时的错误信息
+func loadSAMLKeyPair(certFile, keyFile string) (*samlKeyPair, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	switch key := pair.PrivateKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return &samlKeyPair{Key: key.(crypto.Signer), Certificate: cert}, nil
+	}
+	return nil, fmt.Errorf("SAML私钥必须是RSA或ECDSA私钥")
+}
+
+// signatureMethod 返回与私钥类型一致的签名算法
+func (kp *samlKeyPair) signatureMethod() string {
+	if _, ok := kp.Key.(*ecdsa.PrivateKey); ok {
+		return dsig.ECDSASHA256SignatureMethod
+	}
+	return dsig.RSASHA256SignatureMethod
+}
+
+// samlProvider 把SAML服务提供商包装为goth提供商，复用登录模块的OAuth登录流程
+type samlProvider struct {
+	name string
+	cfg  samlProviderConfig
+	sp   *saml.ServiceProvider
+}
+
+// newSAMLProvider 加载身份提供商的元数据并创建登录提供商
+// 参数：
+// - cfg: 提供商配置
+// - kp: SP的证书和私钥
+// - base: 站点地址，用于生成SP元数据和断言消费地址
+// 返回：
+// - *login.Provider: 登录提供商
+// - error: 配置不完整、无法加载元数据或身份提供商不支持HTTP-Redirect绑定时的错误信息
+func newSAMLProvider(cfg samlProviderConfig, kp *samlKeyPair, base string) (*login.Provider, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	md, err := cfg.idpMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("加载SAML提供商 %s 的元数据失败: %w", cfg.Key, err)
+	}
+	if len(md.IDPSSODescriptors) == 0 {
+		return nil, fmt.Errorf("SAML提供商 %s 的元数据中没有IDPSSODescriptor", cfg.Key)
+	}
+
+	endpoint := strings.TrimRight(base, "/") + samlPathPrefix + cfg.Key + "/"
+	metadataURL, err := url.Parse(endpoint + samlMetadataPath)
+	if err != nil {
+		return nil, err
+	}
+	acsURL, _ := url.Parse(endpoint + samlACSPath)
+
+	sp := &saml.ServiceProvider{
+		EntityID:          cfg.EntityID,
+		Key:               kp.Key,
+		Certificate:       kp.Certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       md,
+		AuthnNameIDFormat: cfg.NameIDFormat,
+		SignatureMethod:   kp.signatureMethod(),
+	}
+	if sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return nil, fmt.Errorf("SAML提供商 %s 不支持HTTP-Redirect绑定的单点登录", cfg.Key)
+	}
+
+	return &login.Provider{
+		Goth: &samlProvider{name: cfg.Key, cfg: cfg, sp: sp},
+		Key:  cfg.Key,
+		Text: cfg.Label,
+		Logo: loginProviderLogo(cfg.Logo),
+	}, nil
+}
+
+// samlLoginProviders 创建所有配置的SAML登录提供商，无法创建的提供商会被跳过
+func samlLoginProviders() []*login.Provider {
+	if len(samlConfigs) == 0 {
+		return nil
+	}
+	kp, err := loadSAMLKeyPair(samlSPCertFile, samlSPKeyFile)
+	if err != nil {
+		log.Printf("警告: 无法加载SAML_SP_CERT_FILE和SAML_SP_KEY_FILE，不启用SAML登录: %v", err)
+		return nil
+	}
+
+	var providers []*login.Provider
+	for i, cfg := range samlConfigs {
+		if slices.ContainsFunc(samlConfigs[:i], func(c samlProviderConfig) bool { return c.Key == cfg.Key }) {
+			log.Printf("警告: 跳过重复的SAML提供商 %s", cfg.Key)
+			continue
+		}
+		p, err := newSAMLProvider(cfg, kp, baseURL)
+		if err != nil {
+			log.Printf("警告: 跳过SAML提供商 %s: %v", cfg.Key, err)
+			continue
+		}
+		log.Printf("启用SAML提供商 %s: %s", cfg.Key, p.Goth.(*samlProvider).sp.IDPMetadata.EntityID)
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// samlConfig 按提供商标识查找SAML提供商配置
+func samlConfig(key string) (samlProviderConfig, bool) {
+	for _, cfg := range samlConfigs {
+		if cfg.Key == key {
+			return cfg, true
+		}
+	}
+	return samlProviderConfig{}, false
+}
+
+// Name 返回提供商标识
+func (p *samlProvider) Name() string {
+	return p.name
+}
+
+// SetName 设置提供商标识
+func (p *samlProvider) SetName(name string) {
+	p.name = name
+}
+
+// Debug 实现goth.Provider，SAML提供商的日志统一使用认证日志
+func (p *samlProvider) Debug(bool) {}
+
+// BeginAuth 生成带签名的AuthnRequest，请求ID保存在登录会话中
+// SAML使用请求ID防止伪造的响应，不使用goth的state参数
+func (p *samlProvider) BeginAuth(state string) (goth.Session, error) {
+	req, err := p.sp.MakeAuthenticationRequest(p.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return nil, err
+	}
+	u, err := req.Redirect("", p.sp)
+	if err != nil {
+		return nil, err
+	}
+	return &samlSession{AuthURL: u.String(), RequestID: req.ID}, nil
+}
+
+// UnmarshalSession 从登录会话中恢复SAML会话
+func (p *samlProvider) UnmarshalSession(data string) (goth.Session, error) {
+	s := &samlSession{}
+	err := json.Unmarshal([]byte(data), s)
+	return s, err
+}
+
+// FetchUser 返回已验证的断言中的用户，断言尚未验证时返回错误
+func (p *samlProvider) FetchUser(session goth.Session) (goth.User, error) {
+	s := session.(*samlSession)
+	user := goth.User{Provider: p.name}
+	if s.NameID == "" {
+		return user, errors.New("SAML断言尚未验证")
+	}
+
+	groups := make([]interface{}, 0, len(s.Groups))
+	for _, g := range s.Groups {
+		groups = append(groups, g)
+	}
+	user.UserID = s.NameID
+	user.Email = s.Email
+	user.Name = s.Name
+	user.RawData = map[string]interface{}{
+		samlGroupsKey:  groups,
+		samlCompanyKey: s.Company,
+	}
+	return user, nil
+}
+
+// RefreshTokenAvailable SAML没有刷新令牌
+func (p *samlProvider) RefreshTokenAvailable() bool {
+	return false
+}
+
+// RefreshToken SAML没有刷新令牌
+func (p *samlProvider) RefreshToken(string) (*oauth2.Token, error) {
+	return nil, errors.New("SAML提供商不支持刷新令牌")
+}
+
+// samlSession 保存在goth登录会话中的SAML登录状态
+type samlSession struct {
+	AuthURL   string   // 身份提供商的登录地址，包含签名的AuthnRequest
+	RequestID string   // 等待响应的AuthnRequest的ID，收到响应后清空
+	NameID    string   `json:",omitempty"` // 已验证的断言中的NameID
+	Email     string   `json:",omitempty"`
+	Name      string   `json:",omitempty"`
+	Company   string   `json:",omitempty"`
+	Groups    []string `json:",omitempty"`
+	Error     string   `json:",omitempty"` // 响应验证失败的原因
+}
+
+// GetAuthURL 返回身份提供商的登录地址
+func (s *samlSession) GetAuthURL() (string, error) {
+	if s.AuthURL == "" {
+		return "", errors.New("缺少SAML登录地址")
+	}
+	return s.AuthURL, nil
+}
+
+// Marshal 序列化SAML会话
+func (s *samlSession) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Authorize 在 FetchUser 失败后由gothic调用，返回响应验证失败的原因
+func (s *samlSession) Authorize(goth.Provider, goth.Params) (string, error) {
+	if s.Error != "" {
+		return "", errors.New(s.Error)
+	}
+	return "", errors.New("没有收到SAML响应")
+}
+
+// acceptResponse 验证身份提供商返回的SAML响应，并把断言中的用户属性保存到会话中
+// 参数：
+// - s: 登录会话中的SAML会话，响应必须对应其中的请求ID
+// - encoded: Base64编码的SAMLResponse
+// 返回：
+// - error: 响应无效时的错误信息，详细原因输出到认证日志
+func (p *samlProvider) acceptResponse(s *samlSession, encoded string) error {
+	possibleRequestIDs := []string{s.RequestID}
+	// 请求ID只能使用一次
+	s.RequestID = ""
+	if possibleRequestIDs[0] == "" {
+		return errors.New("SAML请求已失效")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("SAML响应格式错误: %w", err)
+	}
+	assertion, err := p.sp.ParseXMLResponse(raw, possibleRequestIDs, p.sp.AcsURL)
+	if err != nil {
+		var ire *saml.InvalidResponseError
+		if errors.As(err, &ire) {
+			err = ire.PrivateErr
+		}
+		models.AuthLog().Warn("SAML响应验证失败", "provider", p.name, "error", err)
+		return errors.New("SAML响应验证失败")
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return errors.New("SAML断言中没有NameID")
+	}
+
+	s.NameID = assertion.Subject.NameID.Value
+	s.Email = firstSAMLAttribute(assertion, p.cfg.EmailAttributes)
+	if s.Email == "" && strings.Contains(s.NameID, "@") {
+		s.Email = s.NameID
+	}
+	s.Name = firstSAMLAttribute(assertion, p.cfg.NameAttributes)
+	s.Company = firstSAMLAttribute(assertion, p.cfg.CompanyAttributes)
+	s.Groups = samlAttributeValues(assertion, p.cfg.GroupsAttributes)
+	models.AuthLog().Debug("SAML响应验证成功", "provider", p.name, "account", models.MaskAccount(s.Email), "groups", len(s.Groups))
+	return nil
+}
+
+// samlAttributeValues 按候选属性的顺序返回第一个有值的属性的全部值
+// 属性按Name或FriendlyName匹配，不区分大小写
+func samlAttributeValues(assertion *saml.Assertion, names []string) []string {
+	for _, name := range names {
+		for _, stmt := range assertion.AttributeStatements {
+			for _, attr := range stmt.Attributes {
+				if !strings.EqualFold(attr.Name, name) && !strings.EqualFold(attr.FriendlyName, name) {
+					continue
+				}
+				var values []string
+				for _, v := range attr.Values {
+					if v.Value != "" {
+						values = append(values, v.Value)
+					}
+				}
+				if len(values) > 0 {
+					return values
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// firstSAMLAttribute 返回第一个有值的候选属性的第一个值
+func firstSAMLAttribute(assertion *saml.Assertion, names []string) string {
+	if values := samlAttributeValues(assertion, names); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// samlRelayForm 把跨站POST到ACS的SAML响应通过同站的表单转交给complete端点，使浏览器带上登录会话Cookie
+var samlRelayForm = template.Must(template.New("saml-relay").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="{{.Action}}" id="saml-relay">
+<input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}">
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+<script>document.getElementById("saml-relay").submit();</script>
+</body></html>`))
+
+// samlHandler 处理 /auth/saml/<标识>/ 下的SP元数据、ACS和complete端点
+// 这些端点在登录之前访问，需要跳过登录中间件
+func samlHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, samlPathPrefix), "/")
+		gp, err := goth.GetProvider(key)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		p, ok := gp.(*samlProvider)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch action {
+		case samlMetadataPath:
+			p.serveMetadata(w, r)
+		case samlACSPath:
+			p.serveACS(w, r)
+		case samlCompletePath:
+			p.serveComplete(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// serveMetadata 输出SP元数据，供身份提供商导入
+func (p *samlProvider) serveMetadata(w http.ResponseWriter, r *http.Request) {
+	buf, err := xml.MarshalIndent(p.sp.Metadata(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(buf)
+}
+
+// serveACS 接收身份提供商POST的SAML响应，转交给complete端点
+func (p *samlProvider) serveACS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	samlRelayForm.Execute(w, map[string]string{
+		"Action":       samlPathPrefix + p.name + "/" + samlCompletePath,
+		"SAMLResponse": r.PostFormValue("SAMLResponse"),
+	})
+}
+
+// serveComplete 验证SAML响应并保存到登录会话中，然后跳转到OAuth回调完成登录
+// 验证失败时也跳转到OAuth回调，由登录模块统一处理失败提示和登录事件
+func (p *samlProvider) serveComplete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	callbackURL := samlLoginCallbackURL + url.QueryEscape(p.name)
+
+	value, err := gothic.GetFromSession(p.name, r)
+	if err != nil {
+		models.AuthLog().Warn("SAML登录会话不存在或已过期", "provider", p.name)
+		http.Redirect(w, r, callbackURL, http.StatusFound)
+		return
+	}
+	sess, err := p.UnmarshalSession(value)
+	if err != nil {
+		http.Redirect(w, r, callbackURL, http.StatusFound)
+		return
+	}
+	s := sess.(*samlSession)
+	if err := p.acceptResponse(s, r.PostFormValue("SAMLResponse")); err != nil {
+		s.Error = err.Error()
+	}
+	if err := gothic.StoreInSession(p.name, s.Marshal(), r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, callbackURL, http.StatusFound)
+}
+
+// syncSAMLUser 在SAML登录完成后，用断言中的属性更新或自动创建本地用户
+// 注册为 AfterOAuthComplete 钩子，在登录模块按OAuth标识查找用户之前执行
+func syncSAMLUser(db *gorm.DB) func(in login.HookFunc) login.HookFunc {
+	return func(in login.HookFunc) login.HookFunc {
+		return func(r *http.Request, user interface{}, extraVals ...interface{}) error {
+			if err := in(r, user, extraVals...); err != nil {
+				return err
+			}
+			ouser, ok := user.(goth.User)
+			if !ok {
+				return nil
+			}
+			cfg, ok := samlConfig(ouser.Provider)
+			if !ok {
+				return nil
+			}
+			return applySAMLUser(db, &cfg, ouser)
+		}
+	}
+}
+
+// applySAMLUser 把SAML用户的属性映射到本地用户
+// 参数：
+// - db: 数据库连接
+// - cfg: SAML提供商配置
+// - ouser: 由已验证的断言生成的goth用户
+// 返回：
+// - error: 查询、更新或创建用户失败时的错误信息；本地不存在且不自动创建的用户由登录模块报告用户不存在
+func applySAMLUser(db *gorm.DB, cfg *samlProviderConfig, ouser goth.User) error {
+	identifier := oauthIdentifierValue(cfg.Identifier, ouser)
+	company, _ := ouser.RawData[samlCompanyKey].(string)
+
+	user, err := findOAuthUser(db, cfg.Key, ouser.UserID, identifier)
+	switch {
+	case err == nil:
+		updates := map[string]interface{}{}
+		if ouser.Name != "" && ouser.Name != user.Name {
+			updates["name"] = ouser.Name
+		}
+		if company != "" && company != user.Company {
+			updates["company"] = company
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := db.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		models.AuthLog().Info("已按SAML属性更新用户", "provider", cfg.Key, "account", models.MaskAccount(identifier))
+		return nil
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	case !cfg.JITProvisioning || identifier == "":
+		return nil
+	}
+
+	user = &models.User{
+		Name:    cmp.Or(ouser.Name, identifier),
+		Company: company,
+		Status:  models.StatusActive,
+	}
+	user.OAuthProvider = cfg.Key
+	user.OAuthIdentifier = identifier
+	user.OAuthUserID = ouser.UserID
+	if err := createUserWithRoles(db, user, cfg.DefaultRoles); err != nil {
+		return err
+	}
+	models.AuthLog().Info("已自动创建SAML用户", "provider", cfg.Key, "account", models.MaskAccount(identifier), "roles", cfg.DefaultRoles)
+	return nil
+}
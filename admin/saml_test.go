@@ -0,0 +1,394 @@
+package admin
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/glebarez/sqlite"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	"github.com/naokij/qor5boot/models"
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/x/v3/login"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB 创建内存中的SQLite数据库，包含用户和角色表
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&models.User{}, &role.Role{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestSAMLKeyPair 生成自签名证书，写入PEM文件后用 loadSAMLKeyPair 加载
+func newTestSAMLKeyPair(t *testing.T, cn string) *samlKeyPair {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := loadSAMLKeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("加载证书和私钥失败: %v", err)
+	}
+	return kp
+}
+
+// testSAMLIDP 进程内的SAML身份提供商，使用与SP相同的库实现
+type testSAMLIDP struct {
+	*saml.IdentityProvider
+	spMetadata *saml.EntityDescriptor
+}
+
+// GetServiceProvider 只接受测试中创建的SP
+func (idp *testSAMLIDP) GetServiceProvider(r *http.Request, id string) (*saml.EntityDescriptor, error) {
+	if idp.spMetadata == nil || idp.spMetadata.EntityID != id {
+		return nil, os.ErrNotExist
+	}
+	return idp.spMetadata, nil
+}
+
+// startTestSAMLIDP 启动提供元数据的测试身份提供商
+func startTestSAMLIDP(t *testing.T) (*testSAMLIDP, *httptest.Server) {
+	t.Helper()
+	kp := newTestSAMLKeyPair(t, "idp")
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	metadataURL, _ := url.Parse(srv.URL + "/metadata")
+	ssoURL, _ := url.Parse(srv.URL + "/sso")
+	idp := &testSAMLIDP{}
+	idp.IdentityProvider = &saml.IdentityProvider{
+		Key:                     kp.Key,
+		Certificate:             kp.Certificate,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: idp,
+	}
+	mux.HandleFunc("/metadata", idp.ServeMetadata)
+	return idp, srv
+}
+
+// newTestSAMLProvider 按环境变量配置创建连接测试身份提供商的SAML提供商
+func newTestSAMLProvider(t *testing.T, idp *testSAMLIDP, idpURL string) *samlProvider {
+	t.Helper()
+	t.Setenv("SAML_CORP_IDP_METADATA_URL", idpURL+"/metadata")
+	configs := samlEnvConfigs("corp")
+	useTestSAMLConfigs(t, configs...)
+
+	p, err := newSAMLProvider(configs[0], newTestSAMLKeyPair(t, "sp"), "https://app.example.com")
+	if err != nil {
+		t.Fatalf("创建SAML提供商失败: %v", err)
+	}
+	sp := p.Goth.(*samlProvider)
+	idp.spMetadata = sp.sp.Metadata()
+	goth.UseProviders(sp)
+	// 登录会话保存在gothic的Cookie中，测试环境没有SESSION_SECRET
+	oldStore := gothic.Store
+	gothic.Store = sessions.NewCookieStore([]byte("test-session-secret"))
+	t.Cleanup(func() {
+		goth.ClearProviders()
+		gothic.Store = oldStore
+	})
+	return sp
+}
+
+// useTestSAMLConfigs 在测试期间替换SAML提供商配置，测试结束后恢复
+func useTestSAMLConfigs(t *testing.T, configs ...samlProviderConfig) {
+	t.Helper()
+	old := samlConfigs
+	samlConfigs = configs
+	t.Cleanup(func() { samlConfigs = old })
+}
+
+// beginSAMLLogin 开始SAML登录，返回身份提供商的登录地址和登录会话Cookie
+func beginSAMLLogin(t *testing.T) (string, []*http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	authURL, err := gothic.GetAuthURL(w, httptest.NewRequest(http.MethodGet, "/auth/begin?provider=corp", nil))
+	if err != nil {
+		t.Fatalf("开始登录失败: %v", err)
+	}
+	return authURL, w.Result().Cookies()
+}
+
+// idpResponse 由测试身份提供商处理登录请求，返回POST到ACS的SAMLResponse
+func idpResponse(t *testing.T, idp *testSAMLIDP, authURL string, session *saml.Session) saml.IdpAuthnRequestForm {
+	t.Helper()
+	req, err := saml.NewIdpAuthnRequest(idp.IdentityProvider, httptest.NewRequest(http.MethodGet, authURL, nil))
+	if err != nil {
+		t.Fatalf("解析登录请求失败: %v", err)
+	}
+	if err := req.Validate(); err != nil {
+		t.Fatalf("登录请求无效: %v", err)
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatalf("生成断言失败: %v", err)
+	}
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatalf("生成响应失败: %v", err)
+	}
+	return form
+}
+
+// postSAML 以表单POST调用SAML端点
+func postSAML(path string, values url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	samlHandler().ServeHTTP(w, r)
+	return w
+}
+
+// completeSAMLLogin 把SAML响应交给complete端点，然后按OAuth回调完成登录
+func completeSAMLLogin(t *testing.T, samlResponse string, cookies []*http.Cookie) (goth.User, error) {
+	t.Helper()
+	w := postSAML("/auth/saml/corp/complete", url.Values{"SAMLResponse": {samlResponse}}, cookies)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/auth/callback?provider=corp" {
+		t.Fatalf("期望跳转到OAuth回调，实际 %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?provider=corp", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return gothic.CompleteUserAuth(httptest.NewRecorder(), r)
+}
+
+func TestSAMLEnvConfigs(t *testing.T) {
+	t.Setenv("SAML_CORP_SSO_IDP_METADATA_FILE", "/etc/idp.xml")
+	t.Setenv("SAML_CORP_SSO_NAME_ID_FORMAT", "email")
+	t.Setenv("SAML_CORP_SSO_IDENTIFIER", "userid")
+	t.Setenv("SAML_CORP_SSO_GROUPS_ATTRIBUTES", "memberOf")
+	t.Setenv("SAML_CORP_SSO_JIT_PROVISIONING", "true")
+	t.Setenv("SAML_CORP_SSO_DEFAULT_ROLES", "Viewer, Editor")
+	t.Setenv("SAML_OTHER_NAME_ID_FORMAT", "transient")
+
+	configs := samlEnvConfigs("corp-sso, other")
+	if len(configs) != 2 {
+		t.Fatalf("期望2个配置，实际 %d", len(configs))
+	}
+	c := configs[0]
+	if c.IDPMetadataFile != "/etc/idp.xml" || c.NameIDFormat != saml.EmailAddressNameIDFormat || c.Identifier != login.OAuthIdentifierUserID {
+		t.Errorf("配置解析错误: %+v", c)
+	}
+	if !slices.Equal(c.GroupsAttributes, []string{"memberOf"}) || !c.JITProvisioning || !slices.Equal(c.DefaultRoles, []string{"Viewer", "Editor"}) {
+		t.Errorf("属性映射或自动开通配置错误: %+v", c)
+	}
+
+	// 不支持的NameID格式使用默认值，未配置的项使用默认值
+	o := configs[1]
+	if o.NameIDFormat != saml.PersistentNameIDFormat || o.Identifier != login.OAuthIdentifierEmail || o.Label != "Sign in with other" {
+		t.Errorf("默认配置错误: %+v", o)
+	}
+	if err := o.validate(); err == nil {
+		t.Error("缺少元数据时应该返回错误")
+	}
+	if err := (&samlProviderConfig{Key: "google", IDPMetadataFile: "x"}).validate(); err == nil {
+		t.Error("与内置提供商重复的标识应该返回错误")
+	}
+}
+
+func TestSAMLLogin(t *testing.T) {
+	idp, srv := startTestSAMLIDP(t)
+	p := newTestSAMLProvider(t, idp, srv.URL)
+
+	authURL, cookies := beginSAMLLogin(t)
+	if !strings.HasPrefix(authURL, srv.URL+"/sso?SAMLRequest=") {
+		t.Fatalf("登录地址错误: %s", authURL)
+	}
+
+	// AuthnRequest使用SP的私钥签名
+	u, _ := url.Parse(authURL)
+	signed, encodedSig, ok := strings.Cut(u.RawQuery, "&Signature=")
+	if !ok || u.Query().Get("SigAlg") != p.sp.SignatureMethod {
+		t.Fatalf("登录请求没有签名: %s", u.RawQuery)
+	}
+	sig, _ := url.QueryUnescape(encodedSig)
+	rawSig, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(signed))
+	if err := rsa.VerifyPKCS1v15(p.sp.Certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], rawSig); err != nil {
+		t.Errorf("登录请求签名无效: %v", err)
+	}
+
+	form := idpResponse(t, idp, authURL, &saml.Session{
+		NameID:         "u-1001",
+		NameIDFormat:   string(saml.PersistentNameIDFormat),
+		UserEmail:      "alice@example.com",
+		UserCommonName: "Alice",
+		CustomAttributes: []saml.Attribute{
+			{Name: "groups", Values: []saml.AttributeValue{{Type: "xs:string", Value: "admins"}, {Type: "xs:string", Value: "staff"}}},
+			{FriendlyName: "company", Name: "urn:example:company", Values: []saml.AttributeValue{{Type: "xs:string", Value: "Example Inc"}}},
+		},
+	})
+	if form.URL != "https://app.example.com/auth/saml/corp/acs" {
+		t.Fatalf("ACS地址错误: %s", form.URL)
+	}
+
+	// ACS把响应转交给同站的complete端点
+	w := postSAML("/auth/saml/corp/acs", url.Values{"SAMLResponse": {form.SAMLResponse}}, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `action="/auth/saml/corp/complete"`) {
+		t.Fatalf("ACS应该输出转交表单，实际 %d %s", w.Code, w.Body.String())
+	}
+
+	ouser, err := completeSAMLLogin(t, form.SAMLResponse, cookies)
+	if err != nil {
+		t.Fatalf("完成登录失败: %v", err)
+	}
+	if ouser.Provider != "corp" || ouser.UserID != "u-1001" || ouser.Email != "alice@example.com" || ouser.Name != "Alice" {
+		t.Errorf("用户属性映射错误: %+v", ouser)
+	}
+	if got := oauthUserGroups(ouser); !slices.Equal(got, []string{"admins", "staff"}) {
+		t.Errorf("组属性错误: %v", got)
+	}
+	if got := ouser.RawData[samlCompanyKey]; got != "Example Inc" {
+		t.Errorf("公司属性错误: %v", got)
+	}
+
+	// 同一个响应不能在新的登录会话中使用
+	_, cookies = beginSAMLLogin(t)
+	if _, err := completeSAMLLogin(t, form.SAMLResponse, cookies); err == nil {
+		t.Error("不属于当前登录会话的响应应该被拒绝")
+	}
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	idp, srv := startTestSAMLIDP(t)
+	newTestSAMLProvider(t, idp, srv.URL)
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/saml/corp/metadata", nil)
+	w := httptest.NewRecorder()
+	samlHandler().ServeHTTP(w, r)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `entityID="https://app.example.com/auth/saml/corp/metadata"`) {
+		t.Fatalf("SP元数据错误: %d %s", w.Code, body)
+	}
+	if !strings.Contains(body, `AuthnRequestsSigned="true"`) || !strings.Contains(body, "https://app.example.com/auth/saml/corp/acs") {
+		t.Errorf("SP元数据缺少签名声明或ACS地址: %s", body)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/auth/saml/unknown/metadata", nil)
+	w = httptest.NewRecorder()
+	samlHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("未配置的提供商应该返回404，实际 %d", w.Code)
+	}
+}
+
+func TestApplySAMLUser(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Create(&role.Role{Name: models.RoleViewer}).Error; err != nil {
+		t.Fatal(err)
+	}
+	cfg := &samlProviderConfig{Key: "corp", Identifier: login.OAuthIdentifierEmail, DefaultRoles: []string{models.RoleViewer}}
+	ouser := goth.User{
+		Provider: "corp",
+		UserID:   "u-1001",
+		Email:    "alice@example.com",
+		Name:     "Alice",
+		RawData:  map[string]interface{}{samlCompanyKey: "Example Inc"},
+	}
+
+	// 未开启自动开通时不创建用户
+	if err := applySAMLUser(db, cfg, ouser); err != nil {
+		t.Fatalf("映射用户失败: %v", err)
+	}
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("未开启自动开通时不应该创建用户，实际 %d 个", count)
+	}
+
+	cfg.JITProvisioning = true
+	if err := applySAMLUser(db, cfg, ouser); err != nil {
+		t.Fatalf("自动开通用户失败: %v", err)
+	}
+	var user models.User
+	if err := db.Preload("Roles").Where("o_auth_identifier = ?", "alice@example.com").First(&user).Error; err != nil {
+		t.Fatalf("查询用户失败: %v", err)
+	}
+	if user.OAuthProvider != "corp" || user.OAuthUserID != "u-1001" || user.Name != "Alice" || user.Company != "Example Inc" || user.Status != models.StatusActive {
+		t.Errorf("自动开通的用户属性错误: %+v", user)
+	}
+	if len(user.Roles) != 1 || user.Roles[0].Name != models.RoleViewer {
+		t.Errorf("期望授予默认角色，实际 %v", user.Roles)
+	}
+
+	// 再次登录时按断言更新姓名和公司
+	ouser.Name = "Alice Liddell"
+	ouser.RawData[samlCompanyKey] = "Wonderland"
+	if err := applySAMLUser(db, cfg, ouser); err != nil {
+		t.Fatalf("更新用户失败: %v", err)
+	}
+	db.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("不应该重复创建用户，实际 %d 个", count)
+	}
+	db.First(&user, user.ID)
+	if user.Name != "Alice Liddell" || user.Company != "Wonderland" {
+		t.Errorf("用户属性没有更新: %+v", user)
+	}
+}
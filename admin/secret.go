@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 加密保存在数据库中的敏感配置（例如LDAP服务账号密码）使用的密钥，默认使用LOGIN_SECRET
+// 修改密钥后已保存的敏感配置无法解密，需要重新填写
+var settingsSecret = getEnvWithDefault("SETTINGS_SECRET", loginSecret)
+
+// errNoSettingsSecret 表示没有配置加密密钥
+var errNoSettingsSecret = errors.New("未配置SETTINGS_SECRET或LOGIN_SECRET，无法加密敏感配置")
+
+// 加密的用途，不同用途使用从SETTINGS_SECRET派生的不同密钥，一种用途的密文不能在其他用途中解密
+const (
+	// secretPurposeSettings 数据库中的敏感配置，沿用直接由SETTINGS_SECRET计算的密钥，已保存的配置不需要重新填写
+	secretPurposeSettings = ""
+	// secretPurposeWebAuthn 保存在Cookie中的WebAuthn注册或验证状态
+	secretPurposeWebAuthn = "webauthn-ceremony"
+)
+
+// settingsCipher 使用为该用途派生的密钥创建AES-256-GCM加密器
+func settingsCipher(purpose string) (cipher.AEAD, error) {
+	if settingsSecret == "" {
+		return nil, errNoSettingsSecret
+	}
+	key := sha256.Sum256([]byte(settingsSecret))
+	if purpose != secretPurposeSettings {
+		mac := hmac.New(sha256.New, []byte(settingsSecret))
+		mac.Write([]byte(purpose))
+		copy(key[:], mac.Sum(nil))
+	}
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSetting 加密敏感配置
+// 参数：
+// - plain: 明文，为空时不加密直接返回空字符串
+// 返回：
+// - string: base64编码的随机数和密文
+// - error: 没有配置密钥或加密失败时的错误信息
+func encryptSetting(plain string) (string, error) {
+	return encryptSecret(secretPurposeSettings, plain)
+}
+
+// decryptSetting 解密由 encryptSetting 加密的敏感配置
+// 参数：
+// - encrypted: 密文，为空时直接返回空字符串
+// 返回：
+// - string: 明文
+// - error: 没有配置密钥、密文格式错误或密钥不匹配时的错误信息
+func decryptSetting(encrypted string) (string, error) {
+	return decryptSecret(secretPurposeSettings, encrypted)
+}
+
+// encryptSecret 使用该用途的密钥加密，明文为空时直接返回空字符串
+func encryptSecret(purpose, plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	aead, err := settingsCipher(purpose)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret 使用该用途的密钥解密由 encryptSecret 加密的密文，密文为空时直接返回空字符串
+func decryptSecret(purpose, encrypted string) (string, error) {
+	if encrypted == "" {
+		return "", nil
+	}
+	aead, err := settingsCipher(purpose)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %w", err)
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("密文格式错误: 长度不足")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("解密失败，加密密钥可能已修改")
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"errors"
+	"testing"
+)
+
+// useSettingsSecret 在测试期间替换加密密钥，测试结束后恢复
+func useSettingsSecret(t *testing.T, secret string) {
+	t.Helper()
+	old := settingsSecret
+	settingsSecret = secret
+	t.Cleanup(func() { settingsSecret = old })
+}
+
+func TestEncryptSettingRoundTrip(t *testing.T) {
+	useSettingsSecret(t, "test-secret")
+
+	encrypted, err := encryptSetting("s3cret")
+	if err != nil {
+		t.Fatalf("加密失败: %v", err)
+	}
+	if encrypted == "" || encrypted == "s3cret" {
+		t.Fatalf("密文错误: %q", encrypted)
+	}
+	again, err := encryptSetting("s3cret")
+	if err != nil {
+		t.Fatalf("加密失败: %v", err)
+	}
+	if again == encrypted {
+		t.Error("每次加密应该使用不同的随机数")
+	}
+
+	plain, err := decryptSetting(encrypted)
+	if err != nil {
+		t.Fatalf("解密失败: %v", err)
+	}
+	if plain != "s3cret" {
+		t.Errorf("期望 s3cret，实际 %q", plain)
+	}
+
+	// 空值不加密
+	if got, err := encryptSetting(""); err != nil || got != "" {
+		t.Errorf("空值加密结果错误: %q, %v", got, err)
+	}
+	if got, err := decryptSetting(""); err != nil || got != "" {
+		t.Errorf("空值解密结果错误: %q, %v", got, err)
+	}
+}
+
+func TestDecryptSettingWithChangedSecret(t *testing.T) {
+	useSettingsSecret(t, "old-secret")
+	encrypted, err := encryptSetting("s3cret")
+	if err != nil {
+		t.Fatalf("加密失败: %v", err)
+	}
+
+	settingsSecret = "new-secret"
+	if _, err := decryptSetting(encrypted); err == nil {
+		t.Error("密钥修改后解密应该失败")
+	}
+	if _, err := decryptSetting("not base64!"); err == nil {
+		t.Error("格式错误的密文应该解密失败")
+	}
+}
+
+func TestEncryptSettingWithoutSecret(t *testing.T) {
+	useSettingsSecret(t, "")
+	if _, err := encryptSetting("s3cret"); !errors.Is(err, errNoSettingsSecret) {
+		t.Errorf("没有密钥时期望 errNoSettingsSecret，实际 %v", err)
+	}
+}
+
+func TestEncryptSecretPurpose(t *testing.T) {
+	useSettingsSecret(t, "test-secret")
+	encrypted, err := encryptSecret(secretPurposeWebAuthn, "s3cret")
+	if err != nil {
+		t.Fatalf("加密失败: %v", err)
+	}
+	if plain, err := decryptSecret(secretPurposeWebAuthn, encrypted); err != nil || plain != "s3cret" {
+		t.Errorf("同一用途应该可以解密，实际 %q %v", plain, err)
+	}
+	if _, err := decryptSetting(encrypted); err == nil {
+		t.Error("其他用途的密文不应该可以作为敏感配置解密")
+	}
+}
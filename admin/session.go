@@ -0,0 +1,239 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/qor5/admin/v3/activity"
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+登录会话管理说明：
+
+登录会话由plogin.SessionBuilder保存在cms_login_sessions表中，每次登录创建一条记录，
+会话中间件每个请求都检查记录是否过期，管理员可以通过让记录过期来强制用户退出：
+
+1. 查看会话
+   - 用户编辑页面显示该用户所有未过期的会话：设备（浏览器和操作系统）、IP、登录时间和最后活动时间
+   - 最后活动时间保存在会话记录的updated_at中，由touchLoginSession每分钟最多更新一次
+
+2. 撤销会话
+   - 撤销单个会话：该会话的下一个请求跳转到退出登录
+   - 撤销用户的所有会话：同时更新用户的会话密钥，已签发的登录凭证全部失效
+   - 撤销所有会话：只有管理员可以操作，保留管理员自己当前的会话
+   - 用户被停用或删除时自动撤销该用户的所有会话
+
+3. 权限
+   - 与重置双因素认证一致，需要用户的修改权限，只有管理员可以操作管理员和经理
+*/
+
+// loginSessionTablePrefix 登录会话表的前缀，与initLoginSessionBuilder一致
+const loginSessionTablePrefix = "cms_"
+
+// sessionTouchInterval 最后活动时间的更新间隔
+const sessionTouchInterval = time.Minute
+
+// 撤销会话的事件和列表操作
+const (
+	sessionRevokeEvent      = "user_revoke_session"
+	sessionRevokeAllEvent   = "user_revoke_all_sessions"
+	revokeAllSessionsAction = "RevokeAllSessions"
+)
+
+// 撤销会话的操作日志类型
+const (
+	sessionActionRevoke     = "RevokeSession"
+	sessionActionRevokeUser = "RevokeUserSessions"
+	sessionActionRevokeAll  = "RevokeAllSessions"
+)
+
+var errSessionNotFound = errors.New("会话不存在或已经过期")
+
+// sessionDB 返回带登录会话表前缀的数据库连接
+func sessionDB(db *gorm.DB) *gorm.DB {
+	return db.Scopes(activity.ScopeWithTablePrefix(loginSessionTablePrefix)).Session(&gorm.Session{})
+}
+
+// sessionTokenHash 计算登录凭证的哈希，与plogin保存的token_hash一致
+func sessionTokenHash(token string) string {
+	return getStringHash(token, plogin.LoginTokenHashLen)
+}
+
+// activeSessions 返回用户所有未过期的会话，最近活动的在前
+func activeSessions(db *gorm.DB, userID uint, now time.Time) ([]plogin.LoginSession, error) {
+	var sessions []plogin.LoginSession
+	err := sessionDB(db).Where("user_id = ? AND expired_at > ?", fmt.Sprint(userID), now).
+		Order("updated_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// touchLoginSession 更新当前会话的最后活动时间，放在会话中间件之后、模拟用户之前
+func touchLoginSession(lb *login.Builder, db *gorm.DB) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := getCurrentUser(r)
+			token := login.GetSessionToken(lb, r)
+			if u != nil && token != "" {
+				now := db.NowFunc()
+				if err := sessionDB(db).Model(&plogin.LoginSession{}).
+					Where("user_id = ? AND token_hash = ? AND updated_at < ?", fmt.Sprint(u.ID), sessionTokenHash(token), now.Add(-sessionTouchInterval)).
+					UpdateColumn("updated_at", now).Error; err != nil {
+					models.AuthLog().Error("更新会话的最后活动时间失败", "error", err)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// expireUserSessions 使用户所有的登录会话失效，并更新会话密钥使已签发的登录凭证失效
+func expireUserSessions(db *gorm.DB, u *models.User) error {
+	uid := fmt.Sprint(u.ID)
+	if err := u.UpdateSecure(db, &models.User{}, uid); err != nil {
+		return err
+	}
+	now := db.NowFunc()
+	return sessionDB(db).Model(&plogin.LoginSession{}).
+		Where("user_id = ? AND expired_at > ?", uid, now).
+		Update("expired_at", now).Error
+}
+
+// revokeSession 管理员撤销用户的一个会话
+// 参数：
+// - ctx: 管理员的请求
+// - db: 数据库连接
+// - ab: 操作日志
+// - user: 会话所属的用户
+// - sessionID: 会话ID
+// 返回：
+// - error: 会话不存在、已经过期或更新失败时的错误信息
+func revokeSession(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, user *models.User, sessionID string) error {
+	now := db.NowFunc()
+	result := sessionDB(db).Model(&plogin.LoginSession{}).
+		Where("id = ? AND user_id = ? AND expired_at > ?", sessionID, fmt.Sprint(user.ID), now).
+		Update("expired_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errSessionNotFound
+	}
+	models.AuthLog().Info("管理员撤销了登录会话",
+		"account", models.MaskAccount(user.Account),
+		"session", sessionID,
+		"operator", models.MaskAccount(getCurrentUser(ctx.R).Account),
+	)
+	if _, err := ab.Log(ctx.R.Context(), sessionActionRevoke, user, nil); err != nil {
+		models.AuthLog().Error("记录撤销会话的操作日志失败", "error", err)
+	}
+	return nil
+}
+
+// revokeUserSessions 管理员撤销用户的所有会话
+func revokeUserSessions(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, user *models.User) error {
+	if err := expireUserSessions(db, user); err != nil {
+		return err
+	}
+	models.AuthLog().Info("管理员撤销了用户的所有登录会话",
+		"account", models.MaskAccount(user.Account),
+		"operator", models.MaskAccount(getCurrentUser(ctx.R).Account),
+	)
+	if _, err := ab.Log(ctx.R.Context(), sessionActionRevokeUser, user, nil); err != nil {
+		models.AuthLog().Error("记录撤销会话的操作日志失败", "error", err)
+	}
+	return nil
+}
+
+// revokeAllSessions 管理员撤销所有用户的会话，保留管理员自己当前的会话
+// 参数：
+// - ctx: 管理员的请求
+// - db: 数据库连接
+// - ab: 操作日志
+// - lb: 登录模块，用于读取管理员当前的会话
+// 返回：
+// - int64: 撤销的会话数量
+// - error: 更新失败时的错误信息
+func revokeAllSessions(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, lb *login.Builder) (int64, error) {
+	operator := getCurrentUser(ctx.R)
+	now := db.NowFunc()
+	result := sessionDB(db).Model(&plogin.LoginSession{}).
+		Where("expired_at > ?", now).
+		Where("NOT (user_id = ? AND token_hash = ?)", fmt.Sprint(operator.ID), sessionTokenHash(login.GetSessionToken(lb, ctx.R))).
+		Update("expired_at", now)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	models.AuthLog().Warn("管理员撤销了所有登录会话",
+		"count", result.RowsAffected,
+		"operator", models.MaskAccount(operator.Account),
+	)
+	if _, err := ab.Log(ctx.R.Context(), sessionActionRevokeAll, operator, nil); err != nil {
+		models.AuthLog().Error("记录撤销会话的操作日志失败", "error", err)
+	}
+	return result.RowsAffected, nil
+}
+
+// userSessionsField 用户编辑页面的会话列表，每个会话可以单独撤销
+func userSessionsField(db *gorm.DB, u *models.User, msgr *Messages) h.HTMLComponent {
+	sessions, err := activeSessions(db, u.ID, db.NowFunc())
+	if err != nil {
+		panic(err)
+	}
+
+	var rows []h.HTMLComponent
+	for _, s := range sessions {
+		rows = append(rows, h.Div(
+			h.Div(
+				h.Div(h.Text(fmt.Sprintf("%s · %s", s.Device, s.IP))),
+				h.Div(h.Text(fmt.Sprintf(msgr.SessionTimes,
+					s.CreatedAt.Local().Format(time.DateTime),
+					s.UpdatedAt.Local().Format(time.DateTime),
+				))).Class("text-caption"),
+			),
+			v.VBtn(msgr.SessionRevokeBtn).
+				Size("small").
+				Variant(v.VariantTonal).
+				Color("warning").
+				Attr("@click", web.Plaid().
+					EventFunc(sessionRevokeEvent).
+					Query("id", fmt.Sprint(u.ID)).
+					Query("session", fmt.Sprint(s.ID)).
+					Go()),
+		).Class("d-flex justify-space-between align-center mb-2"))
+	}
+	if len(rows) == 0 {
+		rows = append(rows, h.Span(msgr.SessionNone))
+	} else {
+		rows = append(rows, v.VBtn(msgr.SessionRevokeUserBtn).
+			Size("small").
+			Variant(v.VariantTonal).
+			Color("error").
+			Attr("@click", web.Plaid().
+				EventFunc(sessionRevokeAllEvent).
+				Query("id", fmt.Sprint(u.ID)).
+				Go()))
+	}
+
+	return h.Div(
+		h.Div(h.Text(msgr.SessionTitle)).Class("text-caption"),
+		h.Div(rows...),
+	).Class("mb-4")
+}
+
+// revokeAllSessionsDialog 撤销所有会话的确认提示
+func revokeAllSessionsDialog(id string, ctx *web.EventContext) h.HTMLComponent {
+	msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+	return h.Div(h.Text(msgr.SessionRevokeAllPrompt))
+}
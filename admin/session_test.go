@@ -0,0 +1,149 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/login"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// newTestSessionDB 创建带登录会话表的测试数据库
+func newTestSessionDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := newTestDB(t)
+	if err := plogin.AutoMigrateSession(db, loginSessionTablePrefix); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// createTestSession 为用户创建一个登录会话
+func createTestSession(t *testing.T, db *gorm.DB, u *models.User, token string, expiredAt time.Time) *plogin.LoginSession {
+	t.Helper()
+	s := &plogin.LoginSession{
+		UserID:    fmt.Sprint(u.ID),
+		Device:    "Chrome - Mac OS X",
+		IP:        "10.0.0.1",
+		TokenHash: sessionTokenHash(token),
+		ExpiredAt: expiredAt,
+	}
+	if err := sessionDB(db).Create(s).Error; err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newSessionAdminContext 创建管理员的请求，带有管理员自己的登录凭证
+func newSessionAdminContext(admin *models.User, token string) *web.EventContext {
+	r := httptest.NewRequest("POST", "/users", nil)
+	r.AddCookie(&http.Cookie{Name: "auth", Value: token})
+	r = r.WithContext(context.WithValue(r.Context(), login.UserKey, admin))
+	return &web.EventContext{R: r, W: httptest.NewRecorder()}
+}
+
+func TestRevokeSession(t *testing.T) {
+	db := newTestSessionDB(t)
+	ab := newTestActivity(t, db)
+	admin := createTestUserWithRole(t, db, "admin", models.RoleAdmin)
+	viewer := createTestUserWithRole(t, db, "viewer", models.RoleViewer)
+	ctx := newSessionAdminContext(admin, "admin-token")
+	future := time.Now().Add(time.Hour)
+
+	first := createTestSession(t, db, viewer, "a", future)
+	createTestSession(t, db, viewer, "b", future)
+	createTestSession(t, db, viewer, "expired", time.Now().Add(-time.Hour))
+	other := createTestSession(t, db, admin, "admin-token", future)
+
+	sessions, err := activeSessions(db, viewer.ID, time.Now())
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("应该只返回未过期的会话，实际 %d %v", len(sessions), err)
+	}
+
+	if err := revokeSession(ctx, db, ab, viewer, fmt.Sprint(first.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ := activeSessions(db, viewer.ID, time.Now()); len(sessions) != 1 {
+		t.Errorf("撤销后应该剩下一个会话，实际 %d", len(sessions))
+	}
+	if err := revokeSession(ctx, db, ab, viewer, fmt.Sprint(first.ID)); err != errSessionNotFound {
+		t.Errorf("已经撤销的会话不能再撤销，实际 %v", err)
+	}
+	if err := revokeSession(ctx, db, ab, viewer, fmt.Sprint(other.ID)); err != errSessionNotFound {
+		t.Errorf("不能通过其他用户撤销会话，实际 %v", err)
+	}
+
+	if err := revokeUserSessions(ctx, db, ab, viewer); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ := activeSessions(db, viewer.ID, time.Now()); len(sessions) != 0 {
+		t.Errorf("应该撤销用户的所有会话，实际 %d", len(sessions))
+	}
+	var saved models.User
+	db.First(&saved, viewer.ID)
+	if saved.GetSecure() == "" {
+		t.Errorf("撤销用户的所有会话时应该更新会话密钥")
+	}
+	if sessions, _ := activeSessions(db, admin.ID, time.Now()); len(sessions) != 1 {
+		t.Errorf("不应该影响其他用户的会话")
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	db := newTestSessionDB(t)
+	ab := newTestActivity(t, db)
+	admin := createTestUserWithRole(t, db, "admin", models.RoleAdmin)
+	viewer := createTestUserWithRole(t, db, "viewer", models.RoleViewer)
+	future := time.Now().Add(time.Hour)
+
+	createTestSession(t, db, admin, "admin-token", future)
+	createTestSession(t, db, admin, "admin-other", future)
+	createTestSession(t, db, viewer, "viewer-token", future)
+
+	count, err := revokeAllSessions(newSessionAdminContext(admin, "admin-token"), db, ab, login.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("应该撤销除当前会话以外的所有会话，实际 %d", count)
+	}
+	sessions, _ := activeSessions(db, admin.ID, time.Now())
+	if len(sessions) != 1 || sessions[0].TokenHash != sessionTokenHash("admin-token") {
+		t.Errorf("应该保留管理员当前的会话: %+v", sessions)
+	}
+}
+
+func TestTouchLoginSession(t *testing.T) {
+	db := newTestSessionDB(t)
+	viewer := createTestUserWithRole(t, db, "viewer", models.RoleViewer)
+	s := createTestSession(t, db, viewer, "viewer-token", time.Now().Add(time.Hour))
+	old := time.Now().Add(-time.Hour)
+	sessionDB(db).Model(s).UpdateColumn("updated_at", old)
+
+	handler := touchLoginSession(login.New(), db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() time.Time {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: "auth", Value: "viewer-token"})
+		r = r.WithContext(context.WithValue(r.Context(), login.UserKey, viewer))
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		var saved plogin.LoginSession
+		sessionDB(db).First(&saved, s.ID)
+		return saved.UpdatedAt
+	}
+
+	touched := serve()
+	if !touched.After(old) {
+		t.Fatalf("应该更新会话的最后活动时间")
+	}
+	if again := serve(); !again.Equal(touched) {
+		t.Errorf("更新间隔内不应该重复更新最后活动时间")
+	}
+}
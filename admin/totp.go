@@ -0,0 +1,490 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/qor5/admin/v3/activity"
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	. "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+双因素认证说明：
+
+登录模块自带的TOTP只能对所有用户统一开启或关闭，这里在登录模块之外实现按角色要求的双因素认证：
+
+1. 配置方式
+   - TOTP_REQUIRED_ROLES: 必须启用双因素认证的角色，多个用逗号分隔，例如Admin,Manager，默认为空
+   - TOTP_ISSUER: 验证器应用中显示的名称，默认qor5boot
+   - TOTP_VALIDATION_MAX_AGE: 验证通过后多久需要重新验证，默认12h
+
+2. 适用范围
+   - 本地密码和LDAP用户：拥有上述角色的用户必须启用，其他用户可以在个人资料中自愿启用
+   - 启用后每次登录都需要输入验证码
+   - OAuth和SAML用户由身份提供商负责多因素认证，不适用
+
+3. 登录流程
+   - 用户名密码验证通过后，需要双因素认证的用户访问任何页面都会跳转到验证页面
+   - 还没有启用的必须启用的用户跳转到设置页面，扫描二维码并输入验证码后完成启用，即下次登录时强制启用
+   - 验证通过后设置签名Cookie，绑定用户、TOTP密钥、密码更新时间和会话盐，登录、退出、重置TOTP或修改密码后失效
+
+4. 重置
+   - 管理员可以在用户编辑页面重置用户的TOTP，重置记录在操作日志中
+   - 被重置的用户如果仍然必须启用，下次访问时需要重新设置
+
+5. 安全密钥
+   - 注册了安全密钥的用户同样需要双因素认证，验证页面可以使用安全密钥代替验证码，见webauthn.go
+   - 必须启用的用户在设置页面可以改为注册安全密钥
+
+6. 恢复码
+   - 启用TOTP时生成一次性恢复码，丢失验证器时可以在验证页面代替验证码，见recovery_codes.go
+
+7. 防暴力破解
+   - 同一用户连续输错验证码或恢复码超过LOGIN_THROTTLE_ACCOUNT_FREE次后，与账号密码登录一样需要等待，见login_throttle.go
+   - 等待期间提交直接返回验证页面并提示剩余时间，验证通过后清零
+*/
+
+// 双因素认证页面和验证地址，与登录模块的默认地址一致
+const (
+	totpSetupPageURL    = "/auth/2fa/totp/setup"
+	totpValidatePageURL = "/auth/2fa/totp/validate"
+	totpDoURL           = "/auth/2fa/totp/do"
+	totpCookieName      = "qor5boot_totp"
+)
+
+// 登录模块显示失败提示使用的Cookie，值是login.FailCode
+const loginFailCodeFlashCookie = "qor5_fc_flash"
+
+// totpResetEvent 用户编辑页面重置TOTP的事件
+const totpResetEvent = "user_reset_totp"
+
+// totpActionReset 重置TOTP的操作日志类型
+const totpActionReset = "ResetTOTP"
+
+var (
+	totpRequiredRoles    = splitList(getEnvWithDefault("TOTP_REQUIRED_ROLES", ""))
+	totpIssuer           = getEnvWithDefault("TOTP_ISSUER", "qor5boot")
+	totpValidationMaxAge = getEnvWithDefaultDuration("TOTP_VALIDATION_MAX_AGE", 12*time.Hour)
+)
+
+// totpStaticFile 与登录中间件一致的静态文件格式，验证之前也可以访问
+var totpStaticFile = regexp.MustCompile(`\.(css|js|gif|jpg|jpeg|png|ico|svg|ttf|eot|woff|woff2|js\.map)$`)
+
+// totpRequired 判断用户的角色是否要求启用双因素认证
+// 需要先由withRoles加载用户的角色
+func totpRequired(u *models.User) bool {
+	if u == nil || u.IsOAuthUser() {
+		return false
+	}
+	for _, r := range u.GetRoles() {
+		if slices.Contains(totpRequiredRoles, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// totpEnforced 判断用户登录时是否需要通过双因素认证：已经启用TOTP、注册了安全密钥，或者角色要求启用
+// 需要先由withRoles加载用户的角色和安全密钥
+func totpEnforced(u *models.User) bool {
+	if u == nil || u.IsOAuthUser() {
+		return false
+	}
+	return hasSecondFactor(u) || totpRequired(u)
+}
+
+// hasSecondFactor 判断用户是否已经启用了TOTP或者注册了安全密钥
+func hasSecondFactor(u *models.User) bool {
+	return u.IsTOTPSetup || len(u.WebAuthnCredentials) > 0
+}
+
+// totpSignature 计算验证Cookie的签名
+// 签名包含TOTP密钥、密码更新时间和会话盐，重置TOTP、修改密码或退出所有会话后已有的Cookie失效
+func totpSignature(u *models.User, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(loginSecret))
+	fmt.Fprintf(mac, "%d|%d|%s|%s|%s", u.ID, expires, u.TOTPSecret, u.PassUpdatedAt, u.GetSecure())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setTOTPValidated 设置双因素认证通过的Cookie
+func setTOTPValidated(w http.ResponseWriter, u *models.User, now time.Time) {
+	expires := now.Add(totpValidationMaxAge).Unix()
+	http.SetCookie(w, &http.Cookie{
+		Name:     totpCookieName,
+		Value:    fmt.Sprintf("%d.%d.%s", u.ID, expires, totpSignature(u, expires)),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearTOTPValidated 删除双因素认证通过的Cookie
+func clearTOTPValidated(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     totpCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// totpValidated 判断当前请求的用户是否已经通过双因素认证
+func totpValidated(r *http.Request, u *models.User, now time.Time) bool {
+	c, err := r.Cookie(totpCookieName)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 3 || parts[0] != strconv.FormatUint(uint64(u.ID), 10) {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(parts[2]), []byte(totpSignature(u, expires)))
+}
+
+// requireTOTP 需要双因素认证的用户通过验证之前只能访问设置和验证页面
+// 需要放在登录中间件和withRoles之后；登录和退出时删除验证Cookie，
+// 通行密钥登录时页面提交的是一次性登录凭证，保留通行密钥验证时设置的Cookie
+func requireTOTP(vh *login.ViewHelper, logoutURL string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.Path
+			if path == logoutURL ||
+				path == vh.PasswordLoginURL() && !strings.HasPrefix(r.FormValue("password"), webauthnTicketPrefix) {
+				clearTOTPValidated(w)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// 令牌请求没有浏览器会话，创建令牌时用户已经通过了双因素认证；
+			// 模拟用户时由withImpersonation检查管理员自己的双因素认证
+			u := getCurrentUser(r)
+			if !totpEnforced(u) || totpValidated(r, u, time.Now()) ||
+				accessTokenFromContext(r) != nil || impersonatorFromContext(r.Context()) != nil ||
+				path == totpSetupPageURL || path == totpValidatePageURL || path == totpDoURL ||
+				path == webauthnVerifyBeginURL || path == webauthnVerifyFinishURL ||
+				totpStaticFile.MatchString(strings.ToLower(path)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// 还没有启用的必须启用的用户可以注册安全密钥代替TOTP
+			if !hasSecondFactor(u) &&
+				(path == webauthnCredentialsPageURL || path == webauthnRegisterBeginURL || path == webauthnRegisterFinishURL) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if hasSecondFactor(u) {
+				http.Redirect(w, r, totpValidatePageURL, http.StatusFound)
+			} else {
+				http.Redirect(w, r, totpSetupPageURL, http.StatusFound)
+			}
+		})
+	}
+}
+
+// consumeTOTPCode 验证用户输入的验证码，与登录模块一样拒绝90秒内重复使用的验证码
+func consumeTOTPCode(db *gorm.DB, u *models.User, passcode string) error {
+	if !totp.Validate(passcode, u.TOTPSecret) {
+		return login.ErrWrongTOTPCode
+	}
+	lastCode, usedAt := u.GetLastUsedTOTPCode()
+	if usedAt != nil && time.Since(*usedAt) > 90*time.Second {
+		lastCode = ""
+	}
+	if passcode == lastCode {
+		return login.ErrTOTPCodeHasBeenUsed
+	}
+	return u.SetLastUsedTOTPCode(db, &models.User{}, passcode)
+}
+
+// mountTOTP 挂载双因素认证的设置页面、验证页面、恢复码页面和验证地址
+func mountTOTP(mux *http.ServeMux, pb *presets.Builder, vh *login.ViewHelper, db *gorm.DB, ab *activity.Builder) {
+	wb := web.New()
+	setupPage := vh.I18n().EnsureLanguage(wb.Page(totpSetupPage(pb, vh, db)))
+	validatePage := vh.I18n().EnsureLanguage(wb.Page(totpValidatePage(pb, vh)))
+	codesPage := vh.I18n().EnsureLanguage(wb.Page(recoveryCodesPage(pb, vh, db)))
+
+	// 已经启用的用户不能再次查看密钥，还没有启用的用户不需要验证
+	mux.HandleFunc(totpSetupPageURL, func(w http.ResponseWriter, r *http.Request) {
+		u := getCurrentUser(r)
+		if u == nil || u.IsOAuthUser() || u.IsTOTPSetup {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		setupPage.ServeHTTP(w, r)
+	})
+	mux.HandleFunc(totpValidatePageURL, func(w http.ResponseWriter, r *http.Request) {
+		u := getCurrentUser(r)
+		if u == nil || u.IsOAuthUser() || !hasSecondFactor(u) {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		validatePage.ServeHTTP(w, r)
+	})
+	mux.HandleFunc(totpDoURL, totpDo(db, ab, codesPage))
+	mux.HandleFunc(recoveryCodesPageURL, recoveryCodesHandler(db, ab, codesPage))
+}
+
+// totpSetupPage 显示二维码和密钥，还没有密钥时生成新的密钥
+func totpSetupPage(pb *presets.Builder, vh *login.ViewHelper, db *gorm.DB) web.PageFunc {
+	return pb.PlainLayout(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, login.I18nLoginKey, login.Messages_en_US).(*login.Messages)
+		adminMsgr := webauthnMessages(ctx.R)
+
+		u := getCurrentUser(ctx.R)
+		if u.TOTPSecret == "" {
+			var key *otp.Key
+			if key, err = totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: u.Account}); err != nil {
+				return
+			}
+			if err = u.SetTOTPSecret(db, &models.User{}, key.Secret()); err != nil {
+				return
+			}
+		}
+
+		key, err := otp.NewKeyFromURL(fmt.Sprintf("otpauth://totp/%s:%s?issuer=%s&secret=%s",
+			url.PathEscape(totpIssuer), url.PathEscape(u.Account), url.QueryEscape(totpIssuer), url.QueryEscape(u.TOTPSecret)))
+		if err != nil {
+			return
+		}
+		img, err := key.Image(200, 200)
+		if err != nil {
+			return
+		}
+		var qrCode bytes.Buffer
+		if err = png.Encode(&qrCode, img); err != nil {
+			return
+		}
+
+		r.PageTitle = msgr.TOTPSetupPageTitle
+		r.Body = Div(
+			plogin.DefaultViewCommon.Notice(vh, msgr, ctx.W, ctx.R),
+			Div(
+				Div(
+					H1(msgr.TOTPSetupTitle).Class(plogin.DefaultViewCommon.TitleClass),
+					Label(msgr.TOTPSetupScanPrompt),
+				),
+				Div(
+					Img("data:image/png;base64,"+base64.StdEncoding.EncodeToString(qrCode.Bytes())),
+				).Class("d-flex justify-center my-2"),
+				Div(Label(msgr.TOTPSetupSecretPrompt)),
+				Div(Label(u.TOTPSecret)).Class("font-weight-bold my-4"),
+				Form(
+					Label(msgr.TOTPSetupEnterCodePrompt),
+					plogin.DefaultViewCommon.Input("otp", msgr.TOTPSetupCodePlaceholder, "").Class("mt-6"),
+					plogin.DefaultViewCommon.FormSubmitBtn(msgr.Verify),
+				).Method(http.MethodPost).Action(totpDoURL),
+				If(webauthnEnabled(),
+					A(Text(adminMsgr.WebAuthnSetupInstead)).Href(webauthnCredentialsPageURL).Class("d-block mt-4"),
+				),
+			).Class(plogin.DefaultViewCommon.WrapperClass).Style(plogin.DefaultViewCommon.WrapperStyle).Class("text-center"),
+		)
+		return
+	})
+}
+
+// totpValidatePage 登录时输入验证码的页面，注册了安全密钥的用户也可以使用安全密钥验证
+func totpValidatePage(pb *presets.Builder, vh *login.ViewHelper) web.PageFunc {
+	return pb.PlainLayout(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, login.I18nLoginKey, login.Messages_en_US).(*login.Messages)
+		adminMsgr := webauthnMessages(ctx.R)
+		u := getCurrentUser(ctx.R)
+
+		prompt := msgr.TOTPValidateEnterCodePrompt
+		var codeForm, keyBtn HTMLComponent
+		if u.IsTOTPSetup {
+			codeForm = Form(
+				plogin.DefaultViewCommon.Input("otp", msgr.TOTPValidateCodePlaceholder, "").Autofocus(true).Class("mt-6"),
+				plogin.DefaultViewCommon.FormSubmitBtn(msgr.Verify),
+				Div(Text(adminMsgr.RecoveryCodesValidateHint)).Class("text-caption text-medium-emphasis mt-2"),
+			).Method(http.MethodPost).Action(totpDoURL)
+		} else {
+			prompt = adminMsgr.WebAuthnVerifyPrompt
+		}
+		if len(u.WebAuthnCredentials) > 0 && webauthnEnabled() {
+			ctx.Injector.HeadHTML(webauthnScriptTag())
+			keyBtn = webauthnButton(adminMsgr, adminMsgr.WebAuthnVerifyBtn, webauthnPurposeVerify, webauthnVerifyBeginURL, webauthnVerifyFinishURL).
+				Variant(v.VariantTonal).
+				Color(v.ColorPrimary).
+				PrependIcon("mdi-fingerprint").
+				Class("mt-4")
+		}
+
+		r.PageTitle = msgr.TOTPValidatePageTitle
+		r.Body = Div(
+			plogin.DefaultViewCommon.Notice(vh, msgr, ctx.W, ctx.R),
+			Div(
+				Div(
+					H1(msgr.TOTPValidateTitle).Class(plogin.DefaultViewCommon.TitleClass),
+					Label(prompt),
+				),
+				codeForm,
+				keyBtn,
+			).Class(plogin.DefaultViewCommon.WrapperClass).Style(plogin.DefaultViewCommon.WrapperStyle).Class("text-center"),
+		)
+		return
+	})
+}
+
+// totpDo 验证设置页面或验证页面提交的验证码或恢复码，通过后设置验证Cookie并记录登录事件
+// 设置页面验证通过后生成恢复码，直接显示恢复码页面
+func totpDo(db *gorm.DB, ab *activity.Builder, codesPage http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		u := getCurrentUser(r)
+		if u == nil || u.IsOAuthUser() || u.TOTPSecret == "" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		failURL := totpValidatePageURL
+		if !u.IsTOTPSetup {
+			failURL = totpSetupPageURL
+		}
+		if throttleTOTP(w, r, u, failURL) {
+			return
+		}
+
+		passcode := strings.TrimSpace(r.FormValue("otp"))
+		if u.IsTOTPSetup && !totpCodePattern.MatchString(passcode) {
+			totpDoRecoveryCode(w, r, db, ab, u, passcode)
+			return
+		}
+
+		if err := consumeTOTPCode(db, u, passcode); err != nil {
+			saveLoginEvent(db, newLoginEvent(r, u, err))
+			loginThrottler.fail(time.Now(), throttleKindTOTP, fmt.Sprint(u.ID))
+			code := login.FailCodeIncorrectTOTPCode
+			if err == login.ErrTOTPCodeHasBeenUsed {
+				code = login.FailCodeTOTPCodeHasBeenUsed
+			} else if err != login.ErrWrongTOTPCode {
+				panic(err)
+			}
+			http.SetCookie(w, &http.Cookie{Name: loginFailCodeFlashCookie, Value: fmt.Sprint(code), Path: "/", HttpOnly: true})
+			http.Redirect(w, r, failURL, http.StatusFound)
+			return
+		}
+
+		saveLoginEvent(db, newLoginEvent(r, u, nil))
+		loginThrottler.reset(throttleKindTOTP, fmt.Sprint(u.ID))
+		setTOTPValidated(w, u, time.Now())
+		if !u.IsTOTPSetup {
+			if err := u.SetIsTOTPSetup(db, &models.User{}, true); err != nil {
+				panic(err)
+			}
+			models.AuthLog().Info("已启用双因素认证", "account", models.MaskAccount(u.Account))
+			showRecoveryCodes(w, r, db, ab, codesPage)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+// throttleTOTP 用户连续输错验证码或恢复码后的等待期间拒绝提交，返回验证页面并提示剩余时间
+// 返回：
+// - bool: 是否已经拒绝了请求
+func throttleTOTP(w http.ResponseWriter, r *http.Request, u *models.User, failURL string) bool {
+	wait := loginThrottler.wait(time.Now(), throttleKey(throttleKindTOTP, fmt.Sprint(u.ID)))
+	if wait <= 0 {
+		return false
+	}
+	models.AuthLog().Warn("双因素认证失败次数过多，拒绝验证",
+		"account", models.MaskAccount(u.Account),
+		"ip", ip(r),
+		"wait", wait.Round(time.Second).String(),
+	)
+	setLoginNotice(w, login.NoticeLevel_Error, fmt.Sprintf(Messages_en_US.LoginThrottled, int(math.Ceil(wait.Seconds()))))
+	http.Redirect(w, r, failURL, http.StatusFound)
+	return true
+}
+
+// totpDoRecoveryCode 使用恢复码代替验证码通过双因素认证，每次使用记录在操作日志中
+func totpDoRecoveryCode(w http.ResponseWriter, r *http.Request, db *gorm.DB, ab *activity.Builder, u *models.User, code string) {
+	err := consumeRecoveryCode(db, u.ID, code, time.Now())
+	event := newLoginEvent(r, u, err)
+	event.Method = models.LoginMethodRecoveryCode
+	saveLoginEvent(db, event)
+	if err != nil {
+		if err != errWrongRecoveryCode {
+			panic(err)
+		}
+		loginThrottler.fail(time.Now(), throttleKindTOTP, fmt.Sprint(u.ID))
+		http.SetCookie(w, &http.Cookie{Name: loginFailCodeFlashCookie, Value: fmt.Sprint(login.FailCodeIncorrectTOTPCode), Path: "/", HttpOnly: true})
+		http.Redirect(w, r, totpValidatePageURL, http.StatusFound)
+		return
+	}
+
+	remaining, err := remainingRecoveryCodes(db, u.ID)
+	if err != nil {
+		panic(err)
+	}
+	models.AuthLog().Warn("使用恢复码通过了双因素认证", "account", models.MaskAccount(u.Account), "remaining", remaining)
+	logRecoveryCodeActivity(r.Context(), ab, recoveryCodeActionUse, u)
+	loginThrottler.reset(throttleKindTOTP, fmt.Sprint(u.ID))
+	setTOTPValidated(w, u, time.Now())
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// resetTOTP 清除用户的TOTP密钥、启用状态和恢复码，并记录操作日志
+// 参数：
+// - ctx: 管理员的请求
+// - db: 数据库连接
+// - ab: 操作日志
+// - user: 被重置的用户
+// 返回：
+// - error: 保存失败时的错误信息，操作日志记录失败只输出日志
+func resetTOTP(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, user *models.User) error {
+	if err := db.Model(user).Updates(map[string]interface{}{
+		"totp_secret":            "",
+		"is_totp_setup":          false,
+		"last_used_totp_code":    "",
+		"last_totp_code_used_at": nil,
+	}).Error; err != nil {
+		return err
+	}
+	if err := deleteRecoveryCodes(db, user.ID); err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.IsTOTPSetup = false
+	models.AuthLog().Info("管理员重置了双因素认证",
+		"account", models.MaskAccount(user.Account),
+		"operator", models.MaskAccount(getCurrentUser(ctx.R).Account),
+	)
+	if _, err := ab.Log(ctx.R.Context(), totpActionReset, user, nil); err != nil {
+		models.AuthLog().Error("记录重置TOTP的操作日志失败", "error", err)
+	}
+	return nil
+}
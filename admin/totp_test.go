@@ -0,0 +1,289 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naokij/qor5boot/models"
+	"github.com/pquerna/otp/totp"
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/x/v3/login"
+)
+
+// withTOTPRequiredRoles 在测试期间设置必须启用双因素认证的角色
+func withTOTPRequiredRoles(t *testing.T, roles ...string) {
+	t.Helper()
+	old := totpRequiredRoles
+	totpRequiredRoles = roles
+	t.Cleanup(func() { totpRequiredRoles = old })
+}
+
+func TestTOTPEnforced(t *testing.T) {
+	withTOTPRequiredRoles(t, models.RoleAdmin, models.RoleManager)
+
+	admin := &models.User{Roles: []role.Role{{Name: models.RoleAdmin}}}
+	viewer := &models.User{Roles: []role.Role{{Name: models.RoleViewer}}}
+	enrolled := &models.User{Roles: []role.Role{{Name: models.RoleViewer}}}
+	enrolled.IsTOTPSetup = true
+	oauthAdmin := &models.User{Roles: []role.Role{{Name: models.RoleAdmin}}}
+	oauthAdmin.OAuthProvider = models.OAuthProviderGoogle
+
+	cases := []struct {
+		name     string
+		user     *models.User
+		required bool
+		enforced bool
+	}{
+		{"管理员", admin, true, true},
+		{"普通用户", viewer, false, false},
+		{"自愿启用的普通用户", enrolled, false, true},
+		{"OAuth管理员", oauthAdmin, false, false},
+		{"未登录", nil, false, false},
+	}
+	for _, c := range cases {
+		if got := totpRequired(c.user); got != c.required {
+			t.Errorf("%s: totpRequired = %v, 期望 %v", c.name, got, c.required)
+		}
+		if got := totpEnforced(c.user); got != c.enforced {
+			t.Errorf("%s: totpEnforced = %v, 期望 %v", c.name, got, c.enforced)
+		}
+	}
+}
+
+func TestTOTPValidatedCookie(t *testing.T) {
+	now := time.Now()
+	u := &models.User{}
+	u.ID = 7
+	u.TOTPSecret = "SECRET"
+	u.PassUpdatedAt = "1"
+
+	w := httptest.NewRecorder()
+	setTOTPValidated(w, u, now)
+	cookie := w.Result().Cookies()[0]
+
+	request := func(c *http.Cookie) *http.Request {
+		r := httptest.NewRequest("GET", "/admin", nil)
+		r.AddCookie(c)
+		return r
+	}
+	if !totpValidated(request(cookie), u, now) {
+		t.Fatal("刚设置的验证Cookie应该有效")
+	}
+	if totpValidated(request(cookie), u, now.Add(totpValidationMaxAge+time.Minute)) {
+		t.Error("过期的验证Cookie不应该有效")
+	}
+
+	other := *u
+	other.ID = 8
+	if totpValidated(request(cookie), &other, now) {
+		t.Error("验证Cookie不能用于其他用户")
+	}
+	reset := *u
+	reset.TOTPSecret = "NEW"
+	if totpValidated(request(cookie), &reset, now) {
+		t.Error("重置TOTP后验证Cookie应该失效")
+	}
+	changed := *u
+	changed.PassUpdatedAt = "2"
+	if totpValidated(request(cookie), &changed, now) {
+		t.Error("修改密码后验证Cookie应该失效")
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	forged := &http.Cookie{Name: totpCookieName, Value: fmt.Sprintf("%s.%d.%s", parts[0], now.Add(time.Hour*1000).Unix(), parts[2])}
+	if totpValidated(request(forged), u, now) {
+		t.Error("修改过期时间后签名应该无效")
+	}
+}
+
+func TestRequireTOTP(t *testing.T) {
+	withTOTPRequiredRoles(t, models.RoleAdmin)
+	vh := login.New().ViewHelper()
+
+	handler := requireTOTP(vh, "/auth/logout")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(path string, u *models.User, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		if u != nil {
+			r = r.WithContext(context.WithValue(r.Context(), login.UserKey, u))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	admin := &models.User{Roles: []role.Role{{Name: models.RoleAdmin}}}
+	admin.ID = 1
+	if w := serve("/admin/users", admin); w.Code != http.StatusFound || w.Header().Get("Location") != totpSetupPageURL {
+		t.Errorf("没有启用的管理员应该跳转到设置页面，实际 %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	enrolled := &models.User{Roles: []role.Role{{Name: models.RoleViewer}}}
+	enrolled.ID = 2
+	enrolled.IsTOTPSetup = true
+	enrolled.TOTPSecret = "SECRET"
+	if w := serve("/admin/users", enrolled); w.Code != http.StatusFound || w.Header().Get("Location") != totpValidatePageURL {
+		t.Errorf("已经启用的用户应该跳转到验证页面，实际 %d %s", w.Code, w.Header().Get("Location"))
+	}
+	for _, path := range []string{totpSetupPageURL, totpValidatePageURL, totpDoURL, "/admin/assets/app.js"} {
+		if w := serve(path, enrolled); w.Code != http.StatusOK {
+			t.Errorf("%s 在验证之前应该可以访问，实际 %d", path, w.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	setTOTPValidated(rec, enrolled, time.Now())
+	validated := rec.Result().Cookies()[0]
+	if w := serve("/admin/users", enrolled, validated); w.Code != http.StatusOK {
+		t.Errorf("验证通过后应该可以访问，实际 %d", w.Code)
+	}
+
+	viewer := &models.User{Roles: []role.Role{{Name: models.RoleViewer}}}
+	viewer.ID = 3
+	if w := serve("/admin/users", viewer); w.Code != http.StatusOK {
+		t.Errorf("不需要双因素认证的用户不应该跳转，实际 %d", w.Code)
+	}
+
+	for _, path := range []string{"/auth/logout", vh.PasswordLoginURL()} {
+		w := serve(path, enrolled, validated)
+		if c := w.Result().Cookies(); len(c) != 1 || c[0].Name != totpCookieName || c[0].MaxAge >= 0 {
+			t.Errorf("%s 应该删除验证Cookie，实际 %v", path, c)
+		}
+	}
+}
+
+func TestTOTPDo(t *testing.T) {
+	useTestThrottle(t)
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.LoginEvent{}, &models.RecoveryCode{}); err != nil {
+		t.Fatal(err)
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "test", AccountName: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Name: "Alice", LDAPUserPass: models.LDAPUserPass{Account: "alice@example.com", TOTPSecret: key.Secret()}}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var shown []string
+	codesPage := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shown, _ = r.Context().Value(recoveryCodesKey{}).([]string)
+	})
+	handler := totpDo(db, newTestActivity(t, db), codesPage)
+	submit := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", totpDoURL, strings.NewReader(url.Values{"otp": {code}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = r.WithContext(context.WithValue(r.Context(), login.UserKey, user))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	cookieNamed := func(w *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+
+	w := submit("000000")
+	if w.Header().Get("Location") != totpSetupPageURL || cookieNamed(w, loginFailCodeFlashCookie) == nil {
+		t.Fatalf("验证码错误时应该回到设置页面并显示提示，实际 %s", w.Header().Get("Location"))
+	}
+	if cookieNamed(w, totpCookieName) != nil {
+		t.Fatal("验证码错误时不应该设置验证Cookie")
+	}
+
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = submit(code)
+	if cookieNamed(w, totpCookieName) == nil || len(shown) != recoveryCodeCount {
+		t.Fatalf("验证通过后应该设置验证Cookie并显示恢复码，实际 %d 个恢复码", len(shown))
+	}
+	var saved models.User
+	db.First(&saved, user.ID)
+	if !saved.IsTOTPSetup || saved.LastUsedTOTPCode != code {
+		t.Errorf("验证通过后应该启用TOTP并记录验证码: %v %q", saved.IsTOTPSetup, saved.LastUsedTOTPCode)
+	}
+
+	// 同一个验证码不能重复使用
+	w = submit(code)
+	if w.Header().Get("Location") != totpValidatePageURL || cookieNamed(w, loginFailCodeFlashCookie).Value != fmt.Sprint(login.FailCodeTOTPCodeHasBeenUsed) {
+		t.Errorf("重复使用验证码应该被拒绝，实际 %s", w.Header().Get("Location"))
+	}
+
+	var events []models.LoginEvent
+	db.Order("id").Find(&events)
+	if len(events) != 3 || events[0].Reason != models.LoginReasonWrongTOTPCode || !events[1].Success ||
+		events[2].Reason != models.LoginReasonTOTPCodeUsed || events[1].Method != models.LoginMethodTOTP {
+		t.Errorf("登录事件记录错误: %+v", events)
+	}
+}
+
+func TestTOTPDoThrottle(t *testing.T) {
+	useTestThrottle(t)
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.LoginEvent{}, &models.RecoveryCode{}); err != nil {
+		t.Fatal(err)
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "test", AccountName: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Name: "Alice", LDAPUserPass: models.LDAPUserPass{Account: "alice@example.com", TOTPSecret: key.Secret(), IsTOTPSetup: true}}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	handler := totpDo(db, newTestActivity(t, db), http.NotFoundHandler())
+	submit := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", totpDoURL, strings.NewReader(url.Values{"otp": {code}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = r.WithContext(context.WithValue(r.Context(), login.UserKey, user))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// 验证码和恢复码的失败一起计数
+	for i := 0; i < loginThrottleAccountFree; i++ {
+		submit("000000")
+	}
+	submit("aaaaa-bbbbb")
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := submit(code)
+	if hasCookie(w, totpCookieName) || !hasCookie(w, loginNoticeFlashCookie) || w.Header().Get("Location") != totpValidatePageURL {
+		t.Fatalf("连续失败后的等待期间应该拒绝正确的验证码，实际 %s", w.Header().Get("Location"))
+	}
+	var events int64
+	db.Model(&models.LoginEvent{}).Count(&events)
+	if events != int64(loginThrottleAccountFree+1) {
+		t.Errorf("等待期间的提交不应该验证验证码，实际 %d 条登录事件", events)
+	}
+
+	// 等待结束后验证通过，清零计数
+	loginThrottler.entries[throttleKey(throttleKindTOTP, fmt.Sprint(user.ID))].BlockedUntil = time.Now()
+	if w := submit(code); !hasCookie(w, totpCookieName) {
+		t.Fatalf("等待结束后应该可以通过验证")
+	}
+	if n := loginThrottler.failures(time.Now(), throttleKey(throttleKindTOTP, fmt.Sprint(user.ID))); n != 0 {
+		t.Errorf("验证通过后应该清零计数，实际 %d", n)
+	}
+}
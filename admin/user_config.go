@@ -1,6 +1,7 @@
 package admin
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"slices"
@@ -71,6 +72,68 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 		SearchColumns("name", "account").
 		PerPage(10)
 
+	// 邀请新用户，由用户通过邮件中的链接自己设置密码
+	cl.Action(inviteAction).ComponentFunc(invitationDialog(db)).
+		UpdateFunc(func(id string, ctx *web.EventContext, r *web.EventResponse) (err error) {
+			msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+			if user.Info().Verifier().Do(presets.PermCreate).WithReq(ctx.R).IsAllowed() != nil {
+				return perm.PermissionDenied
+			}
+			u, err := inviteUser(ctx, db, ab, ctx.R.FormValue("InviteName"), ctx.R.FormValue("InviteAccount"), ctx.R.Form["InviteRoles"])
+			if err != nil {
+				return errors.New(msgr.InvitationSendFailed + err.Error())
+			}
+			presets.ShowMessage(r, fmt.Sprintf(msgr.InvitationSent, u.Account), "")
+			r.Emit(user.NotifModelsUpdated(), presets.PayloadModelsUpdated{Ids: []string{fmt.Sprint(u.ID)}})
+			return nil
+		})
+
+	// 撤销所有用户的登录会话，只有管理员可以操作
+	cl.Action(revokeAllSessionsAction).ComponentFunc(revokeAllSessionsDialog).
+		UpdateFunc(func(id string, ctx *web.EventContext, r *web.EventResponse) (err error) {
+			msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+			if !slices.Contains(getCurrentUser(ctx.R).GetRoles(), models.RoleAdmin) {
+				return perm.PermissionDenied
+			}
+			count, err := revokeAllSessions(ctx, db, ab, loginSessionBuilder.GetLoginBuilder())
+			if err != nil {
+				return errors.New(msgr.SessionRevokeFailed + err.Error())
+			}
+			presets.ShowMessage(r, fmt.Sprintf(msgr.SessionAllRevoked, count), "")
+			return nil
+		})
+
+	// 还没有接受邀请的用户可以重新发送邀请
+	cl.RowMenu().RowMenuItem("ResendInvitation").ComponentFunc(func(obj interface{}, id string, ctx *web.EventContext) h.HTMLComponent {
+		if obj.(*models.User).Status != models.StatusPending ||
+			user.Info().Verifier().Do(presets.PermUpdate).WithReq(ctx.R).IsAllowed() != nil {
+			return nil
+		}
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return v.VListItem(
+			web.Slot(v.VIcon("mdi-email-arrow-right")).Name("prepend"),
+			v.VListItemTitle(h.Text(msgr.InvitationResendBtn)),
+		).Attr("@click", web.Plaid().
+			EventFunc(invitationResendEvent).
+			Query("id", id).
+			Go())
+	})
+
+	// 管理员可以在行菜单中模拟普通用户，列表已经预加载了角色
+	cl.RowMenu().RowMenuItem("Impersonate").ComponentFunc(func(obj interface{}, id string, ctx *web.EventContext) h.HTMLComponent {
+		if impersonatorFromContext(ctx.R.Context()) != nil || canImpersonate(getCurrentUser(ctx.R), obj.(*models.User)) != nil {
+			return nil
+		}
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return v.VListItem(
+			web.Slot(v.VIcon("mdi-account-switch")).Name("prepend"),
+			v.VListItemTitle(h.Text(msgr.ImpersonateBtn)),
+		).Attr("@click", web.Plaid().
+			EventFunc(impersonateEvent).
+			Query("id", id).
+			Go())
+	})
+
 	cl.FilterDataFunc(func(ctx *web.EventContext) vx.FilterData {
 		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
 
@@ -94,6 +157,7 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 				Options: []*vx.SelectItem{
 					{Text: msgr.UserStatusActive, Value: models.StatusActive},
 					{Text: msgr.UserStatusInactive, Value: models.StatusInactive},
+					{Text: msgr.UserStatusPending, Value: models.StatusPending},
 				},
 				SQLCondition: `status %s ?`,
 			},
@@ -156,6 +220,9 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 		case strings.ToLower(models.StatusInactive):
 			text = msgr.UserStatusInactive
 			color = "error"
+		case strings.ToLower(models.StatusPending):
+			text = msgr.UserStatusPending
+			color = "warning"
 		default:
 			if u.Status == "" {
 				text = msgr.UserStatusUnset
@@ -299,9 +366,11 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 			return
 		}
 
-		// 软删除用户
+		// 软删除用户，并撤销该用户的所有会话
 		if err = db.Delete(&models.User{}, uid).Error; err != nil {
 			ctx.Flash = msgr.DeleteUserFailed + err.Error()
+		} else if err = expireUserSessions(db, &user); err != nil {
+			ctx.Flash = msgr.SessionRevokeFailed + err.Error()
 		} else {
 			ctx.Flash = msgr.DeleteUserSuccess
 		}
@@ -310,7 +379,21 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 		return
 	})
 
-	ed := user.Editing("Type", "Name", "OAuthProvider", "OAuthIdentifier", "OAuthUserID", "Account", "Password", "Status", "Roles", "Company")
+	ed := user.Editing("Type", "Name", "OAuthProvider", "OAuthIdentifier", "OAuthUserID", "Account", "Password", "TOTP", "WebAuthn", "Sessions", "Status", "Roles", "Company")
+
+	// 通过编辑页面的删除操作软删除用户时，同样撤销该用户的所有会话
+	ed.WrapDeleteFunc(func(in presets.DeleteFunc) presets.DeleteFunc {
+		return func(obj interface{}, id string, ctx *web.EventContext) error {
+			if err := in(obj, id, ctx); err != nil {
+				return err
+			}
+			var u models.User
+			if err := db.Unscoped().First(&u, id).Error; err != nil {
+				return err
+			}
+			return expireUserSessions(db, &u)
+		}
+	})
 
 	// 使用FetchFunc来阻止编辑已删除用户
 	ed.FetchFunc(func(obj interface{}, id string, ctx *web.EventContext) (interface{}, error) {
@@ -362,10 +445,26 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 				return err
 			}
 		} else {
-			// 更新现有用户
+			// 更新现有用户，停用时撤销该用户的所有会话
+			var oldStatus string
+			if err := db.Model(&models.User{}).Select("status").Where("id = ?", u.ID).Scan(&oldStatus).Error; err != nil {
+				return err
+			}
 			if err := db.Save(u).Error; err != nil {
 				return err
 			}
+			if u.Status == models.StatusInactive && oldStatus != models.StatusInactive {
+				if err := expireUserSessions(db, u); err != nil {
+					return err
+				}
+			}
+		}
+
+		// 设置了新密码时保存密码历史
+		if ctx.R.FormValue("Password") != "" {
+			if err := currentPasswordPolicy.recordHistory(db, u); err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -400,7 +499,7 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 		} else {
 			return v.VSelect().Attr(web.VField(field.Name, field.Value(obj))...).
 				Label(field.Label).
-				Items(models.OAuthProviders)
+				Items(oauthProviderKeys())
 		}
 	})
 
@@ -430,15 +529,15 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 			Label(field.Label).
 			Type("password").
 			Placeholder(msgr.PasswordPlaceholder).
-			Hint(msgr.PasswordMinLengthHint).
+			Hint(currentPasswordPolicy.hint(msgr)).
 			ErrorMessages(field.Errors...)
 	}).SetterFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) (err error) {
 		u := obj.(*models.User)
 		if v := ctx.R.FormValue(field.Name); v != "" {
-			// 验证密码长度至少为12位
-			if len(v) < 12 {
-				msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
-				return fmt.Errorf(msgr.PasswordMinLengthError)
+			// 按密码策略检查，u.Password此时还是旧密码的哈希
+			msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+			if err := currentPasswordPolicy.check(db, msgr, u, v); err != nil {
+				return err
 			}
 			u.Password = v
 			u.EncryptPassword()
@@ -446,6 +545,219 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 		return nil
 	})
 
+	// 双因素认证状态，已经设置过的用户显示重置按钮
+	ed.Field("TOTP").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		u := obj.(*models.User)
+		if u.ID == 0 || u.IsOAuthUser() {
+			return nil
+		}
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+
+		var roles []role.Role
+		db.Model(u).Association("Roles").Find(&roles)
+		u.Roles = roles
+
+		status := msgr.UserTOTPNotEnabled
+		switch {
+		case u.IsTOTPSetup:
+			status = msgr.UserTOTPEnabled
+		case totpRequired(u):
+			status = msgr.UserTOTPRequired
+		}
+
+		var resetBtn h.HTMLComponent
+		if u.IsTOTPSetup || u.TOTPSecret != "" {
+			resetBtn = v.VBtn(msgr.UserTOTPResetBtn).
+				Size("small").
+				Variant(v.VariantTonal).
+				Color("warning").
+				Attr("@click", web.Plaid().
+					EventFunc(totpResetEvent).
+					Query("id", fmt.Sprint(u.ID)).
+					Go())
+		}
+
+		return h.Div(
+			h.Div(h.Text(msgr.UserTOTP)).Class("text-caption"),
+			h.Div(
+				h.Span(status).Class("mr-4"),
+				resetBtn,
+			).Class("d-flex align-center"),
+		).Class("mb-4")
+	})
+
+	// 注册重置双因素认证事件
+	user.RegisterEventFunc(totpResetEvent, func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if user.Info().Verifier().Do(presets.PermUpdate).WithReq(ctx.R).IsAllowed() != nil {
+			return r, perm.PermissionDenied
+		}
+
+		var target models.User
+		if err = db.Preload("Roles").First(&target, ctx.R.FormValue("id")).Error; err != nil {
+			ctx.Flash = msgr.UserNotFound + ": " + err.Error()
+			r.Reload = true
+			return r, nil
+		}
+		// 与列表一致，只有管理员可以操作管理员和经理
+		if !slices.Contains(getCurrentUser(ctx.R).GetRoles(), models.RoleAdmin) &&
+			slices.ContainsFunc(target.GetRoles(), func(name string) bool {
+				return name == models.RoleAdmin || name == models.RoleManager
+			}) {
+			return r, perm.PermissionDenied
+		}
+
+		if err = resetTOTP(ctx, db, ab, &target); err != nil {
+			return r, err
+		}
+		ctx.Flash = msgr.UserTOTPResetSuccess
+		r.Reload = true
+		return r, nil
+	})
+
+	ed.Field("WebAuthn").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		u := obj.(*models.User)
+		if u.ID == 0 || !webauthnEligible(u) {
+			return nil
+		}
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if err := loadWebAuthnCredentials(db, u); err != nil {
+			panic(err)
+		}
+
+		var rows []h.HTMLComponent
+		for _, c := range u.WebAuthnCredentials {
+			rows = append(rows, h.Div(
+				h.Div(
+					h.Div(h.Text(c.Name)),
+					h.Div(h.Text(webauthnCredentialUsage(msgr, c))).Class("text-caption"),
+				),
+				v.VBtn(msgr.WebAuthnRevokeBtn).
+					Size("small").
+					Variant(v.VariantTonal).
+					Color("warning").
+					Attr("@click", web.Plaid().
+						EventFunc(webauthnRevokeEvent).
+						Query("id", fmt.Sprint(u.ID)).
+						Query("credential", fmt.Sprint(c.ID)).
+						Go()),
+			).Class("d-flex justify-space-between align-center mb-2"))
+		}
+		if len(rows) == 0 {
+			rows = append(rows, h.Span(msgr.WebAuthnNone))
+		}
+
+		return h.Div(
+			h.Div(h.Text(msgr.WebAuthnTitle)).Class("text-caption"),
+			h.Div(rows...),
+		).Class("mb-4")
+	})
+
+	// 注册撤销安全密钥事件
+	user.RegisterEventFunc(webauthnRevokeEvent, func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if user.Info().Verifier().Do(presets.PermUpdate).WithReq(ctx.R).IsAllowed() != nil {
+			return r, perm.PermissionDenied
+		}
+
+		var target models.User
+		if err = db.Preload("Roles").First(&target, ctx.R.FormValue("id")).Error; err != nil {
+			ctx.Flash = msgr.UserNotFound + ": " + err.Error()
+			r.Reload = true
+			return r, nil
+		}
+		// 与重置双因素认证一致，只有管理员可以操作管理员和经理
+		if !slices.Contains(getCurrentUser(ctx.R).GetRoles(), models.RoleAdmin) &&
+			slices.ContainsFunc(target.GetRoles(), func(name string) bool {
+				return name == models.RoleAdmin || name == models.RoleManager
+			}) {
+			return r, perm.PermissionDenied
+		}
+
+		if err = adminRevokeWebAuthn(ctx, db, ab, &target, ctx.R.FormValue("credential")); err != nil {
+			return r, err
+		}
+		ctx.Flash = msgr.UserWebAuthnRevokeSuccess
+		r.Reload = true
+		return r, nil
+	})
+
+	ed.Field("Sessions").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+		u := obj.(*models.User)
+		if u.ID == 0 || u.DeletedAt.Valid {
+			return nil
+		}
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		return userSessionsField(db, u, msgr)
+	})
+
+	// 注册撤销会话事件，session为空时撤销该用户的所有会话
+	revokeSessionEvent := func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if user.Info().Verifier().Do(presets.PermUpdate).WithReq(ctx.R).IsAllowed() != nil {
+			return r, perm.PermissionDenied
+		}
+
+		var target models.User
+		if err = db.Preload("Roles").First(&target, ctx.R.FormValue("id")).Error; err != nil {
+			ctx.Flash = msgr.UserNotFound + ": " + err.Error()
+			r.Reload = true
+			return r, nil
+		}
+		// 与重置双因素认证一致，只有管理员可以操作管理员和经理
+		if !slices.Contains(getCurrentUser(ctx.R).GetRoles(), models.RoleAdmin) &&
+			slices.ContainsFunc(target.GetRoles(), func(name string) bool {
+				return name == models.RoleAdmin || name == models.RoleManager
+			}) {
+			return r, perm.PermissionDenied
+		}
+
+		if sessionID := ctx.R.FormValue("session"); sessionID != "" {
+			err = revokeSession(ctx, db, ab, &target, sessionID)
+			ctx.Flash = msgr.SessionRevoked
+		} else {
+			err = revokeUserSessions(ctx, db, ab, &target)
+			ctx.Flash = msgr.SessionUserRevoked
+		}
+		if err != nil {
+			ctx.Flash = msgr.SessionRevokeFailed + err.Error()
+		}
+		r.Reload = true
+		return r, nil
+	}
+	user.RegisterEventFunc(sessionRevokeEvent, revokeSessionEvent)
+	user.RegisterEventFunc(sessionRevokeAllEvent, revokeSessionEvent)
+
+	// 注册重新发送邀请事件
+	user.RegisterEventFunc(invitationResendEvent, func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if user.Info().Verifier().Do(presets.PermUpdate).WithReq(ctx.R).IsAllowed() != nil {
+			return r, perm.PermissionDenied
+		}
+		if err = resendInvitation(ctx, db, ab, ctx.R.FormValue("id")); err != nil {
+			ctx.Flash = msgr.InvitationSendFailed + err.Error()
+		} else {
+			ctx.Flash = msgr.InvitationResent
+		}
+		r.Reload = true
+		return r, nil
+	})
+
+	// 注册模拟用户事件
+	user.RegisterEventFunc(impersonateEvent, func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nAdminKey, Messages_zh_CN).(*Messages)
+		if err = startImpersonation(ctx, db, ab, ctx.R.FormValue("id")); err != nil {
+			if errors.Is(err, errImpersonateNotAdmin) {
+				return r, perm.PermissionDenied
+			}
+			ctx.Flash = msgr.ImpersonateFailed + err.Error()
+			r.Reload = true
+			return r, nil
+		}
+		r.RedirectURL = "/"
+		return r, nil
+	})
+
 	ed.Field("Status").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
 		u := obj.(*models.User)
 		// 如果是新用户且状态为空，则默认设置为活跃
@@ -453,9 +765,14 @@ func configUser(b *presets.Builder, ab *activity.Builder, db *gorm.DB, loginSess
 			u.Status = models.StatusActive
 		}
 
+		// 还没有接受邀请的用户保留pending状态，管理员也可以直接激活或停用
+		items := []string{models.StatusActive, models.StatusInactive}
+		if u.Status == models.StatusPending {
+			items = append(items, models.StatusPending)
+		}
 		return v.VSelect().Attr(web.VField(field.Name, field.Value(obj))...).
 			Label(field.Label).
-			Items([]string{models.StatusActive, models.StatusInactive})
+			Items(items)
 	})
 
 	ed.Field("Roles").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
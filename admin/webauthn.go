@@ -0,0 +1,844 @@
+package admin
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/qor5/admin/v3/activity"
+	plogin "github.com/qor5/admin/v3/login"
+	"github.com/qor5/admin/v3/presets"
+	"github.com/qor5/web/v3"
+	"github.com/qor5/x/v3/i18n"
+	"github.com/qor5/x/v3/login"
+	v "github.com/qor5/x/v3/ui/vuetify"
+	. "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+/*
+WebAuthn说明：
+
+1. 配置方式
+   - WEBAUTHN_RP_ID: 依赖方ID，即浏览器地址栏中的域名，默认使用BASE_URL的主机名
+   - WEBAUTHN_RP_NAME: 浏览器提示中显示的名称，默认qor5boot
+   - WEBAUTHN_RP_ORIGINS: 允许的来源，多个用逗号分隔，默认使用BASE_URL
+   - 没有配置BASE_URL也没有配置WEBAUTHN_RP_ID和WEBAUTHN_RP_ORIGINS时不启用
+
+2. 注册
+   - 本地密码和LDAP用户在个人资料的"安全密钥"页面注册，可以注册多个，也可以自己撤销
+   - 角色要求双因素认证但还没有启用的用户，可以注册安全密钥代替TOTP
+
+3. 通行密钥免密码登录
+   - 登录页面的"使用通行密钥登录"按钮，要求认证器验证用户（指纹、PIN等）
+   - 验证通过后签发1分钟内有效的一次性登录凭证，页面使用凭证代替密码提交登录表单，
+     由登录模块完成会话创建、停用检查、登录事件记录等后续步骤，见models.SetLoginTicketVerifier
+   - 使用有效凭证提交的登录不需要验证码，见login_captcha.go
+   - 通行密钥本身已经包含两个因素，登录后不再要求双因素认证
+   - 登录凭证保存在内存中，多实例部署时需要负载均衡保持会话
+   - 注册或验证过程的状态用从SETTINGS_SECRET派生的专用密钥加密后保存在Cookie中，
+     每个挑战只能完成一次，已经完成的挑战保存在内存中直到过期，重新提交旧的Cookie会被拒绝
+
+4. 双因素认证
+   - 注册了安全密钥的用户登录后需要通过双因素认证，验证页面可以选择安全密钥或TOTP验证码
+   - 验证通过后与TOTP一样设置验证Cookie，见totp.go
+
+5. 管理
+   - 管理员可以在用户编辑页面查看和撤销用户的安全密钥，撤销记录在操作日志中
+*/
+
+// WebAuthn页面和接口地址，登录接口在登录之前访问，需要跳过登录中间件
+const (
+	webauthnPathPrefix         = "/auth/webauthn/"
+	webauthnLoginPathPrefix    = "/auth/webauthn/login/"
+	webauthnLoginBeginURL      = "/auth/webauthn/login/begin"
+	webauthnLoginFinishURL     = "/auth/webauthn/login/finish"
+	webauthnVerifyBeginURL     = "/auth/webauthn/verify/begin"
+	webauthnVerifyFinishURL    = "/auth/webauthn/verify/finish"
+	webauthnRegisterBeginURL   = "/auth/webauthn/register/begin"
+	webauthnRegisterFinishURL  = "/auth/webauthn/register/finish"
+	webauthnCredentialsPageURL = "/auth/webauthn/credentials"
+	webauthnRevokeURL          = "/auth/webauthn/revoke"
+	webauthnScriptURL          = "/admin/assets/webauthn.js"
+)
+
+// webauthnCookieName 保存注册或验证过程状态的Cookie
+const webauthnCookieName = "qor5boot_webauthn"
+
+// webauthnCeremonyTimeout 注册或验证的超时时间
+const webauthnCeremonyTimeout = 5 * time.Minute
+
+// 通行密钥登录的一次性登录凭证
+const (
+	webauthnTicketPrefix = "webauthn:"
+	webauthnTicketTTL    = time.Minute
+)
+
+// 注册或验证过程的用途，开始和完成的用途必须一致
+const (
+	webauthnPurposeRegister = "register"
+	webauthnPurposeLogin    = "login"
+	webauthnPurposeVerify   = "verify"
+)
+
+// webauthnRevokeEvent 用户编辑页面撤销安全密钥的事件
+const webauthnRevokeEvent = "user_revoke_webauthn"
+
+// webauthnActionRevoke 撤销安全密钥的操作日志类型
+const webauthnActionRevoke = "RevokeWebAuthn"
+
+// webauthnNameMaxSize 安全密钥名称的最大字节数，与models.WebAuthnCredential一致
+const webauthnNameMaxSize = 100
+
+//go:embed assets/webauthn.js
+var webauthnScript []byte
+
+var (
+	webauthnRPID      = getEnvWithDefault("WEBAUTHN_RP_ID", "")
+	webauthnRPName    = getEnvWithDefault("WEBAUTHN_RP_NAME", "qor5boot")
+	webauthnRPOrigins = splitList(getEnvWithDefault("WEBAUTHN_RP_ORIGINS", ""))
+)
+
+var (
+	// errWebAuthnDisabled 没有配置依赖方时返回的错误
+	errWebAuthnDisabled = errors.New("未配置BASE_URL或WEBAUTHN_RP_ID和WEBAUTHN_RP_ORIGINS，不启用WebAuthn")
+	// errWebAuthnFailed 安全密钥验证失败，登录事件中记为安全密钥验证失败
+	errWebAuthnFailed = errors.New("安全密钥验证失败")
+)
+
+// webauthnTickets 签发后还没有使用的一次性登录凭证
+var webauthnTickets = struct {
+	sync.Mutex
+	m map[string]webauthnTicket
+}{m: map[string]webauthnTicket{}}
+
+// usedWebAuthnCeremonies 已经完成的注册或验证过程的挑战，保存到过期，防止重复提交同一个Cookie
+var usedWebAuthnCeremonies = newCaptchaReplay()
+
+// webauthnTicket 一次性登录凭证对应的账号和过期时间
+type webauthnTicket struct {
+	account string
+	expires time.Time
+}
+
+// webauthnCeremony 注册或验证过程中保存在加密Cookie中的状态
+type webauthnCeremony struct {
+	Purpose string               `json:"purpose"`
+	UserID  uint                 `json:"user_id,omitempty"`
+	Name    string               `json:"name,omitempty"` // 注册时填写的密钥名称
+	Session webauthn.SessionData `json:"session"`
+}
+
+// webauthnUser 把用户适配为webauthn.User，需要先加载用户的安全密钥
+type webauthnUser struct {
+	user *models.User
+}
+
+// WebAuthnID 返回用户ID作为用户句柄，通行密钥登录时用于查找用户
+func (u webauthnUser) WebAuthnID() []byte {
+	return webauthnUserHandle(u.user.ID)
+}
+
+// WebAuthnName 返回登录账号
+func (u webauthnUser) WebAuthnName() string {
+	return u.user.Account
+}
+
+// WebAuthnDisplayName 返回用户名称，没有名称时使用登录账号
+func (u webauthnUser) WebAuthnDisplayName() string {
+	return cmp.Or(u.user.Name, u.user.Account)
+}
+
+// WebAuthnCredentials 返回用户已经注册的安全密钥
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(u.user.WebAuthnCredentials))
+	for _, c := range u.user.WebAuthnCredentials {
+		creds = append(creds, toWebAuthnCredential(c))
+	}
+	return creds
+}
+
+// webauthnUserHandle 返回用户的用户句柄
+func webauthnUserHandle(id uint) []byte {
+	return []byte(strconv.FormatUint(uint64(id), 10))
+}
+
+// newWebAuthn 按配置创建依赖方，依赖方ID和来源默认从BASE_URL获取
+func newWebAuthn() (*webauthn.WebAuthn, error) {
+	rpID, origins := webauthnRPID, webauthnRPOrigins
+	if base, err := url.Parse(baseURL); err == nil && base.Host != "" {
+		rpID = cmp.Or(rpID, base.Hostname())
+		if len(origins) == 0 {
+			origins = []string{base.Scheme + "://" + base.Host}
+		}
+	}
+	if rpID == "" || len(origins) == 0 {
+		return nil, errWebAuthnDisabled
+	}
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: webauthnCeremonyTimeout, TimeoutUVD: webauthnCeremonyTimeout}
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: webauthnRPName,
+		RPOrigins:     origins,
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// webauthnEnabled 判断是否配置了依赖方
+func webauthnEnabled() bool {
+	_, err := newWebAuthn()
+	return err == nil
+}
+
+// webauthnEligible 判断用户是否可以使用安全密钥：只有本地密码和LDAP用户可以使用
+func webauthnEligible(u *models.User) bool {
+	return u != nil && !u.IsOAuthUser() && u.Account != ""
+}
+
+// loadWebAuthnCredentials 加载用户的安全密钥
+func loadWebAuthnCredentials(db *gorm.DB, u *models.User) error {
+	return db.Where("user_id = ?", u.ID).Order("id").Find(&u.WebAuthnCredentials).Error
+}
+
+// toWebAuthnCredential 把保存的安全密钥转换为验证使用的凭据
+func toWebAuthnCredential(c models.WebAuthnCredential) webauthn.Credential {
+	id, _ := base64.RawURLEncoding.DecodeString(c.CredentialID)
+	var transports []protocol.AuthenticatorTransport
+	for _, t := range splitList(c.Transports) {
+		transports = append(transports, protocol.AuthenticatorTransport(t))
+	}
+	return webauthn.Credential{
+		ID:              id,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    true,
+			BackupEligible: c.BackupEligible,
+			BackupState:    c.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.AAGUID,
+			SignCount: c.SignCount,
+		},
+	}
+}
+
+// newWebAuthnCredential 根据注册结果创建要保存的安全密钥
+func newWebAuthnCredential(userID uint, name string, cred *webauthn.Credential) *models.WebAuthnCredential {
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	return &models.WebAuthnCredential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    base64.RawURLEncoding.EncodeToString(cred.ID),
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Transports:      strings.Join(transports, ","),
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	}
+}
+
+// touchWebAuthnCredential 验证通过后保存签名计数器、同步状态和使用时间
+// 签名计数器没有增加说明认证器可能被复制，拒绝这次验证
+func touchWebAuthnCredential(db *gorm.DB, u *models.User, cred *webauthn.Credential, now time.Time) error {
+	if cred.Authenticator.CloneWarning {
+		models.AuthLog().Warn("安全密钥的签名计数器没有增加，认证器可能被复制",
+			"account", models.MaskAccount(u.Account),
+			"sign_count", cred.Authenticator.SignCount,
+		)
+		return errWebAuthnFailed
+	}
+	return db.Model(&models.WebAuthnCredential{}).
+		Where("user_id = ? AND credential_id = ?", u.ID, base64.RawURLEncoding.EncodeToString(cred.ID)).
+		Updates(map[string]interface{}{
+			"sign_count":   cred.Authenticator.SignCount,
+			"backup_state": cred.Flags.BackupState,
+			"last_used_at": now,
+		}).Error
+}
+
+// revokeWebAuthnCredential 删除用户的安全密钥
+// 参数：
+// - db: 数据库连接
+// - userID: 安全密钥所属的用户，只能删除该用户的安全密钥
+// - id: 安全密钥的ID
+// 返回：
+// - *models.WebAuthnCredential: 被删除的安全密钥
+// - error: 安全密钥不存在或删除失败时的错误信息
+func revokeWebAuthnCredential(db *gorm.DB, userID uint, id string) (*models.WebAuthnCredential, error) {
+	var cred models.WebAuthnCredential
+	if err := db.Where("user_id = ?", userID).First(&cred, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	// 撤销的凭据不再保留，同一个认证器可以重新注册
+	if err := db.Unscoped().Delete(&cred).Error; err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// issueLoginTicket 为通过通行密钥验证的账号签发一次性登录凭证
+func issueLoginTicket(account string, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := webauthnTicketPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	webauthnTickets.Lock()
+	defer webauthnTickets.Unlock()
+	for k, t := range webauthnTickets.m {
+		if now.After(t.expires) {
+			delete(webauthnTickets.m, k)
+		}
+	}
+	webauthnTickets.m[ticket] = webauthnTicket{account: account, expires: now.Add(webauthnTicketTTL)}
+	return ticket, nil
+}
+
+// verifyLoginTicket 验证并作废一次性登录凭证，由登录模块验证密码时调用
+func verifyLoginTicket(account, ticket string) bool {
+	if !strings.HasPrefix(ticket, webauthnTicketPrefix) {
+		return false
+	}
+	webauthnTickets.Lock()
+	defer webauthnTickets.Unlock()
+	t, ok := webauthnTickets.m[ticket]
+	delete(webauthnTickets.m, ticket)
+	return ok && t.account == account && time.Now().Before(t.expires)
+}
+
+// passkeyLogin 判断登录请求是否使用有效的一次性登录凭证代替密码，只检查不作废，凭证仍由登录模块验证
+// 通行密钥已经验证过用户，使用凭证登录时不需要验证码
+func passkeyLogin(r *http.Request) bool {
+	ticket := r.FormValue("password")
+	if !strings.HasPrefix(ticket, webauthnTicketPrefix) {
+		return false
+	}
+	webauthnTickets.Lock()
+	defer webauthnTickets.Unlock()
+	t, ok := webauthnTickets.m[ticket]
+	return ok && t.account == r.FormValue("account") && time.Now().Before(t.expires)
+}
+
+// setWebAuthnCeremony 把注册或验证过程的状态加密后保存到Cookie
+func setWebAuthnCeremony(w http.ResponseWriter, c *webauthnCeremony) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	value, err := encryptSecret(secretPurposeWebAuthn, string(data))
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCookieName,
+		Value:    value,
+		Path:     webauthnPathPrefix,
+		MaxAge:   int(webauthnCeremonyTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(baseURL, "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// takeWebAuthnCeremony 读取并删除Cookie中的状态，每次开始只能完成一次
+// 删除Cookie不能阻止重新提交旧的Cookie，已经使用的挑战保存在内存中直到过期
+func takeWebAuthnCeremony(w http.ResponseWriter, r *http.Request, purpose string) (*webauthnCeremony, error) {
+	cookie, err := r.Cookie(webauthnCookieName)
+	if err != nil {
+		return nil, errors.New("没有进行中的WebAuthn验证")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCookieName,
+		Value:    "",
+		Path:     webauthnPathPrefix,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	plain, err := decryptSecret(secretPurposeWebAuthn, cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	var c webauthnCeremony
+	if err := json.Unmarshal([]byte(plain), &c); err != nil {
+		return nil, err
+	}
+	if c.Purpose != purpose {
+		return nil, fmt.Errorf("WebAuthn验证的用途不一致: %s", c.Purpose)
+	}
+	now := time.Now()
+	expires := c.Session.Expires
+	if expires.IsZero() {
+		expires = now.Add(webauthnCeremonyTimeout)
+	}
+	if !usedWebAuthnCeremonies.use(now, c.Session.Challenge, expires) {
+		return nil, errors.New("WebAuthn验证已经完成，不能重复提交")
+	}
+	return &c, nil
+}
+
+// webauthnMessages 返回当前请求语言的提示
+func webauthnMessages(r *http.Request) *Messages {
+	return i18n.MustGetModuleMessages(r, I18nAdminKey, Messages_zh_CN).(*Messages)
+}
+
+// writeWebAuthnJSON 返回JSON响应
+func writeWebAuthnJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeWebAuthnError 返回页面上显示的错误提示
+func writeWebAuthnError(w http.ResponseWriter, status int, message string) {
+	writeWebAuthnJSON(w, status, map[string]string{"error": message})
+}
+
+// webauthnPost 只接受POST请求的接口
+func webauthnPost(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// mountWebAuthn 挂载安全密钥管理页面、注册、登录和双因素认证的接口
+func mountWebAuthn(mux *http.ServeMux, pb *presets.Builder, vh *login.ViewHelper, db *gorm.DB) {
+	models.SetLoginTicketVerifier(verifyLoginTicket)
+
+	wb := web.New()
+	credentialsPage := vh.I18n().EnsureLanguage(wb.Page(webauthnCredentialsPage(pb, vh)))
+	mux.HandleFunc(webauthnCredentialsPageURL, func(w http.ResponseWriter, r *http.Request) {
+		if !webauthnEligible(getCurrentUser(r)) || !webauthnEnabled() {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		credentialsPage.ServeHTTP(w, r)
+	})
+	mux.HandleFunc(webauthnRevokeURL, webauthnPost(webauthnRevoke(db)))
+	mux.HandleFunc(webauthnScriptURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Write(webauthnScript)
+	})
+
+	handlers := map[string]http.HandlerFunc{
+		webauthnRegisterBeginURL:  webauthnRegisterBegin,
+		webauthnRegisterFinishURL: webauthnRegisterFinish(db),
+		webauthnLoginBeginURL:     webauthnLoginBegin,
+		webauthnLoginFinishURL:    webauthnLoginFinish(db),
+		webauthnVerifyBeginURL:    webauthnVerifyBegin,
+		webauthnVerifyFinishURL:   webauthnVerifyFinish(db),
+	}
+	for path, h := range handlers {
+		mux.Handle(path, vh.I18n().EnsureLanguage(webauthnPost(h)))
+	}
+}
+
+// webauthnScriptTag 页面引用WebAuthn脚本的标签
+func webauthnScriptTag() string {
+	return fmt.Sprintf(`<script src="%s" defer></script>`, webauthnScriptURL)
+}
+
+// webauthnButton 触发WebAuthn流程的按钮，由webauthn.js处理点击
+func webauthnButton(msgr *Messages, label, flow, beginURL, finishURL string) *v.VBtnBuilder {
+	return v.VBtn(label).
+		Block(true).
+		Size(v.SizeLarge).
+		Attr("data-webauthn", flow).
+		Attr("data-begin", beginURL).
+		Attr("data-finish", finishURL).
+		Attr("data-error", msgr.WebAuthnFailed).
+		Attr("data-unsupported", msgr.WebAuthnUnsupported)
+}
+
+// withPasskeyLogin 在登录页面的用户名密码表单之后加上通行密钥登录按钮
+// 登录模块的登录页面没有提供扩展的位置，这里把页面渲染后插入到登录表单之后
+func withPasskeyLogin(vh *login.ViewHelper, page web.PageFunc) web.PageFunc {
+	return func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		if r, err = page(ctx); err != nil || !webauthnEnabled() {
+			return
+		}
+		body, err := r.Body.MarshalHTML(ctx.R.Context())
+		if err != nil {
+			return
+		}
+		i := bytes.Index(body, []byte("</form>"))
+		if i < 0 {
+			return r, nil
+		}
+		i += len("</form>")
+
+		msgr := webauthnMessages(ctx.R)
+		btn, err := webauthnButton(msgr, msgr.WebAuthnLoginBtn, webauthnPurposeLogin, webauthnLoginBeginURL, webauthnLoginFinishURL).
+			Variant(v.VariantTonal).
+			Color(v.ColorPrimary).
+			PrependIcon("mdi-fingerprint").
+			Class("mt-4").
+			Attr("data-login", vh.PasswordLoginURL()).
+			MarshalHTML(ctx.R.Context())
+		if err != nil {
+			return
+		}
+		ctx.Injector.HeadHTML(webauthnScriptTag())
+		r.Body = RawHTML(string(body[:i]) + string(btn) + string(body[i:]))
+		return
+	}
+}
+
+// webauthnCredentialsPage 当前用户的安全密钥列表，可以注册新的安全密钥和撤销已有的安全密钥
+func webauthnCredentialsPage(pb *presets.Builder, vh *login.ViewHelper) web.PageFunc {
+	return pb.PlainLayout(func(ctx *web.EventContext) (r web.PageResponse, err error) {
+		msgr := webauthnMessages(ctx.R)
+		loginMsgr := i18n.MustGetModuleMessages(ctx.R, login.I18nLoginKey, login.Messages_en_US).(*login.Messages)
+		u := getCurrentUser(ctx.R)
+		ctx.Injector.HeadHTML(webauthnScriptTag())
+
+		var rows []HTMLComponent
+		for _, c := range u.WebAuthnCredentials {
+			rows = append(rows, Div(
+				Div(
+					Div(Text(c.Name)).Class("font-weight-bold"),
+					Div(Text(webauthnCredentialUsage(msgr, c))).Class("text-caption"),
+				).Class("text-left"),
+				Form(
+					Input("id").Type("hidden").Value(fmt.Sprint(c.ID)),
+					v.VBtn(msgr.WebAuthnRevokeBtn).Size(v.SizeSmall).Variant(v.VariantTonal).Color("warning").Attr("type", "submit"),
+				).Method(http.MethodPost).Action(webauthnRevokeURL),
+			).Class("d-flex justify-space-between align-center my-3"))
+		}
+		if len(rows) == 0 {
+			rows = append(rows, Div(Text(msgr.WebAuthnNone)).Class("my-3 text-medium-emphasis"))
+		}
+
+		r.PageTitle = msgr.WebAuthnTitle
+		r.Body = Div(
+			plogin.DefaultViewCommon.Notice(vh, loginMsgr, ctx.W, ctx.R),
+			Div(
+				Div(
+					H1(msgr.WebAuthnTitle).Class(plogin.DefaultViewCommon.TitleClass),
+					Label(msgr.WebAuthnPrompt),
+				),
+				Div(rows...).Class("my-4"),
+				plogin.DefaultViewCommon.Input("webauthn-name", msgr.WebAuthnNamePlaceholder, "").Class("mt-6"),
+				webauthnButton(msgr, msgr.WebAuthnRegisterBtn, webauthnPurposeRegister, webauthnRegisterBeginURL, webauthnRegisterFinishURL).
+					Variant(v.VariantFlat).
+					Color(v.ColorPrimary).
+					Attr("data-name-input", "#webauthn-name"),
+				A(Text(msgr.Back)).Href("/").Class("d-block mt-4"),
+			).Class(plogin.DefaultViewCommon.WrapperClass).Style(plogin.DefaultViewCommon.WrapperStyle).Class("text-center"),
+		)
+		return
+	})
+}
+
+// webauthnCredentialUsage 返回安全密钥的注册时间和最近使用时间
+func webauthnCredentialUsage(msgr *Messages, c models.WebAuthnCredential) string {
+	used := msgr.WebAuthnNeverUsed
+	if c.LastUsedAt != nil {
+		used = fmt.Sprintf(msgr.WebAuthnLastUsedAt, c.LastUsedAt.Local().Format(time.DateTime))
+	}
+	return fmt.Sprintf(msgr.WebAuthnRegisteredAt, c.CreatedAt.Local().Format(time.DateTime)) + "，" + used
+}
+
+// webauthnRevoke 撤销当前用户自己的安全密钥
+func webauthnRevoke(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := getCurrentUser(r)
+		if !webauthnEligible(u) {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		cred, err := revokeWebAuthnCredential(db, u.ID, r.FormValue("id"))
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			panic(err)
+		}
+		if cred != nil {
+			models.AuthLog().Info("已撤销安全密钥", "account", models.MaskAccount(u.Account), "name", cred.Name)
+		}
+		http.Redirect(w, r, webauthnCredentialsPageURL, http.StatusFound)
+	}
+}
+
+// webauthnRegisterBegin 开始注册安全密钥，请求中包含密钥名称
+func webauthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	msgr := webauthnMessages(r)
+	u := getCurrentUser(r)
+	if !webauthnEligible(u) {
+		writeWebAuthnError(w, http.StatusForbidden, msgr.WebAuthnFailed)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	name := truncateUTF8(strings.TrimSpace(req.Name), webauthnNameMaxSize)
+	if name == "" {
+		writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnNameRequired)
+		return
+	}
+
+	wa, err := newWebAuthn()
+	if err != nil {
+		writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+		return
+	}
+	user := webauthnUser{u}
+	var exclusions []protocol.CredentialDescriptor
+	for _, c := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+	creation, session, err := wa.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		panic(err)
+	}
+	if err = setWebAuthnCeremony(w, &webauthnCeremony{Purpose: webauthnPurposeRegister, UserID: u.ID, Name: name, Session: *session}); err != nil {
+		panic(err)
+	}
+	writeWebAuthnJSON(w, http.StatusOK, creation)
+}
+
+// webauthnRegisterFinish 验证认证器返回的注册结果并保存安全密钥
+// 角色要求双因素认证但还没有启用的用户，注册后视为通过双因素认证
+func webauthnRegisterFinish(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		msgr := webauthnMessages(r)
+		u := getCurrentUser(r)
+		if !webauthnEligible(u) {
+			writeWebAuthnError(w, http.StatusForbidden, msgr.WebAuthnFailed)
+			return
+		}
+		wa, err := newWebAuthn()
+		if err != nil {
+			writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+			return
+		}
+
+		c, err := takeWebAuthnCeremony(w, r, webauthnPurposeRegister)
+		if err == nil && c.UserID != u.ID {
+			err = errors.New("注册安全密钥的用户不一致")
+		}
+		var cred *webauthn.Credential
+		if err == nil {
+			var parsed *protocol.ParsedCredentialCreationData
+			if parsed, err = protocol.ParseCredentialCreationResponseBody(r.Body); err == nil {
+				cred, err = wa.CreateCredential(webauthnUser{u}, c.Session, parsed)
+			}
+		}
+		if err != nil {
+			models.AuthLog().Warn("注册安全密钥失败", "account", models.MaskAccount(u.Account), "error", err)
+			writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+			return
+		}
+
+		now := time.Now()
+		setup := totpEnforced(u) && !totpValidated(r, u, now)
+		if err = db.Create(newWebAuthnCredential(u.ID, c.Name, cred)).Error; err != nil {
+			panic(err)
+		}
+		models.AuthLog().Info("已注册安全密钥", "account", models.MaskAccount(u.Account), "name", c.Name)
+		if setup {
+			setTOTPValidated(w, u, now)
+		}
+		writeWebAuthnJSON(w, http.StatusOK, map[string]string{"redirect": webauthnCredentialsPageURL})
+	}
+}
+
+// webauthnLoginBegin 开始通行密钥登录，不指定用户，由认证器选择通行密钥
+func webauthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	msgr := webauthnMessages(r)
+	wa, err := newWebAuthn()
+	if err != nil {
+		writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+		return
+	}
+	assertion, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		panic(err)
+	}
+	if err = setWebAuthnCeremony(w, &webauthnCeremony{Purpose: webauthnPurposeLogin, Session: *session}); err != nil {
+		panic(err)
+	}
+	writeWebAuthnJSON(w, http.StatusOK, assertion)
+}
+
+// webauthnLoginFinish 验证通行密钥，通过后签发一次性登录凭证，由页面提交登录表单完成登录
+func webauthnLoginFinish(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		msgr := webauthnMessages(r)
+		wa, err := newWebAuthn()
+		if err != nil {
+			writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+			return
+		}
+
+		var u *models.User
+		var cred *webauthn.Credential
+		c, err := takeWebAuthnCeremony(w, r, webauthnPurposeLogin)
+		if err == nil {
+			var parsed *protocol.ParsedCredentialAssertionData
+			if parsed, err = protocol.ParseCredentialRequestResponseBody(r.Body); err == nil {
+				_, cred, err = wa.ValidatePasskeyLogin(func(_, userHandle []byte) (webauthn.User, error) {
+					found, err := findWebAuthnUser(db, userHandle)
+					if err != nil {
+						return nil, err
+					}
+					u = found
+					return webauthnUser{found}, nil
+				}, c.Session, parsed)
+			}
+		}
+		now := time.Now()
+		if err == nil {
+			err = touchWebAuthnCredential(db, u, cred, now)
+		}
+		var ticket string
+		if err == nil {
+			ticket, err = issueLoginTicket(u.Account, now)
+		}
+		if err != nil {
+			models.AuthLog().Warn("通行密钥登录失败", "error", err)
+			var user interface{}
+			if u != nil {
+				user = u
+			}
+			saveLoginEvent(db, newLoginEvent(r, user, errWebAuthnFailed))
+			writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+			return
+		}
+
+		// 通行密钥要求验证用户，同时满足双因素认证
+		setTOTPValidated(w, u, now)
+		writeWebAuthnJSON(w, http.StatusOK, map[string]string{"account": u.Account, "ticket": ticket})
+	}
+}
+
+// findWebAuthnUser 按用户句柄查找可以使用安全密钥的用户并加载安全密钥
+func findWebAuthnUser(db *gorm.DB, userHandle []byte) (*models.User, error) {
+	id, err := strconv.ParseUint(string(userHandle), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("用户句柄格式错误: %w", err)
+	}
+	var u models.User
+	if err := db.First(&u, id).Error; err != nil {
+		return nil, err
+	}
+	if !webauthnEligible(&u) {
+		return nil, errors.New("用户不能使用安全密钥")
+	}
+	if err := loadWebAuthnCredentials(db, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// webauthnVerifyBegin 开始使用安全密钥进行双因素认证
+func webauthnVerifyBegin(w http.ResponseWriter, r *http.Request) {
+	msgr := webauthnMessages(r)
+	u := getCurrentUser(r)
+	wa, err := newWebAuthn()
+	if err != nil || !webauthnEligible(u) || len(u.WebAuthnCredentials) == 0 {
+		writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+		return
+	}
+	assertion, session, err := wa.BeginLogin(webauthnUser{u})
+	if err != nil {
+		panic(err)
+	}
+	if err = setWebAuthnCeremony(w, &webauthnCeremony{Purpose: webauthnPurposeVerify, UserID: u.ID, Session: *session}); err != nil {
+		panic(err)
+	}
+	writeWebAuthnJSON(w, http.StatusOK, assertion)
+}
+
+// webauthnVerifyFinish 验证安全密钥，通过后设置双因素认证的验证Cookie并记录登录事件
+func webauthnVerifyFinish(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		msgr := webauthnMessages(r)
+		u := getCurrentUser(r)
+		wa, err := newWebAuthn()
+		if err != nil || !webauthnEligible(u) {
+			writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+			return
+		}
+
+		c, err := takeWebAuthnCeremony(w, r, webauthnPurposeVerify)
+		if err == nil && c.UserID != u.ID {
+			err = errors.New("验证安全密钥的用户不一致")
+		}
+		var cred *webauthn.Credential
+		if err == nil {
+			var parsed *protocol.ParsedCredentialAssertionData
+			if parsed, err = protocol.ParseCredentialRequestResponseBody(r.Body); err == nil {
+				cred, err = wa.ValidateLogin(webauthnUser{u}, c.Session, parsed)
+			}
+		}
+		now := time.Now()
+		if err == nil {
+			err = touchWebAuthnCredential(db, u, cred, now)
+		}
+		if err != nil {
+			models.AuthLog().Warn("安全密钥验证失败", "account", models.MaskAccount(u.Account), "error", err)
+			saveLoginEvent(db, newLoginEvent(r, u, errWebAuthnFailed))
+			writeWebAuthnError(w, http.StatusBadRequest, msgr.WebAuthnFailed)
+			return
+		}
+
+		saveLoginEvent(db, newLoginEvent(r, u, nil))
+		setTOTPValidated(w, u, now)
+		writeWebAuthnJSON(w, http.StatusOK, map[string]string{"redirect": "/"})
+	}
+}
+
+// adminRevokeWebAuthn 管理员撤销用户的安全密钥，并记录操作日志
+// 参数：
+// - ctx: 管理员的请求
+// - db: 数据库连接
+// - ab: 操作日志
+// - user: 安全密钥所属的用户
+// - id: 安全密钥的ID
+// 返回：
+// - error: 安全密钥不存在或删除失败时的错误信息，操作日志记录失败只输出日志
+func adminRevokeWebAuthn(ctx *web.EventContext, db *gorm.DB, ab *activity.Builder, user *models.User, id string) error {
+	cred, err := revokeWebAuthnCredential(db, user.ID, id)
+	if err != nil {
+		return err
+	}
+	models.AuthLog().Info("管理员撤销了安全密钥",
+		"account", models.MaskAccount(user.Account),
+		"name", cred.Name,
+		"operator", models.MaskAccount(getCurrentUser(ctx.R).Account),
+	)
+	if _, err := ab.Log(ctx.R.Context(), webauthnActionRevoke, user, nil); err != nil {
+		models.AuthLog().Error("记录撤销安全密钥的操作日志失败", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/qor5/admin/v3/role"
+	"github.com/qor5/x/v3/login"
+
+	"github.com/naokij/qor5boot/models"
+)
+
+// softAuthenticator 测试使用的软件认证器，使用P-256密钥，证明格式为none
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	rpID       string
+	origin     string
+	count      uint32
+}
+
+func newSoftAuthenticator(t *testing.T, rpID, origin string) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, id: id, rpID: rpID, origin: origin}
+}
+
+// authenticatorData 生成认证器数据，注册时包含凭据ID和公钥
+func (a *softAuthenticator) authenticatorData(t *testing.T, attested bool) []byte {
+	t.Helper()
+	rpHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+	data := append(rpHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	if !attested {
+		return data
+	}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+	data = append(data, a.id...)
+	return append(data, publicKey...)
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge protocol.URLEncodedBase64) []byte {
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge.String(), "origin": a.origin})
+	return data
+}
+
+// create 模拟navigator.credentials.create，返回提交给服务端的JSON
+func (a *softAuthenticator) create(t *testing.T, options []byte) []byte {
+	t.Helper()
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal(options, &creation); err != nil {
+		t.Fatal(err)
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(creation.Response.User.ID.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = userHandle
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(a.clientData("webauthn.create", creation.Response.Challenge)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	})
+	return body
+}
+
+// get 模拟navigator.credentials.get，返回提交给服务端的JSON
+func (a *softAuthenticator) get(t *testing.T, options []byte) []byte {
+	t.Helper()
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal(options, &assertion); err != nil {
+		t.Fatal(err)
+	}
+	a.count++
+	clientData := a.clientData("webauthn.get", assertion.Response.Challenge)
+	authData := a.authenticatorData(t, false)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(sig),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+		},
+	})
+	return body
+}
+
+// useWebAuthnTestConfig 在测试期间配置依赖方和加密密钥
+func useWebAuthnTestConfig(t *testing.T) {
+	t.Helper()
+	useSettingsSecret(t, "test-secret")
+	old := baseURL
+	baseURL = "https://admin.example.com"
+	t.Cleanup(func() { baseURL = old })
+}
+
+// callWebAuthn 调用WebAuthn接口，上一步设置的Cookie会一起提交
+func callWebAuthn(h http.HandlerFunc, path string, u *models.User, body []byte, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	if u != nil {
+		r = r.WithContext(context.WithValue(r.Context(), login.UserKey, u))
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// hasCookie 响应是否设置了指定的Cookie
+func hasCookie(w *httptest.ResponseRecorder, name string) bool {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	useWebAuthnTestConfig(t)
+	models.SetLoginTicketVerifier(verifyLoginTicket)
+	t.Cleanup(func() { models.SetLoginTicketVerifier(nil) })
+
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.WebAuthnCredential{}, &models.LoginEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Name: "Alice", LDAPUserPass: models.LDAPUserPass{Account: "alice@example.com"}}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	auth := newSoftAuthenticator(t, "admin.example.com", "https://admin.example.com")
+
+	// 注册
+	w := callWebAuthn(webauthnRegisterBegin, webauthnRegisterBeginURL, user, []byte(`{"name":" "}`), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("没有填写名称时应该拒绝，实际 %d", w.Code)
+	}
+	w = callWebAuthn(webauthnRegisterBegin, webauthnRegisterBeginURL, user, []byte(`{"name":"办公室电脑"}`), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("开始注册失败: %d %s", w.Code, w.Body)
+	}
+	w = callWebAuthn(webauthnRegisterFinish(db), webauthnRegisterFinishURL, user, auth.create(t, w.Body.Bytes()), w.Result().Cookies())
+	if w.Code != http.StatusOK {
+		t.Fatalf("完成注册失败: %d %s", w.Code, w.Body)
+	}
+	var creds []models.WebAuthnCredential
+	db.Find(&creds)
+	if len(creds) != 1 || creds[0].UserID != user.ID || creds[0].Name != "办公室电脑" ||
+		creds[0].CredentialID != base64.RawURLEncoding.EncodeToString(auth.id) {
+		t.Fatalf("保存的安全密钥错误: %+v", creds)
+	}
+
+	// 通行密钥登录
+	begin := callWebAuthn(webauthnLoginBegin, webauthnLoginBeginURL, nil, nil, nil)
+	if begin.Code != http.StatusOK {
+		t.Fatalf("开始登录失败: %d %s", begin.Code, begin.Body)
+	}
+	w = callWebAuthn(webauthnLoginFinish(db), webauthnLoginFinishURL, nil, auth.get(t, begin.Body.Bytes()), begin.Result().Cookies())
+	if w.Code != http.StatusOK {
+		t.Fatalf("通行密钥登录失败: %d %s", w.Code, w.Body)
+	}
+	var result struct {
+		Account string `json:"account"`
+		Ticket  string `json:"ticket"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Account != user.Account || !strings.HasPrefix(result.Ticket, webauthnTicketPrefix) {
+		t.Fatalf("应该返回账号和一次性登录凭证，实际 %+v", result)
+	}
+	if !hasCookie(w, totpCookieName) {
+		t.Error("通行密钥登录应该同时通过双因素认证")
+	}
+
+	// 删除Cookie后重新提交同一个验证过程
+	if replay := callWebAuthn(webauthnLoginFinish(db), webauthnLoginFinishURL, nil, auth.get(t, begin.Body.Bytes()), begin.Result().Cookies()); replay.Code != http.StatusBadRequest {
+		t.Errorf("已经完成的验证过程不能重复提交，实际 %d", replay.Code)
+	}
+
+	// 登录模块使用凭证代替密码验证，凭证只能使用一次
+	var loginUser models.User
+	db.First(&loginUser, user.ID)
+	if !loginUser.IsPasswordCorrect(result.Ticket) || loginUser.AuthMethod() != models.LoginMethodWebAuthn {
+		t.Errorf("登录凭证应该可以代替密码，认证方式 %q", loginUser.AuthMethod())
+	}
+	if loginUser.IsPasswordCorrect(result.Ticket) {
+		t.Error("登录凭证只能使用一次")
+	}
+
+	var saved models.WebAuthnCredential
+	db.First(&saved, creds[0].ID)
+	if saved.SignCount != 1 || saved.LastUsedAt == nil {
+		t.Errorf("验证后应该更新签名计数器和使用时间: %d %v", saved.SignCount, saved.LastUsedAt)
+	}
+
+	// 没有开始登录时直接提交验证结果
+	w = callWebAuthn(webauthnLoginFinish(db), webauthnLoginFinishURL, nil, auth.get(t, []byte(`{"publicKey":{"challenge":"AAAA"}}`)), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("没有进行中的验证时应该拒绝，实际 %d", w.Code)
+	}
+	var event models.LoginEvent
+	db.Last(&event)
+	if event.Success || event.Method != models.LoginMethodWebAuthn || event.Reason != models.LoginReasonWebAuthnFailed {
+		t.Errorf("登录失败的事件记录错误: %+v", event)
+	}
+}
+
+func TestWebAuthnSecondFactor(t *testing.T) {
+	useWebAuthnTestConfig(t)
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&models.WebAuthnCredential{}, &models.LoginEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Name: "Bob", LDAPUserPass: models.LDAPUserPass{Account: "bob@example.com"}}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	auth := newSoftAuthenticator(t, "admin.example.com", "https://admin.example.com")
+	w := callWebAuthn(webauthnRegisterBegin, webauthnRegisterBeginURL, user, []byte(`{"name":"YubiKey"}`), nil)
+	w = callWebAuthn(webauthnRegisterFinish(db), webauthnRegisterFinishURL, user, auth.create(t, w.Body.Bytes()), w.Result().Cookies())
+	if w.Code != http.StatusOK {
+		t.Fatalf("注册失败: %d %s", w.Code, w.Body)
+	}
+	if err := loadWebAuthnCredentials(db, user); err != nil || !totpEnforced(user) {
+		t.Fatalf("注册了安全密钥的用户应该需要双因素认证: %v", err)
+	}
+
+	other := &models.User{Name: "Eve", LDAPUserPass: models.LDAPUserPass{Account: "eve@example.com"}}
+	other.ID = user.ID + 1
+	w = callWebAuthn(webauthnVerifyBegin, webauthnVerifyBeginURL, user, nil, nil)
+	if w := callWebAuthn(webauthnVerifyFinish(db), webauthnVerifyFinishURL, other, auth.get(t, w.Body.Bytes()), w.Result().Cookies()); w.Code != http.StatusBadRequest {
+		t.Errorf("其他用户不能使用开始的验证，实际 %d", w.Code)
+	}
+
+	w = callWebAuthn(webauthnVerifyBegin, webauthnVerifyBeginURL, user, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("开始验证失败: %d %s", w.Code, w.Body)
+	}
+	w = callWebAuthn(webauthnVerifyFinish(db), webauthnVerifyFinishURL, user, auth.get(t, w.Body.Bytes()), w.Result().Cookies())
+	if w.Code != http.StatusOK || !hasCookie(w, totpCookieName) {
+		t.Fatalf("验证通过后应该设置验证Cookie: %d %s", w.Code, w.Body)
+	}
+
+	var event models.LoginEvent
+	db.Last(&event)
+	if !event.Success || event.Method != models.LoginMethodWebAuthn || event.UserID == nil || *event.UserID != user.ID {
+		t.Errorf("验证通过的事件记录错误: %+v", event)
+	}
+
+	// 只能撤销自己的安全密钥
+	id := strconv.FormatUint(uint64(user.WebAuthnCredentials[0].ID), 10)
+	if _, err := revokeWebAuthnCredential(db, other.ID, id); err == nil {
+		t.Error("不能撤销其他用户的安全密钥")
+	}
+	if cred, err := revokeWebAuthnCredential(db, user.ID, id); err != nil || cred.Name != "YubiKey" {
+		t.Fatalf("撤销安全密钥失败: %v", err)
+	}
+	var count int64
+	db.Unscoped().Model(&models.WebAuthnCredential{}).Count(&count)
+	if count != 0 {
+		t.Errorf("撤销的安全密钥应该删除，实际还有 %d 个", count)
+	}
+}
+
+func TestLoginTicket(t *testing.T) {
+	now := time.Now()
+	ticket, err := issueLoginTicket("alice@example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifyLoginTicket("alice@example.com", strings.TrimPrefix(ticket, webauthnTicketPrefix)) {
+		t.Error("没有前缀的字符串不是登录凭证")
+	}
+	if verifyLoginTicket("bob@example.com", ticket) {
+		t.Error("登录凭证不能用于其他账号")
+	}
+	if verifyLoginTicket("alice@example.com", ticket) {
+		t.Error("验证失败后登录凭证也应该作废")
+	}
+
+	expired, _ := issueLoginTicket("alice@example.com", now.Add(-2*webauthnTicketTTL))
+	if verifyLoginTicket("alice@example.com", expired) {
+		t.Error("过期的登录凭证不应该有效")
+	}
+	ticket, _ = issueLoginTicket("alice@example.com", now)
+	if !verifyLoginTicket("alice@example.com", ticket) {
+		t.Error("有效的登录凭证应该验证通过")
+	}
+}
+
+func TestRequireTOTPWithWebAuthn(t *testing.T) {
+	withTOTPRequiredRoles(t, models.RoleAdmin)
+	vh := login.New().ViewHelper()
+	handler := requireTOTP(vh, "/auth/logout")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(method, path string, u *models.User, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if u != nil {
+			r = r.WithContext(context.WithValue(r.Context(), login.UserKey, u))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	withKey := &models.User{Roles: []role.Role{{Name: models.RoleViewer}}}
+	withKey.ID = 1
+	withKey.WebAuthnCredentials = []models.WebAuthnCredential{{Name: "YubiKey"}}
+	if w := serve("GET", "/admin/users", withKey, nil); w.Code != http.StatusFound || w.Header().Get("Location") != totpValidatePageURL {
+		t.Errorf("注册了安全密钥的用户应该跳转到验证页面，实际 %d %s", w.Code, w.Header().Get("Location"))
+	}
+	for _, path := range []string{webauthnVerifyBeginURL, webauthnVerifyFinishURL} {
+		if w := serve("POST", path, withKey, nil); w.Code != http.StatusOK {
+			t.Errorf("%s 在验证之前应该可以访问，实际 %d", path, w.Code)
+		}
+	}
+	if w := serve("GET", webauthnCredentialsPageURL, withKey, nil); w.Code != http.StatusFound {
+		t.Errorf("已经注册了安全密钥的用户验证之前不能注册新的安全密钥，实际 %d", w.Code)
+	}
+
+	admin := &models.User{Roles: []role.Role{{Name: models.RoleAdmin}}}
+	admin.ID = 2
+	for _, path := range []string{webauthnCredentialsPageURL, webauthnRegisterBeginURL, webauthnRegisterFinishURL} {
+		if w := serve("GET", path, admin, nil); w.Code != http.StatusOK {
+			t.Errorf("还没有启用的管理员可以注册安全密钥代替TOTP，%s 实际 %d", path, w.Code)
+		}
+	}
+
+	// 使用登录凭证登录时保留通行密钥验证时设置的Cookie，用密码登录时删除
+	w := serve("POST", vh.PasswordLoginURL(), nil, url.Values{"account": {"a"}, "password": {webauthnTicketPrefix + "x"}})
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("使用登录凭证登录时不应该删除验证Cookie: %v", w.Result().Cookies())
+	}
+	w = serve("POST", vh.PasswordLoginURL(), nil, url.Values{"account": {"a"}, "password": {"secret"}})
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].Name != totpCookieName || c[0].MaxAge >= 0 {
+		t.Errorf("使用密码登录时应该删除验证Cookie: %v", c)
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 个人访问令牌的权限范围
+const (
+	AccessTokenScopeRead  = "read"  // 只读，只允许GET、HEAD和OPTIONS请求
+	AccessTokenScopeWrite = "write" // 读写，允许所有请求
+)
+
+// AccessToken 个人访问令牌
+// 脚本使用Bearer令牌代替浏览器会话调用管理后台，权限与令牌所属的用户相同，只保存令牌的哈希
+type AccessToken struct {
+	gorm.Model
+	UserID     uint       `gorm:"index"`               // 用户ID
+	Name       string     `gorm:"size:100"`            // 用户填写的名称，例如"导出脚本"
+	TokenHash  string     `gorm:"size:64;uniqueIndex"` // 令牌的SHA-256哈希，十六进制
+	TokenHint  string     `gorm:"size:20"`             // 令牌的前几位，用于识别令牌
+	Scopes     string     `gorm:"size:255"`            // 权限范围，多个用逗号分隔
+	ExpiresAt  *time.Time // 过期时间，为空时不过期
+	LastUsedAt *time.Time // 最近一次使用的时间
+	LastUsedIP string     `gorm:"size:64"` // 最近一次使用的客户端IP
+}
+
+// HasScope 判断令牌是否包含指定的权限范围
+func (t AccessToken) HasScope(scope string) bool {
+	return slices.Contains(strings.Split(t.Scopes, ","), scope)
+}
+
+// Expired 判断令牌在指定时间是否已经过期
+func (t AccessToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+/*
+认证日志说明：
+
+认证过程（本地密码、LDAP、OAuth）的日志统一通过 AuthLog 输出，使用结构化的 key=value 格式，便于检索和采集：
+
+1. 日志级别
+   - info: 认证结果、用户开通、角色变更等关键事件
+   - warn: 配置错误、目录异常等需要处理的问题
+   - debug: LDAP连接、搜索、绑定等每个步骤的详细信息，只在排查问题时开启
+   - 默认级别为info，可以通过 SetAuthLogLevel 调整（admin包从AUTH_LOG_LEVEL环境变量读取）
+
+2. 脱敏
+   - 账号使用 MaskAccount 脱敏后输出，完整的账号只保存在登录事件表中
+   - 不输出密码，也不输出目录中的用户属性值，调试时只输出属性名和值的数量
+*/
+
+var (
+	authLogLevel = new(slog.LevelVar)
+	authLog      = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: authLogLevel})).With("component", "auth")
+)
+
+// AuthLog 返回认证日志
+func AuthLog() *slog.Logger {
+	return authLog
+}
+
+// SetAuthLogLevel 设置认证日志的级别
+// 参数：
+// - level: 日志级别，可选debug、info、warn、error，不区分大小写
+// 返回：
+// - error: 级别无效时的错误信息，此时保持原来的级别
+func SetAuthLogLevel(level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	authLogLevel.Set(l)
+	return nil
+}
+
+// AuthLogEnabled 判断认证日志是否会输出指定级别的日志，用于跳过开销较大的调试信息
+func AuthLogEnabled(level slog.Level) bool {
+	return level >= authLogLevel.Level()
+}
+
+// MaskAccount 脱敏账号，只保留第一个字符和邮箱域名
+// 例如 alice@example.com 脱敏为 a***@example.com，bob 脱敏为 b***
+func MaskAccount(account string) string {
+	if account == "" {
+		return ""
+	}
+	name, domain, hasDomain := strings.Cut(account, "@")
+	masked := "***"
+	if r := []rune(name); len(r) > 0 {
+		masked = string(r[0]) + masked
+	}
+	if hasDomain {
+		masked += "@" + domain
+	}
+	return masked
+}
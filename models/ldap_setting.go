@@ -0,0 +1,30 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// LDAPSetting LDAP认证配置
+// 在后台LDAP设置页面中保存，保存后代替环境变量中的LDAP配置，数据库中只保存一条记录
+type LDAPSetting struct {
+	gorm.Model
+	Enabled               bool   // 是否启用LDAP认证
+	Servers               string `gorm:"size:1024"` // 按优先级排列的服务器地址，用逗号分隔
+	BindDN                string `gorm:"size:512"`  // 服务账号DN
+	BindPasswordEncrypted string `gorm:"type:text"` // 加密后的服务账号密码
+	SearchBase            string `gorm:"size:512"`  // 用户搜索基础DN
+	SearchFilter          string `gorm:"size:512"`  // 用户搜索过滤器，%s替换为登录账号
+	UseTLS                bool   // 是否使用StartTLS
+	SkipVerify            bool   // 是否跳过TLS证书验证
+	CertFile              string `gorm:"size:512"`  // TLS证书文件路径
+	GroupAttribute        string `gorm:"size:255"`  // 用户条目中表示所属组的属性
+	GroupSearchBase       string `gorm:"size:512"`  // 组搜索基础DN
+	GroupSearchFilter     string `gorm:"size:512"`  // 组搜索过滤器，%s替换为用户DN
+	GroupRoleMapping      string `gorm:"type:text"` // 组到角色的映射，格式为"组:角色"，多个映射用分号分隔
+	RevokeRoles           bool   // 登录时是否移除目录不再授予的映射角色
+	JITProvisioning       bool   // 首次LDAP登录时是否自动创建用户
+	DefaultRoles          string `gorm:"size:512"` // 自动创建的用户的默认角色，用逗号分隔
+	NameAttribute         string `gorm:"size:255"` // 用户姓名属性
+	CompanyAttribute      string `gorm:"size:255"` // 用户公司属性
+	SyncFilter            string `gorm:"size:512"` // 目录同步时搜索用户的过滤器
+}
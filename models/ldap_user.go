@@ -1,8 +1,8 @@
 package models
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/qor5/x/v3/login"
@@ -25,6 +25,7 @@ LDAP集成:
 - 通过admin包中的authenticateWithLDAP函数进行实际的LDAP认证
 - 支持动态启用/禁用LDAP功能
 - 认证成功后，在本地数据库中保存用户记录
+- 本地不存在但目录中存在的用户，在首次LDAP认证成功时自动创建（JIT开通）
 
 安全特性:
 - 密码存储采用哈希加密，不存储明文
@@ -39,15 +40,17 @@ LDAP集成:
 
 2. 认证与密码管理:
    - IsPasswordCorrect: 验证用户密码（LDAP优先，本地备选）
+   - AuthMethod: 获取验证密码时使用的认证方式（本地密码或LDAP），用于记录登录事件
    - EncryptPassword: 加密用户密码
    - SetPassword: 设置新密码
 
 3. 账户锁定机制:
    - GetLoginRetryCount: 获取登录失败次数
-   - GetLocked: 检查账户是否锁定
+   - GetLocked: 检查账户是否锁定，锁定超过lockDuration后自动解锁
    - LockUser: 锁定用户账户
    - UnlockUser: 解锁用户账户
-   - IncreaseRetryCount: 增加登录失败计数
+   - IncreaseRetryCount: 增加登录失败计数，锁定已经过期时重新计数
+   - lockDuration: 锁定时长，通过SetLockDuration设置，默认1小时
 
 4. 密码重置:
    - GenerateResetPasswordToken: 生成密码重置令牌
@@ -69,7 +72,14 @@ LDAP配置:
 - ldapServer: LDAP服务器地址
 - authenticateWithLDAP: LDAP认证函数
 
-这些配置通过SetLDAPConfig函数从admin包中导入
+- lookupLDAPUser: 在目录中查找本地不存在的用户，并填充用户属性
+- provisionLDAPUser: LDAP认证成功后在本地创建用户
+
+这些配置通过SetLDAPConfig和SetLDAPProvisioning函数从admin包中导入
+
+一次性登录凭证:
+- verifyLoginTicket: 验证通行密钥登录后签发的一次性凭证，通过SetLoginTicketVerifier设置
+  通行密钥验证通过后，页面使用凭证代替密码提交登录表单，由登录模块完成后续的会话创建
 */
 
 // LDAPUserPass 嵌入到User结构体，用于支持LDAP认证
@@ -88,17 +98,49 @@ type LDAPUserPass struct {
 	IsTOTPSetup                 bool
 	LastUsedTOTPCode            string
 	LastTOTPCodeUsedAt          *time.Time
+	// DirectoryDN 用户在LDAP目录中的DN，通过LDAP登录过的用户才有值，目录同步只处理这些用户
+	DirectoryDN string `gorm:"size:512"`
+
+	// provision 本地还未创建的目录用户在LDAP认证成功后执行的开通函数
+	provision func() error
+	// authMethod 最近一次验证密码时使用的认证方式，用于记录登录事件
+	authMethod string
 }
 
 // FindUser 查找用户
+// 本地不存在该账号且开启了LDAP开通时，会在目录中查找用户，
+// 找到后返回一个尚未保存的用户，在LDAP认证成功后才写入数据库
 func (up *LDAPUserPass) FindUser(db *gorm.DB, model interface{}, account string) (user interface{}, err error) {
 	err = db.Where("account = ?", account).
 		First(model).
 		Error
-	if err != nil {
+	if err == nil {
+		return model, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) || !ldapEnabled || lookupLDAPUser == nil {
+		return nil, err
+	}
+
+	// 注意：up 是登录模块共享的用户原型，不能在上面保存状态
+	u, ok := model.(*User)
+	if !ok {
+		return nil, err
+	}
+	found, lookupErr := lookupLDAPUser(account, u)
+	if lookupErr != nil {
+		authLog.Warn("在LDAP目录中查找用户失败", "account", MaskAccount(account), "error", lookupErr)
 		return nil, err
 	}
-	return model, nil
+	if !found {
+		return nil, err
+	}
+
+	u.Account = account
+	u.provision = func() error {
+		return provisionLDAPUser(db, u)
+	}
+	authLog.Info("本地不存在用户，将在LDAP认证成功后自动创建", "account", MaskAccount(account))
+	return u, nil
 }
 
 // GetAccountName 获取账号名称
@@ -116,7 +158,7 @@ func (up *LDAPUserPass) GetLocked() bool {
 	if !up.Locked {
 		return false
 	}
-	return up.Locked && up.LockedAt != nil && time.Now().Sub(*up.LockedAt) <= time.Hour
+	return up.LockedAt != nil && time.Since(*up.LockedAt) <= lockDuration
 }
 
 // GetTOTPSecret 获取TOTP密钥
@@ -142,44 +184,83 @@ func (up *LDAPUserPass) EncryptPassword() {
 
 // IsPasswordCorrect 验证密码是否正确
 func (up *LDAPUserPass) IsPasswordCorrect(password string) bool {
+	// 待开通的目录用户没有本地密码，只能通过LDAP认证
+	if up.provision != nil {
+		return up.provisionWithLDAP(password)
+	}
+
+	account := MaskAccount(up.Account)
+
+	// 通行密钥登录使用的一次性凭证，不发送到LDAP
+	if verifyLoginTicket != nil && verifyLoginTicket(up.Account, password) {
+		up.authMethod = LoginMethodWebAuthn
+		authLog.Info("通行密钥登录凭证认证", "account", account, "success", true)
+		return true
+	}
+
 	// 如果LDAP未启用，直接使用本地认证
 	if !ldapEnabled || ldapServer == "" {
-		log.Printf("LDAP未启用，使用本地密码验证用户: %s", up.Account)
-		userPass := login.UserPass{
-			Password: up.Password,
-		}
-		return userPass.IsPasswordCorrect(password)
+		isCorrect := up.isLocalPasswordCorrect(password)
+		authLog.Info("本地密码认证", "account", account, "success", isCorrect)
+		return isCorrect
 	}
 
-	log.Printf("开始验证用户 %s 的密码，尝试LDAP认证", up.Account)
-
 	// 尝试LDAP认证
 	authenticated, err := authenticateWithLDAP(up.Account, password)
 	if err != nil {
-		log.Printf("LDAP认证过程出错: %v，回退到本地认证", err)
 		// LDAP认证出错，回退到本地认证
-		userPass := login.UserPass{
-			Password: up.Password,
-		}
-		isCorrect := userPass.IsPasswordCorrect(password)
-		log.Printf("本地认证结果: %v", isCorrect)
+		isCorrect := up.isLocalPasswordCorrect(password)
+		authLog.Warn("LDAP认证出错，回退到本地认证", "account", account, "error", err, "success", isCorrect)
 		return isCorrect
 	}
 
 	if authenticated {
-		// LDAP认证成功
-		log.Printf("用户 %s LDAP认证成功", up.Account)
+		up.authMethod = LoginMethodLDAP
+		authLog.Info("LDAP认证", "account", account, "success", true)
 		return true
 	}
 
 	// LDAP认证失败，回退到本地认证
-	log.Printf("用户 %s LDAP认证失败，回退到本地认证", up.Account)
+	isCorrect := up.isLocalPasswordCorrect(password)
+	authLog.Info("LDAP认证失败，回退到本地认证", "account", account, "success", isCorrect)
+	return isCorrect
+}
+
+// isLocalPasswordCorrect 使用本地保存的密码哈希验证密码
+func (up *LDAPUserPass) isLocalPasswordCorrect(password string) bool {
+	up.authMethod = LoginMethodPassword
 	userPass := login.UserPass{
 		Password: up.Password,
 	}
-	isCorrect := userPass.IsPasswordCorrect(password)
-	log.Printf("本地认证结果: %v", isCorrect)
-	return isCorrect
+	return userPass.IsPasswordCorrect(password)
+}
+
+// AuthMethod 返回最近一次验证密码时使用的认证方式，没有验证过密码时返回空字符串
+func (up *LDAPUserPass) AuthMethod() string {
+	return up.authMethod
+}
+
+// provisionWithLDAP 通过LDAP认证待开通的用户，认证成功后在本地创建用户
+func (up *LDAPUserPass) provisionWithLDAP(password string) bool {
+	account := MaskAccount(up.Account)
+	up.authMethod = LoginMethodLDAP
+	authenticated, err := authenticateWithLDAP(up.Account, password)
+	if err != nil {
+		authLog.Warn("LDAP认证出错，不开通本地用户", "account", account, "error", err)
+		return false
+	}
+	if !authenticated {
+		authLog.Info("LDAP认证失败，不开通本地用户", "account", account, "success", false)
+		return false
+	}
+
+	if err := up.provision(); err != nil {
+		authLog.Error("自动创建LDAP用户失败", "account", account, "error", err)
+		return false
+	}
+	up.provision = nil
+	authLog.Info("LDAP认证成功，已自动创建本地用户", "account", account, "success", true)
+	return true
 }
 
 // GetPasswordUpdatedAt 获取密码更新时间
@@ -221,7 +302,13 @@ func (up *LDAPUserPass) UnlockUser(db *gorm.DB, model interface{}) error {
 }
 
 // IncreaseRetryCount 增加重试计数
+// 锁定已经过期时先自动解锁，从头开始计数，避免解锁后输错一次就再次锁定
 func (up *LDAPUserPass) IncreaseRetryCount(db *gorm.DB, model interface{}) error {
+	if up.Locked && !up.GetLocked() {
+		if err := up.UnlockUser(db, model); err != nil {
+			return err
+		}
+	}
 	if err := db.Model(model).Where("account = ?", up.Account).Updates(map[string]interface{}{
 		"login_retry_count": gorm.Expr("coalesce(login_retry_count,0) + 1"),
 	}).Error; err != nil {
@@ -346,11 +433,48 @@ var (
 	ldapEnabled          bool
 	ldapServer           string
 	authenticateWithLDAP func(email, password string) (bool, error)
+
+	// 首次LDAP登录时自动开通用户
+	lookupLDAPUser    func(account string, user *User) (bool, error)
+	provisionLDAPUser func(db *gorm.DB, user *User) error
+
+	// 通行密钥登录的一次性凭证
+	verifyLoginTicket func(account, ticket string) bool
+
+	// 连续输错密码后锁定账号的时长
+	lockDuration = time.Hour
 )
 
+// SetLockDuration 从外部设置账号锁定的时长，超过时长后自动解锁
+func SetLockDuration(d time.Duration) {
+	lockDuration = d
+}
+
+// LockDuration 返回账号锁定的时长
+func LockDuration() time.Duration {
+	return lockDuration
+}
+
 // SetLDAPConfig 从外部设置LDAP配置
 func SetLDAPConfig(enabled bool, server string, authFunc func(email, password string) (bool, error)) {
 	ldapEnabled = enabled
 	ldapServer = server
 	authenticateWithLDAP = authFunc
 }
+
+// SetLDAPProvisioning 从外部设置首次LDAP登录时的用户开通函数
+// 参数：
+// - lookup: 在目录中查找用户并填充用户属性，找不到时返回false
+// - provision: LDAP认证成功后在本地创建用户，需要设置用户ID
+// 两个参数都为nil时关闭自动开通
+func SetLDAPProvisioning(lookup func(account string, user *User) (bool, error), provision func(db *gorm.DB, user *User) error) {
+	lookupLDAPUser = lookup
+	provisionLDAPUser = provision
+}
+
+// SetLoginTicketVerifier 从外部设置一次性登录凭证的验证函数
+// 参数：
+// - verify: 凭证属于该账号且有效时返回true，同一个凭证只能验证通过一次；为nil时不接受登录凭证
+func SetLoginTicketVerifier(verify func(account, ticket string) bool) {
+	verifyLoginTicket = verify
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// 登录方式
+const (
+	LoginMethodPassword     = "password"      // 本地密码
+	LoginMethodLDAP         = "ldap"          // LDAP目录
+	LoginMethodOAuth        = "oauth"         // OAuth第三方登录
+	LoginMethodSAML         = "saml"          // SAML单点登录
+	LoginMethodTOTP         = "totp"          // TOTP双因素认证
+	LoginMethodWebAuthn     = "webauthn"      // WebAuthn通行密钥或安全密钥
+	LoginMethodRecoveryCode = "recovery_code" // 双因素认证恢复码
+)
+
+// 登录失败原因
+const (
+	LoginReasonWrongPassword     = "wrong_password"      // 密码错误
+	LoginReasonUserNotFound      = "user_not_found"      // 用户不存在
+	LoginReasonUserLocked        = "user_locked"         // 用户已锁定
+	LoginReasonWrongTOTPCode     = "wrong_totp_code"     // TOTP验证码错误
+	LoginReasonTOTPCodeUsed      = "totp_code_used"      // TOTP验证码已使用
+	LoginReasonWebAuthnFailed    = "webauthn_failed"     // WebAuthn验证失败
+	LoginReasonWrongRecoveryCode = "wrong_recovery_code" // 恢复码错误或已使用
+	LoginReasonUserInactive      = "user_inactive"       // 用户已停用
+	LoginReasonOAuthFailed       = "oauth_failed"        // OAuth认证失败
+	LoginReasonDomainDenied      = "domain_denied"       // 邮箱域名不允许登录
+	LoginReasonError             = "error"               // 其他错误
+)
+
+// LoginEvent 登录事件
+// 记录每次登录的结果，用于审计和排查登录问题，只新增不修改
+type LoginEvent struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	UserID    *uint     `gorm:"index"`          // 用户ID，用户不存在时为空
+	Account   string    `gorm:"size:255;index"` // 登录时输入的账号或OAuth账号
+	Method    string    `gorm:"size:50"`        // 登录方式
+	Provider  string    `gorm:"size:50"`        // OAuth提供商，只有OAuth登录时有值
+	Success   bool      `gorm:"index"`          // 是否登录成功
+	Reason    string    `gorm:"size:50"`        // 失败原因，成功时为空
+	IP        string    `gorm:"size:64"`        // 客户端IP
+	UserAgent string    `gorm:"size:512"`       // 浏览器User-Agent
+}
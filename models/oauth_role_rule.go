@@ -0,0 +1,17 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// OAuthRoleRule OAuth角色分配规则
+// 每次OAuth或SAML登录时按提供商、邮箱域名和组匹配规则，授予匹配的规则中的角色并设置公司
+type OAuthRoleRule struct {
+	gorm.Model
+	Provider string `gorm:"size:64"`  // OAuth提供商标识，为空时匹配所有提供商
+	Domain   string `gorm:"size:255"` // 邮箱域名，为空时匹配所有域名，"*.example.com"匹配所有子域名
+	Group    string `gorm:"size:255"` // 提供商返回的组，为空时不限制组
+	Roles    string `gorm:"size:512"` // 授予的角色，用逗号分隔
+	Company  string `gorm:"size:255"` // 设置的公司，为空时不修改
+	Priority int    // 优先级，数字小的规则先匹配，公司取第一个设置了公司的匹配规则
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PasswordHistory 密码历史
+// 每次设置本地密码后保存新密码的哈希，用于禁止重复使用最近用过的密码，只保留密码策略要求的条数
+type PasswordHistory struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index"`   // 用户ID
+	Password  string `gorm:"size:60"` // 密码哈希
+}
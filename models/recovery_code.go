@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// RecoveryCode 双因素认证的一次性恢复码
+// 丢失验证器时可以代替TOTP验证码登录，只保存哈希，每个恢复码只能使用一次
+type RecoveryCode struct {
+	ID        uint       `gorm:"primarykey"`
+	CreatedAt time.Time  // 生成时间
+	UserID    uint       `gorm:"index"`   // 用户ID
+	CodeHash  string     `gorm:"size:64"` // 恢复码的SHA-256哈希，十六进制
+	UsedAt    *time.Time // 使用时间，未使用时为空
+}
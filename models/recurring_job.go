@@ -1,8 +1,12 @@
 package models
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -24,8 +28,22 @@ type RecurringJob struct {
 	Status         string     `gorm:"size:50" json:"status"`            // 状态(active,paused,completed)
 	ErrorCount     int        `json:"error_count"`                      // 错误次数
 	LastError      string     `gorm:"type:text" json:"last_error"`      // 最后一次错误
+
+	Tags      string `gorm:"size:500" json:"tags"`      // 标签，多个标签用逗号分隔
+	OwnerID   *uint  `gorm:"index" json:"owner_id"`     // 负责人用户ID
+	OwnerRole string `gorm:"size:50" json:"owner_role"` // 负责角色，拥有该角色的用户都视为负责人
+
+	NotifyOn     string `gorm:"size:20" json:"notify_on"`      // 执行结果的通知时机(空表示不通知,failure,always)
+	NotifyEmails string `gorm:"size:500" json:"notify_emails"` // 通知收件人，多个邮箱用逗号分隔
 }
 
+// 任务执行结果的通知时机
+const (
+	NotifyNever     = ""
+	NotifyOnFailure = "failure"
+	NotifyAlways    = "always"
+)
+
 // DisplayName 返回任务的显示名称，用于活动日志
 func (r *RecurringJob) DisplayName() string {
 	return r.Name
@@ -47,6 +65,97 @@ func (r *RecurringJob) SetArgs(args interface{}) error {
 	return nil
 }
 
+// TagList 返回任务的标签列表
+func (r *RecurringJob) TagList() []string {
+	if r.Tags == "" {
+		return nil
+	}
+	return strings.Split(r.Tags, ",")
+}
+
+// SetTags 设置任务标签，去除空白和重复的标签
+func (r *RecurringJob) SetTags(tags []string) {
+	seen := make(map[string]bool)
+	var list []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(strings.ReplaceAll(tag, ",", " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		list = append(list, tag)
+	}
+	r.Tags = strings.Join(list, ",")
+}
+
+// HasTag 判断任务是否带有指定标签
+func (r *RecurringJob) HasTag(tag string) bool {
+	for _, t := range r.TagList() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifyEmailList 返回通知收件人列表
+func (r *RecurringJob) NotifyEmailList() []string {
+	if r.NotifyEmails == "" {
+		return nil
+	}
+	return strings.Split(r.NotifyEmails, ",")
+}
+
+// SetNotifyEmails 设置通知收件人，去除空白和重复的邮箱
+func (r *RecurringJob) SetNotifyEmails(emails []string) {
+	seen := make(map[string]bool)
+	var list []string
+	for _, email := range emails {
+		email = strings.TrimSpace(email)
+		if email == "" || seen[strings.ToLower(email)] {
+			continue
+		}
+		seen[strings.ToLower(email)] = true
+		list = append(list, email)
+	}
+	r.NotifyEmails = strings.Join(list, ",")
+}
+
+// ShouldNotify 判断一次执行结束后是否需要发送通知
+func (r *RecurringJob) ShouldNotify(success bool) bool {
+	if r.NotifyEmails == "" {
+		return false
+	}
+	switch r.NotifyOn {
+	case NotifyAlways:
+		return true
+	case NotifyOnFailure:
+		return !success
+	}
+	return false
+}
+
+// IsOwnedBy 判断任务是否由指定用户负责
+// 参数：
+// - userID: 用户ID
+// - roles: 用户拥有的角色
+// 返回：
+// - bool: 用户是负责人，或拥有任务的负责角色时返回true
+func (r *RecurringJob) IsOwnedBy(userID uint, roles []string) bool {
+	if r.OwnerID != nil && *r.OwnerID == userID {
+		return true
+	}
+	if r.OwnerRole == "" {
+		return false
+	}
+	for _, role := range roles {
+		if role == r.OwnerRole {
+			return true
+		}
+	}
+	return false
+}
+
 // GetArgs 获取任务参数
 func (r *RecurringJob) GetArgs(dest interface{}) error {
 	if r.Args == "" {
@@ -56,6 +165,12 @@ func (r *RecurringJob) GetArgs(dest interface{}) error {
 	return json.Unmarshal([]byte(r.Args), dest)
 }
 
+// ErrArtifactStorageNotConfigured 表示未配置执行产物的存储后端
+var ErrArtifactStorageNotConfigured = errors.New("未配置执行产物存储")
+
+// ArtifactSaver 保存执行产物的函数，由任务管理器在执行任务前注入
+type ArtifactSaver func(execution *RecurringJobExecution, name, contentType string, content io.Reader) (*RecurringJobArtifact, error)
+
 // RecurringJobExecution 重复任务执行记录
 // 用于记录每次任务执行的情况
 type RecurringJobExecution struct {
@@ -67,6 +182,26 @@ type RecurringJobExecution struct {
 	Error          string     `gorm:"type:text" json:"error"`  // 错误信息
 	Output         string     `gorm:"type:text" json:"output"` // 输出信息
 	Duration       int64      `json:"duration"`                // 执行持续时间(毫秒)
+	Interrupted    bool       `json:"interrupted"`             // 是否因系统关闭而中断
+
+	Artifacts     []RecurringJobArtifact `gorm:"foreignKey:RecurringJobExecutionID" json:"artifacts"` // 执行产物
+	artifactSaver ArtifactSaver          // 由任务管理器注入，不持久化
+}
+
+// RecurringJobArtifact 重复任务执行产物
+// 记录任务执行过程中附加的文件，文件内容保存在任务管理器配置的存储后端中
+type RecurringJobArtifact struct {
+	gorm.Model
+	RecurringJobExecutionID uint   `gorm:"index" json:"recurring_job_execution_id"` // 关联的执行记录ID
+	Name                    string `gorm:"size:255" json:"name"`                    // 文件名
+	ContentType             string `gorm:"size:255" json:"content_type"`            // 内容类型
+	Size                    int64  `json:"size"`                                    // 文件大小(字节)
+	StorageKey              string `gorm:"size:512" json:"storage_key"`             // 存储后端中的键
+}
+
+// DisplayName 返回执行产物的显示名称，用于活动日志
+func (a *RecurringJobArtifact) DisplayName() string {
+	return a.Name
 }
 
 // DisplayName 返回执行记录的显示名称，用于活动日志
@@ -74,6 +209,38 @@ func (e *RecurringJobExecution) DisplayName() string {
 	return fmt.Sprintf("执行记录 #%d", e.ID)
 }
 
+// SetArtifactSaver 设置保存执行产物的函数
+func (e *RecurringJobExecution) SetArtifactSaver(saver ArtifactSaver) {
+	e.artifactSaver = saver
+}
+
+// AttachArtifact 将文件作为执行产物附加到本次执行
+// 参数：
+// - name: 文件名，用于下载时显示
+// - contentType: 内容类型，例如 text/csv
+// - content: 文件内容
+// 返回：
+// - error: 未配置存储或保存失败时返回错误
+func (e *RecurringJobExecution) AttachArtifact(name, contentType string, content io.Reader) error {
+	if e.artifactSaver == nil {
+		return ErrArtifactStorageNotConfigured
+	}
+
+	artifact, err := e.artifactSaver(e, name, contentType, content)
+	if err != nil {
+		return err
+	}
+
+	e.Artifacts = append(e.Artifacts, *artifact)
+	e.Info("附加执行产物: %s (%d 字节)", artifact.Name, artifact.Size)
+	return nil
+}
+
+// AttachArtifactBytes 将字节内容作为执行产物附加到本次执行
+func (e *RecurringJobExecution) AttachArtifactBytes(name, contentType string, content []byte) error {
+	return e.AttachArtifact(name, contentType, bytes.NewReader(content))
+}
+
 // Info 记录一条信息级别的输出，自动添加时间戳
 func (e *RecurringJobExecution) Info(format string, args ...interface{}) {
 	e.logWithLevel("INFO", format, args...)
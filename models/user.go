@@ -22,6 +22,8 @@ const (
 
 	StatusActive   = "active"
 	StatusInactive = "inactive"
+	// StatusPending 已邀请但还没有设置密码的用户
+	StatusPending = "pending"
 )
 
 var DefaultRoles = []string{
@@ -49,6 +51,8 @@ type User struct {
 	Roles            []role.Role `gorm:"many2many:user_role_join;"`
 	UpdatedAt        time.Time
 	CreatedAt        time.Time
+	// WebAuthnCredentials 用户注册的WebAuthn凭据，不自动保存，需要时由withRoles等手动加载
+	WebAuthnCredentials []WebAuthnCredential `gorm:"-"`
 
 	// Username is email
 	LDAPUserPass
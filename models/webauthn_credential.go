@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WebAuthnCredential 用户注册的WebAuthn凭据（通行密钥或安全密钥）
+// 可以用于免密码登录，也可以作为双因素认证，用户和管理员都可以撤销
+type WebAuthnCredential struct {
+	gorm.Model
+	UserID          uint       `gorm:"index"`                 // 用户ID
+	Name            string     `gorm:"size:100"`              // 用户填写的名称，例如"办公室电脑"
+	CredentialID    string     `gorm:"size:1024;uniqueIndex"` // 凭据ID，base64url编码
+	PublicKey       []byte     // COSE格式的公钥
+	AttestationType string     `gorm:"size:50"` // 注册时的证明格式
+	AAGUID          []byte     // 认证器型号
+	SignCount       uint32     // 签名计数器，用于发现被复制的认证器
+	Transports      string     `gorm:"size:255"` // 认证器支持的传输方式，多个用逗号分隔
+	BackupEligible  bool       // 是否可以同步到其他设备
+	BackupState     bool       // 是否已经同步到其他设备
+	LastUsedAt      *time.Time // 最近一次使用的时间
+}